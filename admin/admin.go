@@ -0,0 +1,396 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/audit"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// Admin reads and writes records in a broker store. It works with any
+// brokerstore.Store, and changes records only through its Create and Delete
+// methods, as the broker itself does.
+type Admin struct {
+	logger lager.Logger
+	store  brokerstore.Store
+	leases Leases
+
+	audit   *audit.Log
+	user    string
+	started bool
+}
+
+// auditPlatform is recorded as the platform of changes made by an admin,
+// where the broker records the platform that asked for them.
+const auditPlatform = "admin"
+
+func New(logger lager.Logger, store brokerstore.Store) *Admin {
+	return &Admin{logger: logger.Session("admin"), store: store}
+}
+
+// Leases lock instances across every broker that shares the store, as they
+// do for broker.Leased. Acquire returns a function that releases the lease.
+type Leases interface {
+	Acquire(ctx context.Context, instanceID string) (func(), error)
+}
+
+// LeaseWith makes each change to an instance, from reading it to writing it,
+// under the instance's lease, so that brokers serving the store cannot
+// change or delete it in between. Without leases, brokers must be stopped
+// while instances are changed.
+func (a *Admin) LeaseWith(leases Leases) {
+	a.leases = leases
+}
+
+// lease takes an instance's lease, when the admin has leases.
+func (a *Admin) lease(id string) (func(), error) {
+	if a.leases == nil {
+		return func() {}, nil
+	}
+	release, err := a.leases.Acquire(context.Background(), id)
+	if err != nil {
+		return nil, fmt.Errorf("locking instance %s: %w", id, err)
+	}
+	return release, nil
+}
+
+// Instances lists the instances that match filter, by ID.
+func (a *Admin) Instances(filter Filter) ([]Instance, error) {
+	all, err := a.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []Instance{}
+	for id, details := range all {
+		if instance := newInstance(id, details); filter.matchesInstance(instance) {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances, nil
+}
+
+// Bindings lists the bindings on the filter's plan, by ID. Bindings cannot
+// be filtered by org, space or share.
+func (a *Admin) Bindings(filter Filter) ([]Binding, error) {
+	if !filter.onlyPlan() {
+		return nil, errors.New("bindings do not record their org, space or share, and can only be filtered by plan")
+	}
+
+	all, err := a.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := []Binding{}
+	for id, details := range all {
+		if binding := newBinding(id, details); filter.matchesBinding(binding) {
+			bindings = append(bindings, binding)
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].ID < bindings[j].ID })
+	return bindings, nil
+}
+
+func (a *Admin) Instance(id string) (Instance, error) {
+	details, err := a.retrieveInstance(id)
+	if err != nil {
+		return Instance{}, err
+	}
+	return newInstance(id, details), nil
+}
+
+func (a *Admin) Binding(id string) (Binding, error) {
+	details, err := a.retrieveBinding(id)
+	if err != nil {
+		return Binding{}, err
+	}
+	return newBinding(id, details), nil
+}
+
+// retrieveInstance reads an instance, making sure the record is one: the
+// store keeps instances and bindings side by side, and reads either as
+// either.
+func (a *Admin) retrieveInstance(id string) (brokerstore.ServiceInstance, error) {
+	details, err := a.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		return details, fmt.Errorf("reading instance %s: %w", id, err)
+	}
+	if details.ServiceFingerPrint == nil {
+		return details, fmt.Errorf("%s is not an instance", id)
+	}
+	return details, nil
+}
+
+func (a *Admin) retrieveBinding(id string) (domain.BindDetails, error) {
+	if instance, err := a.store.RetrieveInstanceDetails(id); err == nil && instance.ServiceFingerPrint != nil {
+		return domain.BindDetails{}, fmt.Errorf("%s is an instance, not a binding", id)
+	}
+
+	details, err := a.store.RetrieveBindingDetails(id)
+	if err != nil {
+		return details, fmt.Errorf("reading binding %s: %w", id, err)
+	}
+	return details, nil
+}
+
+// DeleteInstance removes an instance record, even one that cannot be read,
+// but not a binding. Its bindings, which do not record it, are left alone.
+func (a *Admin) DeleteInstance(id string) error {
+	if instance, err := a.store.RetrieveInstanceDetails(id); err == nil && instance.ServiceFingerPrint == nil {
+		return fmt.Errorf("%s is not an instance", id)
+	}
+
+	logger := a.logger.Session("delete-instance", lager.Data{"instance_id": id})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := a.store.DeleteInstanceDetails(id); err != nil {
+		return fmt.Errorf("deleting instance %s: %w", id, err)
+	}
+	return a.store.Save(logger)
+}
+
+// DeleteBinding removes a binding record, even one that cannot be read, but
+// not an instance.
+func (a *Admin) DeleteBinding(id string) error {
+	if instance, err := a.store.RetrieveInstanceDetails(id); err == nil && instance.ServiceFingerPrint != nil {
+		return fmt.Errorf("%s is an instance, not a binding", id)
+	}
+
+	logger := a.logger.Session("delete-binding", lager.Data{"binding_id": id})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := a.store.DeleteBindingDetails(id); err != nil {
+		return fmt.Errorf("deleting binding %s: %w", id, err)
+	}
+	return a.store.Save(logger)
+}
+
+// InstanceRepair lists the fields to change on an instance. Nil fields are
+// left as they are.
+type InstanceRepair struct {
+	ServiceID        *string
+	PlanID           *string
+	OrganizationGUID *string
+	SpaceGUID        *string
+	Share            *string
+}
+
+// BindingRepair lists the fields to change on a binding. Nil fields are left
+// as they are.
+type BindingRepair struct {
+	ServiceID *string
+	PlanID    *string
+	AppGUID   *string
+}
+
+// RepairInstance returns the instance with the repair applied, and writes it
+// unless dryRun is set, under the instance's lease when the admin has
+// leases. The share is changed in place, in whichever format the fingerprint
+// has; the rest of the fingerprint is kept.
+func (a *Admin) RepairInstance(id string, repair InstanceRepair, dryRun bool) (Instance, error) {
+	if !dryRun {
+		release, err := a.lease(id)
+		if err != nil {
+			return Instance{}, err
+		}
+		defer release()
+	}
+
+	details, err := a.retrieveInstance(id)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	set(&details.ServiceID, repair.ServiceID)
+	set(&details.PlanID, repair.PlanID)
+	set(&details.OrganizationGUID, repair.OrganizationGUID)
+	set(&details.SpaceGUID, repair.SpaceGUID)
+	if repair.Share != nil {
+		if details.ServiceFingerPrint, err = withShare(details.ServiceFingerPrint, *repair.Share); err != nil {
+			return Instance{}, fmt.Errorf("instance %s: %w", id, err)
+		}
+	}
+
+	if !dryRun {
+		logger := a.logger.Session("repair-instance", lager.Data{"instance_id": id})
+		logger.Info("start")
+		defer logger.Info("end")
+
+		if err := a.store.CreateInstanceDetails(id, details); err != nil {
+			return Instance{}, fmt.Errorf("writing instance %s: %w", id, err)
+		}
+		if err := a.store.Save(logger); err != nil {
+			return Instance{}, err
+		}
+	}
+	return newInstance(id, details), nil
+}
+
+// RepairBinding returns the binding with the repair applied, and writes it
+// unless dryRun is set. Bindings do not record their instance, so with
+// leases instanceID must name it, and the write is made under its lease.
+func (a *Admin) RepairBinding(id, instanceID string, repair BindingRepair, dryRun bool) (Binding, error) {
+	if !dryRun && a.leases != nil {
+		if instanceID == "" {
+			return Binding{}, fmt.Errorf("binding %s does not record its instance: name the instance it belongs to, to lock it", id)
+		}
+		release, err := a.lease(instanceID)
+		if err != nil {
+			return Binding{}, err
+		}
+		defer release()
+
+		if _, err := a.retrieveInstance(instanceID); err != nil {
+			return Binding{}, err
+		}
+	}
+
+	details, err := a.retrieveBinding(id)
+	if err != nil {
+		return Binding{}, err
+	}
+
+	set(&details.ServiceID, repair.ServiceID)
+	set(&details.PlanID, repair.PlanID)
+	if repair.AppGUID != nil {
+		details.AppGUID = *repair.AppGUID
+		if details.BindResource != nil {
+			resource := *details.BindResource
+			resource.AppGuid = *repair.AppGUID
+			details.BindResource = &resource
+		}
+	}
+
+	if !dryRun {
+		logger := a.logger.Session("repair-binding", lager.Data{"binding_id": id})
+		logger.Info("start")
+		defer logger.Info("end")
+
+		if err := a.store.CreateBindingDetails(id, details); err != nil {
+			return Binding{}, fmt.Errorf("writing binding %s: %w", id, err)
+		}
+		if err := a.store.Save(logger); err != nil {
+			return Binding{}, err
+		}
+	}
+	return newBinding(id, details), nil
+}
+
+// withShare returns a copy of fingerprint with its share changed, in
+// whichever format the fingerprint has.
+func withShare(fingerprint interface{}, share string) (interface{}, error) {
+	switch fingerprint := fingerprint.(type) {
+	case map[string]interface{}:
+		updated := map[string]interface{}{}
+		for key, value := range fingerprint {
+			updated[key] = value
+		}
+		updated["share"] = share
+		return updated, nil
+	case string:
+		return share, nil
+	}
+	return nil, errors.New("the fingerprint is unreadable")
+}
+
+func set(field *string, value *string) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+// FingerprintUpgrade reports the instances found with a legacy fingerprint,
+// a bare share string, and those that were upgraded to the current format.
+type FingerprintUpgrade struct {
+	DryRun bool `json:"dry_run"`
+	// Legacy lists the instances that had a legacy fingerprint.
+	Legacy   []Instance `json:"legacy"`
+	Upgraded []string   `json:"upgraded"`
+	// Current counts the instances already in the current format.
+	Current int `json:"current"`
+	// Errors are keyed by instance ID.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// UpgradeFingerprints rewrites legacy fingerprints as {"share": share}, which
+// binds read the same way, so mount configs and volume IDs do not change.
+// Unless dryRun is set, each instance is read again just before it is
+// written, under its lease when the admin has leases, and skipped if it has
+// since been deleted or upgraded; running it again finds nothing to do.
+func (a *Admin) UpgradeFingerprints(dryRun bool) (FingerprintUpgrade, error) {
+	upgrade := FingerprintUpgrade{DryRun: dryRun, Legacy: []Instance{}, Upgraded: []string{}}
+
+	all, err := a.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return upgrade, err
+	}
+	for id, details := range all {
+		switch details.ServiceFingerPrint.(type) {
+		case string:
+			upgrade.Legacy = append(upgrade.Legacy, newInstance(id, details))
+		case map[string]interface{}:
+			upgrade.Current++
+		}
+	}
+	sort.Slice(upgrade.Legacy, func(i, j int) bool { return upgrade.Legacy[i].ID < upgrade.Legacy[j].ID })
+	if dryRun || len(upgrade.Legacy) == 0 {
+		return upgrade, nil
+	}
+
+	logger := a.logger.Session("upgrade-fingerprints")
+	logger.Info("start", lager.Data{"legacy": len(upgrade.Legacy)})
+	defer logger.Info("end")
+
+	for _, instance := range upgrade.Legacy {
+		upgraded, err := a.upgradeFingerprint(logger, instance.ID)
+		if err != nil {
+			logger.Error("upgrade-failed", err, lager.Data{"instance_id": instance.ID})
+			if upgrade.Errors == nil {
+				upgrade.Errors = map[string]string{}
+			}
+			upgrade.Errors[instance.ID] = err.Error()
+			continue
+		}
+		if upgraded {
+			upgrade.Upgraded = append(upgrade.Upgraded, instance.ID)
+		}
+	}
+	return upgrade, nil
+}
+
+func (a *Admin) upgradeFingerprint(logger lager.Logger, id string) (bool, error) {
+	release, err := a.lease(id)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	details, err := a.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		// deleted since it was listed
+		return false, nil
+	}
+	share, ok := details.ServiceFingerPrint.(string)
+	if !ok {
+		return false, nil
+	}
+
+	details.ServiceFingerPrint = map[string]interface{}{"share": share}
+	if err := a.store.CreateInstanceDetails(id, details); err != nil {
+		return false, fmt.Errorf("writing instance %s: %w", id, err)
+	}
+	if err := a.store.Save(logger); err != nil {
+		return false, err
+	}
+	logger.Info("upgraded", lager.Data{"instance_id": id})
+	return true, nil
+}
@@ -0,0 +1,644 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Usage describes the admin subcommands.
+const Usage = `admin list instances [-org GUID] [-space GUID] [-share SHARE] [-plan ID] [-json]
+admin list bindings [-plan ID] [-json]
+admin show instance|binding ID [-json]
+admin delete instance|binding ID [-yes]
+admin repair instance ID [-service ID] [-plan ID] [-org GUID] [-space GUID] [-share SHARE] [-offline] [-yes] [-json]
+admin repair binding ID [-instance ID] [-service ID] [-plan ID] [-app GUID] [-offline] [-yes] [-json]
+admin upgrade fingerprints [-dry-run] [-offline] [-yes] [-json]
+admin rewrite shares (-host OLD=NEW | -prefix OLD/PATH=NEW/PATH)... [-dry-run] [-offline] [-yes] [-json]`
+
+// Command runs the admin subcommands. Results are written to Stdout, as text
+// or with -json as JSON; previews of changes and confirmation prompts are
+// written to Stderr, and answers read from Stdin. Bindings, when set, lists
+// the bindings that share rewrites leave mounting the old share.
+//
+// Subcommands that read an instance or binding and write it back do so under
+// the instance's lease when the admin has leases. Without, a broker serving the store could
+// delete or change the instance in between, and the write would undo that,
+// so they refuse to run unless -offline acknowledges that every broker
+// sharing the store is stopped.
+type Command struct {
+	Admin    *Admin
+	Bindings BindingFinder
+	Stdin    io.Reader
+	Stdout   io.Writer
+	Stderr   io.Writer
+}
+
+func (c *Command) Run(args []string) error {
+	if len(args) < 2 {
+		return usageError()
+	}
+
+	switch args[0] + " " + args[1] {
+	case "list instances":
+		return c.listInstances(args[2:])
+	case "list bindings":
+		return c.listBindings(args[2:])
+	case "show instance":
+		return c.show(args[2:], "instance")
+	case "show binding":
+		return c.show(args[2:], "binding")
+	case "delete instance":
+		return c.delete(args[2:], "instance")
+	case "delete binding":
+		return c.delete(args[2:], "binding")
+	case "repair instance":
+		return c.repairInstance(args[2:])
+	case "repair binding":
+		return c.repairBinding(args[2:])
+	case "upgrade fingerprints":
+		return c.upgradeFingerprints(args[2:])
+	case "rewrite shares":
+		return c.rewriteShares(args[2:])
+	}
+	return usageError()
+}
+
+func usageError() error {
+	return fmt.Errorf("usage:\n%s", Usage)
+}
+
+func (c *Command) listInstances(args []string) error {
+	var filter Filter
+	flags := c.flagSet("list instances")
+	flags.StringVar(&filter.OrganizationGUID, "org", "", "only instances in this org")
+	flags.StringVar(&filter.SpaceGUID, "space", "", "only instances in this space")
+	flags.StringVar(&filter.Share, "share", "", "only instances of this share")
+	flags.StringVar(&filter.PlanID, "plan", "", "only instances on this plan")
+	asJSON := flags.Bool("json", false, "write JSON")
+	if _, err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	instances, err := c.Admin.Instances(filter)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return c.writeJSON(instances)
+	}
+
+	w := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSERVICE\tPLAN\tORG\tSPACE\tSHARE")
+	for _, instance := range instances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", instance.ID, instance.ServiceID, instance.PlanID, instance.OrganizationGUID, instance.SpaceGUID, instance.Share)
+	}
+	return w.Flush()
+}
+
+func (c *Command) listBindings(args []string) error {
+	var filter Filter
+	flags := c.flagSet("list bindings")
+	flags.StringVar(&filter.PlanID, "plan", "", "only bindings on this plan")
+	asJSON := flags.Bool("json", false, "write JSON")
+	if _, err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	bindings, err := c.Admin.Bindings(filter)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return c.writeJSON(bindings)
+	}
+
+	w := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSERVICE\tPLAN\tAPP")
+	for _, binding := range bindings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", binding.ID, binding.ServiceID, binding.PlanID, binding.AppGUID)
+	}
+	return w.Flush()
+}
+
+func (c *Command) show(args []string, kind string) error {
+	flags := c.flagSet("show " + kind)
+	asJSON := flags.Bool("json", false, "write JSON")
+	ids, err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var record interface{}
+	if kind == "instance" {
+		record, err = c.Admin.Instance(ids[0])
+	} else {
+		record, err = c.Admin.Binding(ids[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return c.writeJSON(record)
+	}
+	return writeFields(c.Stdout, record)
+}
+
+func (c *Command) delete(args []string, kind string) error {
+	flags := c.flagSet("delete " + kind)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	ids, err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	id := ids[0]
+
+	// show what is about to go, when it can be read
+	var record interface{}
+	if kind == "instance" {
+		record, err = c.Admin.Instance(id)
+	} else {
+		record, err = c.Admin.Binding(id)
+	}
+	if err == nil {
+		fmt.Fprintf(c.Stderr, "Deleting %s %s:\n", kind, id)
+		_ = writeFields(c.Stderr, record)
+	} else {
+		fmt.Fprintf(c.Stderr, "Deleting %s %s, which cannot be read: %s\n", kind, id, err)
+	}
+
+	if err := c.confirm(id, *yes); err != nil {
+		return err
+	}
+
+	if kind == "instance" {
+		err = c.Admin.DeleteInstance(id)
+	} else {
+		err = c.Admin.DeleteBinding(id)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "deleted %s %s\n", kind, id)
+	return nil
+}
+
+func (c *Command) repairInstance(args []string) error {
+	flags := c.flagSet("repair instance")
+	serviceID := optionalString(flags, "service", "set the service ID")
+	planID := optionalString(flags, "plan", "set the plan ID")
+	org := optionalString(flags, "org", "set the org GUID")
+	space := optionalString(flags, "space", "set the space GUID")
+	share := optionalString(flags, "share", "set the share")
+	offline := offlineFlag(flags)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	asJSON := flags.Bool("json", false, "write JSON")
+	ids, err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	repair := InstanceRepair{ServiceID: serviceID.value(), PlanID: planID.value(), OrganizationGUID: org.value(), SpaceGUID: space.value(), Share: share.value()}
+	if repair == (InstanceRepair{}) {
+		return errors.New("repair instance needs at least one of -service, -plan, -org, -space or -share")
+	}
+	if err := c.checkOffline("repair instance", *offline); err != nil {
+		return err
+	}
+
+	return c.repair(ids[0], "instance", *yes, *asJSON,
+		func() (interface{}, error) { return c.Admin.Instance(ids[0]) },
+		func(dryRun bool) (interface{}, error) { return c.Admin.RepairInstance(ids[0], repair, dryRun) },
+	)
+}
+
+func (c *Command) repairBinding(args []string) error {
+	flags := c.flagSet("repair binding")
+	serviceID := optionalString(flags, "service", "set the service ID")
+	planID := optionalString(flags, "plan", "set the plan ID")
+	app := optionalString(flags, "app", "set the app GUID")
+	instanceID := flags.String("instance", "", "the instance the binding belongs to, whose lease is held while it is repaired; needed with store leases")
+	offline := offlineFlag(flags)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	asJSON := flags.Bool("json", false, "write JSON")
+	ids, err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	repair := BindingRepair{ServiceID: serviceID.value(), PlanID: planID.value(), AppGUID: app.value()}
+	if repair == (BindingRepair{}) {
+		return errors.New("repair binding needs at least one of -service, -plan or -app")
+	}
+	if err := c.checkOffline("repair binding", *offline); err != nil {
+		return err
+	}
+	if c.Admin.leases != nil && *instanceID == "" {
+		return errors.New("repair binding with store leases needs -instance, the instance the binding belongs to, since bindings do not record it")
+	}
+
+	return c.repair(ids[0], "binding", *yes, *asJSON,
+		func() (interface{}, error) { return c.Admin.Binding(ids[0]) },
+		func(dryRun bool) (interface{}, error) {
+			return c.Admin.RepairBinding(ids[0], *instanceID, repair, dryRun)
+		},
+	)
+}
+
+// repair previews the change, asks for confirmation, then makes it.
+func (c *Command) repair(id, kind string, yes, asJSON bool, current func() (interface{}, error), apply func(dryRun bool) (interface{}, error)) error {
+	before, err := current()
+	if err != nil {
+		return err
+	}
+	after, err := apply(true)
+	if err != nil {
+		return err
+	}
+
+	changes := diff(before, after)
+	if len(changes) == 0 {
+		return fmt.Errorf("%s %s already has those values", kind, id)
+	}
+	fmt.Fprintf(c.Stderr, "Repairing %s %s:\n", kind, id)
+	for _, change := range changes {
+		fmt.Fprintf(c.Stderr, "  %s\n", change)
+	}
+
+	if err := c.confirm(id, yes); err != nil {
+		return err
+	}
+
+	repaired, err := apply(false)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return c.writeJSON(repaired)
+	}
+	fmt.Fprintf(c.Stdout, "repaired %s %s\n", kind, id)
+	return nil
+}
+
+// upgradeFingerprints lists the legacy fingerprints, and unless -dry-run is
+// set, asks for confirmation and upgrades them.
+func (c *Command) upgradeFingerprints(args []string) error {
+	flags := c.flagSet("upgrade fingerprints")
+	dryRun := flags.Bool("dry-run", false, "only report the legacy fingerprints")
+	offline := offlineFlag(flags)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	asJSON := flags.Bool("json", false, "write JSON")
+	if _, err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := c.checkOffline("upgrade fingerprints", *offline); err != nil {
+			return err
+		}
+	}
+
+	preview, err := c.Admin.UpgradeFingerprints(true)
+	if err != nil {
+		return err
+	}
+	if *dryRun || len(preview.Legacy) == 0 {
+		if *asJSON {
+			return c.writeJSON(preview)
+		}
+		return writeFingerprintUpgrade(c.Stdout, preview)
+	}
+
+	fmt.Fprintf(c.Stderr, "Upgrading %d legacy fingerprint(s):\n", len(preview.Legacy))
+	for _, instance := range preview.Legacy {
+		fmt.Fprintf(c.Stderr, "  %s: %q -> {\"share\": %q}\n", instance.ID, instance.Share, instance.Share)
+	}
+	if err := c.confirm("upgrade", *yes); err != nil {
+		return err
+	}
+
+	upgrade, err := c.Admin.UpgradeFingerprints(false)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		err = c.writeJSON(upgrade)
+	} else {
+		err = writeFingerprintUpgrade(c.Stdout, upgrade)
+	}
+	if err != nil {
+		return err
+	}
+	if len(upgrade.Errors) > 0 {
+		return fmt.Errorf("%d fingerprint(s) could not be upgraded; run it again to retry them", len(upgrade.Errors))
+	}
+	return nil
+}
+
+func writeFingerprintUpgrade(w io.Writer, upgrade FingerprintUpgrade) error {
+	if upgrade.DryRun {
+		fmt.Fprintf(w, "%d instance(s) have a legacy fingerprint, and %d the current format\n", len(upgrade.Legacy), upgrade.Current)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, instance := range upgrade.Legacy {
+			fmt.Fprintf(tw, "  %s\t%s\n", instance.ID, instance.Share)
+		}
+		return tw.Flush()
+	}
+
+	fmt.Fprintf(w, "upgraded %d of %d legacy fingerprint(s)\n", len(upgrade.Upgraded), len(upgrade.Legacy))
+	for _, id := range upgrade.Upgraded {
+		fmt.Fprintf(w, "  %s\n", id)
+	}
+	var failed []string
+	for id := range upgrade.Errors {
+		failed = append(failed, id)
+	}
+	sort.Strings(failed)
+	for _, id := range failed {
+		fmt.Fprintf(w, "  %s not upgraded: %s\n", id, upgrade.Errors[id])
+	}
+	return nil
+}
+
+// rewriteShares previews the share changes the rules make as a diff, and
+// unless -dry-run is set, asks for confirmation and makes them. Either way
+// it lists the bindings of the instances changed, which go on mounting the
+// old share until they are re-created.
+func (c *Command) rewriteShares(args []string) error {
+	var rules []ShareRule
+	flags := c.flagSet("rewrite shares")
+	for _, kind := range []string{RuleHost, RulePrefix} {
+		kind := kind
+		flags.Func(kind, "a rule, OLD=NEW; rules are tried in the order given, and the first to match is used", func(s string) error {
+			rule, err := ParseShareRule(kind, s)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	}
+	dryRun := flags.Bool("dry-run", false, "only preview the changes")
+	offline := offlineFlag(flags)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	asJSON := flags.Bool("json", false, "write JSON")
+	if _, err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return errors.New("rewrite shares needs at least one -host or -prefix rule")
+	}
+	if !*dryRun {
+		if err := c.checkOffline("rewrite shares", *offline); err != nil {
+			return err
+		}
+	}
+
+	rewrite, err := c.Admin.RewriteShares(rules, true)
+	if err != nil {
+		return err
+	}
+	if !*dryRun && len(rewrite.Changes) > 0 {
+		fmt.Fprintf(c.Stderr, "Rewriting the share of %d instance(s):\n", len(rewrite.Changes))
+		for _, change := range rewrite.Changes {
+			fmt.Fprintf(c.Stderr, "  %s (%s)\n  - %s\n  + %s\n", change.InstanceID, change.Rule, change.From, change.To)
+		}
+		if err := c.confirm("rewrite", *yes); err != nil {
+			return err
+		}
+
+		rewrite, err = c.Admin.RewriteShares(rules, false)
+	}
+
+	var changed []string
+	if rewrite.DryRun {
+		for _, change := range rewrite.Changes {
+			changed = append(changed, change.InstanceID)
+		}
+	} else {
+		changed = rewrite.Rewritten
+	}
+	if c.Bindings != nil {
+		stale, rebindErr := c.Admin.BindingsToRecreate(context.Background(), c.Bindings, changed)
+		if rebindErr != nil {
+			rewrite.RebindError = rebindErr.Error()
+		}
+		rewrite.Rebind = stale
+	}
+
+	if *asJSON {
+		if writeErr := c.writeJSON(rewrite); writeErr != nil {
+			return writeErr
+		}
+	} else if writeErr := writeShareRewrite(c.Stdout, rewrite, len(changed)); writeErr != nil {
+		return writeErr
+	}
+
+	switch {
+	case err != nil:
+		return err
+	case len(rewrite.Errors) > 0:
+		return fmt.Errorf("%d share(s) could not be rewritten; run it again to retry them", len(rewrite.Errors))
+	case rewrite.RebindError != "":
+		return fmt.Errorf("the bindings to re-create could not be listed: %s", rewrite.RebindError)
+	}
+	return nil
+}
+
+func writeShareRewrite(w io.Writer, rewrite ShareRewrite, changed int) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if rewrite.DryRun {
+		fmt.Fprintf(w, "%d instance(s) would have their share rewritten, and %d are left as they are\n", len(rewrite.Changes), rewrite.Unchanged)
+		for _, change := range rewrite.Changes {
+			fmt.Fprintf(tw, "  %s\t%s\t->\t%s\n", change.InstanceID, change.From, change.To)
+		}
+	} else {
+		fmt.Fprintf(w, "rewrote %d of %d share(s)\n", len(rewrite.Rewritten), len(rewrite.Changes))
+		for _, id := range rewrite.Rewritten {
+			fmt.Fprintf(w, "  %s\n", id)
+		}
+		var failed []string
+		for id := range rewrite.Errors {
+			failed = append(failed, id)
+		}
+		sort.Strings(failed)
+		for _, id := range failed {
+			fmt.Fprintf(w, "  %s not rewritten: %s\n", id, rewrite.Errors[id])
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if changed == 0 {
+		return nil
+	}
+
+	switch {
+	case rewrite.Rebind == nil:
+		fmt.Fprintf(w, "apps bound to these instances mount the old share until they are unbound and bound again; configure cloud_controller to list their bindings\n")
+	case len(rewrite.Rebind) == 0:
+		fmt.Fprintf(w, "no bindings need to be re-created\n")
+	default:
+		fmt.Fprintf(w, "%d binding(s) mount the old share until they are unbound and bound again:\n", len(rewrite.Rebind))
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  BINDING\tINSTANCE\tAPP")
+		for _, binding := range rewrite.Rebind {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", binding.ID, binding.InstanceID, binding.AppGUID)
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+func offlineFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("offline", false, "acknowledge that every broker sharing the store is stopped; needed unless store leases are enabled")
+}
+
+// checkOffline refuses a change that could race with brokers serving the
+// store, unless the admin has leases or offline is set.
+func (c *Command) checkOffline(name string, offline bool) error {
+	if c.Admin.leases != nil || offline {
+		return nil
+	}
+	return fmt.Errorf("%s could race with brokers serving the store: enable store leases, or stop every broker sharing the store and pass -offline", name)
+}
+
+// confirm asks for the ID to be typed, unless yes is set.
+func (c *Command) confirm(id string, yes bool) error {
+	if yes {
+		return nil
+	}
+
+	fmt.Fprintf(c.Stderr, "Type %q to confirm: ", id)
+	answer, err := bufio.NewReader(c.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if strings.TrimSpace(answer) != id {
+		return errors.New("not confirmed; nothing was changed")
+	}
+	return nil
+}
+
+func (c *Command) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("admin "+name, flag.ContinueOnError)
+	flags.SetOutput(c.Stderr)
+	return flags
+}
+
+func (c *Command) writeJSON(value interface{}) error {
+	encoder := json.NewEncoder(c.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// parse allows flags before, between and after the positional arguments,
+// of which there must be exactly positionals.
+func parse(flags *flag.FlagSet, args []string, positionals int) ([]string, error) {
+	var found []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		found = append(found, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if len(found) != positionals {
+		return nil, fmt.Errorf("%s takes %d argument(s), not %d", flags.Name(), positionals, len(found))
+	}
+	return found, nil
+}
+
+// optional is a string flag that records whether it was given, so that it
+// can be set to "".
+type optional struct {
+	set bool
+	s   string
+}
+
+func optionalString(flags *flag.FlagSet, name, usage string) *optional {
+	o := &optional{}
+	flags.Func(name, usage, func(s string) error {
+		o.set, o.s = true, s
+		return nil
+	})
+	return o
+}
+
+func (o *optional) value() *string {
+	if !o.set {
+		return nil
+	}
+	return &o.s
+}
+
+// fields returns a record's JSON fields in order, as text.
+func fields(record interface{}) ([][2]string, error) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(b)))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var out [][2]string
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		text := string(value)
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			text = s
+		}
+		out = append(out, [2]string{key.(string), text})
+	}
+	return out, nil
+}
+
+func writeFields(w io.Writer, record interface{}) error {
+	fs, err := fields(record)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, field := range fs {
+		fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+	}
+	return tw.Flush()
+}
+
+func diff(before, after interface{}) []string {
+	old, err := fields(before)
+	if err != nil {
+		return nil
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil
+	}
+
+	var changes []string
+	for i := range updated {
+		if i < len(old) && old[i] != updated[i] {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", updated[i][0], old[i][1], updated[i][1]))
+		}
+	}
+	return changes
+}
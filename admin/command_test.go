@@ -0,0 +1,580 @@
+package admin_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/admin"
+	"code.cloudfoundry.org/nfsbroker/audit"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+var _ = Describe("Command", func() {
+	var (
+		store     *fakes.FakeStore
+		instances map[string]brokerstore.ServiceInstance
+		bindings  map[string]domain.BindDetails
+		stdin     *strings.Reader
+		stdout    *bytes.Buffer
+		stderr    *bytes.Buffer
+		leases    *leaseRecorder
+	)
+
+	BeforeEach(func() {
+		instances = map[string]brokerstore.ServiceInstance{
+			"instance-1": {ServiceID: "nfs", PlanID: "existing", OrganizationGUID: "org-1", SpaceGUID: "space-1", ServiceFingerPrint: map[string]interface{}{"share": "server/export-1", "uid": "1000"}},
+			"instance-2": {ServiceID: "nfs", PlanID: "existing", OrganizationGUID: "org-2", SpaceGUID: "space-2", ServiceFingerPrint: "server/export-2"},
+		}
+		bindings = map[string]domain.BindDetails{
+			"binding-1": {ServiceID: "nfs", PlanID: "existing", AppGUID: "app-1"},
+			"binding-2": {ServiceID: "nfs", PlanID: "other", BindResource: &domain.BindResource{AppGuid: "app-2"}},
+		}
+
+		store = &fakes.FakeStore{}
+		store.RetrieveAllInstanceDetailsStub = func() (map[string]brokerstore.ServiceInstance, error) { return instances, nil }
+		store.RetrieveAllBindingDetailsStub = func() (map[string]domain.BindDetails, error) { return bindings, nil }
+		// like the CredHub store, reads any record as either
+		store.RetrieveInstanceDetailsStub = func(id string) (brokerstore.ServiceInstance, error) {
+			if instance, ok := instances[id]; ok {
+				return instance, nil
+			}
+			if binding, ok := bindings[id]; ok {
+				return brokerstore.ServiceInstance{ServiceID: binding.ServiceID, PlanID: binding.PlanID}, nil
+			}
+			return brokerstore.ServiceInstance{}, errors.New("not found")
+		}
+		store.RetrieveBindingDetailsStub = func(id string) (domain.BindDetails, error) {
+			if binding, ok := bindings[id]; ok {
+				return binding, nil
+			}
+			if instance, ok := instances[id]; ok {
+				return domain.BindDetails{ServiceID: instance.ServiceID, PlanID: instance.PlanID}, nil
+			}
+			return domain.BindDetails{}, errors.New("not found")
+		}
+
+		stdin = strings.NewReader("")
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+		leases = nil
+	})
+
+	newAdmin := func() *admin.Admin {
+		a := admin.New(lager.NewLogger("test"), store)
+		if leases != nil {
+			a.LeaseWith(leases)
+		}
+		return a
+	}
+
+	run := func(args ...string) error {
+		command := &admin.Command{Admin: newAdmin(), Stdin: stdin, Stdout: stdout, Stderr: stderr}
+		return command.Run(args)
+	}
+
+	Describe("list", func() {
+		It("lists instances as a table", func() {
+			Expect(run("list", "instances")).To(Succeed())
+			lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+			Expect(lines).To(HaveLen(3))
+			Expect(strings.Fields(lines[0])).To(Equal([]string{"ID", "SERVICE", "PLAN", "ORG", "SPACE", "SHARE"}))
+			Expect(strings.Fields(lines[1])).To(Equal([]string{"instance-1", "nfs", "existing", "org-1", "space-1", "server/export-1"}))
+			Expect(strings.Fields(lines[2])).To(Equal([]string{"instance-2", "nfs", "existing", "org-2", "space-2", "server/export-2"}))
+		})
+
+		DescribeTable("filters instances",
+			func(flag, value string) {
+				Expect(run("list", "instances", flag, value, "-json")).To(Succeed())
+
+				var listed []admin.Instance
+				Expect(json.Unmarshal(stdout.Bytes(), &listed)).To(Succeed())
+				Expect(listed).To(HaveLen(1))
+				Expect(listed[0].ID).To(Equal("instance-2"))
+			},
+			Entry("by org", "-org", "org-2"),
+			Entry("by space", "-space", "space-2"),
+			Entry("by share, including legacy instances", "-share", "server/export-2"),
+		)
+
+		It("lists bindings, filtered by plan", func() {
+			Expect(run("list", "bindings", "-plan", "other", "-json")).To(Succeed())
+
+			var listed []admin.Binding
+			Expect(json.Unmarshal(stdout.Bytes(), &listed)).To(Succeed())
+			Expect(listed).To(Equal([]admin.Binding{{ID: "binding-2", ServiceID: "nfs", PlanID: "other", AppGUID: "app-2"}}))
+		})
+
+		It("refuses to filter bindings by what they do not record", func() {
+			Expect(run("list", "bindings", "-org", "org-1")).To(MatchError(ContainSubstring("flag provided but not defined: -org")))
+			_, err := admin.New(lager.NewLogger("test"), store).Bindings(admin.Filter{Share: "server/export-1"})
+			Expect(err).To(MatchError(ContainSubstring("can only be filtered by plan")))
+		})
+
+		It("lists nothing as an empty JSON list", func() {
+			Expect(run("list", "instances", "-plan", "none", "-json")).To(Succeed())
+			Expect(stdout.String()).To(Equal("[]\n"))
+		})
+	})
+
+	Describe("show", func() {
+		It("shows an instance in full", func() {
+			Expect(run("show", "instance", "instance-1")).To(Succeed())
+			Expect(stdout.String()).To(MatchRegexp(`(?m)^id:\s+instance-1$`))
+			Expect(stdout.String()).To(MatchRegexp(`(?m)^organization_guid:\s+org-1$`))
+			Expect(stdout.String()).To(MatchRegexp(`(?m)^fingerprint:\s+\{"share":"server/export-1","uid":"1000"\}$`))
+		})
+
+		It("shows a binding as JSON, with flags after the ID", func() {
+			Expect(run("show", "binding", "binding-1", "-json")).To(Succeed())
+			Expect(stdout.String()).To(MatchJSON(`{"id": "binding-1", "service_id": "nfs", "plan_id": "existing", "app_guid": "app-1"}`))
+		})
+
+		It("tells instances and bindings apart", func() {
+			Expect(run("show", "instance", "binding-1")).To(MatchError("binding-1 is not an instance"))
+			Expect(run("show", "binding", "instance-1")).To(MatchError("instance-1 is an instance, not a binding"))
+		})
+
+		It("needs exactly one ID", func() {
+			Expect(run("show", "instance")).To(MatchError("admin show instance takes 1 argument(s), not 0"))
+		})
+	})
+
+	Describe("delete", func() {
+		It("deletes once the ID is typed to confirm", func() {
+			stdin = strings.NewReader("instance-1\n")
+
+			Expect(run("delete", "instance", "instance-1")).To(Succeed())
+			Expect(stderr.String()).To(ContainSubstring("Deleting instance instance-1:"))
+			Expect(stderr.String()).To(ContainSubstring(`Type "instance-1" to confirm: `))
+			Expect(store.DeleteInstanceDetailsCallCount()).To(Equal(1))
+			Expect(store.DeleteInstanceDetailsArgsForCall(0)).To(Equal("instance-1"))
+			Expect(store.SaveCallCount()).To(Equal(1))
+			Expect(stdout.String()).To(Equal("deleted instance instance-1\n"))
+		})
+
+		It("changes nothing without confirmation", func() {
+			stdin = strings.NewReader("yes\n")
+
+			Expect(run("delete", "binding", "binding-1")).To(MatchError("not confirmed; nothing was changed"))
+			Expect(store.DeleteBindingDetailsCallCount()).To(BeZero())
+		})
+
+		It("deletes records that cannot be read, with -yes", func() {
+			Expect(run("delete", "binding", "-yes", "broken")).To(Succeed())
+			Expect(stderr.String()).To(ContainSubstring("Deleting binding broken, which cannot be read: reading binding broken: not found"))
+			Expect(store.DeleteBindingDetailsArgsForCall(0)).To(Equal("broken"))
+		})
+
+		It("does not delete an instance as a binding", func() {
+			Expect(run("delete", "binding", "instance-1", "-yes")).To(MatchError("instance-1 is an instance, not a binding"))
+			Expect(store.DeleteBindingDetailsCallCount()).To(BeZero())
+		})
+	})
+
+	Describe("repair", func() {
+		It("previews the change, then writes the instance once confirmed", func() {
+			stdin = strings.NewReader("instance-1\n")
+
+			Expect(run("repair", "instance", "instance-1", "-plan", "new-plan", "-share", "server/moved", "-offline", "-json")).To(Succeed())
+
+			Expect(stderr.String()).To(ContainSubstring("plan_id: existing -> new-plan"))
+			Expect(stderr.String()).To(ContainSubstring("share: server/export-1 -> server/moved"))
+			Expect(store.CreateInstanceDetailsCallCount()).To(Equal(1))
+			id, written := store.CreateInstanceDetailsArgsForCall(0)
+			Expect(id).To(Equal("instance-1"))
+			Expect(written.PlanID).To(Equal("new-plan"))
+			Expect(written.OrganizationGUID).To(Equal("org-1"))
+			Expect(written.ServiceFingerPrint).To(Equal(map[string]interface{}{"share": "server/moved", "uid": "1000"}))
+
+			var repaired admin.Instance
+			Expect(json.Unmarshal(stdout.Bytes(), &repaired)).To(Succeed())
+			Expect(repaired.Share).To(Equal("server/moved"))
+		})
+
+		It("keeps legacy fingerprints in their format", func() {
+			Expect(run("repair", "instance", "instance-2", "-share", "server/moved", "-offline", "-yes")).To(Succeed())
+			_, written := store.CreateInstanceDetailsArgsForCall(0)
+			Expect(written.ServiceFingerPrint).To(Equal("server/moved"))
+			Expect(stdout.String()).To(Equal("repaired instance instance-2\n"))
+		})
+
+		It("repairs bindings", func() {
+			Expect(run("repair", "binding", "binding-2", "-app", "app-3", "-offline", "-yes")).To(Succeed())
+			id, written := store.CreateBindingDetailsArgsForCall(0)
+			Expect(id).To(Equal("binding-2"))
+			Expect(written.BindResource.AppGuid).To(Equal("app-3"))
+			Expect(bindings["binding-2"].BindResource.AppGuid).To(Equal("app-2"), "the record read is not changed in place")
+		})
+
+		It("changes nothing without confirmation", func() {
+			Expect(run("repair", "instance", "instance-1", "-org", "org-2", "-offline")).To(MatchError("not confirmed; nothing was changed"))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("needs a change to make", func() {
+			Expect(run("repair", "instance", "instance-1")).To(MatchError(ContainSubstring("needs at least one of")))
+			Expect(run("repair", "instance", "instance-1", "-plan", "existing", "-offline", "-yes")).To(MatchError("instance instance-1 already has those values"))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("refuses to repair an instance without leases, unless the brokers are offline", func() {
+			Expect(run("repair", "instance", "instance-1", "-org", "org-2", "-yes")).To(MatchError(ContainSubstring("repair instance could race with brokers serving the store")))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("refuses to repair a binding without leases, unless the brokers are offline", func() {
+			Expect(run("repair", "binding", "binding-2", "-app", "app-3", "-yes")).To(MatchError(ContainSubstring("repair binding could race with brokers serving the store")))
+			Expect(store.CreateBindingDetailsCallCount()).To(BeZero())
+		})
+
+		Context("with leases", func() {
+			BeforeEach(func() {
+				leases = &leaseRecorder{}
+				store.CreateInstanceDetailsStub = func(id string, _ brokerstore.ServiceInstance) error {
+					leases.events = append(leases.events, "write "+id)
+					return nil
+				}
+			})
+
+			It("repairs the instance under its lease, without -offline", func() {
+				Expect(run("repair", "instance", "instance-1", "-org", "org-2", "-yes")).To(Succeed())
+				Expect(leases.events).To(Equal([]string{"acquire instance-1", "write instance-1", "release instance-1"}))
+			})
+
+			It("repairs a binding under its instance's lease", func() {
+				store.CreateBindingDetailsStub = func(id string, _ domain.BindDetails) error {
+					leases.events = append(leases.events, "write "+id)
+					return nil
+				}
+
+				Expect(run("repair", "binding", "binding-2", "-instance", "instance-2", "-app", "app-3", "-yes")).To(Succeed())
+				Expect(leases.events).To(Equal([]string{"acquire instance-2", "write binding-2", "release instance-2"}))
+			})
+
+			It("needs the binding's instance to lock", func() {
+				Expect(run("repair", "binding", "binding-2", "-app", "app-3", "-yes")).To(MatchError(ContainSubstring("needs -instance")))
+				Expect(run("repair", "binding", "binding-2", "-instance", "binding-1", "-app", "app-3", "-yes")).To(MatchError("binding-1 is not an instance"))
+				Expect(store.CreateBindingDetailsCallCount()).To(BeZero())
+				Expect(leases.events).To(Equal([]string{"acquire binding-1", "release binding-1"}))
+			})
+
+			It("does not repair the instance when its lease is held", func() {
+				leases.err = errors.New("the instance is locked by another operation")
+
+				Expect(run("repair", "instance", "instance-1", "-org", "org-2", "-yes")).To(MatchError("locking instance instance-1: the instance is locked by another operation"))
+				Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+			})
+		})
+	})
+
+	Describe("upgrade fingerprints", func() {
+		BeforeEach(func() {
+			instances["instance-3"] = brokerstore.ServiceInstance{ServiceID: "nfs", PlanID: "existing", ServiceFingerPrint: "server/export-3"}
+			store.CreateInstanceDetailsStub = func(id string, details brokerstore.ServiceInstance) error {
+				instances[id] = details
+				return nil
+			}
+		})
+
+		It("reports the legacy fingerprints with -dry-run, without changing them", func() {
+			Expect(run("upgrade", "fingerprints", "-dry-run")).To(Succeed())
+			Expect(stdout.String()).To(Equal("2 instance(s) have a legacy fingerprint, and 1 the current format\n  instance-2  server/export-2\n  instance-3  server/export-3\n"))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("rewrites them as maps once confirmed, keeping the rest of the instance", func() {
+			stdin = strings.NewReader("upgrade\n")
+
+			Expect(run("upgrade", "fingerprints", "-offline")).To(Succeed())
+			Expect(stderr.String()).To(ContainSubstring(`instance-2: "server/export-2" -> {"share": "server/export-2"}`))
+			Expect(stdout.String()).To(Equal("upgraded 2 of 2 legacy fingerprint(s)\n  instance-2\n  instance-3\n"))
+			Expect(instances["instance-2"]).To(Equal(brokerstore.ServiceInstance{
+				ServiceID: "nfs", PlanID: "existing", OrganizationGUID: "org-2", SpaceGUID: "space-2",
+				ServiceFingerPrint: map[string]interface{}{"share": "server/export-2"},
+			}))
+			Expect(store.SaveCallCount()).To(Equal(2))
+		})
+
+		It("finds nothing to do when run again", func() {
+			Expect(run("upgrade", "fingerprints", "-offline", "-yes")).To(Succeed())
+			stdout.Reset()
+
+			Expect(run("upgrade", "fingerprints", "-offline", "-yes", "-json")).To(Succeed())
+			var upgrade admin.FingerprintUpgrade
+			Expect(json.Unmarshal(stdout.Bytes(), &upgrade)).To(Succeed())
+			Expect(upgrade.Legacy).To(BeEmpty())
+			Expect(upgrade.Current).To(Equal(3))
+			Expect(store.CreateInstanceDetailsCallCount()).To(Equal(2))
+		})
+
+		It("skips instances deleted or upgraded since they were listed", func() {
+			all := map[string]brokerstore.ServiceInstance{}
+			for id, instance := range instances {
+				all[id] = instance
+			}
+			store.RetrieveAllInstanceDetailsStub = func() (map[string]brokerstore.ServiceInstance, error) { return all, nil }
+			delete(instances, "instance-2")
+			instances["instance-3"] = brokerstore.ServiceInstance{ServiceFingerPrint: map[string]interface{}{"share": "server/export-3"}}
+
+			upgrade, err := admin.New(lager.NewLogger("test"), store).UpgradeFingerprints(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgrade.Upgraded).To(BeEmpty())
+			Expect(upgrade.Errors).To(BeEmpty())
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("carries on past failures, and reports them", func() {
+			store.CreateInstanceDetailsStub = func(id string, details brokerstore.ServiceInstance) error {
+				if id == "instance-2" {
+					return errors.New("permission denied")
+				}
+				instances[id] = details
+				return nil
+			}
+
+			Expect(run("upgrade", "fingerprints", "-offline", "-yes")).To(MatchError("1 fingerprint(s) could not be upgraded; run it again to retry them"))
+			Expect(stdout.String()).To(ContainSubstring("instance-2 not upgraded: writing instance instance-2: permission denied"))
+			Expect(instances["instance-3"].ServiceFingerPrint).To(Equal(map[string]interface{}{"share": "server/export-3"}))
+		})
+
+		It("changes nothing without confirmation", func() {
+			Expect(run("upgrade", "fingerprints", "-offline")).To(MatchError("not confirmed; nothing was changed"))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("refuses to upgrade without leases, unless the brokers are offline", func() {
+			Expect(run("upgrade", "fingerprints", "-yes")).To(MatchError(ContainSubstring("upgrade fingerprints could race with brokers serving the store")))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("upgrades each instance under its lease, and carries on past one that is held", func() {
+			leases = &leaseRecorder{held: map[string]bool{"instance-2": true}}
+			store.CreateInstanceDetailsStub = func(id string, details brokerstore.ServiceInstance) error {
+				leases.events = append(leases.events, "write "+id)
+				instances[id] = details
+				return nil
+			}
+
+			Expect(run("upgrade", "fingerprints", "-yes")).To(MatchError("1 fingerprint(s) could not be upgraded; run it again to retry them"))
+			Expect(stdout.String()).To(ContainSubstring("instance-2 not upgraded: locking instance instance-2: held"))
+			Expect(leases.events).To(Equal([]string{"acquire instance-3", "write instance-3", "release instance-3"}))
+			Expect(instances["instance-2"].ServiceFingerPrint).To(Equal("server/export-2"))
+		})
+	})
+
+	Describe("rewrite shares", func() {
+		var (
+			finder   *fakeFinder
+			auditLog *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			instances["instance-3"] = brokerstore.ServiceInstance{ServiceID: "nfs", PlanID: "existing", ServiceFingerPrint: map[string]interface{}{"share": "server/export-10/app", "uid": "2000"}}
+			instances["instance-4"] = brokerstore.ServiceInstance{ServiceID: "nfs", PlanID: "existing", ServiceFingerPrint: map[string]interface{}{"share": "other/export-1"}}
+			store.CreateInstanceDetailsStub = func(id string, details brokerstore.ServiceInstance) error {
+				instances[id] = details
+				return nil
+			}
+			finder = &fakeFinder{bindings: map[string][]string{"instance-1": {"binding-1"}, "instance-3": {"binding-3"}}}
+			auditLog = &bytes.Buffer{}
+		})
+
+		rewrite := func(args ...string) error {
+			a := newAdmin()
+			a.AuditTo(audit.NewLog(auditLog, "", clock.NewClock()), "operator")
+			command := &admin.Command{Admin: a, Bindings: finder, Stdin: stdin, Stdout: stdout, Stderr: stderr}
+			return command.Run(append([]string{"rewrite", "shares"}, args...))
+		}
+
+		It("previews the changes with -dry-run, without making them", func() {
+			Expect(rewrite("-host", "server=newserver", "-dry-run")).To(Succeed())
+			Expect(stdout.String()).To(HavePrefix("3 instance(s) would have their share rewritten, and 1 are left as they are\n"))
+			Expect(stdout.String()).To(MatchRegexp(`(?m)^  instance-2\s+server/export-2\s+->\s+newserver/export-2$`))
+			Expect(stdout.String()).To(ContainSubstring("2 binding(s) mount the old share until they are unbound and bound again"))
+			Expect(finder.asked).To(Equal([]string{"instance-1", "instance-2", "instance-3"}))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+			Expect(auditLog.Len()).To(BeZero())
+		})
+
+		It("uses the first rule that matches, and matches whole path components", func() {
+			Expect(rewrite("-prefix", "server/export-1/=filer/a", "-host", "server=newserver", "-dry-run", "-json")).To(Succeed())
+
+			var preview admin.ShareRewrite
+			Expect(json.Unmarshal(stdout.Bytes(), &preview)).To(Succeed())
+			Expect(preview.Changes).To(Equal([]admin.ShareChange{
+				{InstanceID: "instance-1", From: "server/export-1", To: "filer/a", Rule: "prefix server/export-1=filer/a"},
+				{InstanceID: "instance-2", From: "server/export-2", To: "newserver/export-2", Rule: "host server=newserver"},
+				{InstanceID: "instance-3", From: "server/export-10/app", To: "newserver/export-10/app", Rule: "host server=newserver"},
+			}))
+		})
+
+		It("shows a diff, then rewrites each instance once confirmed, keeping the rest of its fingerprint", func() {
+			stdin = strings.NewReader("rewrite\n")
+
+			Expect(rewrite("-prefix", "server/export-10=filer/ten", "-offline")).To(Succeed())
+			Expect(stderr.String()).To(ContainSubstring("Rewriting the share of 1 instance(s):\n  instance-3 (prefix server/export-10=filer/ten)\n  - server/export-10/app\n  + filer/ten/app\n"))
+			Expect(instances["instance-3"].ServiceFingerPrint).To(Equal(map[string]interface{}{"share": "filer/ten/app", "uid": "2000"}))
+			Expect(store.SaveCallCount()).To(Equal(1))
+			Expect(stdout.String()).To(ContainSubstring("rewrote 1 of 1 share(s)\n  instance-3\n"))
+			Expect(stdout.String()).To(MatchRegexp(`(?m)^  binding-3\s+instance-3\s*$`))
+		})
+
+		It("records each rewrite in the audit log", func() {
+			Expect(rewrite("-host", "server=newserver", "-offline", "-yes")).To(Succeed())
+
+			summary, err := audit.Verify(bytes.NewReader(auditLog.Bytes()))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Entries).To(Equal(4))
+
+			var entries []audit.Entry
+			for _, line := range strings.Split(strings.TrimSpace(auditLog.String()), "\n") {
+				var entry audit.Entry
+				Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+				entries = append(entries, entry)
+			}
+			Expect(entries[0].Operation).To(Equal(audit.OperationStart))
+			Expect(entries[1].Operation).To(Equal(audit.OperationRewriteShare))
+			Expect(entries[1].InstanceID).To(Equal("instance-1"))
+			Expect(entries[1].Platform).To(Equal("admin"))
+			Expect(entries[1].User).To(Equal("operator"))
+			Expect(entries[1].PreviousShare).To(Equal("server/export-1"))
+			Expect(entries[1].Share).To(Equal("newserver/export-1"))
+			Expect(entries[1].Outcome).To(Equal(audit.OutcomeSuccess))
+			Expect(instances["instance-2"].ServiceFingerPrint).To(Equal("newserver/export-2"), "legacy fingerprints keep their format")
+		})
+
+		It("leaves alone, and reports, an instance whose share changed since the preview", func() {
+			all := map[string]brokerstore.ServiceInstance{}
+			for id, instance := range instances {
+				all[id] = instance
+			}
+			store.RetrieveAllInstanceDetailsStub = func() (map[string]brokerstore.ServiceInstance, error) { return all, nil }
+			instances["instance-1"] = brokerstore.ServiceInstance{ServiceFingerPrint: map[string]interface{}{"share": "elsewhere/export"}}
+			delete(instances, "instance-2")
+
+			Expect(rewrite("-host", "server=newserver", "-offline", "-yes")).To(MatchError("1 share(s) could not be rewritten; run it again to retry them"))
+			Expect(stdout.String()).To(ContainSubstring(`instance-1 not rewritten: its share has changed to "elsewhere/export" since it was listed`))
+			Expect(stdout.String()).To(ContainSubstring("rewrote 1 of 3 share(s)\n  instance-3\n"))
+			Expect(instances["instance-1"].ServiceFingerPrint).To(Equal(map[string]interface{}{"share": "elsewhere/export"}))
+			Expect(auditLog.String()).To(ContainSubstring(`"outcome":"failure"`))
+		})
+
+		It("stops when a rewrite cannot be recorded", func() {
+			a := admin.New(lager.NewLogger("test"), store)
+			a.AuditTo(audit.NewLog(failingWriter{}, "", clock.NewClock()), "operator")
+
+			rewritten, err := a.RewriteShares([]admin.ShareRule{{Kind: admin.RuleHost, From: "server", To: "newserver"}}, false)
+			Expect(err).To(MatchError(ContainSubstring("stopped, as the rewrite of instance instance-1 could not be recorded in the audit log")))
+			Expect(rewritten.Rewritten).To(Equal([]string{"instance-1"}))
+			Expect(store.CreateInstanceDetailsCallCount()).To(Equal(1))
+		})
+
+		It("says how to list the bindings when Cloud Controller is not configured", func() {
+			command := &admin.Command{Admin: admin.New(lager.NewLogger("test"), store), Stdin: stdin, Stdout: stdout, Stderr: stderr}
+			Expect(command.Run([]string{"rewrite", "shares", "-host", "server=newserver", "-dry-run"})).To(Succeed())
+			Expect(stdout.String()).To(ContainSubstring("configure cloud_controller to list their bindings"))
+		})
+
+		It("reports a failure to list the bindings after rewriting", func() {
+			finder.err = errors.New("cloud controller unavailable")
+
+			Expect(rewrite("-host", "other=newother", "-offline", "-yes", "-json")).To(MatchError("the bindings to re-create could not be listed: cloud controller unavailable"))
+			Expect(instances["instance-4"].ServiceFingerPrint).To(Equal(map[string]interface{}{"share": "newother/export-1"}))
+			Expect(stdout.String()).To(ContainSubstring(`"rebind": null`))
+		})
+
+		It("needs valid rules", func() {
+			Expect(rewrite()).To(MatchError("rewrite shares needs at least one -host or -prefix rule"))
+			Expect(rewrite("-host", "server/export=other")).To(MatchError(ContainSubstring(`a host rule renames a server, and "server/export=other" has a path`)))
+			Expect(rewrite("-prefix", "server/export")).To(MatchError(ContainSubstring(`a prefix rule is OLD=NEW, not "server/export"`)))
+		})
+
+		It("changes nothing without confirmation", func() {
+			Expect(rewrite("-host", "server=newserver", "-offline")).To(MatchError("not confirmed; nothing was changed"))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+		})
+
+		It("refuses to rewrite without leases, unless the brokers are offline", func() {
+			Expect(rewrite("-host", "server=newserver", "-yes")).To(MatchError(ContainSubstring("rewrite shares could race with brokers serving the store")))
+			Expect(store.CreateInstanceDetailsCallCount()).To(BeZero())
+			Expect(auditLog.Len()).To(BeZero())
+		})
+
+		It("rewrites each instance under its lease, and reports one that is held", func() {
+			leases = &leaseRecorder{held: map[string]bool{"instance-1": true}}
+			store.CreateInstanceDetailsStub = func(id string, details brokerstore.ServiceInstance) error {
+				leases.events = append(leases.events, "write "+id)
+				instances[id] = details
+				return nil
+			}
+
+			Expect(rewrite("-host", "server=newserver", "-yes")).To(MatchError("1 share(s) could not be rewritten; run it again to retry them"))
+			Expect(stdout.String()).To(ContainSubstring("instance-1 not rewritten: locking instance instance-1: held"))
+			Expect(leases.events).To(Equal([]string{
+				"acquire instance-2", "write instance-2", "release instance-2",
+				"acquire instance-3", "write instance-3", "release instance-3",
+			}))
+			Expect(instances["instance-1"].ServiceFingerPrint).To(Equal(map[string]interface{}{"share": "server/export-1", "uid": "1000"}))
+		})
+	})
+
+	It("explains its usage", func() {
+		Expect(run("list")).To(MatchError(ContainSubstring(admin.Usage)))
+		Expect(run("frobnicate", "instance")).To(MatchError(ContainSubstring(admin.Usage)))
+	})
+})
+
+// fakeFinder answers with fixed bindings, keeping only the asked instances'.
+type fakeFinder struct {
+	bindings map[string][]string
+	err      error
+	asked    []string
+}
+
+func (f *fakeFinder) InstanceBindings(_ context.Context, instanceIDs []string) (map[string][]string, error) {
+	f.asked = instanceIDs
+	if f.err != nil {
+		return nil, f.err
+	}
+	found := map[string][]string{}
+	for _, id := range instanceIDs {
+		if bindings, ok := f.bindings[id]; ok {
+			found[id] = bindings
+		}
+	}
+	return found, nil
+}
+
+// leaseRecorder grants every lease, unless told to fail or that the lease is
+// held, and notes each acquire and release.
+type leaseRecorder struct {
+	err    error
+	held   map[string]bool
+	events []string
+}
+
+func (l *leaseRecorder) Acquire(_ context.Context, instanceID string) (func(), error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	if l.held[instanceID] {
+		return nil, errors.New("held")
+	}
+	l.events = append(l.events, "acquire "+instanceID)
+	return func() { l.events = append(l.events, "release "+instanceID) }, nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
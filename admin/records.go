@@ -0,0 +1,98 @@
+// Package admin inspects and repairs the instances and bindings in a broker
+// store, for operators diagnosing records that no longer match what Cloud
+// Controller expects.
+package admin
+
+import (
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// Instance is a service instance as the store records it.
+type Instance struct {
+	ID               string      `json:"id"`
+	ServiceID        string      `json:"service_id"`
+	PlanID           string      `json:"plan_id"`
+	OrganizationGUID string      `json:"organization_guid"`
+	SpaceGUID        string      `json:"space_guid"`
+	Share            string      `json:"share"`
+	Fingerprint      interface{} `json:"fingerprint"`
+}
+
+// Binding is a service binding as the store records it. The store keeps
+// only a hash of the bind parameters, and not the instance that was bound.
+type Binding struct {
+	ID         string `json:"id"`
+	ServiceID  string `json:"service_id"`
+	PlanID     string `json:"plan_id"`
+	AppGUID    string `json:"app_guid"`
+	Parameters string `json:"parameters,omitempty"`
+}
+
+func newInstance(id string, instance brokerstore.ServiceInstance) Instance {
+	return Instance{
+		ID:               id,
+		ServiceID:        instance.ServiceID,
+		PlanID:           instance.PlanID,
+		OrganizationGUID: instance.OrganizationGUID,
+		SpaceGUID:        instance.SpaceGUID,
+		Share:            shareOf(instance.ServiceFingerPrint),
+		Fingerprint:      instance.ServiceFingerPrint,
+	}
+}
+
+func newBinding(id string, binding domain.BindDetails) Binding {
+	appGUID := binding.AppGUID
+	if binding.BindResource != nil && binding.BindResource.AppGuid != "" {
+		appGUID = binding.BindResource.AppGuid
+	}
+	return Binding{
+		ID:         id,
+		ServiceID:  binding.ServiceID,
+		PlanID:     binding.PlanID,
+		AppGUID:    appGUID,
+		Parameters: string(binding.RawParameters),
+	}
+}
+
+// shareOf reads the share from a fingerprint in the current map format, or
+// the legacy bare string.
+func shareOf(fingerprint interface{}) string {
+	switch fingerprint := fingerprint.(type) {
+	case map[string]interface{}:
+		share, _ := fingerprint["share"].(string)
+		return share
+	case string:
+		return fingerprint
+	}
+	return ""
+}
+
+// Filter selects instances. Empty fields match everything.
+type Filter struct {
+	OrganizationGUID string
+	SpaceGUID        string
+	Share            string
+	PlanID           string
+}
+
+func (f Filter) matchesInstance(instance Instance) bool {
+	return matches(f.OrganizationGUID, instance.OrganizationGUID) &&
+		matches(f.SpaceGUID, instance.SpaceGUID) &&
+		matches(f.Share, instance.Share) &&
+		matches(f.PlanID, instance.PlanID)
+}
+
+// onlyPlan reports whether the filter can be applied to bindings, which do
+// not record their org, space or share.
+func (f Filter) onlyPlan() bool {
+	return f.OrganizationGUID == "" && f.SpaceGUID == "" && f.Share == ""
+}
+
+func (f Filter) matchesBinding(binding Binding) bool {
+	return matches(f.PlanID, binding.PlanID)
+}
+
+func matches(want, got string) bool {
+	return want == "" || want == got
+}
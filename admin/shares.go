@@ -0,0 +1,261 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/audit"
+)
+
+// Kinds of share rule.
+const (
+	RuleHost   = "host"
+	RulePrefix = "prefix"
+)
+
+// ShareRule maps shares on an old server or export to a new one. A host rule
+// renames the server of every share on it; a prefix rule moves every share
+// at or below a path, matching whole path components.
+type ShareRule struct {
+	Kind string `json:"kind"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ParseShareRule reads a rule given as OLD=NEW.
+func ParseShareRule(kind, rule string) (ShareRule, error) {
+	from, to, ok := strings.Cut(rule, "=")
+	if kind == RulePrefix {
+		from, to = strings.TrimSuffix(from, "/"), strings.TrimSuffix(to, "/")
+	}
+	if !ok || from == "" || to == "" {
+		return ShareRule{}, fmt.Errorf("a %s rule is OLD=NEW, not %q", kind, rule)
+	}
+	if kind == RuleHost && (strings.Contains(from, "/") || strings.Contains(to, "/")) {
+		return ShareRule{}, fmt.Errorf("a host rule renames a server, and %q has a path", rule)
+	}
+	return ShareRule{Kind: kind, From: from, To: to}, nil
+}
+
+func (r ShareRule) String() string {
+	return r.Kind + " " + r.From + "=" + r.To
+}
+
+// apply returns the share the rule maps share to, if it matches.
+func (r ShareRule) apply(share string) (string, bool) {
+	switch r.Kind {
+	case RuleHost:
+		host, path, hasPath := strings.Cut(share, "/")
+		if host != r.From {
+			return "", false
+		}
+		if !hasPath {
+			return r.To, true
+		}
+		return r.To + "/" + path, true
+	case RulePrefix:
+		if share == r.From || strings.HasPrefix(share, r.From+"/") {
+			return r.To + strings.TrimPrefix(share, r.From), true
+		}
+	}
+	return "", false
+}
+
+// ShareChange is the share of an instance, and what a rule maps it to.
+type ShareChange struct {
+	InstanceID string `json:"instance_id"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Rule       string `json:"rule"`
+}
+
+// StaleBinding is a binding made before its instance's share was rewritten.
+// Its app mounts the old share until it is unbound and bound again.
+type StaleBinding struct {
+	ID         string `json:"id"`
+	InstanceID string `json:"instance_id"`
+	AppGUID    string `json:"app_guid,omitempty"`
+}
+
+// ShareRewrite reports the shares that rules change, and those rewritten.
+type ShareRewrite struct {
+	DryRun    bool          `json:"dry_run"`
+	Changes   []ShareChange `json:"changes"`
+	Rewritten []string      `json:"rewritten"`
+	// Unchanged counts the instances no rule matched.
+	Unchanged int `json:"unchanged"`
+	// Errors are keyed by instance ID.
+	Errors map[string]string `json:"errors,omitempty"`
+	// Rebind lists the bindings to re-create. It is nil when they could
+	// not be looked up, as Cloud Controller was not configured or failed.
+	Rebind      []StaleBinding `json:"rebind"`
+	RebindError string         `json:"rebind_error,omitempty"`
+}
+
+// BindingFinder looks up the bindings of instances, which the store does
+// not record, by instance ID.
+type BindingFinder interface {
+	InstanceBindings(ctx context.Context, instanceIDs []string) (map[string][]string, error)
+}
+
+// AuditTo records each share rewrite in log, as made by user. Share
+// rewrites are not recorded otherwise.
+func (a *Admin) AuditTo(log *audit.Log, user string) {
+	a.audit, a.user = log, user
+}
+
+// RewriteShares maps the share of each instance with the first rule that
+// matches it, and writes the changed instances unless dryRun is set. Each
+// instance is changed on its own, with a single write, and read again just
+// before, under its lease when the admin has leases: one deleted since it
+// was listed is skipped, and one whose share has changed is left alone and
+// reported. The rest of the fingerprint, and its format, are kept.
+//
+// When an audit log is set, each write is recorded in it, and a failure to
+// record one stops the rewrite.
+func (a *Admin) RewriteShares(rules []ShareRule, dryRun bool) (ShareRewrite, error) {
+	rewrite := ShareRewrite{DryRun: dryRun, Changes: []ShareChange{}, Rewritten: []string{}}
+
+	all, err := a.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return rewrite, err
+	}
+	for id, details := range all {
+		change, ok := changeFor(id, shareOf(details.ServiceFingerPrint), rules)
+		if !ok {
+			rewrite.Unchanged++
+			continue
+		}
+		rewrite.Changes = append(rewrite.Changes, change)
+	}
+	sort.Slice(rewrite.Changes, func(i, j int) bool { return rewrite.Changes[i].InstanceID < rewrite.Changes[j].InstanceID })
+	if dryRun || len(rewrite.Changes) == 0 {
+		return rewrite, nil
+	}
+
+	logger := a.logger.Session("rewrite-shares")
+	logger.Info("start", lager.Data{"changes": len(rewrite.Changes)})
+	defer logger.Info("end")
+
+	for _, change := range rewrite.Changes {
+		rewritten, err := a.rewriteShare(logger, change)
+		if err != nil {
+			logger.Error("rewrite-failed", err, lager.Data{"instance_id": change.InstanceID})
+			if rewrite.Errors == nil {
+				rewrite.Errors = map[string]string{}
+			}
+			rewrite.Errors[change.InstanceID] = err.Error()
+		}
+		if rewritten {
+			rewrite.Rewritten = append(rewrite.Rewritten, change.InstanceID)
+		}
+
+		if auditErr := a.recordRewrite(change, rewritten, err); auditErr != nil {
+			logger.Error("audit-failed", auditErr, lager.Data{"instance_id": change.InstanceID})
+			return rewrite, fmt.Errorf("stopped, as the rewrite of instance %s could not be recorded in the audit log: %w", change.InstanceID, auditErr)
+		}
+	}
+	return rewrite, nil
+}
+
+func changeFor(id, share string, rules []ShareRule) (ShareChange, bool) {
+	if share == "" {
+		return ShareChange{}, false
+	}
+	for _, rule := range rules {
+		if to, ok := rule.apply(share); ok {
+			return ShareChange{InstanceID: id, From: share, To: to, Rule: rule.String()}, to != share
+		}
+	}
+	return ShareChange{}, false
+}
+
+func (a *Admin) rewriteShare(logger lager.Logger, change ShareChange) (bool, error) {
+	release, err := a.lease(change.InstanceID)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	details, err := a.store.RetrieveInstanceDetails(change.InstanceID)
+	if err != nil || details.ServiceFingerPrint == nil {
+		// deleted since it was listed
+		return false, nil
+	}
+	if share := shareOf(details.ServiceFingerPrint); share != change.From {
+		return false, fmt.Errorf("its share has changed to %q since it was listed", share)
+	}
+
+	if details.ServiceFingerPrint, err = withShare(details.ServiceFingerPrint, change.To); err != nil {
+		return false, fmt.Errorf("instance %s: %w", change.InstanceID, err)
+	}
+	if err := a.store.CreateInstanceDetails(change.InstanceID, details); err != nil {
+		return false, fmt.Errorf("writing instance %s: %w", change.InstanceID, err)
+	}
+	if err := a.store.Save(logger); err != nil {
+		return false, err
+	}
+	logger.Info("rewritten", lager.Data{"instance_id": change.InstanceID, "from": change.From, "to": change.To})
+	return true, nil
+}
+
+// recordRewrite records an attempted write. The first entry an admin
+// records begins with a start entry, as a syslog log begins a new chain.
+func (a *Admin) recordRewrite(change ShareChange, rewritten bool, err error) error {
+	if a.audit == nil || (!rewritten && err == nil) {
+		return nil
+	}
+	if !a.started {
+		if err := a.audit.Record(audit.Entry{Operation: audit.OperationStart, Platform: auditPlatform, User: a.user}); err != nil {
+			return err
+		}
+		a.started = true
+	}
+
+	entry := audit.Entry{
+		Operation:     audit.OperationRewriteShare,
+		InstanceID:    change.InstanceID,
+		Platform:      auditPlatform,
+		User:          a.user,
+		Share:         change.To,
+		PreviousShare: change.From,
+		Outcome:       audit.OutcomeSuccess,
+	}
+	if err != nil {
+		entry.Outcome, entry.Error = audit.OutcomeFailure, err.Error()
+	}
+	return a.audit.Record(entry)
+}
+
+// BindingsToRecreate looks up the bindings of instances with finder, and
+// their apps in the store, ordered by instance and binding.
+func (a *Admin) BindingsToRecreate(ctx context.Context, finder BindingFinder, instanceIDs []string) ([]StaleBinding, error) {
+	stale := []StaleBinding{}
+	if len(instanceIDs) == 0 {
+		return stale, nil
+	}
+
+	found, err := finder.InstanceBindings(ctx, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+	for instanceID, bindingIDs := range found {
+		for _, id := range bindingIDs {
+			binding := StaleBinding{ID: id, InstanceID: instanceID}
+			if details, err := a.retrieveBinding(id); err == nil {
+				binding.AppGUID = newBinding(id, details).AppGUID
+			}
+			stale = append(stale, binding)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].InstanceID != stale[j].InstanceID {
+			return stale[i].InstanceID < stale[j].InstanceID
+		}
+		return stale[i].ID < stale[j].ID
+	})
+	return stale, nil
+}
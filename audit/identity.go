@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Redacted replaces the values of secret mount options.
+const Redacted = "*REDACTED*"
+
+// ParseOriginatingIdentity decodes an X-Broker-API-Originating-Identity
+// header, "<platform> <base64 JSON>", into the platform and the user: the
+// user_id sent by Cloud Foundry, or the username sent by Kubernetes. A value
+// that cannot be decoded is returned whole as the user, so that it is still
+// recorded.
+func ParseOriginatingIdentity(header string) (platform, user string) {
+	platform, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return "", header
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return platform, encoded
+	}
+
+	var identity struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(decoded, &identity); err != nil {
+		return platform, string(decoded)
+	}
+	if identity.UserID != "" {
+		return platform, identity.UserID
+	}
+	return platform, identity.Username
+}
+
+// Redact returns a copy of options with the values of any whose name
+// suggests a secret replaced.
+func Redact(options map[string]interface{}) map[string]interface{} {
+	if options == nil {
+		return nil
+	}
+
+	redacted := make(map[string]interface{}, len(options))
+	for key, value := range options {
+		if isSecret(key) {
+			value = Redacted
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"password", "secret", "token", "credential"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
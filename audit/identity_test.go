@@ -0,0 +1,53 @@
+package audit_test
+
+import (
+	"encoding/base64"
+
+	"code.cloudfoundry.org/nfsbroker/audit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseOriginatingIdentity", func() {
+	encode := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	It("reads the Cloud Foundry user ID", func() {
+		platform, user := audit.ParseOriginatingIdentity("cloudfoundry " + encode(`{"user_id": "683ea748-3092-4ff4-b656-39cacc4d5360"}`))
+		Expect(platform).To(Equal("cloudfoundry"))
+		Expect(user).To(Equal("683ea748-3092-4ff4-b656-39cacc4d5360"))
+	})
+
+	It("reads the Kubernetes username", func() {
+		platform, user := audit.ParseOriginatingIdentity("kubernetes " + encode(`{"username": "duke", "uid": "c2dde242", "groups": ["admin"]}`))
+		Expect(platform).To(Equal("kubernetes"))
+		Expect(user).To(Equal("duke"))
+	})
+
+	It("keeps identities it cannot decode", func() {
+		platform, user := audit.ParseOriginatingIdentity("cloudfoundry not-base64!")
+		Expect(platform).To(Equal("cloudfoundry"))
+		Expect(user).To(Equal("not-base64!"))
+	})
+
+	It("returns nothing without an identity", func() {
+		platform, user := audit.ParseOriginatingIdentity("")
+		Expect(platform).To(BeEmpty())
+		Expect(user).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Redact", func() {
+	It("hides secret options and keeps the rest", func() {
+		options := map[string]interface{}{"source": "nfs://server/export", "uid": "1000", "password": "hunter2", "client_secret": "s"}
+
+		Expect(audit.Redact(options)).To(Equal(map[string]interface{}{
+			"source":        "nfs://server/export",
+			"uid":           "1000",
+			"password":      audit.Redacted,
+			"client_secret": audit.Redacted,
+		}))
+		Expect(options["password"]).To(Equal("hunter2"))
+	})
+})
@@ -0,0 +1,236 @@
+// Package audit records the operations that change broker state as a
+// tamper-evident stream of JSON lines. Each line carries the hash of the line
+// before it, so that editing, removing or reordering lines breaks the chain
+// from that point on, which Verify detects.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// Operations recorded in the log. OperationStart is recorded each time the
+// broker or an admin command that writes to the log starts, and is the only
+// entry that may begin a new chain. OperationRewriteShare is an admin
+// changing the share of an instance.
+const (
+	OperationStart        = "start"
+	OperationProvision    = "provision"
+	OperationUpdate       = "update"
+	OperationDeprovision  = "deprovision"
+	OperationBind         = "bind"
+	OperationUnbind       = "unbind"
+	OperationRewriteShare = "rewrite-share"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// SyslogTarget selects the local syslog daemon instead of a file.
+const SyslogTarget = "syslog"
+
+type Entry struct {
+	Time             time.Time              `json:"time"`
+	Operation        string                 `json:"operation"`
+	InstanceID       string                 `json:"instance_id,omitempty"`
+	BindingID        string                 `json:"binding_id,omitempty"`
+	Platform         string                 `json:"platform,omitempty"`
+	User             string                 `json:"user,omitempty"`
+	OrganizationGUID string                 `json:"organization_guid,omitempty"`
+	SpaceGUID        string                 `json:"space_guid,omitempty"`
+	ServiceID        string                 `json:"service_id,omitempty"`
+	PlanID           string                 `json:"plan_id,omitempty"`
+	Share            string                 `json:"share,omitempty"`
+	PreviousShare    string                 `json:"previous_share,omitempty"`
+	MountOptions     map[string]interface{} `json:"mount_options,omitempty"`
+	Outcome          string                 `json:"outcome,omitempty"`
+	Error            string                 `json:"error,omitempty"`
+	PreviousHash     string                 `json:"previous_hash"`
+	Hash             string                 `json:"hash,omitempty"`
+}
+
+// Log appends entries to a writer, chaining each to the one before.
+type Log struct {
+	clock clock.Clock
+
+	lock   sync.Mutex
+	writer io.Writer
+	last   string
+	// file is set when the log is a file that other processes may also
+	// append to.
+	file *os.File
+}
+
+// NewLog starts writing after the entry with hash previousHash, or a new
+// chain when it is empty.
+func NewLog(writer io.Writer, previousHash string, clock clock.Clock) *Log {
+	return &Log{writer: writer, last: previousHash, clock: clock}
+}
+
+// Open returns a log that continues the chain in the file at target,
+// creating the file if needed, or when target is SyslogTarget, a log that
+// starts a new chain in the local syslog. A file may be shared with other
+// processes, such as the broker and an admin command: each entry is written
+// under a lock on the file, and chained to its last line, whoever wrote it.
+func Open(target string, clock clock.Clock) (*Log, io.Closer, error) {
+	if target == SyslogTarget {
+		writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "nfsbroker-audit")
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening syslog: %w", err)
+		}
+		return NewLog(writer, "", clock), writer, nil
+	}
+
+	last, err := lastHash(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot continue audit log %s: %w", target, err)
+	}
+
+	/* #nosec */
+	file, err := os.OpenFile(target, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	log := NewLog(file, last, clock)
+	log.file = file
+	return log, file, nil
+}
+
+// Record stamps entry with the time and its place in the chain, and writes
+// it as a single line.
+func (l *Log) Record(entry Entry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file != nil {
+		fd := int(l.file.Fd())
+		if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+			return fmt.Errorf("locking audit log: %w", err)
+		}
+		defer func() { _ = syscall.Flock(fd, syscall.LOCK_UN) }()
+
+		last, err := tailHash(l.file)
+		if err != nil {
+			return fmt.Errorf("cannot continue audit log: %w", err)
+		}
+		l.last = last
+	}
+
+	entry.Time = l.clock.Now().UTC()
+	entry.PreviousHash = l.last
+	entry.Hash = ""
+
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	hash := hashOf(body)
+
+	line := append(body[:len(body)-1], `,"hash":"`+hash+`"}`+"\n"...)
+	if _, err := l.writer.Write(line); err != nil {
+		return err
+	}
+	l.last = hash
+	return nil
+}
+
+// hashOf covers the line as written, less its own hash, so that verifying
+// it does not depend on how the JSON would be encoded again.
+func hashOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// split separates a line into the entry it records and the hashed body,
+// ignoring anything before the JSON, such as a syslog prefix.
+func split(line string) (Entry, []byte, error) {
+	var entry Entry
+
+	start := strings.Index(line, "{")
+	if start < 0 {
+		return entry, nil, errors.New("no entry found")
+	}
+	line = strings.TrimSpace(line[start:])
+
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return entry, nil, err
+	}
+	suffix := `,"hash":"` + entry.Hash + `"}`
+	if entry.Hash == "" || !strings.HasSuffix(line, suffix) {
+		return entry, nil, errors.New("hash missing or not last")
+	}
+	return entry, []byte(strings.TrimSuffix(line, suffix) + "}"), nil
+}
+
+func lastHash(path string) (string, error) {
+	/* #nosec */
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return tailHash(file)
+}
+
+// tailHash returns the hash of the last entry in file, reading back from
+// the end only as far as the start of that entry.
+func tailHash(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	for n := int64(4096); ; n *= 2 {
+		offset := size - n
+		if offset < 0 {
+			offset = 0
+		}
+		tail := make([]byte, size-offset)
+		if _, err := file.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+
+		tail = bytes.TrimRight(tail, " \t\r\n")
+		newline := bytes.LastIndexByte(tail, '\n')
+		if newline < 0 && offset > 0 {
+			continue
+		}
+		last := string(tail[newline+1:])
+		if strings.TrimSpace(last) == "" {
+			return "", nil
+		}
+
+		entry, _, err := split(last)
+		if err != nil {
+			return "", fmt.Errorf("last entry unreadable: %w", err)
+		}
+		return entry.Hash, nil
+	}
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
@@ -0,0 +1,187 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/nfsbroker/audit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fixedClock always reads the same time.
+type fixedClock struct {
+	clock.Clock
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+var _ = Describe("Log", func() {
+	var (
+		buffer *bytes.Buffer
+		log    *audit.Log
+		clk    fixedClock
+	)
+
+	BeforeEach(func() {
+		buffer = &bytes.Buffer{}
+		clk = fixedClock{now: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}
+		log = audit.NewLog(buffer, "", clk)
+
+		Expect(log.Record(audit.Entry{Operation: audit.OperationStart})).To(Succeed())
+		Expect(log.Record(audit.Entry{
+			Operation:        audit.OperationProvision,
+			InstanceID:       "instance-1",
+			Platform:         "cloudfoundry",
+			User:             "user-1",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			Share:            "server/export",
+			Outcome:          audit.OutcomeSuccess,
+		})).To(Succeed())
+		Expect(log.Record(audit.Entry{
+			Operation:    audit.OperationBind,
+			InstanceID:   "instance-1",
+			BindingID:    "binding-1",
+			MountOptions: map[string]interface{}{"source": "nfs://server/export", "uid": "1000"},
+			Outcome:      audit.OutcomeFailure,
+			Error:        "credhub unavailable",
+		})).To(Succeed())
+	})
+
+	lines := func() []string {
+		return strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	}
+
+	It("writes one JSON entry per line, each chained to the one before", func() {
+		Expect(lines()).To(HaveLen(3))
+
+		var entries []audit.Entry
+		for _, line := range lines() {
+			var entry audit.Entry
+			Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+			entries = append(entries, entry)
+		}
+
+		Expect(entries[0].PreviousHash).To(BeEmpty())
+		Expect(entries[1].PreviousHash).To(Equal(entries[0].Hash))
+		Expect(entries[2].PreviousHash).To(Equal(entries[1].Hash))
+		Expect(entries[1].Time).To(Equal(clk.now))
+		Expect(entries[1].User).To(Equal("user-1"))
+		Expect(entries[2].MountOptions).To(HaveKeyWithValue("uid", "1000"))
+	})
+
+	Describe("Verify", func() {
+		It("verifies an untouched log", func() {
+			summary, err := audit.Verify(buffer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Entries).To(Equal(3))
+			Expect(summary.Chains).To(Equal(1))
+			Expect(summary.LastHash).To(HaveLen(64))
+		})
+
+		It("detects an edited entry", func() {
+			tampered := strings.Replace(buffer.String(), `"user":"user-1"`, `"user":"user-2"`, 1)
+
+			_, err := audit.Verify(strings.NewReader(tampered))
+			Expect(err).To(MatchError("line 2: hash does not match the entry"))
+		})
+
+		It("detects a removed entry", func() {
+			l := lines()
+			_, err := audit.Verify(strings.NewReader(l[0] + "\n" + l[2] + "\n"))
+			Expect(err).To(MatchError("line 2: previous_hash does not match the entry before it"))
+		})
+
+		It("detects reordered entries", func() {
+			l := lines()
+			_, err := audit.Verify(strings.NewReader(l[0] + "\n" + l[2] + "\n" + l[1] + "\n"))
+			Expect(err).To(MatchError("line 2: previous_hash does not match the entry before it"))
+		})
+
+		It("accepts a log whose oldest entries have been rotated away", func() {
+			l := lines()
+			summary, err := audit.Verify(strings.NewReader(l[1] + "\n" + l[2] + "\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Entries).To(Equal(2))
+		})
+
+		It("reads entries with a syslog prefix, and counts each chain", func() {
+			Expect(audit.NewLog(buffer, "", clk).Record(audit.Entry{Operation: audit.OperationStart})).To(Succeed())
+
+			var prefixed strings.Builder
+			for _, line := range lines() {
+				prefixed.WriteString("Oct 19 12:00:00 broker nfsbroker-audit[42]: " + line + "\n")
+			}
+
+			summary, err := audit.Verify(strings.NewReader(prefixed.String()))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Entries).To(Equal(4))
+			Expect(summary.Chains).To(Equal(2))
+		})
+
+		It("only lets a start entry begin a new chain", func() {
+			Expect(audit.NewLog(buffer, "", clk).Record(audit.Entry{Operation: audit.OperationUnbind})).To(Succeed())
+
+			_, err := audit.Verify(buffer)
+			Expect(err).To(MatchError("line 4: previous_hash does not match the entry before it"))
+		})
+	})
+
+	Describe("Open", func() {
+		It("continues the chain in an existing file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+			Expect(os.WriteFile(path, buffer.Bytes(), 0600)).To(Succeed())
+
+			fileLog, closer, err := audit.Open(path, clk)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileLog.Record(audit.Entry{Operation: audit.OperationStart})).To(Succeed())
+			Expect(closer.Close()).To(Succeed())
+
+			contents, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			summary, err := audit.Verify(bytes.NewReader(contents))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Entries).To(Equal(4))
+			Expect(summary.Chains).To(Equal(1))
+		})
+
+		It("keeps one chain when several logs append to the same file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+			brokerLog, brokerCloser, err := audit.Open(path, clk)
+			Expect(err).NotTo(HaveOccurred())
+			defer brokerCloser.Close()
+			adminLog, adminCloser, err := audit.Open(path, clk)
+			Expect(err).NotTo(HaveOccurred())
+			defer adminCloser.Close()
+
+			Expect(brokerLog.Record(audit.Entry{Operation: audit.OperationStart})).To(Succeed())
+			Expect(adminLog.Record(audit.Entry{Operation: audit.OperationStart})).To(Succeed())
+			Expect(adminLog.Record(audit.Entry{Operation: audit.OperationRewriteShare, InstanceID: "instance-1", PreviousShare: "old/export", Share: "new/export"})).To(Succeed())
+			Expect(brokerLog.Record(audit.Entry{Operation: audit.OperationBind, InstanceID: "instance-1"})).To(Succeed())
+
+			contents, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			summary, err := audit.Verify(bytes.NewReader(contents))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Entries).To(Equal(4))
+			Expect(summary.Chains).To(Equal(1))
+		})
+
+		It("refuses to continue a file whose last entry is damaged", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+			Expect(os.WriteFile(path, append(buffer.Bytes(), "garbage\n"...), 0600)).To(Succeed())
+
+			_, _, err := audit.Open(path, clk)
+			Expect(err).To(MatchError(ContainSubstring("last entry unreadable")))
+		})
+	})
+})
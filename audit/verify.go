@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Summary describes a log that verified.
+type Summary struct {
+	Entries int
+	// Chains counts the runs of entries linked by hash. A log written to
+	// a file has one; syslog starts a new one each time the broker starts.
+	Chains int
+	// LastHash is the hash of the final entry. Recording it elsewhere
+	// detects the log being cut short, or rewritten from start to end.
+	LastHash string
+}
+
+// Verify checks every entry in r against its hash and the hash of the entry
+// before it, and fails at the first that does not match. Blank lines, and
+// text before each entry, are ignored.
+func Verify(r io.Reader) (Summary, error) {
+	var summary Summary
+
+	scanner := newScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		entry, body, err := split(scanner.Text())
+		if err != nil {
+			return summary, fmt.Errorf("line %d: %w", number, err)
+		}
+		if hashOf(body) != entry.Hash {
+			return summary, fmt.Errorf("line %d: hash does not match the entry", number)
+		}
+
+		switch {
+		case summary.Entries == 0:
+			// the entries before it may have been rotated away
+			summary.Chains++
+		case entry.PreviousHash == summary.LastHash:
+		case entry.PreviousHash == "" && entry.Operation == OperationStart:
+			summary.Chains++
+		default:
+			return summary, fmt.Errorf("line %d: previous_hash does not match the entry before it", number)
+		}
+
+		summary.Entries++
+		summary.LastHash = entry.Hash
+	}
+	return summary, scanner.Err()
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"net/http"
+	"sync"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Identity is who a broker API request was authenticated as.
+type Identity struct {
+	// Name of the credential that authenticated the request
+	Name string
+	// Method is how the request was authenticated, "basic" or
+	// "client-certificate"
+	Method string
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity recorded by an Authenticator.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+const notAuthorized = "Not Authorized"
+
+// Authenticator is broker API middleware that accepts any one of a list of
+// basic auth credentials or client certificate identities, and records which
+// one authenticated each request. The lists can be replaced while the broker
+// is running.
+//
+// Client certificates must already have been verified against a trusted CA
+// during the TLS handshake; the Authenticator only maps them to identities.
+type Authenticator struct {
+	logger lager.Logger
+	clock  clock.Clock
+
+	lock               sync.RWMutex
+	credentials        []hashedCredential
+	clientCertificates []ClientCertificate
+}
+
+type hashedCredential struct {
+	Credential
+	username [32]byte
+	password [32]byte
+}
+
+func NewAuthenticator(logger lager.Logger, clock clock.Clock, credentials Credentials) *Authenticator {
+	a := &Authenticator{logger: logger.Session("auth"), clock: clock}
+	a.SetCredentials(credentials)
+	return a
+}
+
+// SetCredentials replaces the accepted credentials.
+func (a *Authenticator) SetCredentials(credentials Credentials) {
+	var hashed []hashedCredential
+	for _, c := range credentials.Basic {
+		hashed = append(hashed, hashedCredential{
+			Credential: c,
+			username:   sha256.Sum256([]byte(c.Username)),
+			password:   sha256.Sum256([]byte(c.Password)),
+		})
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.credentials = hashed
+	a.clientCertificates = credentials.ClientCertificates
+}
+
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := a.logger.WithData(lager.Data{"method": r.Method, "path": r.URL.Path, "remote_addr": r.RemoteAddr})
+
+		identity, ok := a.authenticateClientCertificate(logger, r)
+		if !ok {
+			identity, ok = a.authenticateBasic(logger, r)
+		}
+		if !ok {
+			logger.Info("unauthorized")
+			http.Error(w, notAuthorized, http.StatusUnauthorized)
+			return
+		}
+
+		logger.Info("authenticated", lager.Data{"credential": identity.Name, "auth_method": identity.Method})
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	})
+}
+
+func (a *Authenticator) authenticateBasic(logger lager.Logger, r *http.Request) (Identity, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, false
+	}
+	u := sha256.Sum256([]byte(username))
+	p := sha256.Sum256([]byte(password))
+
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	now := a.clock.Now()
+	for _, c := range a.credentials {
+		if subtle.ConstantTimeCompare(c.username[:], u[:]) != 1 || subtle.ConstantTimeCompare(c.password[:], p[:]) != 1 {
+			continue
+		}
+		if !c.ValidAt(now) {
+			logger.Info("credential-outside-validity-period", lager.Data{"credential": c.Name, "not_before": c.NotBefore, "not_after": c.NotAfter})
+			continue
+		}
+		return Identity{Name: c.Name, Method: "basic"}, true
+	}
+	return Identity{}, false
+}
+
+func (a *Authenticator) authenticateClientCertificate(logger lager.Logger, r *http.Request) (Identity, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return Identity{}, false
+	}
+	cert := r.TLS.PeerCertificates[0]
+
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	for _, c := range a.clientCertificates {
+		if c.matches(cert) {
+			return Identity{Name: c.Name, Method: "client-certificate"}, true
+		}
+	}
+
+	logger.Info("client-certificate-not-allowed", lager.Data{"subject": cert.Subject.String(), "sans": sans(cert)})
+	return Identity{}, false
+}
+
+func (c ClientCertificate) matches(cert *x509.Certificate) bool {
+	for _, commonName := range c.CommonNames {
+		if commonName == cert.Subject.CommonName {
+			return true
+		}
+	}
+	for _, san := range sans(cert) {
+		for _, allowed := range c.SANs {
+			if san == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sans(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
@@ -0,0 +1,151 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/auth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+type fixedClock struct {
+	clock.Clock
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+var _ = Describe("Authenticator", func() {
+	var (
+		logs          *gbytes.Buffer
+		now           time.Time
+		credentials   auth.Credentials
+		authenticator *auth.Authenticator
+		identity      auth.Identity
+		identified    bool
+		handler       http.Handler
+	)
+
+	BeforeEach(func() {
+		logs = gbytes.NewBuffer()
+		now = time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+		credentials = auth.Credentials{
+			Basic: []auth.Credential{
+				{Name: "old", Username: "admin", Password: "old-password", NotAfter: now.Add(time.Hour)},
+				{Name: "new", Username: "admin", Password: "new-password", NotBefore: now.Add(-time.Hour)},
+				{Name: "future", Username: "admin", Password: "future-password", NotBefore: now.Add(time.Hour)},
+			},
+			ClientCertificates: []auth.ClientCertificate{
+				{Name: "cloud-controller", CommonNames: []string{"cloud_controller"}},
+				{Name: "cc-by-san", SANs: []string{"cc.service.internal", "spiffe://cf/cc"}},
+			},
+		}
+		identified = false
+	})
+
+	JustBeforeEach(func() {
+		logger := lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+
+		authenticator = auth.NewAuthenticator(logger, fixedClock{now: now}, credentials)
+		handler = authenticator.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, identified = auth.IdentityFromContext(r.Context())
+		}))
+	})
+
+	serveRequest := func(req *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	serve := func(username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v2/catalog", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		return serveRequest(req)
+	}
+
+	serveWithCertificate := func(cert *x509.Certificate) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v2/catalog", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		return serveRequest(req)
+	}
+
+	It("accepts every valid credential and records which one was used", func() {
+		Expect(serve("admin", "old-password").Code).To(Equal(http.StatusOK))
+		Expect(identity).To(Equal(auth.Identity{Name: "old", Method: "basic"}))
+		Expect(logs).To(gbytes.Say(`"credential":"old"`))
+
+		Expect(serve("admin", "new-password").Code).To(Equal(http.StatusOK))
+		Expect(identity).To(Equal(auth.Identity{Name: "new", Method: "basic"}))
+		Expect(logs).To(gbytes.Say(`"credential":"new"`))
+	})
+
+	It("rejects credentials outside their validity period", func() {
+		recorder := serve("admin", "future-password")
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(recorder.Body.String()).To(ContainSubstring("Not Authorized"))
+		Expect(identified).To(BeFalse())
+		Expect(logs).To(gbytes.Say("credential-outside-validity-period.*future"))
+	})
+
+	It("rejects unknown and missing credentials", func() {
+		Expect(serve("admin", "wrong").Code).To(Equal(http.StatusUnauthorized))
+		Expect(serve("", "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(identified).To(BeFalse())
+		Expect(logs).To(gbytes.Say("unauthorized"))
+	})
+
+	It("accepts replaced credentials", func() {
+		authenticator.SetCredentials(auth.Credentials{Basic: []auth.Credential{{Name: "replacement", Username: "other", Password: "secret"}}})
+
+		Expect(serve("admin", "new-password").Code).To(Equal(http.StatusUnauthorized))
+		Expect(serve("other", "secret").Code).To(Equal(http.StatusOK))
+		Expect(identity.Name).To(Equal("replacement"))
+	})
+	Context("with a client certificate", func() {
+		It("identifies the request by common name", func() {
+			Expect(serveWithCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "cloud_controller"}}).Code).To(Equal(http.StatusOK))
+			Expect(identity).To(Equal(auth.Identity{Name: "cloud-controller", Method: "client-certificate"}))
+			Expect(logs).To(gbytes.Say(`"auth_method":"client-certificate","credential":"cloud-controller"`))
+		})
+
+		It("identifies the request by SAN", func() {
+			Expect(serveWithCertificate(&x509.Certificate{DNSNames: []string{"cc.service.internal"}}).Code).To(Equal(http.StatusOK))
+			Expect(identity.Name).To(Equal("cc-by-san"))
+
+			uri, err := url.Parse("spiffe://cf/cc")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(serveWithCertificate(&x509.Certificate{URIs: []*url.URL{uri}}).Code).To(Equal(http.StatusOK))
+			Expect(identity.Name).To(Equal("cc-by-san"))
+		})
+
+		It("rejects certificates that are not allowed", func() {
+			Expect(serveWithCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "someone-else"}}).Code).To(Equal(http.StatusUnauthorized))
+			Expect(identified).To(BeFalse())
+			Expect(logs).To(gbytes.Say(`client-certificate-not-allowed.*CN=someone-else`))
+		})
+
+		It("falls back to basic auth for certificates that are not allowed", func() {
+			req := httptest.NewRequest("GET", "/v2/catalog", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "someone-else"}}}}
+			req.SetBasicAuth("admin", "new-password")
+
+			Expect(serveRequest(req).Code).To(Equal(http.StatusOK))
+			Expect(identity).To(Equal(auth.Identity{Name: "new", Method: "basic"}))
+		})
+	})
+})
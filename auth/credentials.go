@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Credential is one accepted basic auth username and password. NotBefore and
+// NotAfter, when set, limit when it is accepted, so that a new password can be
+// added ahead of a rotation and the old one retired after it.
+type Credential struct {
+	Name      string    `yaml:"name"`
+	Username  string    `yaml:"username"`
+	Password  string    `yaml:"password"`
+	NotBefore time.Time `yaml:"not_before"`
+	NotAfter  time.Time `yaml:"not_after"`
+}
+
+// ValidAt reports whether the credential is accepted at time t.
+func (c Credential) ValidAt(t time.Time) bool {
+	if !c.NotBefore.IsZero() && t.Before(c.NotBefore) {
+		return false
+	}
+	if !c.NotAfter.IsZero() && !t.Before(c.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// ClientCertificate is a broker identity for requests made with a client
+// certificate. A certificate matches if its subject common name is one of
+// CommonNames, or any of its DNS, IP, URI or email SANs is one of SANs.
+type ClientCertificate struct {
+	Name        string   `yaml:"name"`
+	CommonNames []string `yaml:"common_names"`
+	SANs        []string `yaml:"sans"`
+}
+
+// Credentials are everything the broker accepts as proof of identity.
+type Credentials struct {
+	Basic              []Credential        `yaml:"credentials"`
+	ClientCertificates []ClientCertificate `yaml:"client_certificates"`
+}
+
+// LoadCredentials reads a credentials file:
+//
+//	credentials:
+//	- name: cc-2024-06
+//	  username: admin
+//	  password: secret
+//	  not_before: 2024-06-01T00:00:00Z
+//	  not_after: 2024-07-01T00:00:00Z
+//	client_certificates:
+//	- name: cloud-controller
+//	  common_names: [cloud_controller]
+//	  sans: [cloud-controller-ng.service.cf.internal]
+//
+// Basic credential names default to the username. All names must be unique.
+func LoadCredentials(path string) (Credentials, error) {
+	var credentials Credentials
+
+	/* #nosec */
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return credentials, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&credentials); err != nil && !errors.Is(err, io.EOF) {
+		return credentials, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+
+	if err := credentials.validate(); err != nil {
+		return credentials, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+	return credentials, nil
+}
+
+func (c *Credentials) validate() error {
+	if len(c.Basic) == 0 && len(c.ClientCertificates) == 0 {
+		return errors.New("no credentials or client certificates defined")
+	}
+
+	names := map[string]bool{}
+	for i, credential := range c.Basic {
+		if credential.Name == "" {
+			credential.Name = credential.Username
+			c.Basic[i] = credential
+		}
+		if credential.Username == "" || credential.Password == "" {
+			return fmt.Errorf("credential %d must have a username and password", i)
+		}
+		if names[credential.Name] {
+			return fmt.Errorf("duplicate credential name %q", credential.Name)
+		}
+		if !credential.NotAfter.IsZero() && !credential.NotAfter.After(credential.NotBefore) {
+			return fmt.Errorf("credential %q has not_after before not_before", credential.Name)
+		}
+		names[credential.Name] = true
+	}
+
+	for i, certificate := range c.ClientCertificates {
+		if certificate.Name == "" {
+			return fmt.Errorf("client certificate %d must have a name", i)
+		}
+		if len(certificate.CommonNames) == 0 && len(certificate.SANs) == 0 {
+			return fmt.Errorf("client certificate %q must list common_names or sans", certificate.Name)
+		}
+		if names[certificate.Name] {
+			return fmt.Errorf("duplicate credential name %q", certificate.Name)
+		}
+		names[certificate.Name] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/nfsbroker/auth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Credentials", func() {
+	Describe("ValidAt", func() {
+		var (
+			notBefore  = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+			notAfter   = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+			credential auth.Credential
+		)
+
+		BeforeEach(func() {
+			credential = auth.Credential{NotBefore: notBefore, NotAfter: notAfter}
+		})
+
+		It("accepts times within the validity period", func() {
+			Expect(credential.ValidAt(notBefore)).To(BeTrue())
+			Expect(credential.ValidAt(notAfter.Add(-time.Second))).To(BeTrue())
+		})
+
+		It("rejects times outside the validity period", func() {
+			Expect(credential.ValidAt(notBefore.Add(-time.Second))).To(BeFalse())
+			Expect(credential.ValidAt(notAfter)).To(BeFalse())
+		})
+
+		It("is always valid without a validity period", func() {
+			Expect(auth.Credential{}.ValidAt(time.Now())).To(BeTrue())
+		})
+	})
+
+	Describe("LoadCredentials", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "credentials.yml")
+		})
+
+		It("loads every credential, naming them after their username by default", func() {
+			Expect(os.WriteFile(path, []byte(`
+credentials:
+- name: old
+  username: admin
+  password: old-password
+  not_after: 2024-07-01T00:00:00Z
+- username: admin
+  password: new-password
+  not_before: 2024-06-01T00:00:00Z
+`), 0600)).To(Succeed())
+
+			credentials, err := auth.LoadCredentials(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Basic).To(Equal([]auth.Credential{
+				{Name: "old", Username: "admin", Password: "old-password", NotAfter: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+				{Name: "admin", Username: "admin", Password: "new-password", NotBefore: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+			}))
+			Expect(credentials.ClientCertificates).To(BeEmpty())
+		})
+
+		It("loads client certificate identities", func() {
+			Expect(os.WriteFile(path, []byte(`
+client_certificates:
+- name: cloud-controller
+  common_names: [cloud_controller]
+  sans: [cloud-controller-ng.service.cf.internal]
+`), 0600)).To(Succeed())
+
+			credentials, err := auth.LoadCredentials(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Basic).To(BeEmpty())
+			Expect(credentials.ClientCertificates).To(Equal([]auth.ClientCertificate{{
+				Name:        "cloud-controller",
+				CommonNames: []string{"cloud_controller"},
+				SANs:        []string{"cloud-controller-ng.service.cf.internal"},
+			}}))
+		})
+
+		DescribeTable("rejects invalid files",
+			func(contents, message string) {
+				Expect(os.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+
+				_, err := auth.LoadCredentials(path)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("no credentials", "credentials: []", "no credentials or client certificates defined"),
+			Entry("unknown keys", "credentials:\n- username: a\n  pasword: b\n", "line 3: field pasword not found"),
+			Entry("missing password", "credentials:\n- username: a\n", "must have a username and password"),
+			Entry("duplicate names", "credentials:\n- {username: a, password: b}\n- {username: a, password: c}\n", `duplicate credential name "a"`),
+			Entry("unnamed client certificate", "client_certificates:\n- {common_names: [cc]}\n", "client certificate 0 must have a name"),
+			Entry("client certificate matching nothing", "client_certificates:\n- {name: cc}\n", `client certificate "cc" must list common_names or sans`),
+			Entry("names shared by both kinds", "credentials:\n- {username: cc, password: b}\nclient_certificates:\n- {name: cc, sans: [cc.internal]}\n", `duplicate credential name "cc"`),
+			Entry("inverted validity period", "credentials:\n- {username: a, password: b, not_before: 2024-07-01T00:00:00Z, not_after: 2024-06-01T00:00:00Z}\n", "not_after before not_before"),
+		)
+	})
+})
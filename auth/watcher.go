@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"bytes"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/tedsuo/ifrit"
+)
+
+// NewCredentialsWatcher returns a runner that polls a credentials file and,
+// whenever it changes, loads it with load and passes the credentials to
+// apply. A file that load rejects is logged and the previous credentials stay
+// in effect.
+func NewCredentialsWatcher(logger lager.Logger, clock clock.Clock, path string, interval time.Duration, load func() (Credentials, error), apply func(Credentials)) ifrit.Runner {
+	logger = logger.Session("credentials-watcher", lager.Data{"path": path})
+
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		/* #nosec */
+		last, _ := os.ReadFile(path)
+
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		close(ready)
+
+		for {
+			select {
+			case <-signals:
+				return nil
+			case <-ticker.C():
+				/* #nosec */
+				contents, err := os.ReadFile(path)
+				if err != nil {
+					logger.Error("read-failed-keeping-current-credentials", err)
+					continue
+				}
+				if bytes.Equal(contents, last) {
+					continue
+				}
+				last = contents
+
+				credentials, err := load()
+				if err != nil {
+					logger.Error("load-failed-keeping-current-credentials", err)
+					continue
+				}
+
+				var names []string
+				for _, c := range credentials.Basic {
+					names = append(names, c.Name)
+				}
+				for _, c := range credentials.ClientCertificates {
+					names = append(names, c.Name)
+				}
+				apply(credentials)
+				logger.Info("credentials-reloaded", lager.Data{"credentials": names})
+			}
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/auth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/tedsuo/ifrit"
+	ginkgomon "github.com/tedsuo/ifrit/ginkgomon_v2"
+)
+
+var _ = Describe("CredentialsWatcher", func() {
+	var (
+		logs          *gbytes.Buffer
+		path          string
+		authenticator *auth.Authenticator
+		process       ifrit.Process
+	)
+
+	statusFor := func(username, password string) int {
+		req := httptest.NewRequest("GET", "/v2/catalog", nil)
+		req.SetBasicAuth(username, password)
+		recorder := httptest.NewRecorder()
+		authenticator.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	BeforeEach(func() {
+		logs = gbytes.NewBuffer()
+		logger := lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+
+		path = filepath.Join(GinkgoT().TempDir(), "credentials.yml")
+		Expect(os.WriteFile(path, []byte("credentials:\n- {username: admin, password: old}\n"), 0600)).To(Succeed())
+
+		credentials, err := auth.LoadCredentials(path)
+		Expect(err).NotTo(HaveOccurred())
+		authenticator = auth.NewAuthenticator(logger, clock.NewClock(), credentials)
+
+		load := func() (auth.Credentials, error) {
+			credentials, err := auth.LoadCredentials(path)
+			if err == nil && len(credentials.ClientCertificates) > 0 {
+				return credentials, errors.New("client certificates are not trusted")
+			}
+			return credentials, err
+		}
+		process = ginkgomon.Invoke(auth.NewCredentialsWatcher(logger, clock.NewClock(), path, 10*time.Millisecond, load, authenticator.SetCredentials))
+	})
+
+	AfterEach(func() {
+		ginkgomon.Interrupt(process)
+	})
+
+	It("picks up changes to the credentials file", func() {
+		Expect(statusFor("admin", "old")).To(Equal(http.StatusOK))
+
+		Expect(os.WriteFile(path, []byte("credentials:\n- {username: admin, password: new}\n"), 0600)).To(Succeed())
+
+		Eventually(logs).Should(gbytes.Say("credentials-reloaded"))
+		Expect(statusFor("admin", "new")).To(Equal(http.StatusOK))
+		Expect(statusFor("admin", "old")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("keeps the current credentials when the file is rejected", func() {
+		Expect(os.WriteFile(path, []byte("credentials:\n- {username: admin, password: new}\nclient_certificates:\n- {name: platform, common_names: [cloud-controller]}\n"), 0600)).To(Succeed())
+
+		Eventually(logs).Should(gbytes.Say("load-failed-keeping-current-credentials.*client certificates are not trusted"))
+		Expect(statusFor("admin", "old")).To(Equal(http.StatusOK))
+	})
+
+	It("keeps the current credentials when the file becomes invalid", func() {
+		Expect(os.WriteFile(path, []byte("credentials: []\n"), 0600)).To(Succeed())
+
+		Eventually(logs).Should(gbytes.Say("load-failed-keeping-current-credentials"))
+		Expect(statusFor("admin", "old")).To(Equal(http.StatusOK))
+	})
+})
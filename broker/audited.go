@@ -0,0 +1,167 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/audit"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/pivotal-cf/brokerapi/v11/middlewares"
+)
+
+// Audited records each operation that changes broker state in the audit log
+// once it has completed, whether or not it succeeded: who asked for it, in
+// which org and space, for which share, and the mount options that resulted.
+//
+// The instance is read from the store before each operation other than
+// provision, to find its org, space and share; it is gone after a
+// deprovision. A write to the audit log that fails is logged, and does not
+// fail the operation, which has already happened.
+type Audited struct {
+	domain.ServiceBroker
+
+	logger   lager.Logger
+	log      *audit.Log
+	storeFor func(ctx context.Context) brokerstore.Store
+}
+
+func NewAudited(logger lager.Logger, wrapped domain.ServiceBroker, log *audit.Log, storeFor func(ctx context.Context) brokerstore.Store) *Audited {
+	return &Audited{ServiceBroker: wrapped, logger: logger.Session("audit"), log: log, storeFor: storeFor}
+}
+
+func (b *Audited) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	entry := b.entry(ctx, audit.OperationProvision, instanceID, "")
+	entry.OrganizationGUID, entry.SpaceGUID = details.OrganizationGUID, details.SpaceGUID
+	entry.ServiceID, entry.PlanID = details.ServiceID, details.PlanID
+	parameters := parametersOf(details.RawParameters)
+	entry.Share = shareOf(parameters)
+	entry.MountOptions = mountOptionsOf(parameters)
+
+	spec, err := b.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+	b.record(entry, err)
+	return spec, err
+}
+
+func (b *Audited) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (domain.UpdateServiceSpec, error) {
+	entry := b.entryForInstance(ctx, audit.OperationUpdate, instanceID, "")
+	entry.ServiceID, entry.PlanID = details.ServiceID, details.PlanID
+	if entry.PlanID == "" {
+		entry.PlanID = details.PreviousValues.PlanID
+	}
+	if parameters := parametersOf(details.RawParameters); parameters != nil {
+		entry.MountOptions = mountOptionsOf(parameters)
+	}
+
+	spec, err := b.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+	b.record(entry, err)
+	return spec, err
+}
+
+func (b *Audited) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	entry := b.entryForInstance(ctx, audit.OperationDeprovision, instanceID, "")
+	entry.ServiceID, entry.PlanID = details.ServiceID, details.PlanID
+
+	spec, err := b.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+	b.record(entry, err)
+	return spec, err
+}
+
+func (b *Audited) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	entry := b.entryForInstance(ctx, audit.OperationBind, instanceID, bindingID)
+	entry.ServiceID, entry.PlanID = details.ServiceID, details.PlanID
+	entry.MountOptions = nil
+
+	binding, err := b.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+	if err == nil && len(binding.VolumeMounts) > 0 {
+		entry.MountOptions = audit.Redact(binding.VolumeMounts[0].Device.MountConfig)
+	} else if parameters := parametersOf(details.RawParameters); parameters != nil {
+		// the options that were asked for, when none resulted
+		entry.MountOptions = mountOptionsOf(parameters)
+	}
+	b.record(entry, err)
+	return binding, err
+}
+
+func (b *Audited) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	entry := b.entryForInstance(ctx, audit.OperationUnbind, instanceID, bindingID)
+	entry.ServiceID, entry.PlanID = details.ServiceID, details.PlanID
+	entry.MountOptions = nil
+
+	spec, err := b.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+	b.record(entry, err)
+	return spec, err
+}
+
+func (b *Audited) entry(ctx context.Context, operation, instanceID, bindingID string) audit.Entry {
+	identity, _ := ctx.Value(middlewares.OriginatingIdentityKey).(string)
+	platform, user := audit.ParseOriginatingIdentity(identity)
+	return audit.Entry{
+		Operation:  operation,
+		InstanceID: instanceID,
+		BindingID:  bindingID,
+		Platform:   platform,
+		User:       user,
+	}
+}
+
+// entryForInstance fills in what the store knows about the instance. An
+// instance that cannot be read is recorded without it.
+func (b *Audited) entryForInstance(ctx context.Context, operation, instanceID, bindingID string) audit.Entry {
+	entry := b.entry(ctx, operation, instanceID, bindingID)
+
+	instance, err := b.storeFor(ctx).RetrieveInstanceDetails(instanceID)
+	if err != nil {
+		return entry
+	}
+	entry.OrganizationGUID, entry.SpaceGUID = instance.OrganizationGUID, instance.SpaceGUID
+	switch fingerprint := instance.ServiceFingerPrint.(type) {
+	case map[string]interface{}:
+		entry.Share = shareOf(fingerprint)
+		entry.MountOptions = mountOptionsOf(fingerprint)
+	case string:
+		// legacy instances record only the share
+		entry.Share = fingerprint
+	}
+	return entry
+}
+
+func (b *Audited) record(entry audit.Entry, err error) {
+	entry.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		entry.Outcome, entry.Error = audit.OutcomeFailure, err.Error()
+	}
+
+	if err := b.log.Record(entry); err != nil {
+		b.logger.Error("record-failed", err, lager.Data{"operation": entry.Operation, "instance_id": entry.InstanceID, "binding_id": entry.BindingID})
+	}
+}
+
+func parametersOf(raw json.RawMessage) map[string]interface{} {
+	var parameters map[string]interface{}
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &parameters)
+	}
+	return parameters
+}
+
+func shareOf(parameters map[string]interface{}) string {
+	share, _ := parameters["share"].(string)
+	return share
+}
+
+// mountOptionsOf leaves out the share, which is recorded separately, and the
+// broker's own bookkeeping.
+func mountOptionsOf(parameters map[string]interface{}) map[string]interface{} {
+	options := map[string]interface{}{}
+	for key, value := range parameters {
+		if key != "share" && key != MetadataKey {
+			options[key] = value
+		}
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return audit.Redact(options)
+}
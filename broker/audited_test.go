@@ -0,0 +1,130 @@
+package broker_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/audit"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/pivotal-cf/brokerapi/v11/middlewares"
+)
+
+var _ = Describe("Audited", func() {
+	var (
+		inner   *fakes.FakeServiceBroker
+		store   *fakes.FakeStore
+		buffer  *bytes.Buffer
+		subject *broker.Audited
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		inner = &fakes.FakeServiceBroker{}
+		store = &fakes.FakeStore{}
+		store.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: map[string]interface{}{"share": "server/export", "uid": "1000", broker.MetadataKey: map[string]interface{}{}},
+		}, nil)
+		buffer = &bytes.Buffer{}
+		subject = broker.NewAudited(lager.NewLogger("test"), inner, audit.NewLog(buffer, "", clock.NewClock()), func(context.Context) brokerstore.Store {
+			return store
+		})
+
+		identity := base64.StdEncoding.EncodeToString([]byte(`{"user_id": "user-guid"}`))
+		ctx = context.WithValue(context.TODO(), middlewares.OriginatingIdentityKey, "cloudfoundry "+identity)
+	})
+
+	entries := func() []audit.Entry {
+		var entries []audit.Entry
+		for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+			var entry audit.Entry
+			Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	It("records provisions with the user, org, space, share and options", func() {
+		_, err := subject.Provision(ctx, "instance-1", domain.ProvisionDetails{
+			ServiceID:        "nfs",
+			PlanID:           "existing",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			RawParameters:    json.RawMessage(`{"share": "server/export", "password": "hunter2"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		entry := entries()[0]
+		Expect(entry.Operation).To(Equal("provision"))
+		Expect(entry.Platform).To(Equal("cloudfoundry"))
+		Expect(entry.User).To(Equal("user-guid"))
+		Expect(entry.OrganizationGUID).To(Equal("org-guid"))
+		Expect(entry.SpaceGUID).To(Equal("space-guid"))
+		Expect(entry.Share).To(Equal("server/export"))
+		Expect(entry.MountOptions).To(Equal(map[string]interface{}{"password": audit.Redacted}))
+		Expect(entry.Outcome).To(Equal("success"))
+	})
+
+	It("records binds with the resulting mount options", func() {
+		inner.BindReturns(domain.Binding{VolumeMounts: []domain.VolumeMount{{
+			Device: domain.SharedDevice{MountConfig: map[string]interface{}{"source": "nfs://server/export", "uid": "1000"}},
+		}}}, nil)
+
+		_, err := subject.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{ServiceID: "nfs", PlanID: "existing"}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		entry := entries()[0]
+		Expect(entry.Operation).To(Equal("bind"))
+		Expect(entry.BindingID).To(Equal("binding-1"))
+		Expect(entry.OrganizationGUID).To(Equal("org-guid"))
+		Expect(entry.Share).To(Equal("server/export"))
+		Expect(entry.MountOptions).To(Equal(map[string]interface{}{"source": "nfs://server/export", "uid": "1000"}))
+	})
+
+	It("records failures with their error", func() {
+		inner.DeprovisionReturns(domain.DeprovisionServiceSpec{}, errors.New("credhub unavailable"))
+
+		_, err := subject.Deprovision(ctx, "instance-1", domain.DeprovisionDetails{}, false)
+		Expect(err).To(MatchError("credhub unavailable"))
+
+		entry := entries()[0]
+		Expect(entry.Operation).To(Equal("deprovision"))
+		Expect(entry.Share).To(Equal("server/export"))
+		Expect(entry.MountOptions).To(Equal(map[string]interface{}{"uid": "1000"}))
+		Expect(entry.Outcome).To(Equal("failure"))
+		Expect(entry.Error).To(Equal("credhub unavailable"))
+	})
+
+	It("records operations on instances that cannot be read", func() {
+		store.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{}, errors.New("not found"))
+
+		_, err := subject.Unbind(ctx, "instance-1", "binding-1", domain.UnbindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		entry := entries()[0]
+		Expect(entry.Operation).To(Equal("unbind"))
+		Expect(entry.OrganizationGUID).To(BeEmpty())
+		Expect(entry.Outcome).To(Equal("success"))
+	})
+
+	It("chains every entry", func() {
+		_, _ = subject.Update(ctx, "instance-1", domain.UpdateDetails{PreviousValues: domain.PreviousValues{PlanID: "existing"}}, false)
+		_, _ = subject.Unbind(ctx, "instance-1", "binding-1", domain.UnbindDetails{}, false)
+
+		summary, err := audit.Verify(buffer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Entries).To(Equal(2))
+	})
+})
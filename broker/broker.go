@@ -0,0 +1,290 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/pivotal-cf/brokerapi/v11/domain/apiresponses"
+)
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
+//counterfeiter:generate -o ../fakes/fake_service_broker.go github.com/pivotal-cf/brokerapi/v11/domain.ServiceBroker
+//counterfeiter:generate -o ../fakes/fake_store.go code.cloudfoundry.org/service-broker-store/brokerstore.Store
+//counterfeiter:generate -o ../fakes/fake_services.go code.cloudfoundry.org/existingvolumebroker.Services
+
+// Broker adds nfsbroker specific behaviour on top of an
+// existingvolumebroker.Broker. Operations it does not override are passed
+// straight through to the wrapped broker.
+type Broker struct {
+	domain.ServiceBroker
+
+	logger         lager.Logger
+	store          brokerstore.Store
+	configMask     vmo.MountOptsMask
+	volumeIDScheme VolumeIDScheme
+}
+
+func New(
+	logger lager.Logger,
+	wrapped domain.ServiceBroker,
+	store brokerstore.Store,
+	configMask vmo.MountOptsMask,
+	volumeIDScheme VolumeIDScheme,
+) *Broker {
+	return &Broker{
+		ServiceBroker:  wrapped,
+		logger:         logger,
+		store:          store,
+		configMask:     configMask,
+		volumeIDScheme: volumeIDScheme,
+	}
+}
+
+// Provision refuses new instances of deprecated and disabled plans.
+func (b *Broker) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	if service, plan, ok := b.findPlan(ctx, details.ServiceID, details.PlanID); ok {
+		switch state, hint := planState(plan); state {
+		case PlanStateDeprecated:
+			return domain.ProvisionedServiceSpec{}, apiresponses.NewFailureResponse(
+				refusal(service, plan, "is deprecated, and no longer accepts new instances", hint),
+				http.StatusUnprocessableEntity,
+				"plan-deprecated",
+			)
+		case PlanStateDisabled:
+			return domain.ProvisionedServiceSpec{}, apiresponses.NewFailureResponse(
+				refusal(service, plan, "is disabled", hint),
+				http.StatusUnprocessableEntity,
+				"plan-disabled",
+			)
+		}
+	}
+
+	metadata := InstanceMetadata{VolumeIDScheme: b.volumeIDScheme}
+
+	planInfo := b.maintenanceInfo(ctx, details.ServiceID, details.PlanID)
+	if details.MaintenanceInfo != nil {
+		if planInfo == nil {
+			return domain.ProvisionedServiceSpec{}, apiresponses.ErrMaintenanceInfoNilConflict
+		}
+		if !planInfo.Equals(*details.MaintenanceInfo) {
+			return domain.ProvisionedServiceSpec{}, apiresponses.ErrMaintenanceInfoConflict
+		}
+	}
+	if planInfo != nil {
+		metadata.MaintenanceVersion = planInfo.Version
+	}
+
+	rawParameters, reserved, err := withMetadata(details.RawParameters, metadata)
+	if err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if reserved {
+		return domain.ProvisionedServiceSpec{}, apiresponses.NewFailureResponse(
+			errors.New("create configuration contains the following invalid option: ['"+MetadataKey+"']"),
+			http.StatusBadRequest,
+			"invalid-raw-params",
+		)
+	}
+	details.RawParameters = rawParameters
+
+	return b.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+// Bind refuses bindings to instances of disabled plans. Instances of
+// deprecated plans can still be bound.
+func (b *Broker) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	logger := b.logger.Session("bind").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+
+	if service, plan, ok := b.findPlan(ctx, details.ServiceID, details.PlanID); ok {
+		if state, hint := planState(plan); state == PlanStateDisabled {
+			return domain.Binding{}, apiresponses.NewFailureResponse(
+				refusal(service, plan, "is disabled, and its instances can no longer be bound", hint),
+				http.StatusUnprocessableEntity,
+				"plan-disabled",
+			)
+		}
+	}
+
+	var metadata InstanceMetadata
+	// if the instance cannot be read the wrapped broker reports it as missing
+	if instance, err := b.store.RetrieveInstanceDetails(instanceID); err == nil {
+		metadata, err = GetInstanceMetadata(instance)
+		if err != nil {
+			logger.Error("error-reading-instance-metadata", err)
+			return domain.Binding{}, err
+		}
+	}
+
+	binding, err := b.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+	if err != nil {
+		return binding, err
+	}
+
+	if metadata.VolumeIDScheme == VolumeIDSchemeV2 {
+		for i, mount := range binding.VolumeMounts {
+			binding.VolumeMounts[i].Device.VolumeId = VolumeIDV2(instanceID, mount.Device.MountConfig)
+		}
+	}
+
+	return binding, nil
+}
+
+// Update performs maintenance_info upgrades. Any other kind of update is
+// passed on to the wrapped broker, which does not support them.
+func (b *Broker) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (_ domain.UpdateServiceSpec, e error) {
+	if !isUpgrade(details) {
+		return b.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+	}
+
+	logger := b.logger.Session("upgrade").WithData(lager.Data{"instanceID": instanceID, "maintenanceInfo": details.MaintenanceInfo})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	planID := details.PlanID
+	if planID == "" {
+		planID = details.PreviousValues.PlanID
+	}
+
+	planInfo := b.maintenanceInfo(ctx, details.ServiceID, planID)
+	if planInfo == nil {
+		return domain.UpdateServiceSpec{}, apiresponses.ErrMaintenanceInfoNilConflict
+	}
+	if !planInfo.Equals(*details.MaintenanceInfo) {
+		return domain.UpdateServiceSpec{}, apiresponses.ErrMaintenanceInfoConflict
+	}
+
+	instance, err := b.store.RetrieveInstanceDetails(instanceID)
+	if err != nil {
+		return domain.UpdateServiceSpec{}, apiresponses.ErrInstanceDoesNotExist
+	}
+
+	metadata, err := GetInstanceMetadata(instance)
+	if err != nil {
+		logger.Error("error-reading-instance-metadata", err)
+		return domain.UpdateServiceSpec{}, err
+	}
+
+	fingerprint, err := upgradeFingerprint(instance.ServiceFingerPrint)
+	if err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+
+	opts := map[string]interface{}{}
+	for k, v := range fingerprint {
+		opts[k] = v
+	}
+	if _, err := vmo.NewMountOpts(opts, b.configMask); err != nil {
+		logger.Error("instance-violates-current-policy", err)
+		return domain.UpdateServiceSpec{}, apiresponses.NewFailureResponse(
+			fmt.Errorf("the instance configuration does not satisfy the current mount options policy: %s", err.Error()),
+			http.StatusUnprocessableEntity,
+			"upgrade-policy-violation",
+		)
+	}
+
+	// The instance keeps its volume ID scheme, so that new bindings share
+	// mounts with existing ones.
+	if metadata.VolumeIDScheme == "" {
+		metadata.VolumeIDScheme = VolumeIDSchemeV1
+	}
+	metadata.MaintenanceVersion = planInfo.Version
+	fingerprint[MetadataKey] = metadata
+	previous := instance
+	instance.ServiceFingerPrint = fingerprint
+
+	// Writing the instance back would undo a deprovision, or any other
+	// change, made since it was read. Serialized and Leased keep other
+	// operations out; this check is only a best-effort one for brokers
+	// sharing a store without leases, which can still change the instance
+	// between it and the write.
+	current, err := b.store.RetrieveInstanceDetails(instanceID)
+	if err != nil {
+		logger.Info("instance-deleted-during-upgrade")
+		return domain.UpdateServiceSpec{}, apiresponses.ErrInstanceDoesNotExist
+	}
+	if !reflect.DeepEqual(current, previous) {
+		logger.Info("instance-changed-during-upgrade")
+		return domain.UpdateServiceSpec{}, apiresponses.ErrConcurrentInstanceAccess
+	}
+
+	defer func() {
+		out := b.store.Save(logger)
+		if e == nil {
+			e = out
+		}
+	}()
+
+	if err := b.store.CreateInstanceDetails(instanceID, instance); err != nil {
+		return domain.UpdateServiceSpec{}, fmt.Errorf("failed to store instance details: %s", err.Error())
+	}
+
+	logger.Info("service-instance-upgraded")
+	return domain.UpdateServiceSpec{}, nil
+}
+
+func (b *Broker) maintenanceInfo(ctx context.Context, serviceID, planID string) *domain.MaintenanceInfo {
+	if _, plan, ok := b.findPlan(ctx, serviceID, planID); ok {
+		return plan.MaintenanceInfo
+	}
+	return nil
+}
+
+func (b *Broker) findPlan(ctx context.Context, serviceID, planID string) (domain.Service, domain.ServicePlan, bool) {
+	services, err := b.ServiceBroker.Services(ctx)
+	if err != nil {
+		return domain.Service{}, domain.ServicePlan{}, false
+	}
+	for _, service := range services {
+		if service.ID != serviceID {
+			continue
+		}
+		for _, plan := range service.Plans {
+			if plan.ID == planID {
+				return service, plan, true
+			}
+		}
+	}
+	return domain.Service{}, domain.ServicePlan{}, false
+}
+
+// isUpgrade reports whether an update request only asks for a new
+// maintenance_info, without changing the plan or passing parameters.
+func isUpgrade(details domain.UpdateDetails) bool {
+	if details.MaintenanceInfo == nil {
+		return false
+	}
+	if details.PlanID != "" && details.PlanID != details.PreviousValues.PlanID {
+		return false
+	}
+	if len(details.RawParameters) > 0 {
+		var params map[string]interface{}
+		if err := json.Unmarshal(details.RawParameters, &params); err != nil || len(params) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// upgradeFingerprint returns a copy of the fingerprint in the current map
+// format. Legacy instances only stored the share as a bare string.
+func upgradeFingerprint(rawObject interface{}) (map[string]interface{}, error) {
+	switch fingerprint := rawObject.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k, v := range fingerprint {
+			out[k] = v
+		}
+		return out, nil
+	case string:
+		return map[string]interface{}{"share": fingerprint}, nil
+	}
+	return nil, errors.New("unable to deserialize service fingerprint")
+}
@@ -0,0 +1,493 @@
+package broker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/existingvolumebroker"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/pivotal-cf/brokerapi/v11/domain/apiresponses"
+)
+
+var _ = Describe("Broker", func() {
+	var (
+		logger    lager.Logger
+		fakeStore *fakes.FakeStore
+		scheme    broker.VolumeIDScheme
+		services  *fakes.FakeServices
+		subject   *broker.Broker
+		ctx       context.Context
+	)
+
+	BeforeEach(func() {
+		logger = lager.NewLogger("test-broker")
+		logger.RegisterSink(lager.NewWriterSink(GinkgoWriter, lager.DEBUG))
+		fakeStore = &fakes.FakeStore{}
+		scheme = broker.VolumeIDSchemeV1
+		services = &fakes.FakeServices{}
+		ctx = context.TODO()
+	})
+
+	JustBeforeEach(func() {
+		mask, err := vmo.NewMountOptsMask(
+			[]string{"source", "uid", "gid", "auto_cache", "readonly", "version"},
+			map[string]interface{}{"auto_cache": "true"},
+			map[string]string{"share": "source"},
+			[]string{broker.MetadataKey},
+			[]string{"source"},
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		wrapped := existingvolumebroker.New(
+			existingvolumebroker.BrokerTypeNFS,
+			logger,
+			services,
+			&osshim.OsShim{},
+			clock.NewClock(),
+			fakeStore,
+			mask,
+		)
+		wrapped.DisallowedBindOverrides = append(wrapped.DisallowedBindOverrides, broker.MetadataKey)
+
+		subject = broker.New(logger, wrapped, fakeStore, mask, scheme)
+	})
+
+	Describe("#Provision", func() {
+		var (
+			rawParameters   json.RawMessage
+			maintenanceInfo *domain.MaintenanceInfo
+			err             error
+		)
+
+		BeforeEach(func() {
+			rawParameters = json.RawMessage(`{"share":"server/export","uid":"1000"}`)
+			maintenanceInfo = nil
+		})
+
+		JustBeforeEach(func() {
+			_, err = subject.Provision(ctx, "some-instance-id", domain.ProvisionDetails{
+				ServiceID:       "some-service-id",
+				PlanID:          "some-plan-id",
+				RawParameters:   rawParameters,
+				MaintenanceInfo: maintenanceInfo,
+			}, false)
+		})
+
+		It("records the volume id scheme with the instance", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(1))
+
+			id, details := fakeStore.CreateInstanceDetailsArgsForCall(0)
+			Expect(id).To(Equal("some-instance-id"))
+
+			metadata, err := broker.GetInstanceMetadata(details)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.VolumeIDScheme).To(Equal(broker.VolumeIDSchemeV1))
+			Expect(details.ServiceFingerPrint).To(HaveKeyWithValue("share", "server/export"))
+		})
+
+		Context("when the plan has maintenance info", func() {
+			BeforeEach(func() {
+				services.ListReturns(catalogWithMaintenanceInfo("1.1.0"))
+			})
+
+			It("records the version with the instance", func() {
+				Expect(err).NotTo(HaveOccurred())
+				_, details := fakeStore.CreateInstanceDetailsArgsForCall(0)
+				metadata, err := broker.GetInstanceMetadata(details)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(metadata.MaintenanceVersion).To(Equal("1.1.0"))
+			})
+
+			Context("and the request passes a different version", func() {
+				BeforeEach(func() {
+					maintenanceInfo = &domain.MaintenanceInfo{Version: "1.0.0"}
+				})
+
+				It("rejects the request", func() {
+					Expect(err).To(Equal(apiresponses.ErrMaintenanceInfoConflict))
+					Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		Context("when the request passes maintenance info but the plan has none", func() {
+			BeforeEach(func() {
+				maintenanceInfo = &domain.MaintenanceInfo{Version: "1.0.0"}
+			})
+
+			It("rejects the request", func() {
+				Expect(err).To(Equal(apiresponses.ErrMaintenanceInfoNilConflict))
+			})
+		})
+
+		Context("when the caller sets the reserved metadata key", func() {
+			BeforeEach(func() {
+				rawParameters = json.RawMessage(`{"share":"server/export","nfsbroker_metadata":{}}`)
+			})
+
+			It("rejects the request", func() {
+				Expect(err).To(BeAssignableToTypeOf(&apiresponses.FailureResponse{}))
+				Expect(err.Error()).To(ContainSubstring("nfsbroker_metadata"))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the parameters are not a JSON object", func() {
+			BeforeEach(func() {
+				rawParameters = json.RawMessage(`not-json`)
+			})
+
+			It("lets the wrapped broker report them", func() {
+				Expect(err).To(Equal(apiresponses.ErrRawParamsInvalid))
+			})
+		})
+
+		Context("when the plan is deprecated", func() {
+			BeforeEach(func() {
+				services.ListReturns(catalogWithPlanState(broker.PlanStateDeprecated, "Create an nfs instance instead."))
+			})
+
+			It("refuses the request with the migration hint", func() {
+				Expect(err).To(BeAssignableToTypeOf(&apiresponses.FailureResponse{}))
+				Expect(err.(*apiresponses.FailureResponse).ValidatedStatusCode(nil)).To(Equal(422))
+				Expect(err.(*apiresponses.FailureResponse).LoggerAction()).To(Equal("plan-deprecated"))
+				Expect(err).To(MatchError(`plan "Existing" of service "nfs-legacy" is deprecated, and no longer accepts new instances. Create an nfs instance instead.`))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the plan is disabled", func() {
+			BeforeEach(func() {
+				services.ListReturns(catalogWithPlanState(broker.PlanStateDisabled, ""))
+			})
+
+			It("refuses the request", func() {
+				Expect(err).To(MatchError(`plan "Existing" of service "nfs-legacy" is disabled`))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("#Bind", func() {
+		var (
+			fingerprint interface{}
+			bindParams  json.RawMessage
+			binding     domain.Binding
+			err         error
+		)
+
+		bind := func(params json.RawMessage) (domain.Binding, error) {
+			return subject.Bind(ctx, "some-instance-id", "some-binding-id", domain.BindDetails{
+				AppGUID:       "some-app-guid",
+				ServiceID:     "some-service-id",
+				PlanID:        "some-plan-id",
+				RawParameters: params,
+			}, false)
+		}
+
+		BeforeEach(func() {
+			fingerprint = map[string]interface{}{
+				"share": "server/export",
+				"uid":   "1000",
+				"gid":   "1000",
+			}
+			bindParams = nil
+		})
+
+		JustBeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{
+				ServiceID:          "some-service-id",
+				ServiceFingerPrint: fingerprint,
+			}, nil)
+			binding, err = bind(bindParams)
+		})
+
+		Context("when the instance has no recorded scheme", func() {
+			It("returns the legacy volume id", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts[0].Device.VolumeId).To(MatchRegexp(`^some-instance-id-[0-9a-f]{32}$`))
+			})
+		})
+
+		Context("when the instance has a legacy string fingerprint", func() {
+			BeforeEach(func() {
+				fingerprint = "server/export"
+			})
+
+			It("returns the legacy volume id", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts[0].Device.VolumeId).To(MatchRegexp(`^some-instance-id-[0-9a-f]{32}$`))
+			})
+		})
+
+		Context("when the instance was provisioned with the v2 scheme", func() {
+			BeforeEach(func() {
+				fingerprint.(map[string]interface{})[broker.MetadataKey] = map[string]interface{}{"volume_id_scheme": "v2"}
+			})
+
+			It("returns a v2 volume id", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts[0].Device.VolumeId).To(MatchRegexp(`^some-instance-id-v2-[0-9a-f]{64}$`))
+			})
+
+			It("does not pass the metadata on to the driver", func() {
+				Expect(binding.VolumeMounts[0].Device.MountConfig).NotTo(HaveKey(broker.MetadataKey))
+			})
+
+			It("returns the same volume id when only non-identity options differ", func() {
+				other, err := bind(json.RawMessage(`{"auto_cache":"false"}`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(other.VolumeMounts[0].Device.VolumeId).To(Equal(binding.VolumeMounts[0].Device.VolumeId))
+			})
+
+			It("returns a different volume id when identity options differ", func() {
+				other, err := bind(json.RawMessage(`{"uid":"2000"}`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(other.VolumeMounts[0].Device.VolumeId).NotTo(Equal(binding.VolumeMounts[0].Device.VolumeId))
+			})
+		})
+
+		Context("when the bind parameters try to override the metadata", func() {
+			BeforeEach(func() {
+				bindParams = json.RawMessage(`{"nfsbroker_metadata":{"volume_id_scheme":"v2"}}`)
+			})
+
+			It("rejects the request", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("nfsbroker_metadata"))
+			})
+		})
+
+		Context("when the stored metadata is malformed", func() {
+			BeforeEach(func() {
+				fingerprint.(map[string]interface{})[broker.MetadataKey] = "garbage"
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("invalid nfsbroker_metadata")))
+			})
+		})
+
+		Context("when the instance does not exist", func() {
+			JustBeforeEach(func() {
+				fakeStore.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{}, errors.New("not found"))
+				binding, err = bind(nil)
+			})
+
+			It("returns the wrapped broker's error", func() {
+				Expect(err).To(Equal(apiresponses.ErrInstanceDoesNotExist))
+			})
+		})
+
+		Context("when the plan is deprecated", func() {
+			BeforeEach(func() {
+				services.ListReturns(catalogWithPlanState(broker.PlanStateDeprecated, "Create an nfs instance instead."))
+			})
+
+			It("still binds", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts).To(HaveLen(1))
+			})
+		})
+
+		Context("when the plan is disabled", func() {
+			BeforeEach(func() {
+				services.ListReturns(catalogWithPlanState(broker.PlanStateDisabled, "Create an nfs instance instead."))
+			})
+
+			It("refuses the request with the migration hint", func() {
+				Expect(err).To(BeAssignableToTypeOf(&apiresponses.FailureResponse{}))
+				Expect(err.(*apiresponses.FailureResponse).LoggerAction()).To(Equal("plan-disabled"))
+				Expect(err).To(MatchError(`plan "Existing" of service "nfs-legacy" is disabled, and its instances can no longer be bound. Create an nfs instance instead.`))
+				Expect(fakeStore.RetrieveInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("#Update", func() {
+		var (
+			details     domain.UpdateDetails
+			fingerprint interface{}
+			err         error
+		)
+
+		BeforeEach(func() {
+			services.ListReturns(catalogWithMaintenanceInfo("2.0.0"))
+			fingerprint = "server/export"
+			details = domain.UpdateDetails{
+				ServiceID:       "some-service-id",
+				MaintenanceInfo: &domain.MaintenanceInfo{Version: "2.0.0"},
+				PreviousValues: domain.PreviousValues{
+					PlanID:          "some-plan-id",
+					MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{
+				ServiceID:          "some-service-id",
+				PlanID:             "some-plan-id",
+				ServiceFingerPrint: fingerprint,
+			}, nil)
+			_, err = subject.Update(ctx, "some-instance-id", details, false)
+		})
+
+		It("rewrites the instance under the current policy", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(1))
+			Expect(fakeStore.SaveCallCount()).To(Equal(1))
+
+			id, instance := fakeStore.CreateInstanceDetailsArgsForCall(0)
+			Expect(id).To(Equal("some-instance-id"))
+			Expect(instance.PlanID).To(Equal("some-plan-id"))
+			Expect(instance.ServiceFingerPrint).To(HaveKeyWithValue("share", "server/export"))
+
+			metadata, err := broker.GetInstanceMetadata(instance)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata).To(Equal(broker.InstanceMetadata{
+				VolumeIDScheme:     broker.VolumeIDSchemeV1,
+				MaintenanceVersion: "2.0.0",
+			}))
+		})
+
+		Context("when the broker records a different scheme on new instances", func() {
+			BeforeEach(func() {
+				scheme = broker.VolumeIDSchemeV2
+			})
+
+			It("keeps the legacy scheme of an instance with no metadata", func() {
+				Expect(err).NotTo(HaveOccurred())
+				_, instance := fakeStore.CreateInstanceDetailsArgsForCall(0)
+				metadata, err := broker.GetInstanceMetadata(instance)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(metadata.VolumeIDScheme).To(Equal(broker.VolumeIDSchemeV1))
+			})
+
+			Context("and the instance was provisioned with the v1 scheme", func() {
+				BeforeEach(func() {
+					fingerprint = map[string]interface{}{
+						"share":            "server/export",
+						broker.MetadataKey: map[string]interface{}{"volume_id_scheme": "v1", "maintenance_version": "1.0.0"},
+					}
+				})
+
+				It("keeps the v1 scheme and records the new version", func() {
+					Expect(err).NotTo(HaveOccurred())
+					_, instance := fakeStore.CreateInstanceDetailsArgsForCall(0)
+					metadata, err := broker.GetInstanceMetadata(instance)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(metadata).To(Equal(broker.InstanceMetadata{
+						VolumeIDScheme:     broker.VolumeIDSchemeV1,
+						MaintenanceVersion: "2.0.0",
+					}))
+				})
+			})
+		})
+
+		Context("when the instance no longer satisfies the policy", func() {
+			BeforeEach(func() {
+				fingerprint = map[string]interface{}{"share": "server/export", "nfs_uid": "0"}
+			})
+
+			It("refuses the upgrade", func() {
+				Expect(err).To(BeAssignableToTypeOf(&apiresponses.FailureResponse{}))
+				Expect(err.Error()).To(ContainSubstring("nfs_uid"))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the instance is deprovisioned while it is upgraded", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveInstanceDetailsReturnsOnCall(1, brokerstore.ServiceInstance{}, errors.New("not found"))
+			})
+
+			It("does not write the instance back", func() {
+				Expect(err).To(Equal(apiresponses.ErrInstanceDoesNotExist))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the instance changes while it is upgraded", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveInstanceDetailsReturnsOnCall(1, brokerstore.ServiceInstance{
+					ServiceID:          "some-service-id",
+					PlanID:             "some-plan-id",
+					ServiceFingerPrint: "other-server/export",
+				}, nil)
+			})
+
+			It("refuses the upgrade as concurrent", func() {
+				Expect(err).To(Equal(apiresponses.ErrConcurrentInstanceAccess))
+				Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the requested version is not the catalog version", func() {
+			BeforeEach(func() {
+				details.MaintenanceInfo = &domain.MaintenanceInfo{Version: "3.0.0"}
+			})
+
+			It("rejects the request", func() {
+				Expect(err).To(Equal(apiresponses.ErrMaintenanceInfoConflict))
+			})
+		})
+
+		Context("when the request also passes parameters", func() {
+			BeforeEach(func() {
+				details.RawParameters = json.RawMessage(`{"uid":"1"}`)
+			})
+
+			It("is not supported", func() {
+				Expect(err).To(MatchError(ContainSubstring("does not support instance updates")))
+			})
+		})
+
+		Context("when the request does not pass maintenance info", func() {
+			BeforeEach(func() {
+				details.MaintenanceInfo = nil
+			})
+
+			It("is not supported", func() {
+				Expect(err).To(MatchError(ContainSubstring("does not support instance updates")))
+			})
+		})
+	})
+})
+
+func catalogWithMaintenanceInfo(version string) []domain.Service {
+	return []domain.Service{{
+		ID: "some-service-id",
+		Plans: []domain.ServicePlan{{
+			ID:              "some-plan-id",
+			MaintenanceInfo: &domain.MaintenanceInfo{Version: version},
+		}},
+	}}
+}
+
+func catalogWithPlanState(state, hint string) []domain.Service {
+	metadata := map[string]interface{}{broker.PlanStateKey: state}
+	if hint != "" {
+		metadata[broker.MigrationHintKey] = hint
+	}
+	return []domain.Service{{
+		ID:   "some-service-id",
+		Name: "nfs-legacy",
+		Plans: []domain.ServicePlan{{
+			ID:       "some-plan-id",
+			Name:     "Existing",
+			Metadata: &domain.ServicePlanMetadata{AdditionalMetadata: metadata},
+		}},
+	}}
+}
@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/nfsbroker/metrics"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// Metrics are the broker operation metrics.
+type Metrics struct {
+	operations        *metrics.CounterVec
+	operationDuration *metrics.HistogramVec
+	lockWait          *metrics.HistogramVec
+	storeDuration     *metrics.HistogramVec
+	leasesLost        *metrics.CounterVec
+}
+
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		operations: registry.NewCounterVec(
+			"nfsbroker_operations_total",
+			"OSBAPI operations completed, by result.",
+			"operation", "service", "plan", "result",
+		),
+		operationDuration: registry.NewHistogramVec(
+			"nfsbroker_operation_duration_seconds",
+			"Time taken by OSBAPI operations, including waiting for instance locks.",
+			metrics.DefaultBuckets,
+			"operation", "service", "plan", "result",
+		),
+		lockWait: registry.NewHistogramVec(
+			"nfsbroker_lock_wait_seconds",
+			"Time OSBAPI operations spent waiting for instance locks.",
+			metrics.DefaultBuckets,
+			"operation",
+		),
+		storeDuration: registry.NewHistogramVec(
+			"nfsbroker_store_duration_seconds",
+			"Time taken by broker store calls, by method and result.",
+			metrics.DefaultBuckets,
+			"method", "result",
+		),
+		leasesLost: registry.NewCounterVec(
+			"nfsbroker_leases_lost_total",
+			"Instance leases that expired and were taken over while an operation held them.",
+		),
+	}
+}
+
+// LeaseLost counts an instance lease that was taken over while held.
+func (m *Metrics) LeaseLost() {
+	m.leasesLost.Inc()
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// Instrumented counts and times the operations that change broker state.
+type Instrumented struct {
+	domain.ServiceBroker
+
+	metrics *Metrics
+	clock   clock.Clock
+}
+
+func NewInstrumented(wrapped domain.ServiceBroker, metrics *Metrics, clock clock.Clock) *Instrumented {
+	return &Instrumented{ServiceBroker: wrapped, metrics: metrics, clock: clock}
+}
+
+func (b *Instrumented) observe(operation, service, plan string, start time.Time, err *error) {
+	r := result(*err)
+	b.metrics.operations.Inc(operation, service, plan, r)
+	b.metrics.operationDuration.Observe(b.clock.Since(start).Seconds(), operation, service, plan, r)
+}
+
+func (b *Instrumented) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (_ domain.ProvisionedServiceSpec, err error) {
+	defer b.observe("provision", details.ServiceID, details.PlanID, b.clock.Now(), &err)
+	return b.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Instrumented) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (_ domain.DeprovisionServiceSpec, err error) {
+	defer b.observe("deprovision", details.ServiceID, details.PlanID, b.clock.Now(), &err)
+	return b.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Instrumented) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (_ domain.UpdateServiceSpec, err error) {
+	plan := details.PlanID
+	if plan == "" {
+		plan = details.PreviousValues.PlanID
+	}
+	defer b.observe("update", details.ServiceID, plan, b.clock.Now(), &err)
+	return b.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Instrumented) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (_ domain.Binding, err error) {
+	defer b.observe("bind", details.ServiceID, details.PlanID, b.clock.Now(), &err)
+	return b.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (b *Instrumented) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (_ domain.UnbindSpec, err error) {
+	defer b.observe("unbind", details.ServiceID, details.PlanID, b.clock.Now(), &err)
+	return b.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
@@ -0,0 +1,194 @@
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// InstrumentedStore times every call to the wrapped store.
+type InstrumentedStore struct {
+	store   brokerstore.Store
+	metrics *Metrics
+	clock   clock.Clock
+}
+
+var _ brokerstore.Store = &InstrumentedStore{}
+
+func NewInstrumentedStore(store brokerstore.Store, metrics *Metrics, clock clock.Clock) *InstrumentedStore {
+	return &InstrumentedStore{store: store, metrics: metrics, clock: clock}
+}
+
+func (s *InstrumentedStore) observe(method string, start time.Time, err error) {
+	s.metrics.storeDuration.Observe(s.clock.Since(start).Seconds(), method, result(err))
+}
+
+func (s *InstrumentedStore) RetrieveInstanceDetails(id string) (brokerstore.ServiceInstance, error) {
+	start := s.clock.Now()
+	instance, err := s.store.RetrieveInstanceDetails(id)
+	s.observe("RetrieveInstanceDetails", start, err)
+	return instance, err
+}
+
+func (s *InstrumentedStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	start := s.clock.Now()
+	binding, err := s.store.RetrieveBindingDetails(id)
+	s.observe("RetrieveBindingDetails", start, err)
+	return binding, err
+}
+
+func (s *InstrumentedStore) RetrieveAllInstanceDetails() (map[string]brokerstore.ServiceInstance, error) {
+	start := s.clock.Now()
+	instances, err := s.store.RetrieveAllInstanceDetails()
+	s.observe("RetrieveAllInstanceDetails", start, err)
+	return instances, err
+}
+
+func (s *InstrumentedStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	start := s.clock.Now()
+	bindings, err := s.store.RetrieveAllBindingDetails()
+	s.observe("RetrieveAllBindingDetails", start, err)
+	return bindings, err
+}
+
+func (s *InstrumentedStore) CreateInstanceDetails(id string, details brokerstore.ServiceInstance) error {
+	start := s.clock.Now()
+	err := s.store.CreateInstanceDetails(id, details)
+	s.observe("CreateInstanceDetails", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	start := s.clock.Now()
+	err := s.store.CreateBindingDetails(id, details)
+	s.observe("CreateBindingDetails", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) DeleteInstanceDetails(id string) error {
+	start := s.clock.Now()
+	err := s.store.DeleteInstanceDetails(id)
+	s.observe("DeleteInstanceDetails", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) DeleteBindingDetails(id string) error {
+	start := s.clock.Now()
+	err := s.store.DeleteBindingDetails(id)
+	s.observe("DeleteBindingDetails", start, err)
+	return err
+}
+
+// IsInstanceConflict and IsBindingConflict cannot fail; a store error reads
+// as a conflict.
+func (s *InstrumentedStore) IsInstanceConflict(id string, details brokerstore.ServiceInstance) bool {
+	start := s.clock.Now()
+	conflict := s.store.IsInstanceConflict(id, details)
+	s.observe("IsInstanceConflict", start, nil)
+	return conflict
+}
+
+func (s *InstrumentedStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	start := s.clock.Now()
+	conflict := s.store.IsBindingConflict(id, details)
+	s.observe("IsBindingConflict", start, nil)
+	return conflict
+}
+
+func (s *InstrumentedStore) Restore(logger lager.Logger) error {
+	start := s.clock.Now()
+	err := s.store.Restore(logger)
+	s.observe("Restore", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Save(logger lager.Logger) error {
+	start := s.clock.Now()
+	err := s.store.Save(logger)
+	s.observe("Save", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Cleanup() error {
+	start := s.clock.Now()
+	err := s.store.Cleanup()
+	s.observe("Cleanup", start, err)
+	return err
+}
+
+// Inventory counts the instances and bindings in the store for the
+// nfsbroker_instances and nfsbroker_bindings gauges, and the instances with
+// a legacy fingerprint, a bare share string, for nfsbroker_legacy_instances.
+// Counting reads every record, so counts are refreshed at most once per
+// maxAge however often they are scraped.
+type Inventory struct {
+	logger lager.Logger
+	store  brokerstore.Store
+	clock  clock.Clock
+	maxAge time.Duration
+
+	lock        sync.Mutex
+	refreshedAt time.Time
+	instances   int
+	bindings    int
+	legacy      int
+}
+
+func NewInventory(logger lager.Logger, store brokerstore.Store, clock clock.Clock, maxAge time.Duration) *Inventory {
+	return &Inventory{logger: logger.Session("inventory"), store: store, clock: clock, maxAge: maxAge}
+}
+
+func (i *Inventory) Instances() float64 {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.refresh()
+	return float64(i.instances)
+}
+
+func (i *Inventory) Bindings() float64 {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.refresh()
+	return float64(i.bindings)
+}
+
+func (i *Inventory) LegacyInstances() float64 {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.refresh()
+	return float64(i.legacy)
+}
+
+// refresh keeps the previous counts when the store cannot be read, and does
+// not retry until maxAge has passed.
+func (i *Inventory) refresh() {
+	if !i.refreshedAt.IsZero() && i.clock.Since(i.refreshedAt) < i.maxAge {
+		return
+	}
+	i.refreshedAt = i.clock.Now()
+
+	instances, err := i.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		i.logger.Error("count-failed-keeping-previous-counts", err)
+		return
+	}
+	bindings, err := i.store.RetrieveAllBindingDetails()
+	if err != nil {
+		i.logger.Error("count-failed-keeping-previous-counts", err)
+		return
+	}
+	legacy := 0
+	for _, instance := range instances {
+		if _, ok := instance.ServiceFingerPrint.(string); ok {
+			legacy++
+		}
+	}
+	i.instances, i.bindings, i.legacy = len(instances), len(bindings), legacy
+}
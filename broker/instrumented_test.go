@@ -0,0 +1,163 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/metrics"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// manualClock only moves when told to.
+type manualClock struct {
+	clock.Clock
+
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var _ = Describe("Instrumentation", func() {
+	var (
+		registry *metrics.Registry
+		m        *broker.Metrics
+		clk      *manualClock
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+		m = broker.NewMetrics(registry)
+		clk = &manualClock{now: time.Unix(1000, 0)}
+		ctx = context.TODO()
+	})
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+
+	Describe("Instrumented", func() {
+		var (
+			inner   *fakes.FakeServiceBroker
+			subject *broker.Instrumented
+		)
+
+		BeforeEach(func() {
+			inner = &fakes.FakeServiceBroker{}
+			subject = broker.NewInstrumented(inner, m, clk)
+		})
+
+		It("counts and times operations by service, plan and result", func() {
+			inner.BindStub = func(context.Context, string, string, domain.BindDetails, bool) (domain.Binding, error) {
+				clk.Advance(300 * time.Millisecond)
+				return domain.Binding{}, nil
+			}
+			_, err := subject.Bind(ctx, "instance-id", "binding-id", domain.BindDetails{ServiceID: "nfs", PlanID: "existing"}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			inner.ProvisionReturns(domain.ProvisionedServiceSpec{}, errors.New("bad share"))
+			_, err = subject.Provision(ctx, "instance-id", domain.ProvisionDetails{ServiceID: "nfs", PlanID: "existing"}, false)
+			Expect(err).To(MatchError("bad share"))
+
+			output := scrape()
+			Expect(output).To(ContainSubstring(`nfsbroker_operations_total{operation="bind",service="nfs",plan="existing",result="success"} 1`))
+			Expect(output).To(ContainSubstring(`nfsbroker_operations_total{operation="provision",service="nfs",plan="existing",result="failure"} 1`))
+			Expect(output).To(ContainSubstring(`nfsbroker_operation_duration_seconds_sum{operation="bind",service="nfs",plan="existing",result="success"} 0.3`))
+		})
+
+		It("labels updates that keep their plan with the previous plan", func() {
+			_, err := subject.Update(ctx, "instance-id", domain.UpdateDetails{ServiceID: "nfs", PreviousValues: domain.PreviousValues{PlanID: "existing"}}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(scrape()).To(ContainSubstring(`nfsbroker_operations_total{operation="update",service="nfs",plan="existing",result="success"} 1`))
+		})
+	})
+
+	Describe("InstrumentedStore", func() {
+		It("times store calls by method and result", func() {
+			store := &fakes.FakeStore{}
+			store.RetrieveInstanceDetailsStub = func(string) (brokerstore.ServiceInstance, error) {
+				clk.Advance(50 * time.Millisecond)
+				return brokerstore.ServiceInstance{}, nil
+			}
+			store.DeleteBindingDetailsReturns(errors.New("credhub unavailable"))
+			subject := broker.NewInstrumentedStore(store, m, clk)
+
+			_, err := subject.RetrieveInstanceDetails("instance-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.DeleteBindingDetails("binding-id")).To(MatchError("credhub unavailable"))
+
+			output := scrape()
+			Expect(output).To(ContainSubstring(`nfsbroker_store_duration_seconds_sum{method="RetrieveInstanceDetails",result="success"} 0.05`))
+			Expect(output).To(ContainSubstring(`nfsbroker_store_duration_seconds_count{method="DeleteBindingDetails",result="failure"} 1`))
+		})
+	})
+
+	Describe("Inventory", func() {
+		var (
+			store     *fakes.FakeStore
+			inventory *broker.Inventory
+		)
+
+		BeforeEach(func() {
+			store = &fakes.FakeStore{}
+			store.RetrieveAllInstanceDetailsReturns(map[string]brokerstore.ServiceInstance{"a": {ServiceFingerPrint: "server/export"}, "b": {ServiceFingerPrint: map[string]interface{}{"share": "server/export"}}}, nil)
+			store.RetrieveAllBindingDetailsReturns(map[string]domain.BindDetails{"c": {}}, nil)
+			inventory = broker.NewInventory(lager.NewLogger("test"), store, clk, time.Minute)
+		})
+
+		It("counts instances and bindings", func() {
+			Expect(inventory.Instances()).To(Equal(2.0))
+			Expect(inventory.Bindings()).To(Equal(1.0))
+		})
+
+		It("counts instances with a legacy fingerprint", func() {
+			Expect(inventory.LegacyInstances()).To(Equal(1.0))
+		})
+
+		It("reads the store at most once per interval", func() {
+			inventory.Instances()
+			inventory.Bindings()
+			Expect(store.RetrieveAllInstanceDetailsCallCount()).To(Equal(1))
+
+			clk.Advance(time.Minute)
+			inventory.Instances()
+			Expect(store.RetrieveAllInstanceDetailsCallCount()).To(Equal(2))
+		})
+
+		It("keeps the previous counts when the store cannot be read", func() {
+			inventory.Instances()
+			store.RetrieveAllInstanceDetailsReturns(nil, errors.New("credhub unavailable"))
+			clk.Advance(time.Minute)
+
+			Expect(inventory.Instances()).To(Equal(2.0))
+		})
+	})
+})
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/pivotal-cf/brokerapi/v11/domain/apiresponses"
+)
+
+// ErrLeaseHeld is returned by Leases that gave up waiting for a lease
+// another operation holds.
+var ErrLeaseHeld = errors.New("the instance is locked by another operation")
+
+// Leases lock instances across every broker that shares a store. Acquire
+// returns a function that releases the lease. Forget deletes what it can of
+// the lease of an instance that no longer exists, once it has been released.
+type Leases interface {
+	Acquire(ctx context.Context, instanceID string) (func(), error)
+	Forget(instanceID string) error
+}
+
+// Leased runs the operations that change an instance, or its bindings,
+// under the instance's lease, so that brokers sharing a store do not race
+// between the wrapped broker's conflict checks and its writes. Serialized
+// does the same within one broker, and belongs in front of Leased, so that
+// operations in the same broker wait for one another without polling the
+// store.
+type Leased struct {
+	domain.ServiceBroker
+
+	logger lager.Logger
+	leases Leases
+}
+
+func NewLeased(logger lager.Logger, wrapped domain.ServiceBroker, leases Leases) *Leased {
+	return &Leased{ServiceBroker: wrapped, logger: logger.Session("leased"), leases: leases}
+}
+
+func (b *Leased) acquire(ctx context.Context, operation, instanceID string) (func(), error) {
+	release, err := b.leases.Acquire(ctx, instanceID)
+	if errors.Is(err, ErrLeaseHeld) {
+		b.logger.Info("lease-held", lager.Data{"operation": operation, "instanceID": instanceID})
+		return nil, apiresponses.ErrConcurrentInstanceAccess
+	}
+	if err != nil {
+		b.logger.Error("acquire-lease-failed", err, lager.Data{"operation": operation, "instanceID": instanceID})
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("the instance could not be locked: %w", err),
+			http.StatusServiceUnavailable,
+			"acquire-lease-failed",
+		)
+	}
+	return release, nil
+}
+
+func (b *Leased) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	release, err := b.acquire(ctx, "provision", instanceID)
+	if err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	defer release()
+
+	return b.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Leased) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	release, err := b.acquire(ctx, "deprovision", instanceID)
+	if err != nil {
+		return domain.DeprovisionServiceSpec{}, err
+	}
+
+	spec, err := b.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+	release()
+
+	// the instance is gone, whether this deprovision or an earlier one
+	// deleted it, and its lease can go too
+	if err == nil || errors.Is(err, apiresponses.ErrInstanceDoesNotExist) {
+		if forgetErr := b.leases.Forget(instanceID); forgetErr != nil {
+			b.logger.Error("forget-lease-failed", forgetErr, lager.Data{"instanceID": instanceID})
+		}
+	}
+	return spec, err
+}
+
+func (b *Leased) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (domain.UpdateServiceSpec, error) {
+	release, err := b.acquire(ctx, "update", instanceID)
+	if err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	defer release()
+
+	return b.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Leased) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	release, err := b.acquire(ctx, "bind", instanceID)
+	if err != nil {
+		return domain.Binding{}, err
+	}
+	defer release()
+
+	return b.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (b *Leased) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	release, err := b.acquire(ctx, "unbind", instanceID)
+	if err != nil {
+		return domain.UnbindSpec{}, err
+	}
+	defer release()
+
+	return b.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
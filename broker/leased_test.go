@@ -0,0 +1,129 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/pivotal-cf/brokerapi/v11/domain/apiresponses"
+)
+
+// leaseRecorder grants every lease, unless told to fail, and notes each
+// acquire, release and forget.
+type leaseRecorder struct {
+	err    error
+	events []string
+}
+
+func (l *leaseRecorder) Forget(instanceID string) error {
+	l.events = append(l.events, "forget "+instanceID)
+	return nil
+}
+
+func (l *leaseRecorder) Acquire(_ context.Context, instanceID string) (func(), error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	l.events = append(l.events, "acquire "+instanceID)
+	return func() { l.events = append(l.events, "release "+instanceID) }, nil
+}
+
+var _ = Describe("Leased", func() {
+	var (
+		inner   *fakes.FakeServiceBroker
+		leases  *leaseRecorder
+		subject *broker.Leased
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		inner = &fakes.FakeServiceBroker{}
+		leases = &leaseRecorder{}
+		subject = broker.NewLeased(lager.NewLogger("test"), inner, leases)
+		ctx = context.TODO()
+	})
+
+	It("provisions under the instance's lease", func() {
+		inner.ProvisionStub = func(context.Context, string, domain.ProvisionDetails, bool) (domain.ProvisionedServiceSpec, error) {
+			leases.events = append(leases.events, "provision")
+			return domain.ProvisionedServiceSpec{}, nil
+		}
+
+		_, err := subject.Provision(ctx, "instance-1", domain.ProvisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(leases.events).To(Equal([]string{"acquire instance-1", "provision", "release instance-1"}))
+	})
+
+	It("binds and unbinds under the instance's lease", func() {
+		_, err := subject.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Unbind(ctx, "instance-1", "binding-1", domain.UnbindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(leases.events).To(Equal([]string{"acquire instance-1", "release instance-1", "acquire instance-1", "release instance-1"}))
+	})
+
+	It("forgets the lease once the instance is deprovisioned", func() {
+		inner.DeprovisionStub = func(context.Context, string, domain.DeprovisionDetails, bool) (domain.DeprovisionServiceSpec, error) {
+			leases.events = append(leases.events, "deprovision")
+			return domain.DeprovisionServiceSpec{}, nil
+		}
+
+		_, err := subject.Deprovision(ctx, "instance-1", domain.DeprovisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(leases.events).To(Equal([]string{"acquire instance-1", "deprovision", "release instance-1", "forget instance-1"}))
+	})
+
+	It("forgets the lease of an instance that was already deprovisioned", func() {
+		inner.DeprovisionReturns(domain.DeprovisionServiceSpec{}, apiresponses.ErrInstanceDoesNotExist)
+
+		_, err := subject.Deprovision(ctx, "instance-1", domain.DeprovisionDetails{}, false)
+		Expect(err).To(Equal(apiresponses.ErrInstanceDoesNotExist))
+		Expect(leases.events).To(Equal([]string{"acquire instance-1", "release instance-1", "forget instance-1"}))
+	})
+
+	It("releases the lease when the operation fails", func() {
+		inner.DeprovisionReturns(domain.DeprovisionServiceSpec{}, errors.New("credhub unavailable"))
+
+		_, err := subject.Deprovision(ctx, "instance-1", domain.DeprovisionDetails{}, false)
+		Expect(err).To(MatchError("credhub unavailable"))
+		Expect(leases.events).To(Equal([]string{"acquire instance-1", "release instance-1"}))
+	})
+
+	It("does not lease the instance to poll it", func() {
+		_, err := subject.LastOperation(ctx, "instance-1", domain.PollDetails{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(leases.events).To(BeEmpty())
+	})
+
+	Context("when another operation holds the lease", func() {
+		BeforeEach(func() {
+			leases.err = broker.ErrLeaseHeld
+		})
+
+		It("refuses the operation as concurrent", func() {
+			_, err := subject.Update(ctx, "instance-1", domain.UpdateDetails{}, false)
+			Expect(err).To(Equal(apiresponses.ErrConcurrentInstanceAccess))
+			Expect(inner.UpdateCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the lease cannot be read", func() {
+		BeforeEach(func() {
+			leases.err = errors.New("credhub unavailable")
+		})
+
+		It("fails the operation as unavailable", func() {
+			_, err := subject.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{}, false)
+			Expect(err).To(MatchError("the instance could not be locked: credhub unavailable"))
+			Expect(err.(*apiresponses.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusServiceUnavailable))
+			Expect(inner.BindCallCount()).To(Equal(0))
+		})
+	})
+})
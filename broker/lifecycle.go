@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// The lifecycle states of a plan. A deprecated plan's instances can still be
+// bound, but no new ones created; a disabled plan's can be neither. Both can
+// still be unbound and deprovisioned.
+const (
+	PlanStateActive     = "active"
+	PlanStateDeprecated = "deprecated"
+	PlanStateDisabled   = "disabled"
+)
+
+// The keys of a plan's catalog metadata that publish its state, and what
+// to move to instead, when it is not active.
+const (
+	PlanStateKey     = "state"
+	MigrationHintKey = "migration_hint"
+)
+
+// ValidPlanState reports whether state is one of the lifecycle states.
+func ValidPlanState(state string) bool {
+	return state == PlanStateActive || state == PlanStateDeprecated || state == PlanStateDisabled
+}
+
+// planState reads a plan's state and migration hint from its catalog
+// metadata. A plan without one is active.
+func planState(plan domain.ServicePlan) (string, string) {
+	if plan.Metadata == nil {
+		return PlanStateActive, ""
+	}
+	state, _ := plan.Metadata.AdditionalMetadata[PlanStateKey].(string)
+	if state == "" {
+		state = PlanStateActive
+	}
+	hint, _ := plan.Metadata.AdditionalMetadata[MigrationHintKey].(string)
+	return state, hint
+}
+
+// refusal explains why an operation is refused on a plan, with the plan's
+// migration hint.
+func refusal(service domain.Service, plan domain.ServicePlan, reason, hint string) error {
+	message := fmt.Sprintf("plan %q of service %q %s", plan.Name, service.Name, reason)
+	if hint != "" {
+		message += ". " + hint
+	}
+	return fmt.Errorf("%s", message)
+}
@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// DefaultLockStripes is enough stripes that unrelated instances rarely share
+// one, at a few kilobytes of mutexes.
+const DefaultLockStripes = 256
+
+// Locks are mutexes striped by ID: operations on the same ID always share a
+// mutex, and operations on different IDs usually do not.
+type Locks struct {
+	stripes []sync.Mutex
+}
+
+// NewLocks returns locks with the given number of stripes. With one stripe,
+// every ID shares a single mutex.
+func NewLocks(stripes int) *Locks {
+	if stripes < 1 {
+		stripes = 1
+	}
+	return &Locks{stripes: make([]sync.Mutex, stripes)}
+}
+
+// Lock locks the stripes of every ID, and returns a function that unlocks
+// them. Stripes are always locked in the same order, so callers locking
+// several IDs cannot deadlock one another.
+func (l *Locks) Lock(ids ...string) func() {
+	var stripes []int
+	for _, id := range ids {
+		stripe := l.stripe(id)
+		if !contains(stripes, stripe) {
+			stripes = append(stripes, stripe)
+		}
+	}
+	sort.Ints(stripes)
+
+	for _, stripe := range stripes {
+		l.stripes[stripe].Lock()
+	}
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			l.stripes[stripes[i]].Unlock()
+		}
+	}
+}
+
+func (l *Locks) stripe(id string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(id))
+	return int(hash.Sum32() % uint32(len(l.stripes)))
+}
+
+func contains(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
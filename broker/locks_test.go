@@ -0,0 +1,40 @@
+package broker_test
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/broker"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Locks", func() {
+	It("shares one mutex between every ID when there is one stripe", func() {
+		locks := broker.NewLocks(1)
+		unlock := locks.Lock("instance-1")
+
+		locked := make(chan struct{})
+		go func() {
+			locks.Lock("instance-2", "binding-id")()
+			close(locked)
+		}()
+
+		Consistently(locked).ShouldNot(BeClosed())
+		unlock()
+		Eventually(locked).Should(BeClosed())
+	})
+
+	It("does not deadlock callers that lock the same IDs in different orders", func() {
+		locks := broker.NewLocks(broker.DefaultLockStripes)
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(2)
+			go func() { defer wg.Done(); locks.Lock("a", "b")() }()
+			go func() { defer wg.Done(); locks.Lock("b", "a")() }()
+		}
+
+		finished := make(chan struct{})
+		go func() { wg.Wait(); close(finished) }()
+		Eventually(finished).Should(BeClosed())
+	})
+})
@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+)
+
+// MetadataKey is the reserved service fingerprint key under which the broker
+// records its own bookkeeping for an instance. It must be listed in the mount
+// options mask's ignored keys so that it never reaches the volume driver.
+const MetadataKey = "nfsbroker_metadata"
+
+type InstanceMetadata struct {
+	VolumeIDScheme VolumeIDScheme `json:"volume_id_scheme,omitempty"`
+
+	// MaintenanceVersion is the plan's maintenance_info version that was in
+	// effect when the instance was provisioned or last upgraded.
+	MaintenanceVersion string `json:"maintenance_version,omitempty"`
+}
+
+// GetInstanceMetadata returns the metadata stored in an instance's
+// fingerprint. Instances created before metadata was recorded (including
+// legacy instances whose fingerprint is a bare share string) get the zero
+// value, which callers treat as "legacy behaviour".
+func GetInstanceMetadata(instance brokerstore.ServiceInstance) (InstanceMetadata, error) {
+	fingerprint, ok := instance.ServiceFingerPrint.(map[string]interface{})
+	if !ok {
+		return InstanceMetadata{}, nil
+	}
+
+	raw, ok := fingerprint[MetadataKey]
+	if !ok {
+		return InstanceMetadata{}, nil
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return InstanceMetadata{}, err
+	}
+
+	var metadata InstanceMetadata
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return InstanceMetadata{}, fmt.Errorf("invalid %s in service fingerprint: %s", MetadataKey, err.Error())
+	}
+	return metadata, nil
+}
+
+// withMetadata adds the metadata to provision parameters. Parameters that are
+// not a JSON object are returned untouched so that the wrapped broker reports
+// them as invalid in its usual way. The boolean result reports whether the
+// caller tried to set the reserved key themselves.
+func withMetadata(rawParameters json.RawMessage, metadata InstanceMetadata) (json.RawMessage, bool, error) {
+	var configuration map[string]interface{}
+	if err := json.Unmarshal(rawParameters, &configuration); err != nil || configuration == nil {
+		return rawParameters, false, nil
+	}
+
+	if _, ok := configuration[MetadataKey]; ok {
+		return nil, true, nil
+	}
+	configuration[MetadataKey] = metadata
+
+	b, err := json.Marshal(configuration)
+	return b, false, err
+}
@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// Notifier queues webhook events; see webhooks.Dispatcher.
+type Notifier interface {
+	Enqueue(event webhooks.Event) error
+}
+
+// Notifying queues a webhook event after each provision, bind, unbind and
+// deprovision that succeeds. Repeated requests that change nothing, such as
+// a provision of an instance that already exists, are not events: the
+// wrapped broker accepts an identical repeat as if it were new, so the
+// instance or binding is looked up in the store first. Serialized keeps
+// other operations on the instance out in between.
+//
+// As with Audited, the instance is read from the store before binds, unbinds
+// and deprovisions to find its org, space and share. An event that cannot be
+// queued is logged, and does not fail the operation, which has already
+// happened.
+type Notifying struct {
+	domain.ServiceBroker
+
+	logger   lager.Logger
+	notifier Notifier
+	storeFor func(ctx context.Context) brokerstore.Store
+}
+
+func NewNotifying(logger lager.Logger, wrapped domain.ServiceBroker, notifier Notifier, storeFor func(ctx context.Context) brokerstore.Store) *Notifying {
+	return &Notifying{ServiceBroker: wrapped, logger: logger.Session("notify"), notifier: notifier, storeFor: storeFor}
+}
+
+func (b *Notifying) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	_, err := b.storeFor(ctx).RetrieveInstanceDetails(instanceID)
+	existed := err == nil
+
+	spec, err := b.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+	if err != nil || spec.AlreadyExists || existed {
+		return spec, err
+	}
+
+	b.enqueue(webhooks.Event{
+		Type:             webhooks.EventProvision,
+		InstanceID:       instanceID,
+		ServiceID:        details.ServiceID,
+		PlanID:           details.PlanID,
+		OrganizationGUID: details.OrganizationGUID,
+		SpaceGUID:        details.SpaceGUID,
+		Share:            shareOf(parametersOf(details.RawParameters)),
+	})
+	return spec, nil
+}
+
+func (b *Notifying) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	event := b.eventForInstance(ctx, webhooks.EventDeprovision, instanceID, "")
+	event.ServiceID, event.PlanID = details.ServiceID, details.PlanID
+
+	spec, err := b.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+	if err != nil {
+		return spec, err
+	}
+	b.enqueue(event)
+	return spec, nil
+}
+
+func (b *Notifying) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	event := b.eventForInstance(ctx, webhooks.EventBind, instanceID, bindingID)
+	event.ServiceID, event.PlanID = details.ServiceID, details.PlanID
+	event.AppGUID = details.AppGUID
+	if details.BindResource != nil && details.BindResource.AppGuid != "" {
+		event.AppGUID = details.BindResource.AppGuid
+	}
+
+	_, err := b.storeFor(ctx).RetrieveBindingDetails(bindingID)
+	existed := err == nil
+
+	binding, err := b.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+	if err != nil || binding.AlreadyExists || existed {
+		return binding, err
+	}
+	b.enqueue(event)
+	return binding, nil
+}
+
+func (b *Notifying) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	event := b.eventForInstance(ctx, webhooks.EventUnbind, instanceID, bindingID)
+	event.ServiceID, event.PlanID = details.ServiceID, details.PlanID
+
+	spec, err := b.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+	if err != nil {
+		return spec, err
+	}
+	b.enqueue(event)
+	return spec, nil
+}
+
+// eventForInstance fills in what the store knows about the instance. An
+// instance that cannot be read is reported without it.
+func (b *Notifying) eventForInstance(ctx context.Context, eventType, instanceID, bindingID string) webhooks.Event {
+	event := webhooks.Event{Type: eventType, InstanceID: instanceID, BindingID: bindingID}
+
+	instance, err := b.storeFor(ctx).RetrieveInstanceDetails(instanceID)
+	if err != nil {
+		return event
+	}
+	event.OrganizationGUID, event.SpaceGUID = instance.OrganizationGUID, instance.SpaceGUID
+	switch fingerprint := instance.ServiceFingerPrint.(type) {
+	case map[string]interface{}:
+		event.Share = shareOf(fingerprint)
+	case string:
+		event.Share = fingerprint
+	}
+	return event
+}
+
+func (b *Notifying) enqueue(event webhooks.Event) {
+	if err := b.notifier.Enqueue(event); err != nil {
+		b.logger.Error("enqueue-failed", err, lager.Data{"event": event.Type, "instance_id": event.InstanceID, "binding_id": event.BindingID})
+	}
+}
@@ -0,0 +1,168 @@
+package broker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// eventRecorder keeps every event it is given.
+type eventRecorder struct {
+	lock   sync.Mutex
+	events []webhooks.Event
+	err    error
+}
+
+func (r *eventRecorder) Enqueue(event webhooks.Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event)
+	return r.err
+}
+
+var _ = Describe("Notifying", func() {
+	var (
+		inner    *fakes.FakeServiceBroker
+		store    *fakes.FakeStore
+		recorder *eventRecorder
+		logs     *gbytes.Buffer
+		subject  *broker.Notifying
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		inner = &fakes.FakeServiceBroker{}
+		store = &fakes.FakeStore{}
+		store.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: map[string]interface{}{"share": "server/export"},
+		}, nil)
+		store.RetrieveBindingDetailsReturns(domain.BindDetails{}, errors.New("not found"))
+		recorder = &eventRecorder{}
+		logs = gbytes.NewBuffer()
+		logger := lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+		subject = broker.NewNotifying(logger, inner, recorder, func(context.Context) brokerstore.Store {
+			return store
+		})
+		ctx = context.TODO()
+	})
+
+	It("notifies of provisions", func() {
+		store.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{}, errors.New("not found"))
+
+		_, err := subject.Provision(ctx, "instance-1", domain.ProvisionDetails{
+			ServiceID:        "nfs",
+			PlanID:           "existing",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			RawParameters:    json.RawMessage(`{"share": "server/export"}`),
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.events).To(Equal([]webhooks.Event{{
+			Type:             webhooks.EventProvision,
+			InstanceID:       "instance-1",
+			ServiceID:        "nfs",
+			PlanID:           "existing",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			Share:            "server/export",
+		}}))
+	})
+
+	It("notifies of binds with the instance's org, space and share", func() {
+		_, err := subject.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{
+			ServiceID:    "nfs",
+			PlanID:       "existing",
+			BindResource: &domain.BindResource{AppGuid: "app-guid"},
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.events).To(Equal([]webhooks.Event{{
+			Type:             webhooks.EventBind,
+			InstanceID:       "instance-1",
+			BindingID:        "binding-1",
+			ServiceID:        "nfs",
+			PlanID:           "existing",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			AppGUID:          "app-guid",
+			Share:            "server/export",
+		}}))
+	})
+
+	It("reads the instance before deprovisioning it", func() {
+		inner.DeprovisionStub = func(context.Context, string, domain.DeprovisionDetails, bool) (domain.DeprovisionServiceSpec, error) {
+			store.RetrieveInstanceDetailsReturns(brokerstore.ServiceInstance{}, errors.New("not found"))
+			return domain.DeprovisionServiceSpec{}, nil
+		}
+
+		_, err := subject.Deprovision(ctx, "instance-1", domain.DeprovisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.events).To(HaveLen(1))
+		Expect(recorder.events[0].Type).To(Equal(webhooks.EventDeprovision))
+		Expect(recorder.events[0].Share).To(Equal("server/export"))
+	})
+
+	It("notifies of unbinds", func() {
+		_, err := subject.Unbind(ctx, "instance-1", "binding-1", domain.UnbindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.events).To(ConsistOf(HaveField("Type", webhooks.EventUnbind)))
+	})
+
+	It("does not notify of failures", func() {
+		inner.BindReturns(domain.Binding{}, errors.New("credhub unavailable"))
+
+		_, err := subject.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{}, false)
+		Expect(err).To(MatchError("credhub unavailable"))
+		Expect(recorder.events).To(BeEmpty())
+	})
+
+	It("does not notify of requests that change nothing", func() {
+		inner.ProvisionReturns(domain.ProvisionedServiceSpec{AlreadyExists: true}, nil)
+		inner.BindReturns(domain.Binding{AlreadyExists: true}, nil)
+
+		_, err := subject.Provision(ctx, "instance-1", domain.ProvisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.events).To(BeEmpty())
+	})
+
+	It("does not notify of a repeated provision or bind that the wrapped broker accepts", func() {
+		store.RetrieveBindingDetailsReturns(domain.BindDetails{AppGUID: "app-guid"}, nil)
+
+		_, err := subject.Provision(ctx, "instance-1", domain.ProvisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(inner.ProvisionCallCount()).To(Equal(1))
+		Expect(inner.BindCallCount()).To(Equal(1))
+		Expect(recorder.events).To(BeEmpty())
+	})
+
+	It("logs events that cannot be queued without failing the operation", func() {
+		recorder.err = errors.New("credhub unavailable")
+
+		_, err := subject.Unbind(ctx, "instance-1", "binding-1", domain.UnbindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logs).To(gbytes.Say("enqueue-failed"))
+	})
+})
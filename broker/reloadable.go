@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// Reloadable serves every request with the most recently loaded broker, so
+// that the catalog and mount option policy can be replaced without a restart.
+//
+// The lock is only held to read or swap the current broker, never for a
+// request: in-flight requests finish with the broker they started with, and
+// Reload does not wait for them.
+type Reloadable struct {
+	lock    sync.RWMutex
+	current domain.ServiceBroker
+}
+
+func NewReloadable(initial domain.ServiceBroker) *Reloadable {
+	return &Reloadable{current: initial}
+}
+
+// Reload replaces the broker that serves subsequent requests.
+func (r *Reloadable) Reload(next domain.ServiceBroker) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.current = next
+}
+
+func (r *Reloadable) broker() domain.ServiceBroker {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.current
+}
+
+func (r *Reloadable) Services(ctx context.Context) ([]domain.Service, error) {
+	return r.broker().Services(ctx)
+}
+
+func (r *Reloadable) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	return r.broker().Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (r *Reloadable) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	return r.broker().Deprovision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (r *Reloadable) GetInstance(ctx context.Context, instanceID string, details domain.FetchInstanceDetails) (domain.GetInstanceDetailsSpec, error) {
+	return r.broker().GetInstance(ctx, instanceID, details)
+}
+
+func (r *Reloadable) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (domain.UpdateServiceSpec, error) {
+	return r.broker().Update(ctx, instanceID, details, asyncAllowed)
+}
+
+func (r *Reloadable) LastOperation(ctx context.Context, instanceID string, details domain.PollDetails) (domain.LastOperation, error) {
+	return r.broker().LastOperation(ctx, instanceID, details)
+}
+
+func (r *Reloadable) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	return r.broker().Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (r *Reloadable) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	return r.broker().Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (r *Reloadable) GetBinding(ctx context.Context, instanceID, bindingID string, details domain.FetchBindingDetails) (domain.GetBindingSpec, error) {
+	return r.broker().GetBinding(ctx, instanceID, bindingID, details)
+}
+
+func (r *Reloadable) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details domain.PollDetails) (domain.LastOperation, error) {
+	return r.broker().LastBindingOperation(ctx, instanceID, bindingID, details)
+}
@@ -0,0 +1,79 @@
+package broker_test
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+var _ = Describe("Reloadable", func() {
+	var (
+		first, second *fakes.FakeServiceBroker
+		subject       *broker.Reloadable
+		ctx           context.Context
+	)
+
+	BeforeEach(func() {
+		first = &fakes.FakeServiceBroker{}
+		first.ServicesReturns([]domain.Service{{ID: "first"}}, nil)
+		second = &fakes.FakeServiceBroker{}
+		second.ServicesReturns([]domain.Service{{ID: "second"}}, nil)
+		ctx = context.TODO()
+
+		subject = broker.NewReloadable(first)
+	})
+
+	It("serves requests with the initial broker", func() {
+		services, err := subject.Services(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(services).To(Equal([]domain.Service{{ID: "first"}}))
+
+		_, err = subject.Bind(ctx, "instance-id", "binding-id", domain.BindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.BindCallCount()).To(Equal(1))
+	})
+
+	It("serves requests with the reloaded broker after a reload", func() {
+		subject.Reload(second)
+
+		services, err := subject.Services(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(services).To(Equal([]domain.Service{{ID: "second"}}))
+
+		_, err = subject.Provision(ctx, "instance-id", domain.ProvisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.ProvisionCallCount()).To(Equal(0))
+		Expect(second.ProvisionCallCount()).To(Equal(1))
+	})
+
+	It("reloads without waiting for in-flight requests, which finish with their broker", func() {
+		provisioning := make(chan struct{})
+		release := make(chan struct{})
+		first.ProvisionStub = func(context.Context, string, domain.ProvisionDetails, bool) (domain.ProvisionedServiceSpec, error) {
+			close(provisioning)
+			<-release
+			return domain.ProvisionedServiceSpec{}, nil
+		}
+
+		provisioned := make(chan struct{})
+		go func() {
+			defer close(provisioned)
+			subject.Provision(ctx, "instance-id", domain.ProvisionDetails{}, false)
+		}()
+		Eventually(provisioning).Should(BeClosed())
+
+		subject.Reload(second)
+		services, err := subject.Services(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(services).To(Equal([]domain.Service{{ID: "second"}}))
+
+		close(release)
+		Eventually(provisioned).Should(BeClosed())
+		Expect(first.ProvisionCallCount()).To(Equal(1))
+		Expect(second.ProvisionCallCount()).To(Equal(0))
+	})
+})
@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// Serialized runs the operations that existingvolumebroker.Broker serializes
+// with its global mutex under per-instance locks instead, so that a slow
+// CredHub call for one instance does not hold up the others, and measures the
+// time spent waiting for them. Behind PerRequest, each request has its own
+// existingvolumebroker.Broker, and these are the only locks that serialize
+// them. Upgrades, which Broker makes itself by reading the instance and
+// writing it back, take the instance's lock too.
+//
+// Operations on the same instance still run one at a time, and binds and
+// unbinds also hold their binding's lock, so the wrapped broker's instance
+// and binding conflict checks see every earlier operation on the same ID.
+type Serialized struct {
+	domain.ServiceBroker
+
+	locks   *Locks
+	metrics *Metrics
+	clock   clock.Clock
+}
+
+func NewSerialized(wrapped domain.ServiceBroker, stripes int, metrics *Metrics, clock clock.Clock) *Serialized {
+	return &Serialized{ServiceBroker: wrapped, locks: NewLocks(stripes), metrics: metrics, clock: clock}
+}
+
+func (b *Serialized) acquire(operation string, ids ...string) func() {
+	start := b.clock.Now()
+	unlock := b.locks.Lock(ids...)
+	b.metrics.lockWait.Observe(b.clock.Since(start).Seconds(), operation)
+	return unlock
+}
+
+func (b *Serialized) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	defer b.acquire("provision", instanceID)()
+
+	return b.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Serialized) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	defer b.acquire("deprovision", instanceID)()
+
+	return b.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Serialized) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (domain.UpdateServiceSpec, error) {
+	defer b.acquire("update", instanceID)()
+
+	return b.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *Serialized) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	defer b.acquire("bind", instanceID, bindingID)()
+
+	return b.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (b *Serialized) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	defer b.acquire("unbind", instanceID, bindingID)()
+
+	return b.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (b *Serialized) LastOperation(ctx context.Context, instanceID string, details domain.PollDetails) (domain.LastOperation, error) {
+	defer b.acquire("last_operation", instanceID)()
+
+	return b.ServiceBroker.LastOperation(ctx, instanceID, details)
+}
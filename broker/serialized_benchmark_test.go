@@ -0,0 +1,58 @@
+package broker_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/metrics"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// credhubLatency stands in for the CredHub round trips a real operation makes
+// while it holds its lock.
+const credhubLatency = time.Millisecond
+
+// BenchmarkSerialized provisions and binds a new instance per iteration, from
+// many goroutines at once. One stripe is the global mutex the broker used to
+// have; compare it with the default:
+//
+//	go test ./broker -run '^$' -bench Serialized
+func BenchmarkSerialized(b *testing.B) {
+	for _, stripes := range []int{1, broker.DefaultLockStripes} {
+		b.Run(fmt.Sprintf("stripes=%d", stripes), func(b *testing.B) {
+			inner := &fakes.FakeServiceBroker{}
+			inner.ProvisionStub = func(context.Context, string, domain.ProvisionDetails, bool) (domain.ProvisionedServiceSpec, error) {
+				time.Sleep(credhubLatency)
+				return domain.ProvisionedServiceSpec{}, nil
+			}
+			inner.BindStub = func(context.Context, string, string, domain.BindDetails, bool) (domain.Binding, error) {
+				time.Sleep(credhubLatency)
+				return domain.Binding{}, nil
+			}
+			subject := broker.NewSerialized(inner, stripes, broker.NewMetrics(metrics.NewRegistry()), clock.NewClock())
+
+			var next int64
+			ctx := context.Background()
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := fmt.Sprintf("instance-%d", atomic.AddInt64(&next, 1))
+					if _, err := subject.Provision(ctx, id, domain.ProvisionDetails{}, false); err != nil {
+						b.Error(err)
+					}
+					if _, err := subject.Bind(ctx, id, id+"-binding", domain.BindDetails{}, false); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package broker_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/metrics"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+var _ = Describe("Serialized", func() {
+	var (
+		registry     *metrics.Registry
+		clk          *manualClock
+		ctx          context.Context
+		inner        *fakes.FakeServiceBroker
+		subject      *broker.Serialized
+		provisioning chan struct{}
+		release      chan struct{}
+	)
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+		clk = &manualClock{now: time.Unix(1000, 0)}
+		ctx = context.TODO()
+		inner = &fakes.FakeServiceBroker{}
+		subject = broker.NewSerialized(inner, broker.DefaultLockStripes, broker.NewMetrics(registry), clk)
+
+		provisioning = make(chan struct{})
+		release = make(chan struct{})
+		provisioned := make(chan struct{})
+		inner.ProvisionStub = func(context.Context, string, domain.ProvisionDetails, bool) (domain.ProvisionedServiceSpec, error) {
+			close(provisioning)
+			<-release
+			clk.Advance(2 * time.Second)
+			return domain.ProvisionedServiceSpec{}, nil
+		}
+
+		go func() {
+			defer close(provisioned)
+			subject.Provision(ctx, "instance-1", domain.ProvisionDetails{}, false)
+		}()
+		Eventually(provisioning).Should(BeClosed())
+
+		DeferCleanup(func() {
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+			Eventually(provisioned).Should(BeClosed())
+		})
+	})
+
+	bindInBackground := func(instanceID, bindingID string) chan struct{} {
+		bound := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := subject.Bind(ctx, instanceID, bindingID, domain.BindDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			close(bound)
+		}()
+		return bound
+	}
+
+	It("runs one operation on an instance at a time and records the wait", func() {
+		bound := bindInBackground("instance-1", "binding-id")
+
+		Consistently(bound).ShouldNot(BeClosed())
+		Expect(inner.BindCallCount()).To(Equal(0))
+		close(release)
+		Eventually(bound).Should(BeClosed())
+
+		Expect(scrape()).To(ContainSubstring(`nfsbroker_lock_wait_seconds_sum{operation="bind"} 2`))
+	})
+
+	It("runs operations on other instances at once", func() {
+		Eventually(bindInBackground("instance-2", "binding-id")).Should(BeClosed())
+
+		_, err := subject.LastOperation(ctx, "instance-3", domain.PollDetails{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("runs one operation on a binding at a time, whichever instance it names", func() {
+		inner.BindStub = func(context.Context, string, string, domain.BindDetails, bool) (domain.Binding, error) {
+			<-release
+			return domain.Binding{}, nil
+		}
+		first := bindInBackground("instance-2", "binding-id")
+		Eventually(inner.BindCallCount).Should(Equal(1))
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := subject.Unbind(ctx, "instance-3", "binding-id", domain.UnbindDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			close(done)
+		}()
+
+		Consistently(done).ShouldNot(BeClosed())
+		close(release)
+		Eventually(first).Should(BeClosed())
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("does not deprovision an instance while it is upgraded", func() {
+		inner.UpdateStub = func(context.Context, string, domain.UpdateDetails, bool) (domain.UpdateServiceSpec, error) {
+			<-release
+			return domain.UpdateServiceSpec{}, nil
+		}
+		updated := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := subject.Update(ctx, "instance-2", domain.UpdateDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			close(updated)
+		}()
+		Eventually(inner.UpdateCallCount).Should(Equal(1))
+
+		deprovisioned := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := subject.Deprovision(ctx, "instance-2", domain.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			close(deprovisioned)
+		}()
+
+		Consistently(deprovisioned).ShouldNot(BeClosed())
+		Expect(inner.DeprovisionCallCount()).To(Equal(0))
+		close(release)
+		Eventually(updated).Should(BeClosed())
+		Eventually(deprovisioned).Should(BeClosed())
+	})
+})
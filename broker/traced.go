@@ -0,0 +1,191 @@
+package broker
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/tracing"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// PerRequest serves each request with a broker built for it, so that the
+// store the broker is given can carry the request's context, and so its
+// trace, into store calls, which do not take one.
+//
+// Brokers built this way share no existingvolumebroker mutex; Serialized
+// must be in front of PerRequest to serialize their operations on each
+// instance.
+type PerRequest struct {
+	build func(ctx context.Context) domain.ServiceBroker
+}
+
+var _ domain.ServiceBroker = &PerRequest{}
+
+func NewPerRequest(build func(ctx context.Context) domain.ServiceBroker) *PerRequest {
+	return &PerRequest{build: build}
+}
+
+func (b *PerRequest) Services(ctx context.Context) ([]domain.Service, error) {
+	return b.build(ctx).Services(ctx)
+}
+
+func (b *PerRequest) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	return b.build(ctx).Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *PerRequest) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	return b.build(ctx).Deprovision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *PerRequest) GetInstance(ctx context.Context, instanceID string, details domain.FetchInstanceDetails) (domain.GetInstanceDetailsSpec, error) {
+	return b.build(ctx).GetInstance(ctx, instanceID, details)
+}
+
+func (b *PerRequest) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (domain.UpdateServiceSpec, error) {
+	return b.build(ctx).Update(ctx, instanceID, details, asyncAllowed)
+}
+
+func (b *PerRequest) LastOperation(ctx context.Context, instanceID string, details domain.PollDetails) (domain.LastOperation, error) {
+	return b.build(ctx).LastOperation(ctx, instanceID, details)
+}
+
+func (b *PerRequest) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	return b.build(ctx).Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (b *PerRequest) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	return b.build(ctx).Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (b *PerRequest) GetBinding(ctx context.Context, instanceID, bindingID string, details domain.FetchBindingDetails) (domain.GetBindingSpec, error) {
+	return b.build(ctx).GetBinding(ctx, instanceID, bindingID, details)
+}
+
+func (b *PerRequest) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details domain.PollDetails) (domain.LastOperation, error) {
+	return b.build(ctx).LastBindingOperation(ctx, instanceID, bindingID, details)
+}
+
+// TracedStore records a span for each store call, as a child of the span in
+// the context it was built with. The wrapped store is built afresh for each
+// call from the context of the call's own span, so that the requests it makes
+// to CredHub appear beneath it.
+type TracedStore struct {
+	ctx      context.Context
+	tracer   *tracing.Tracer
+	storeFor func(ctx context.Context) brokerstore.Store
+}
+
+var _ brokerstore.Store = &TracedStore{}
+
+func NewTracedStore(ctx context.Context, tracer *tracing.Tracer, storeFor func(ctx context.Context) brokerstore.Store) *TracedStore {
+	return &TracedStore{ctx: ctx, tracer: tracer, storeFor: storeFor}
+}
+
+func (s *TracedStore) start(method, id string) (*tracing.Span, brokerstore.Store) {
+	span, ctx := s.tracer.StartSpan(s.ctx, "store "+method, "")
+	if id != "" {
+		span.Tag("store.id", id)
+	}
+	return span, s.storeFor(ctx)
+}
+
+func (s *TracedStore) RetrieveInstanceDetails(id string) (brokerstore.ServiceInstance, error) {
+	span, store := s.start("RetrieveInstanceDetails", id)
+	defer span.Finish()
+	instance, err := store.RetrieveInstanceDetails(id)
+	span.SetError(err)
+	return instance, err
+}
+
+func (s *TracedStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	span, store := s.start("RetrieveBindingDetails", id)
+	defer span.Finish()
+	binding, err := store.RetrieveBindingDetails(id)
+	span.SetError(err)
+	return binding, err
+}
+
+func (s *TracedStore) RetrieveAllInstanceDetails() (map[string]brokerstore.ServiceInstance, error) {
+	span, store := s.start("RetrieveAllInstanceDetails", "")
+	defer span.Finish()
+	instances, err := store.RetrieveAllInstanceDetails()
+	span.SetError(err)
+	return instances, err
+}
+
+func (s *TracedStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	span, store := s.start("RetrieveAllBindingDetails", "")
+	defer span.Finish()
+	bindings, err := store.RetrieveAllBindingDetails()
+	span.SetError(err)
+	return bindings, err
+}
+
+func (s *TracedStore) CreateInstanceDetails(id string, details brokerstore.ServiceInstance) error {
+	span, store := s.start("CreateInstanceDetails", id)
+	defer span.Finish()
+	err := store.CreateInstanceDetails(id, details)
+	span.SetError(err)
+	return err
+}
+
+func (s *TracedStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	span, store := s.start("CreateBindingDetails", id)
+	defer span.Finish()
+	err := store.CreateBindingDetails(id, details)
+	span.SetError(err)
+	return err
+}
+
+func (s *TracedStore) DeleteInstanceDetails(id string) error {
+	span, store := s.start("DeleteInstanceDetails", id)
+	defer span.Finish()
+	err := store.DeleteInstanceDetails(id)
+	span.SetError(err)
+	return err
+}
+
+func (s *TracedStore) DeleteBindingDetails(id string) error {
+	span, store := s.start("DeleteBindingDetails", id)
+	defer span.Finish()
+	err := store.DeleteBindingDetails(id)
+	span.SetError(err)
+	return err
+}
+
+func (s *TracedStore) IsInstanceConflict(id string, details brokerstore.ServiceInstance) bool {
+	span, store := s.start("IsInstanceConflict", id)
+	defer span.Finish()
+	return store.IsInstanceConflict(id, details)
+}
+
+func (s *TracedStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	span, store := s.start("IsBindingConflict", id)
+	defer span.Finish()
+	return store.IsBindingConflict(id, details)
+}
+
+func (s *TracedStore) Restore(logger lager.Logger) error {
+	span, store := s.start("Restore", "")
+	defer span.Finish()
+	err := store.Restore(logger)
+	span.SetError(err)
+	return err
+}
+
+func (s *TracedStore) Save(logger lager.Logger) error {
+	span, store := s.start("Save", "")
+	defer span.Finish()
+	err := store.Save(logger)
+	span.SetError(err)
+	return err
+}
+
+func (s *TracedStore) Cleanup() error {
+	span, store := s.start("Cleanup", "")
+	defer span.Finish()
+	err := store.Cleanup()
+	span.SetError(err)
+	return err
+}
@@ -0,0 +1,92 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/tracing"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+type spanRecorder struct {
+	lock  sync.Mutex
+	spans []model.SpanModel
+}
+
+func (r *spanRecorder) Report(span model.SpanModel) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+var _ = Describe("Tracing", func() {
+	var (
+		reporter *spanRecorder
+		tracer   *tracing.Tracer
+		parent   *tracing.Span
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		reporter = &spanRecorder{}
+		tracer = tracing.NewTracer("nfsbroker", reporter, clock.NewClock())
+		parent, ctx = tracer.StartSpan(context.Background(), "PUT /v2/service_instances/:instance_id", model.Server)
+	})
+
+	Describe("TracedStore", func() {
+		It("records each call as a child span, and builds the store for it", func() {
+			inner := &fakes.FakeStore{}
+			inner.DeleteBindingDetailsReturns(errors.New("credhub unavailable"))
+			var built []*tracing.Span
+			subject := broker.NewTracedStore(ctx, tracer, func(ctx context.Context) brokerstore.Store {
+				built = append(built, tracing.SpanFromContext(ctx))
+				return inner
+			})
+
+			_, err := subject.RetrieveInstanceDetails("instance-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.DeleteBindingDetails("binding-id")).To(MatchError("credhub unavailable"))
+
+			Expect(reporter.spans).To(HaveLen(2))
+			Expect(reporter.spans[0].Name).To(Equal("store RetrieveInstanceDetails"))
+			Expect(reporter.spans[0].TraceID).To(Equal(parent.Context().TraceID))
+			Expect(*reporter.spans[0].ParentID).To(Equal(parent.Context().ID))
+			Expect(reporter.spans[0].Tags).To(HaveKeyWithValue("store.id", "instance-id"))
+			Expect(reporter.spans[1].Tags).To(HaveKeyWithValue("error", "credhub unavailable"))
+
+			Expect(built).To(HaveLen(2))
+			Expect(built[0].Context().ID).To(Equal(reporter.spans[0].ID))
+			Expect(built[1].Context().ID).To(Equal(reporter.spans[1].ID))
+		})
+	})
+
+	Describe("PerRequest", func() {
+		It("builds a broker for each request with its context", func() {
+			var contexts []context.Context
+			inner := &fakes.FakeServiceBroker{}
+			subject := broker.NewPerRequest(func(ctx context.Context) domain.ServiceBroker {
+				contexts = append(contexts, ctx)
+				return inner
+			})
+
+			_, err := subject.Bind(ctx, "instance-id", "binding-id", domain.BindDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = subject.Services(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(contexts).To(HaveLen(2))
+			Expect(tracing.SpanFromContext(contexts[0])).To(BeIdenticalTo(parent))
+			Expect(tracing.SpanFromContext(contexts[1])).To(BeNil())
+			Expect(inner.BindCallCount()).To(Equal(1))
+			Expect(inner.ServicesCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type VolumeIDScheme string
+
+const (
+	// VolumeIDSchemeV1 is the legacy scheme: an md5 over the JSON encoding of
+	// every mount option. It is kept so that instances provisioned before v2
+	// continue to hand out the same volume ID to new bindings.
+	VolumeIDSchemeV1 VolumeIDScheme = "v1"
+
+	// VolumeIDSchemeV2 is a sha256 over the canonical encoding of the
+	// identity-relevant mount options only (see identityKeys).
+	VolumeIDSchemeV2 VolumeIDScheme = "v2"
+)
+
+// identityKeys are the mount options that change what ends up mounted on the
+// cell. Options such as auto_cache or the container mount path do not, so
+// bindings that differ only in those share a volume ID (and a mount).
+var identityKeys = []string{"gid", "readonly", "source", "uid", "username", "version"}
+
+func ParseVolumeIDScheme(s string) (VolumeIDScheme, error) {
+	switch VolumeIDScheme(s) {
+	case VolumeIDSchemeV1, VolumeIDSchemeV2:
+		return VolumeIDScheme(s), nil
+	}
+	return "", fmt.Errorf("unknown volume id scheme %q, expected one of: %s, %s", s, VolumeIDSchemeV1, VolumeIDSchemeV2)
+}
+
+// VolumeIDV2 derives a v2 volume ID of the form <instanceID>-v2-<sha256>.
+// The hash input is one "key=value\n" line per identity key present in the
+// mount config, in sorted key order, so it is independent of map ordering.
+// Each value is written in its canonical form (see canonicalValue), so that
+// the same mount hashes the same whether its options arrived as JSON numbers,
+// booleans or strings. Volume IDs are stored with bindings on the cells, so
+// this encoding must never change.
+func VolumeIDV2(instanceID string, mountConfig map[string]interface{}) string {
+	keys := []string{}
+	for _, k := range identityKeys {
+		if _, ok := mountConfig[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var canonical strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&canonical, "%s=%s\n", k, canonicalValue(mountConfig[k]))
+	}
+
+	return fmt.Sprintf("%s-%s-%x", instanceID, VolumeIDSchemeV2, sha256.Sum256([]byte(canonical.String())))
+}
+
+// canonicalValue writes a mount option value the way the driver reads it:
+// strings as they are, booleans as "true" or "false", and numbers in decimal
+// without an exponent, trailing zeros or a trailing point, so 1000, 1e3 and
+// "1000" are all "1000" and 0.5 is "0.5". Anything else is formatted with %v.
+func canonicalValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case int:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return v.String()
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,66 @@
+package broker_test
+
+import (
+	"code.cloudfoundry.org/nfsbroker/broker"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VolumeIDV2", func() {
+	var mountConfig map[string]interface{}
+
+	BeforeEach(func() {
+		mountConfig = map[string]interface{}{
+			"source":     "nfs://server/export",
+			"uid":        "1000",
+			"gid":        "1000",
+			"version":    "4.1",
+			"auto_cache": "true",
+		}
+	})
+
+	It("is a stable function of the identity-relevant options", func() {
+		Expect(broker.VolumeIDV2("instance", mountConfig)).To(Equal(
+			"instance-v2-87ca1966a79350e748740071c98bb399f7a30dc51dcd9335c8920a47e74c2e2e",
+		))
+	})
+
+	It("ignores options that do not change the mount", func() {
+		before := broker.VolumeIDV2("instance", mountConfig)
+		delete(mountConfig, "auto_cache")
+		mountConfig["cache"] = "false"
+		Expect(broker.VolumeIDV2("instance", mountConfig)).To(Equal(before))
+	})
+
+	It("gives the same ID whether an option is a number, a boolean or a string", func() {
+		before := broker.VolumeIDV2("instance", mountConfig)
+		mountConfig["uid"] = float64(1000)
+		Expect(broker.VolumeIDV2("instance", mountConfig)).To(Equal(before))
+		mountConfig["uid"] = 1e3
+		mountConfig["gid"] = 1000
+		Expect(broker.VolumeIDV2("instance", mountConfig)).To(Equal(before))
+
+		mountConfig["readonly"] = "true"
+		before = broker.VolumeIDV2("instance", mountConfig)
+		mountConfig["readonly"] = true
+		Expect(broker.VolumeIDV2("instance", mountConfig)).To(Equal(before))
+	})
+
+	It("changes when an identity option changes", func() {
+		before := broker.VolumeIDV2("instance", mountConfig)
+		mountConfig["source"] = "nfs://other-server/export"
+		Expect(broker.VolumeIDV2("instance", mountConfig)).NotTo(Equal(before))
+	})
+})
+
+var _ = Describe("ParseVolumeIDScheme", func() {
+	It("accepts the known schemes", func() {
+		Expect(broker.ParseVolumeIDScheme("v1")).To(Equal(broker.VolumeIDSchemeV1))
+		Expect(broker.ParseVolumeIDScheme("v2")).To(Equal(broker.VolumeIDSchemeV2))
+	})
+
+	It("rejects anything else", func() {
+		_, err := broker.ParseVolumeIDScheme("v3")
+		Expect(err).To(MatchError(ContainSubstring("unknown volume id scheme")))
+	})
+})
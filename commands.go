@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/credhub-cli/credhub/server"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/admin"
+	"code.cloudfoundry.org/nfsbroker/audit"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/credhubstore"
+	"code.cloudfoundry.org/nfsbroker/explain"
+	"code.cloudfoundry.org/nfsbroker/reconcile"
+	"code.cloudfoundry.org/nfsbroker/shares"
+	"code.cloudfoundry.org/nfsbroker/tlsconfig"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+)
+
+// A command is an alternative mode of the nfsbroker binary, selected by the
+// first non-flag argument. Commands see the same flags as the broker itself:
+//
+//	nfsbroker -servicesConfig services.json validate-config
+type command struct {
+	description string
+	run         func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = map[string]command{
+	"admin": {
+		description: "Inspect, delete, repair and rewrite records in the store:\n      " + strings.ReplaceAll(admin.Usage, "\n", "\n      "),
+		run:         adminCommand,
+	},
+	"audit-verify": {
+		description: "Verify the hash chain of the audit log given as an argument, or in auditLog, then exit",
+		run:         auditVerifyCommand,
+	},
+	"explain": {
+		description: "Show the mount config a bind would give, which defaults were applied and which options were rejected and why, without contacting the store, then exit:\n      explain [-plan ID|NAME] -share SHARE [-provision JSON] [-bind JSON] [-instance ID] [-json]",
+		run:         explainCommand,
+	},
+	"reconcile": {
+		description: "Compare the store with Cloud Controller and report the instances and bindings only one of them has, then exit. With -gc, delete the store-only records; with -json, write the report as JSON",
+		run:         reconcileCommand,
+	},
+	"shares": {
+		description: "Report which orgs, spaces and plans use each NFS server and export, with instance and binding counts and effective uid and gid, as CSV or with -json as JSON, then exit. Bindings are counted only when Cloud Controller is configured",
+		run:         sharesCommand,
+	},
+	"validate-config": {
+		description: "Validate the services config and mount option policy, then exit",
+		run:         validateConfigCommand,
+	},
+}
+
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "\nERROR: unknown command %q.\n\n", args[0])
+		printCommands(os.Stderr)
+		return 1
+	}
+
+	if err := cmd.run(args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s\n\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+func printCommands(w io.Writer) {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n    \t%s\n", name, commands[name].description)
+	}
+}
+
+func validateConfigCommand(_ []string, stdout, _ io.Writer) error {
+	errs := validateConfig()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	fmt.Fprintln(stdout, "configuration is valid")
+	return nil
+}
+
+// adminCommand connects to the store configured for the broker, the same
+// way the broker does, and with -storeLeases, changes instances under the
+// leases the brokers take.
+func adminCommand(args []string, stdout, stderr io.Writer) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage:\n%s", admin.Usage)
+	}
+
+	clients, err := newCommandCredhub()
+	if err != nil {
+		return err
+	}
+	logger := lager.NewLogger("nfsbroker")
+	store := credhubstore.NewStore(logger, credhubstore.NewCredhubShim(clients.Client()), *storeID)
+
+	command := &admin.Command{
+		Admin:  admin.New(logger, store),
+		Stdin:  os.Stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if *storeLeases {
+		command.Admin.LeaseWith(credhubstore.NewLeases(logger, clients.Client(), *storeID, clock.NewClock(), credhubstore.DefaultLeasePolicy))
+	}
+
+	// the broker may be appending to the same log, which Open allows for
+	if *auditLog != "" {
+		log, closer, err := audit.Open(*auditLog, clock.NewClock())
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		command.Admin.AuditTo(log, operator())
+	}
+
+	if *ccAPIURL != "" {
+		if err := checkReconcileParams(true); err != nil {
+			return err
+		}
+		factory, err := newTransportFactory()
+		if err != nil {
+			return err
+		}
+		command.Bindings = newCloudController(factory.Client(nil))
+	}
+	return command.Run(args)
+}
+
+// operator names the user running a command, for the audit log.
+func operator() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+func sharesCommand(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("shares", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "write the report as JSON rather than CSV")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("shares takes no arguments, not %q", flags.Args())
+	}
+
+	mask, err := newConfigMask(flagSettings())
+	if err != nil {
+		return fmt.Errorf("invalid mount option policy: %w", err)
+	}
+	store, err := newCommandStore()
+	if err != nil {
+		return err
+	}
+	var finder shares.BindingFinder
+	if *ccAPIURL != "" {
+		if err := checkReconcileParams(true); err != nil {
+			return err
+		}
+		factory, err := newTransportFactory()
+		if err != nil {
+			return err
+		}
+		finder = newCloudController(factory.Client(nil))
+	}
+
+	report, err := shares.NewReporter(lager.NewLogger("nfsbroker"), store, finder, mask.Defaults).Report(context.Background())
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else if err := shares.WriteCSV(stdout, report); err != nil {
+		return err
+	}
+	if report.BindingsError != "" {
+		return fmt.Errorf("bindings could not be counted: %s", report.BindingsError)
+	}
+	return nil
+}
+
+// newCommandStore returns the CredHub store, without the logging, metrics
+// and tracing the broker adds.
+func newCommandStore() (*credhubstore.Store, error) {
+	clients, err := newCommandCredhub()
+	if err != nil {
+		return nil, err
+	}
+	return credhubstore.NewStore(lager.NewLogger("nfsbroker"), credhubstore.NewCredhubShim(clients.Client()), *storeID), nil
+}
+
+// newCommandCredhub connects to the CredHub configured for the broker.
+func newCommandCredhub() (*credhubstore.Clients, error) {
+	if *credhubURL == "" {
+		return nil, errors.New("credhubURL must be provided")
+	}
+	if err := checkCredhubParams(); err != nil {
+		return nil, err
+	}
+
+	factory, err := newTransportFactory()
+	if err != nil {
+		return nil, err
+	}
+	var certificate *tls.Certificate
+	if *credhubClientCertPath != "" {
+		loaded, err := tls.LoadX509KeyPair(*credhubClientCertPath, *credhubClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credhub client certificate: %w", err)
+		}
+		certificate = &loaded
+	}
+	client := factory.Client(certificate)
+
+	info, err := credhubInfo(client)
+	if err != nil {
+		return nil, err
+	}
+	caCerts, err := readCACerts()
+	if err != nil {
+		return nil, err
+	}
+	return newCredhubClients(client, info, caCerts)
+}
+
+// explainCommand explains with the services config and mount option policy
+// the broker would load.
+func explainCommand(args []string, stdout, stderr io.Writer) error {
+	var request explain.Request
+	var provision, bind string
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&request.PlanID, "plan", "", "plan ID or name; may be left out when there is only one plan")
+	flags.StringVar(&request.Share, "share", "", "share to provision, as server/export")
+	flags.StringVar(&provision, "provision", "", "provision parameters, as a JSON object")
+	flags.StringVar(&bind, "bind", "", "bind parameters, as a JSON object")
+	flags.StringVar(&request.InstanceID, "instance", "", "instance ID to compute the volume ID with")
+	asJSON := flags.Bool("json", false, "write the explanation as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("explain takes no arguments, not %q", flags.Args())
+	}
+	if provision != "" {
+		if err := json.Unmarshal([]byte(provision), &request.ProvisionParameters); err != nil {
+			return fmt.Errorf("-provision must be a JSON object: %w", err)
+		}
+	}
+	if bind != "" {
+		if err := json.Unmarshal([]byte(bind), &request.BindParameters); err != nil {
+			return fmt.Errorf("-bind must be a JSON object: %w", err)
+		}
+	}
+
+	scheme, err := broker.ParseVolumeIDScheme(*volumeIDScheme)
+	if err != nil {
+		return err
+	}
+	logger := lager.NewLogger("nfsbroker")
+	config, err := newExplainConfig(logger, scheme, flagSettings())
+	if err != nil {
+		return err
+	}
+
+	explanation, err := explain.New(logger, config).Explain(context.Background(), request)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(explanation)
+	}
+	return explain.WriteText(stdout, explanation)
+}
+
+func reconcileCommand(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	collect := flags.Bool("gc", false, "delete the records only the store has")
+	asJSON := flags.Bool("json", false, "write the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := checkReconcileParams(true); err != nil {
+		return err
+	}
+	store, err := newCommandStore()
+	if err != nil {
+		return err
+	}
+	factory, err := newTransportFactory()
+	if err != nil {
+		return err
+	}
+
+	reconciler := reconcile.NewReconciler(lager.NewLogger("nfsbroker"), store, newCloudController(factory.Client(nil)), clock.NewClock())
+	report, err := reconciler.Reconcile(context.Background(), *collect)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writeReconcileReport(stdout, report)
+	}
+	if len(report.DeleteErrors) > 0 {
+		return fmt.Errorf("%d record(s) could not be deleted", len(report.DeleteErrors))
+	}
+	return nil
+}
+
+func writeReconcileReport(w io.Writer, report reconcile.Report) {
+	sections := []struct {
+		title string
+		ids   []string
+	}{
+		{"instances only in the store", report.StoreOnlyInstances},
+		{"bindings only in the store", report.StoreOnlyBindings},
+		{"instances only in cloud controller", report.PlatformOnlyInstances},
+		{"bindings only in cloud controller", report.PlatformOnlyBindings},
+		{"deleted instances", report.DeletedInstances},
+		{"deleted bindings", report.DeletedBindings},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(w, "%s: %d\n", section.title, len(section.ids))
+		for _, id := range section.ids {
+			if message, failed := report.DeleteErrors[id]; failed {
+				fmt.Fprintf(w, "  %s (not deleted: %s)\n", id, message)
+			} else {
+				fmt.Fprintf(w, "  %s\n", id)
+			}
+		}
+	}
+}
+
+func credhubInfo(client *http.Client) (server.Info, error) {
+	var info server.Info
+
+	resp, err := client.Get(*credhubURL + "/info")
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return info, fmt.Errorf("credhub responded with %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
+
+func auditVerifyCommand(args []string, stdout, _ io.Writer) error {
+	path := *auditLog
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" || path == audit.SyslogTarget {
+		return errors.New("audit-verify needs the path of an audit log file")
+	}
+
+	/* #nosec */
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	summary, err := audit.Verify(file)
+	if err != nil {
+		return fmt.Errorf("audit log %s failed verification: %w", path, err)
+	}
+
+	fmt.Fprintf(stdout, "audit log is intact\nentries: %d\nchains: %d\nlast hash: %s\n", summary.Entries, summary.Chains, summary.LastHash)
+	return nil
+}
+
+// validateConfig checks everything that can be checked without contacting
+// the store.
+func validateConfig() []error {
+	var errs []error
+
+	if *servicesConfig == "" {
+		errs = append(errs, errors.New("servicesConfig parameter must be provided"))
+	} else if _, err := NewServicesFromConfig(*servicesConfig); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := newConfigMask(flagSettings()); err != nil {
+		errs = append(errs, fmt.Errorf("invalid mount option policy: %w", err))
+	}
+
+	if *maintenanceInfoVersion != "" && !semverPattern.MatchString(*maintenanceInfoVersion) {
+		errs = append(errs, errors.New("maintenanceInfoVersion must be a semantic version"))
+	}
+
+	if _, err := broker.ParseVolumeIDScheme(*volumeIDScheme); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := checkTracingParams(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := checkTLSParams(); err != nil {
+		errs = append(errs, err)
+	} else if *tlsCertFile != "" {
+		minVersion, _ := tlsconfig.ParseMinVersion(*tlsMinVersion)
+		if _, err := tlsconfig.NewServer(lager.NewLogger("validate-config"), *tlsCertFile, *tlsKeyFile, *tlsClientCAFile, minVersion); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := checkCredhubParams(); err != nil {
+		errs = append(errs, err)
+	} else if *credhubClientCertPath != "" {
+		if _, err := tls.LoadX509KeyPair(*credhubClientCertPath, *credhubClientKeyPath); err != nil {
+			errs = append(errs, fmt.Errorf("invalid credhub client certificate: %w", err))
+		}
+	}
+
+	if _, err := newTransportFactory(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := loadCredentials(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if *webhooksConfig != "" {
+		if _, err := webhooks.LoadSubscribers(*webhooksConfig); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := checkReconcileParams(*reconcileInterval > 0); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var configFile = flag.String(
+	"configFile",
+	"",
+	"(optional) Path to a YAML config file. Flags take precedence over the environment, which takes precedence over the file",
+)
+
+// Config is the optional broker config file. Every setting in it can also be
+// given as a flag; precedence, lowest first, is file, environment, flags.
+type Config struct {
+	ListenAddr      string                `yaml:"listen_addr"`
+	ServicesConfig  string                `yaml:"services_config"`
+	MountOptions    MountOptionsConfig    `yaml:"mount_options"`
+	VolumeIDScheme  string                `yaml:"volume_id_scheme"`
+	MaintenanceInfo MaintenanceInfoConfig `yaml:"maintenance_info"`
+	Store           StoreConfig           `yaml:"store"`
+	CredHub         CredHubConfig         `yaml:"credhub"`
+	UAA             UAAConfig             `yaml:"uaa"`
+	Auth            AuthConfig            `yaml:"auth"`
+	TLS             TLSConfig             `yaml:"tls"`
+	Outbound        OutboundConfig        `yaml:"outbound"`
+	Tracing         TracingConfig         `yaml:"tracing"`
+	Audit           AuditConfig           `yaml:"audit"`
+	Webhooks        WebhooksConfig        `yaml:"webhooks"`
+	CloudController CloudControllerConfig `yaml:"cloud_controller"`
+	Reconcile       ReconcileConfig       `yaml:"reconcile"`
+	LogLevel        string                `yaml:"log_level"`
+	DebugAddr       string                `yaml:"debug_addr"`
+	HealthAddr      string                `yaml:"health_addr"`
+}
+
+type MountOptionsConfig struct {
+	Allowed    []string          `yaml:"allowed"`
+	Defaults   map[string]string `yaml:"defaults"`
+	ConfigFile string            `yaml:"config_file"`
+}
+
+type MaintenanceInfoConfig struct {
+	Version     string `yaml:"version"`
+	Description string `yaml:"description"`
+}
+
+// StoreConfig selects where broker state is kept: in CredHub when
+// credhub.url is set, or for CF pushed brokers, the service named here.
+type StoreConfig struct {
+	ID            string `yaml:"id"`
+	DataDir       string `yaml:"data_dir"`
+	CFServiceName string `yaml:"cf_service_name"`
+	// Leases lock instances in CredHub, so that several brokers can share
+	// one store.
+	Leases bool `yaml:"leases"`
+}
+
+// CredHubConfig authenticates to CredHub with a client certificate when
+// ClientCertPath is set, and with the UAA client otherwise.
+type CredHubConfig struct {
+	URL            string `yaml:"url"`
+	CACertPath     string `yaml:"ca_cert_path"`
+	ClientCertPath string `yaml:"client_cert_path"`
+	ClientKeyPath  string `yaml:"client_key_path"`
+}
+
+type UAAConfig struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	CACertPath   string `yaml:"ca_cert_path"`
+}
+
+// TLSConfig configures TLS for the broker API listener.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	MinVersion   string `yaml:"min_version"`
+}
+
+// OutboundConfig applies to every connection the broker makes, to CredHub and
+// UAA alike. Proxies are read from the environment.
+type OutboundConfig struct {
+	Timeout string `yaml:"timeout"`
+}
+
+// TracingConfig sends traces of broker API requests to a Zipkin collector.
+type TracingConfig struct {
+	Endpoint string `yaml:"endpoint"`
+}
+
+// AuditConfig names the file, or syslog, that the audit log is written to.
+type AuditConfig struct {
+	Log string `yaml:"log"`
+}
+
+// WebhooksConfig names the file listing webhook subscribers.
+type WebhooksConfig struct {
+	ConfigFile string `yaml:"config_file"`
+}
+
+// CloudControllerConfig is the Cloud Controller the store is reconciled
+// against, and the UAA client to read it with.
+type CloudControllerConfig struct {
+	APIURL       string `yaml:"api_url"`
+	CACertPath   string `yaml:"ca_cert_path"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	BrokerName   string `yaml:"broker_name"`
+}
+
+// ReconcileConfig schedules reconciliation against Cloud Controller.
+type ReconcileConfig struct {
+	Interval       string `yaml:"interval"`
+	GarbageCollect bool   `yaml:"garbage_collect"`
+}
+
+type AuthConfig struct {
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+// reloadableFlags are re-applied from the config file on SIGHUP.
+var reloadableFlags = []string{
+	"servicesConfig",
+	"allowedOptions",
+	"defaultOptions",
+	"mountOptionsConfig",
+	"maintenanceInfoVersion",
+	"maintenanceInfoDescription",
+}
+
+// commandLineFlags records the flags given explicitly on the command line,
+// which nothing in the config file or environment may override.
+var commandLineFlags = map[string]bool{}
+
+func recordCommandLineFlags() {
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+}
+
+// LoadConfig strictly decodes a config file; unknown keys are an error.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	/* #nosec */
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return config, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Flags returns the flag values set by the config file, keyed by flag name.
+func (c Config) Flags() map[string]string {
+	values := map[string]string{
+		"listenAddr":                 c.ListenAddr,
+		"servicesConfig":             c.ServicesConfig,
+		"allowedOptions":             strings.Join(c.MountOptions.Allowed, ","),
+		"defaultOptions":             joinOptions(c.MountOptions.Defaults),
+		"mountOptionsConfig":         c.MountOptions.ConfigFile,
+		"volumeIdScheme":             c.VolumeIDScheme,
+		"maintenanceInfoVersion":     c.MaintenanceInfo.Version,
+		"maintenanceInfoDescription": c.MaintenanceInfo.Description,
+		"storeID":                    c.Store.ID,
+		"dataDir":                    c.Store.DataDir,
+		"cfServiceName":              c.Store.CFServiceName,
+		"storeLeases":                flagBool(c.Store.Leases),
+		"credhubURL":                 c.CredHub.URL,
+		"credhubCACertPath":          c.CredHub.CACertPath,
+		"credhubClientCertPath":      c.CredHub.ClientCertPath,
+		"credhubClientKeyPath":       c.CredHub.ClientKeyPath,
+		"uaaClientID":                c.UAA.ClientID,
+		"uaaClientSecret":            c.UAA.ClientSecret,
+		"uaaCACertPath":              c.UAA.CACertPath,
+		"logLevel":                   c.LogLevel,
+		"debugAddr":                  c.DebugAddr,
+		"healthAddr":                 c.HealthAddr,
+		"credentialsFile":            c.Auth.CredentialsFile,
+		"tlsCertFile":                c.TLS.CertFile,
+		"tlsKeyFile":                 c.TLS.KeyFile,
+		"tlsClientCAFile":            c.TLS.ClientCAFile,
+		"tlsMinVersion":              c.TLS.MinVersion,
+		"outboundTimeout":            c.Outbound.Timeout,
+		"tracingEndpoint":            c.Tracing.Endpoint,
+		"auditLog":                   c.Audit.Log,
+		"webhooksConfig":             c.Webhooks.ConfigFile,
+		"ccAPIURL":                   c.CloudController.APIURL,
+		"ccCACertPath":               c.CloudController.CACertPath,
+		"ccClientID":                 c.CloudController.ClientID,
+		"ccClientSecret":             c.CloudController.ClientSecret,
+		"ccBrokerName":               c.CloudController.BrokerName,
+		"reconcileInterval":          c.Reconcile.Interval,
+		"reconcileGarbageCollect":    flagBool(c.Reconcile.GarbageCollect),
+	}
+
+	for name, value := range values {
+		if value == "" {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// flagBool leaves a false setting unset, so that it does not override the
+// environment.
+func flagBool(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
+
+func joinOptions(options map[string]string) string {
+	var pairs []string
+	for key, value := range options {
+		pairs = append(pairs, key+":"+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// applyConfigFile sets every flag that was not given on the command line from
+// the config file, if there is one.
+func applyConfigFile() error {
+	if *configFile == "" {
+		return nil
+	}
+
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range config.Flags() {
+		if commandLineFlags[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid config file %s: %w", *configFile, err)
+		}
+	}
+
+	username, password = config.Auth.Username, config.Auth.Password
+	return nil
+}
+
+// brokerSettings are the settings the service broker is built from, which
+// are the reloadable flags.
+type brokerSettings struct {
+	servicesConfig             string
+	allowedOptions             string
+	defaultOptions             string
+	mountOptionsConfig         string
+	maintenanceInfoVersion     string
+	maintenanceInfoDescription string
+}
+
+// newBrokerSettings reads broker settings from reloadable flag values, keyed
+// by flag name.
+func newBrokerSettings(values map[string]string) brokerSettings {
+	return brokerSettings{
+		servicesConfig:             values["servicesConfig"],
+		allowedOptions:             values["allowedOptions"],
+		defaultOptions:             values["defaultOptions"],
+		mountOptionsConfig:         values["mountOptionsConfig"],
+		maintenanceInfoVersion:     values["maintenanceInfoVersion"],
+		maintenanceInfoDescription: values["maintenanceInfoDescription"],
+	}
+}
+
+// flagSettings returns the broker settings the flags hold now.
+func flagSettings() brokerSettings {
+	return newBrokerSettings(reloadableFlagValues())
+}
+
+func reloadableFlagValues() map[string]string {
+	values := map[string]string{}
+	for _, name := range reloadableFlags {
+		values[name] = flag.Lookup(name).Value.String()
+	}
+	return values
+}
+
+// check makes the checks on the settings that checkParams makes at startup.
+func (s brokerSettings) check() error {
+	if s.servicesConfig == "" {
+		return errors.New("servicesConfig parameter must be provided")
+	}
+	if s.maintenanceInfoVersion != "" && !semverPattern.MatchString(s.maintenanceInfoVersion) {
+		return errors.New("maintenanceInfoVersion must be a semantic version")
+	}
+	return nil
+}
+
+// reloadConfigFile reads the reloadable flag values from the config file,
+// without setting them, so that the caller can build and check the broker
+// before committing to them with setFlags. Settings removed from the file
+// revert to their flag defaults, and flags given on the command line keep
+// their values.
+func reloadConfigFile() (map[string]string, error) {
+	values := reloadableFlagValues()
+	if *configFile == "" {
+		return values, nil
+	}
+
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		return nil, err
+	}
+	fromFile := config.Flags()
+
+	for _, name := range reloadableFlags {
+		if commandLineFlags[name] {
+			continue
+		}
+		value, ok := fromFile[name]
+		if !ok {
+			value = flag.Lookup(name).DefValue
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// setFlags sets each flag to its value.
+func setFlags(values map[string]string) error {
+	for name, value := range values {
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid config file %s: %w", *configFile, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var configPath string
+
+	BeforeEach(func() {
+		configPath = filepath.Join(GinkgoT().TempDir(), "config.yml")
+	})
+
+	Describe("LoadConfig", func() {
+		It("maps settings onto their flags", func() {
+			Expect(os.WriteFile(configPath, []byte(`
+listen_addr: 0.0.0.0:9000
+services_config: /var/vcap/jobs/nfsbroker/config/services.json
+mount_options:
+  allowed: [source, uid, gid]
+  defaults:
+    uid: "1000"
+    auto_cache: "true"
+store:
+  id: my-store
+  leases: true
+credhub:
+  url: https://credhub.service.internal:8844
+uaa:
+  client_id: nfs-broker
+auth:
+  username: admin
+`), 0600)).To(Succeed())
+
+			config, err := LoadConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Auth.Username).To(Equal("admin"))
+			Expect(config.Flags()).To(Equal(map[string]string{
+				"listenAddr":     "0.0.0.0:9000",
+				"servicesConfig": "/var/vcap/jobs/nfsbroker/config/services.json",
+				"allowedOptions": "source,uid,gid",
+				"defaultOptions": "auto_cache:true,uid:1000",
+				"storeID":        "my-store",
+				"storeLeases":    "true",
+				"credhubURL":     "https://credhub.service.internal:8844",
+				"uaaClientID":    "nfs-broker",
+			}))
+		})
+
+		It("maps reconcile settings onto their flags", func() {
+			Expect(os.WriteFile(configPath, []byte(`
+cloud_controller:
+  api_url: https://api.example.com
+  client_id: reconciler
+  broker_name: nfs
+reconcile:
+  interval: 1h
+  garbage_collect: true
+`), 0600)).To(Succeed())
+
+			config, err := LoadConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Flags()).To(Equal(map[string]string{
+				"ccAPIURL":                "https://api.example.com",
+				"ccClientID":              "reconciler",
+				"ccBrokerName":            "nfs",
+				"reconcileInterval":       "1h",
+				"reconcileGarbageCollect": "true",
+			}))
+		})
+
+		It("accepts an empty file", func() {
+			Expect(os.WriteFile(configPath, nil, 0600)).To(Succeed())
+
+			config, err := LoadConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Flags()).To(BeEmpty())
+		})
+
+		It("rejects unknown keys", func() {
+			Expect(os.WriteFile(configPath, []byte("credhub:\n  url: https://credhub\n  ca_cert: /tmp/ca.pem\n"), 0600)).To(Succeed())
+
+			_, err := LoadConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("line 3: field ca_cert not found")))
+		})
+	})
+
+	Describe("reloadConfigFile", func() {
+		BeforeEach(func() {
+			previous := *configFile
+			*configFile = configPath
+			DeferCleanup(func() { *configFile = previous })
+		})
+
+		It("reads the reloadable settings without setting the flags", func() {
+			Expect(os.WriteFile(configPath, []byte(`
+services_config: /var/vcap/jobs/nfsbroker/config/services.json
+maintenance_info:
+  version: 2.0.0
+`), 0600)).To(Succeed())
+			before := flagSettings()
+
+			values, err := reloadConfigFile()
+			Expect(err).NotTo(HaveOccurred())
+			settings := newBrokerSettings(values)
+			Expect(settings.servicesConfig).To(Equal("/var/vcap/jobs/nfsbroker/config/services.json"))
+			Expect(settings.maintenanceInfoVersion).To(Equal("2.0.0"))
+			Expect(settings.check()).To(Succeed())
+			Expect(flagSettings()).To(Equal(before))
+		})
+
+		It("rejects settings that startup would reject", func() {
+			Expect(os.WriteFile(configPath, []byte(`
+services_config: /var/vcap/jobs/nfsbroker/config/services.json
+maintenance_info:
+  version: two
+`), 0600)).To(Succeed())
+
+			values, err := reloadConfigFile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newBrokerSettings(values).check()).To(MatchError("maintenanceInfoVersion must be a semantic version"))
+		})
+	})
+})
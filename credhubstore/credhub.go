@@ -0,0 +1,216 @@
+package credhubstore
+
+import (
+	"context"
+	"net/http"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/auth"
+	"code.cloudfoundry.org/credhub-cli/credhub/auth/uaa"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+)
+
+// Config describes how to reach and authenticate to CredHub.
+type Config struct {
+	URL string
+	// AuthURL is the UAA server that issues tokens. When it is empty it is
+	// read from CredHub's /info endpoint.
+	AuthURL string
+	// ServerVersion, when known, saves looking it up before each write.
+	ServerVersion string
+	CACerts       []string
+	// ClientCertPath and ClientKeyPath authenticate with a client
+	// certificate rather than a UAA token, so that the broker does not
+	// depend on UAA being available.
+	ClientCertPath  string
+	ClientKeyPath   string
+	UAAClientID     string
+	UAAClientSecret string
+}
+
+// NewClient returns a CredHub client that makes its requests to CredHub and
+// UAA with httpClient. Only the unauthenticated /info lookups, when AuthURL or
+// ServerVersion are not given, use the client's own transport, which is
+// configured with the same CA certificates and client certificate.
+func NewClient(config Config, httpClient *http.Client) (*credhub.CredHub, error) {
+	options := []credhub.Option{credhub.SetHttpTimeout(&httpClient.Timeout)}
+	if len(config.CACerts) > 0 {
+		options = append(options, credhub.CaCerts(config.CACerts...))
+	}
+	if config.AuthURL != "" {
+		options = append(options, credhub.AuthURL(config.AuthURL))
+	}
+	if config.ServerVersion != "" {
+		options = append(options, credhub.ServerVersion(config.ServerVersion))
+	}
+
+	if config.ClientCertPath != "" {
+		options = append(options,
+			credhub.ClientCert(config.ClientCertPath, config.ClientKeyPath),
+			credhub.Auth(withClient(httpClient, auth.Noop)),
+		)
+	} else {
+		options = append(options, credhub.Auth(withClient(httpClient, auth.UaaClientCredentials(config.UAAClientID, config.UAAClientSecret))))
+	}
+
+	return credhub.New(config.URL, options...)
+}
+
+// withClient makes an auth strategy send its requests, and request its
+// tokens, with client.
+func withClient(client *http.Client, builder auth.Builder) auth.Builder {
+	return func(config auth.Config) (auth.Strategy, error) {
+		return builder(authConfig{Config: config, client: client})
+	}
+}
+
+type authConfig struct {
+	auth.Config
+	client *http.Client
+}
+
+func (c authConfig) Client() *http.Client {
+	return c.client
+}
+
+// Clients hands out CredHub clients whose requests, including those for UAA
+// tokens, carry the values of a context, such as the trace of the broker
+// request they are made for. The CredHub client does not take a context
+// itself. Every client shares one UAA token.
+type Clients struct {
+	shared     *credhub.CredHub
+	httpClient *http.Client
+	authURL    string
+	// tokens is nil when authenticating with a client certificate
+	tokens *auth.OAuthStrategy
+}
+
+func NewClients(config Config, httpClient *http.Client) (*Clients, error) {
+	shared, err := NewClient(config, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := &Clients{shared: shared, httpClient: httpClient}
+	if tokens, ok := shared.Auth.(*auth.OAuthStrategy); ok {
+		// looked up once here, rather than from /info by every client
+		if clients.authURL, err = shared.AuthURL(); err != nil {
+			return nil, err
+		}
+		clients.tokens = tokens
+	}
+	return clients, nil
+}
+
+// Client returns the client for requests made outside any broker request.
+func (c *Clients) Client() *credhub.CredHub {
+	return c.shared
+}
+
+// ForContext returns a client for requests made on behalf of ctx. Only the
+// values of ctx are used: each request keeps its own timeout.
+func (c *Clients) ForContext(ctx context.Context) *credhub.CredHub {
+	httpClient := &http.Client{
+		Transport: &contextTransport{values: ctx, base: c.httpClient.Transport},
+		Timeout:   c.httpClient.Timeout,
+	}
+
+	client := *c.shared
+	if c.tokens == nil {
+		client.Auth = &auth.NoopStrategy{Client: httpClient}
+		return &client
+	}
+	client.Auth = &sharedToken{
+		OAuthStrategy: &auth.OAuthStrategy{
+			ClientId:                c.tokens.ClientId,
+			ClientSecret:            c.tokens.ClientSecret,
+			ApiClient:               httpClient,
+			OAuthClient:             &uaa.Client{AuthURL: c.authURL, Client: httpClient},
+			ClientCredentialRefresh: true,
+		},
+		shared: c.tokens,
+	}
+	return &client
+}
+
+// sharedToken authenticates with the shared token, and shares any token it
+// has to fetch.
+type sharedToken struct {
+	*auth.OAuthStrategy
+	shared *auth.OAuthStrategy
+}
+
+func (s *sharedToken) Do(req *http.Request) (*http.Response, error) {
+	s.SetTokens(s.shared.AccessToken(), s.shared.RefreshToken())
+	resp, err := s.OAuthStrategy.Do(req)
+	if token := s.AccessToken(); token != "" && token != s.shared.AccessToken() {
+		s.shared.SetTokens(token, s.RefreshToken())
+	}
+	return resp, err
+}
+
+type contextTransport struct {
+	values context.Context
+	base   http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req.WithContext(withValues{Context: req.Context(), values: t.values}))
+}
+
+// withValues is the request's own context, with the values of another as a
+// fallback.
+type withValues struct {
+	context.Context
+	values context.Context
+}
+
+func (c withValues) Value(key interface{}) interface{} {
+	if value := c.Context.Value(key); value != nil {
+		return value
+	}
+	return c.values.Value(key)
+}
+
+// CredhubShim adapts a CredHub client for brokerstore.NewCredhubStore. Unlike
+// credhub_shims.NewCredhubShim it accepts a client that has already been
+// configured, whatever its authentication method.
+type CredhubShim struct {
+	delegate *credhub.CredHub
+}
+
+var _ credhub_shims.Credhub = &CredhubShim{}
+
+func NewCredhubShim(delegate *credhub.CredHub) *CredhubShim {
+	return &CredhubShim{delegate: delegate}
+}
+
+func (ch *CredhubShim) SetJSON(name string, value values.JSON) (credentials.JSON, error) {
+	return ch.delegate.SetJSON(name, value)
+}
+
+func (ch *CredhubShim) GetLatestJSON(name string) (credentials.JSON, error) {
+	return ch.delegate.GetLatestJSON(name)
+}
+
+func (ch *CredhubShim) SetValue(name string, value values.Value) (credentials.Value, error) {
+	return ch.delegate.SetValue(name, value)
+}
+
+func (ch *CredhubShim) GetLatestValue(name string) (credentials.Value, error) {
+	return ch.delegate.GetLatestValue(name)
+}
+
+func (ch *CredhubShim) FindByPath(path string) (credentials.FindResults, error) {
+	return ch.delegate.FindByPath(path)
+}
+
+func (ch *CredhubShim) Delete(name string) error {
+	return ch.delegate.Delete(name)
+}
@@ -0,0 +1,89 @@
+package credhubstore_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"code.cloudfoundry.org/nfsbroker/credhubstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+type requestKey struct{}
+
+// requestRecorder notes which request context each outgoing request was
+// made for.
+type requestRecorder struct {
+	lock     sync.Mutex
+	requests map[string][]interface{}
+}
+
+func (r *requestRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.lock.Lock()
+	r.requests[req.URL.Path] = append(r.requests[req.URL.Path], req.Context().Value(requestKey{}))
+	r.lock.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+var _ = Describe("Clients", func() {
+	var (
+		server   *ghttp.Server
+		recorder *requestRecorder
+		clients  *credhubstore.Clients
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusOK,
+			`{"access_token": "token-1", "token_type": "bearer"}`,
+			http.Header{"Content-Type": {"application/json"}},
+		))
+		server.RouteToHandler("GET", "/api/v1/data", ghttp.CombineHandlers(
+			ghttp.VerifyHeaderKV("Authorization", "Bearer token-1"),
+			ghttp.RespondWith(http.StatusOK,
+				`{"data": [{"type": "json", "name": "/nfsbroker/instance-1", "value": {"share": "server/export"}}]}`,
+				http.Header{"Content-Type": {"application/json"}},
+			),
+		))
+
+		recorder = &requestRecorder{requests: map[string][]interface{}{}}
+		var err error
+		clients, err = credhubstore.NewClients(credhubstore.Config{
+			URL:             server.URL(),
+			AuthURL:         server.URL(),
+			ServerVersion:   "2.0.0",
+			UAAClientID:     "client-id",
+			UAAClientSecret: "client-secret",
+		}, &http.Client{Transport: recorder})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("makes CredHub and UAA requests with the values of the context", func() {
+		ctx := context.WithValue(context.Background(), requestKey{}, "request-1")
+
+		credential, err := clients.ForContext(ctx).GetLatestJSON("/nfsbroker/instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credential.Value).To(HaveKeyWithValue("share", "server/export"))
+
+		Expect(recorder.requests["/oauth/token"]).To(Equal([]interface{}{"request-1"}))
+		Expect(recorder.requests["/api/v1/data"]).To(Equal([]interface{}{"request-1"}))
+	})
+
+	It("shares one token between clients", func() {
+		for _, id := range []string{"request-1", "request-2"} {
+			_, err := clients.ForContext(context.WithValue(context.Background(), requestKey{}, id)).GetLatestJSON("/nfsbroker/instance-1")
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := clients.Client().GetLatestJSON("/nfsbroker/instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.requests["/oauth/token"]).To(HaveLen(1))
+		Expect(recorder.requests["/api/v1/data"]).To(Equal([]interface{}{"request-1", "request-2", nil}))
+	})
+})
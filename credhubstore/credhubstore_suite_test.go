@@ -0,0 +1,13 @@
+package credhubstore_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCredhubStore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CredHub Store Suite")
+}
@@ -0,0 +1,318 @@
+package credhubstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"github.com/google/uuid"
+)
+
+// LeaseCredhub is the part of a CredHub client that leases are taken with.
+// *credhub.CredHub implements it.
+type LeaseCredhub interface {
+	GenerateUser(name string, gen generate.User, overwrite credhub.Mode) (credentials.User, error)
+	GetLatestUser(name string) (credentials.User, error)
+	FindByPath(path string) (credentials.FindResults, error)
+	Delete(name string) error
+}
+
+// LeasePolicy is how long leases last and how long to wait for them.
+type LeasePolicy struct {
+	// TTL is how long a lease lasts before another broker may take it over,
+	// unless it is renewed or released. Held leases are renewed every third
+	// of it, so a renewal that CredHub takes longer than two thirds of it to
+	// complete can lose the lease.
+	TTL time.Duration
+	// Wait is how long to wait for a lease another operation holds before
+	// giving up.
+	Wait time.Duration
+	// RetryInterval is how often a held lease is checked while waiting.
+	RetryInterval time.Duration
+}
+
+var DefaultLeasePolicy = LeasePolicy{
+	TTL:           5 * time.Minute,
+	Wait:          30 * time.Second,
+	RetryInterval: 250 * time.Millisecond,
+}
+
+// freeLease marks a generation that its holder released.
+const freeLease = "free"
+
+// Leases lock instances across every broker sharing a store, in
+// leases/<instance id>/ under the store ID.
+//
+// CredHub has no compare-and-set, but generating a credential that already
+// exists returns the existing one, so generating a user credential named
+// with the caller's token creates it only if nobody else has. A lease is a
+// series of such credentials, numbered generations: the highest is current,
+// and holds the token of the operation holding it and when it expires, or
+// marks the lease free. An operation takes the lease by creating the next
+// generation when the current one is free or expired, then checks that no
+// higher generation has appeared, and releases it by creating the one after
+// as free. While it holds the lease it renews it the same way, by creating
+// the next generation with a later expiry. Earlier generations are deleted
+// along the way, but the current one never is, even once the instance is
+// deprovisioned: the numbering must not restart under an operation that
+// listed the generations before they were deleted.
+type Leases struct {
+	logger  lager.Logger
+	client  LeaseCredhub
+	storeID string
+	clock   clock.Clock
+	policy  LeasePolicy
+	metrics *broker.Metrics
+}
+
+var _ broker.Leases = &Leases{}
+
+func NewLeases(logger lager.Logger, client LeaseCredhub, storeID string, clock clock.Clock, policy LeasePolicy) *Leases {
+	return &Leases{logger: logger.Session("leases"), client: client, storeID: storeID, clock: clock, policy: policy}
+}
+
+// InstrumentWith counts leases that are lost while held in metrics.
+func (l *Leases) InstrumentWith(metrics *broker.Metrics) {
+	l.metrics = metrics
+}
+
+// Acquire waits for the lease on an instance, up to the policy's wait, and
+// returns broker.ErrLeaseHeld if another operation still holds it by then.
+func (l *Leases) Acquire(ctx context.Context, instanceID string) (func(), error) {
+	logger := l.logger.Session("acquire", lager.Data{"instanceID": instanceID})
+	token := uuid.NewString()
+	deadline := l.clock.Now().Add(l.policy.Wait)
+
+	for {
+		generation, err := l.take(instanceID, token)
+		if err == nil && generation >= 0 {
+			return l.hold(logger, instanceID, token, generation), nil
+		}
+
+		if !l.clock.Now().Before(deadline) {
+			if err != nil {
+				return nil, err
+			}
+			return nil, broker.ErrLeaseHeld
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-l.clock.After(l.policy.RetryInterval):
+		}
+	}
+}
+
+// take makes one attempt at the lease, returning the generation taken, or
+// -1 if the lease is held. Errors may be transient, and are retried.
+func (l *Leases) take(instanceID, token string) (int, error) {
+	generations, err := l.generations(instanceID)
+	if err != nil {
+		return -1, err
+	}
+
+	next := 0
+	if len(generations) > 0 {
+		current := generations[len(generations)-1]
+		holder, err := l.client.GetLatestUser(l.path(instanceID, current))
+		if err != nil {
+			return -1, err
+		}
+		if !l.free(holder.Value.Username) {
+			return -1, nil
+		}
+		next = current + 1
+	}
+
+	claim := l.claim(token)
+	created, err := l.client.GenerateUser(l.path(instanceID, next), generate.User{Username: claim}, credhub.NoOverwrite)
+	if err != nil {
+		return -1, err
+	}
+	if created.Value.Username != claim {
+		// another operation created it first
+		return -1, nil
+	}
+
+	// An operation that listed the generations before some were deleted
+	// can recreate one of them; it must find that a higher one exists.
+	generations, err = l.generations(instanceID)
+	if err != nil {
+		return -1, err
+	}
+	if len(generations) == 0 || generations[len(generations)-1] != next {
+		return -1, nil
+	}
+
+	for _, generation := range generations[:len(generations)-1] {
+		_ = l.client.Delete(l.path(instanceID, generation))
+	}
+	return next, nil
+}
+
+// hold renews a lease taken at generation until the function it returns
+// releases it.
+func (l *Leases) hold(logger lager.Logger, instanceID, token string, generation int) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	held := true
+
+	go func() {
+		defer close(stopped)
+		for held {
+			select {
+			case <-stop:
+				return
+			case <-l.clock.After(l.policy.TTL / 3):
+				generation, held = l.renew(logger, instanceID, token, generation)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		if held {
+			l.release(logger, instanceID, generation)
+		}
+	}
+}
+
+// renew extends a lease held at generation by creating the next generation
+// with a later expiry, and returns the generation held and whether the lease
+// is still held. A renewal that fails is retried at the next interval.
+func (l *Leases) renew(logger lager.Logger, instanceID, token string, generation int) (int, bool) {
+	next := generation + 1
+	created, err := l.client.GenerateUser(l.path(instanceID, next), generate.User{Username: l.claim(token)}, credhub.NoOverwrite)
+	if err != nil {
+		logger.Error("renew-failed", err, lager.Data{"generation": generation})
+		return generation, true
+	}
+	// a renewal whose response was lost may have created it already
+	if !strings.HasPrefix(created.Value.Username, token+":") {
+		l.lost(logger, instanceID, generation)
+		return generation, false
+	}
+
+	generations, err := l.generations(instanceID)
+	if err != nil {
+		logger.Error("renew-failed", err, lager.Data{"generation": next})
+		return next, true
+	}
+	if len(generations) == 0 || generations[len(generations)-1] != next {
+		l.lost(logger, instanceID, next)
+		return next, false
+	}
+
+	for _, generation := range generations[:len(generations)-1] {
+		_ = l.client.Delete(l.path(instanceID, generation))
+	}
+	return next, true
+}
+
+// claim is the holder of a generation taken or renewed with token now.
+func (l *Leases) claim(token string) string {
+	return token + ":" + strconv.FormatInt(l.clock.Now().Add(l.policy.TTL).UnixNano(), 10)
+}
+
+// free reports whether the holder of a generation has released it, or let
+// it expire.
+func (l *Leases) free(holder string) bool {
+	if holder == freeLease {
+		return true
+	}
+	_, expires, ok := strings.Cut(holder, ":")
+	if !ok {
+		return true
+	}
+	nanos, err := strconv.ParseInt(expires, 10, 64)
+	return err != nil || !l.clock.Now().Before(time.Unix(0, nanos))
+}
+
+func (l *Leases) release(logger lager.Logger, instanceID string, generation int) {
+	created, err := l.client.GenerateUser(l.path(instanceID, generation+1), generate.User{Username: freeLease}, credhub.NoOverwrite)
+	if err != nil {
+		logger.Error("release-failed", err, lager.Data{"generation": generation})
+		return
+	}
+	if created.Value.Username != freeLease {
+		l.lost(logger, instanceID, generation)
+	}
+}
+
+// lost reports that a lease expired and was taken over while it was held, so
+// the operation holding it may have raced another.
+func (l *Leases) lost(logger lager.Logger, instanceID string, generation int) {
+	logger.Error("lease-lost", fmt.Errorf("lease on instance %s expired and was taken over while it was held", instanceID), lager.Data{"generation": generation})
+	if l.metrics != nil {
+		l.metrics.LeaseLost()
+	}
+}
+
+// Forget deletes what it can of the lease on an instance that no longer
+// exists, once it has been released: every generation but the current one,
+// which stays, free, so that an operation that listed the generations before
+// they were deleted finds the one it creates taken, or a higher one when it
+// checks. A lease another operation has taken since is left to it.
+func (l *Leases) Forget(instanceID string) error {
+	generations, err := l.generations(instanceID)
+	if err != nil || len(generations) == 0 {
+		return err
+	}
+	current := generations[len(generations)-1]
+	holder, err := l.client.GetLatestUser(l.path(instanceID, current))
+	if err != nil {
+		return err
+	}
+	if !l.free(holder.Value.Username) {
+		return nil
+	}
+
+	for _, generation := range generations[:len(generations)-1] {
+		if err := l.client.Delete(l.path(instanceID, generation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generations lists the generations of an instance's lease, in order.
+func (l *Leases) generations(instanceID string) ([]int, error) {
+	prefix := l.directory(instanceID) + "/"
+
+	results, err := l.client.FindByPath(l.directory(instanceID))
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []int
+	for _, result := range results.Credentials {
+		if !strings.HasPrefix(result.Name, prefix) {
+			continue
+		}
+		generation, err := strconv.Atoi(strings.TrimPrefix(result.Name, prefix))
+		if err != nil {
+			continue
+		}
+		generations = append(generations, generation)
+	}
+	sort.Ints(generations)
+	return generations, nil
+}
+
+func (l *Leases) directory(instanceID string) string {
+	return "/" + l.storeID + "/leases/" + instanceID
+}
+
+func (l *Leases) path(instanceID string, generation int) string {
+	return l.directory(instanceID) + "/" + strconv.Itoa(generation)
+}
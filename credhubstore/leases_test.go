@@ -0,0 +1,619 @@
+package credhubstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/existingvolumebroker"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/credhubstore"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/metrics"
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/pivotal-cf/brokerapi/v11/domain/apiresponses"
+)
+
+// memoryCredhub keeps credentials in memory, as one CredHub that several
+// brokers share. Every call waits for latency first, so that calls from
+// different brokers interleave as they would over the network.
+type memoryCredhub struct {
+	latency time.Duration
+
+	lock        sync.Mutex
+	credentials map[string]interface{}
+	err         error
+}
+
+func newMemoryCredhub(latency time.Duration) *memoryCredhub {
+	return &memoryCredhub{latency: latency, credentials: map[string]interface{}{}}
+}
+
+func (c *memoryCredhub) call() error {
+	time.Sleep(c.latency)
+	c.lock.Lock()
+	return c.err
+}
+
+func (c *memoryCredhub) failWith(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.err = err
+}
+
+func (c *memoryCredhub) SetJSON(name string, value values.JSON) (credentials.JSON, error) {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return credentials.JSON{}, err
+	}
+	defer c.lock.Unlock()
+
+	// stored as CredHub would return it, not as the caller's map
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return credentials.JSON{}, err
+	}
+	var stored values.JSON
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		return credentials.JSON{}, err
+	}
+	c.credentials[name] = stored
+	return credentials.JSON{Value: stored}, nil
+}
+
+func (c *memoryCredhub) GetLatestJSON(name string) (credentials.JSON, error) {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return credentials.JSON{}, err
+	}
+	defer c.lock.Unlock()
+
+	value, ok := c.credentials[name].(values.JSON)
+	if !ok {
+		return credentials.JSON{}, errors.New("The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+	}
+	return credentials.JSON{Value: value}, nil
+}
+
+func (c *memoryCredhub) SetValue(name string, value values.Value) (credentials.Value, error) {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return credentials.Value{}, err
+	}
+	defer c.lock.Unlock()
+
+	c.credentials[name] = value
+	return credentials.Value{Value: value}, nil
+}
+
+func (c *memoryCredhub) GetLatestValue(name string) (credentials.Value, error) {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return credentials.Value{}, err
+	}
+	defer c.lock.Unlock()
+
+	value, ok := c.credentials[name].(values.Value)
+	if !ok {
+		return credentials.Value{}, errors.New("The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+	}
+	return credentials.Value{Value: value}, nil
+}
+
+// GenerateUser returns the existing credential, without overwriting it, as
+// CredHub does when asked not to overwrite.
+func (c *memoryCredhub) GenerateUser(name string, gen generate.User, overwrite credhub.Mode) (credentials.User, error) {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return credentials.User{}, err
+	}
+	defer c.lock.Unlock()
+
+	if existing, ok := c.credentials[name].(credentials.User); ok && overwrite != credhub.Overwrite {
+		return existing, nil
+	}
+	var user credentials.User
+	user.Value.Username = gen.Username
+	user.Value.Password = "generated"
+	c.credentials[name] = user
+	return user, nil
+}
+
+func (c *memoryCredhub) GetLatestUser(name string) (credentials.User, error) {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return credentials.User{}, err
+	}
+	defer c.lock.Unlock()
+
+	user, ok := c.credentials[name].(credentials.User)
+	if !ok {
+		return credentials.User{}, errors.New("The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+	}
+	return user, nil
+}
+
+func (c *memoryCredhub) FindByPath(path string) (credentials.FindResults, error) {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return credentials.FindResults{}, err
+	}
+	defer c.lock.Unlock()
+
+	var results credentials.FindResults
+	for name := range c.credentials {
+		if name == path || strings.HasPrefix(name, strings.TrimSuffix(path, "/")+"/") {
+			results.Credentials = append(results.Credentials, struct {
+				Name             string `json:"name" yaml:"name"`
+				VersionCreatedAt string `json:"version_created_at" yaml:"version_created_at"`
+			}{Name: name})
+		}
+	}
+	return results, nil
+}
+
+func (c *memoryCredhub) Delete(name string) error {
+	if err := c.call(); err != nil {
+		c.lock.Unlock()
+		return err
+	}
+	defer c.lock.Unlock()
+
+	if _, ok := c.credentials[name]; !ok {
+		return errors.New("The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+	}
+	delete(c.credentials, name)
+	return nil
+}
+
+func (c *memoryCredhub) names(prefix string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var names []string
+	for name := range c.credentials {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// stalledClock is an hour behind, and its timers fire only when fire is sent
+// to, like a broker that stopped running while it held a lease.
+type stalledClock struct {
+	clock.Clock
+	fire chan time.Time
+}
+
+func newStalledClock() *stalledClock {
+	return &stalledClock{Clock: clock.NewClock(), fire: make(chan time.Time)}
+}
+
+func (c *stalledClock) Now() time.Time {
+	return time.Now().Add(-time.Hour)
+}
+
+func (c *stalledClock) After(time.Duration) <-chan time.Time {
+	return c.fire
+}
+
+// pausedCredhub pauses the first credential generated through it until it
+// is resumed.
+type pausedCredhub struct {
+	*memoryCredhub
+
+	once    sync.Once
+	reached chan struct{}
+	resume  chan struct{}
+}
+
+func (c *pausedCredhub) GenerateUser(name string, gen generate.User, overwrite credhub.Mode) (credentials.User, error) {
+	c.once.Do(func() {
+		close(c.reached)
+		<-c.resume
+	})
+	return c.memoryCredhub.GenerateUser(name, gen, overwrite)
+}
+
+var _ = Describe("Leases", func() {
+	var (
+		memory *memoryCredhub
+		policy credhubstore.LeasePolicy
+		logs   *gbytes.Buffer
+		logger lager.Logger
+		ctx    context.Context
+	)
+
+	newLeases := func() *credhubstore.Leases {
+		return credhubstore.NewLeases(logger, memory, "nfsbroker", clock.NewClock(), policy)
+	}
+
+	BeforeEach(func() {
+		memory = newMemoryCredhub(0)
+		policy = credhubstore.LeasePolicy{TTL: time.Minute, Wait: 50 * time.Millisecond, RetryInterval: 5 * time.Millisecond}
+		logs = gbytes.NewBuffer()
+		logger = lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+		ctx = context.TODO()
+	})
+
+	It("grants one lease on an instance at a time", func() {
+		release, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = newLeases().Acquire(ctx, "instance-1")
+		Expect(err).To(Equal(broker.ErrLeaseHeld))
+
+		other, err := newLeases().Acquire(ctx, "instance-2")
+		Expect(err).NotTo(HaveOccurred())
+		other()
+
+		release()
+		release, err = newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		release()
+	})
+
+	It("waits for a held lease to be released", func() {
+		policy.Wait = 5 * time.Second
+		release, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		acquired := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			release, err := newLeases().Acquire(ctx, "instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			release()
+			close(acquired)
+		}()
+
+		Consistently(acquired, 100*time.Millisecond).ShouldNot(BeClosed())
+		release()
+		Eventually(acquired).Should(BeClosed())
+	})
+
+	It("takes over a lease that has expired", func() {
+		policy.Wait = 5 * time.Second
+		stalled := credhubstore.NewLeases(logger, memory, "nfsbroker", newStalledClock(), policy)
+		release, err := stalled.Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		next, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		release()
+		Expect(logs).To(gbytes.Say("lease-lost"))
+
+		policy.Wait = 50 * time.Millisecond
+		_, err = newLeases().Acquire(ctx, "instance-1")
+		Expect(err).To(Equal(broker.ErrLeaseHeld))
+		next()
+	})
+
+	It("renews a lease for as long as it is held", func() {
+		policy.TTL = 30 * time.Millisecond
+		release, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		policy.Wait = 200 * time.Millisecond
+		_, err = newLeases().Acquire(ctx, "instance-1")
+		Expect(err).To(Equal(broker.ErrLeaseHeld))
+		Expect(logs).NotTo(gbytes.Say("lease-lost"))
+
+		release()
+		release, err = newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		release()
+	})
+
+	It("counts a lease that is taken over while it is held", func() {
+		registry := metrics.NewRegistry()
+		clock := newStalledClock()
+		stalled := credhubstore.NewLeases(logger, memory, "nfsbroker", clock, policy)
+		stalled.InstrumentWith(broker.NewMetrics(registry))
+		release, err := stalled.Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		next, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		// the stalled broker resumes, and finds the lease taken when it
+		// renews it
+		clock.fire <- time.Now()
+		Eventually(logs).Should(gbytes.Say("lease-lost"))
+		release()
+
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(recorder.Body.String()).To(ContainSubstring("\nnfsbroker_leases_lost_total 1\n"))
+
+		policy.Wait = 50 * time.Millisecond
+		_, err = newLeases().Acquire(ctx, "instance-1")
+		Expect(err).To(Equal(broker.ErrLeaseHeld))
+		next()
+	})
+
+	It("keeps the current generation of the lease, and deletes the rest", func() {
+		for i := 0; i < 5; i++ {
+			release, err := newLeases().Acquire(ctx, "instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			release()
+		}
+
+		Expect(memory.names("/nfsbroker/leases/instance-1/")).To(ConsistOf(
+			"/nfsbroker/leases/instance-1/8",
+			"/nfsbroker/leases/instance-1/9",
+		))
+	})
+
+	It("keeps only the current, free generation once it is forgotten", func() {
+		for i := 0; i < 3; i++ {
+			release, err := newLeases().Acquire(ctx, "instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			release()
+		}
+
+		Expect(newLeases().Forget("instance-1")).To(Succeed())
+		Expect(memory.names("/nfsbroker/leases/instance-1/")).To(ConsistOf("/nfsbroker/leases/instance-1/5"))
+
+		release, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		release()
+		Expect(memory.names("/nfsbroker/leases/instance-1/")).To(ConsistOf(
+			"/nfsbroker/leases/instance-1/6",
+			"/nfsbroker/leases/instance-1/7",
+		))
+	})
+
+	It("does not forget a lease another operation holds", func() {
+		release, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(newLeases().Forget("instance-1")).To(Succeed())
+		_, err = newLeases().Acquire(ctx, "instance-1")
+		Expect(err).To(Equal(broker.ErrLeaseHeld))
+		release()
+	})
+
+	It("is not mistaken for an instance or binding by the store", func() {
+		release, err := newLeases().Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		release()
+
+		store := credhubstore.NewStore(logger, memory, "nfsbroker")
+		instances, err := store.RetrieveAllInstanceDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(BeEmpty())
+	})
+
+	Context("when CredHub fails", func() {
+		BeforeEach(func() {
+			memory.failWith(errors.New("credhub unavailable"))
+		})
+
+		It("returns its error once it has waited", func() {
+			_, err := newLeases().Acquire(ctx, "instance-1")
+			Expect(err).To(MatchError("credhub unavailable"))
+		})
+	})
+
+	Context("when the context is done", func() {
+		It("stops waiting", func() {
+			policy.Wait = time.Minute
+			release, err := newLeases().Acquire(ctx, "instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			defer release()
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err = newLeases().Acquire(cancelled, "instance-1")
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+})
+
+var _ = Describe("Brokers sharing a store", func() {
+	const brokers = 5
+
+	var (
+		memory  *memoryCredhub
+		subject []domain.ServiceBroker
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		memory = newMemoryCredhub(time.Millisecond)
+		ctx = context.TODO()
+
+		mask, err := vmo.NewMountOptsMask(
+			[]string{"source", "uid", "gid"},
+			map[string]interface{}{},
+			map[string]string{"share": "source"},
+			[]string{broker.MetadataKey},
+			[]string{"source"},
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		subject = nil
+		for i := 0; i < brokers; i++ {
+			logger := lager.NewLogger(fmt.Sprintf("broker-%d", i))
+			store := credhubstore.NewStore(logger, memory, "nfsbroker")
+			wrapped := existingvolumebroker.New(existingvolumebroker.BrokerTypeNFS, logger, &fakes.FakeServices{}, &osshim.OsShim{}, clock.NewClock(), store, mask)
+			wrapped.DisallowedBindOverrides = append(wrapped.DisallowedBindOverrides, broker.MetadataKey)
+
+			leases := credhubstore.NewLeases(logger, memory, "nfsbroker", clock.NewClock(), credhubstore.LeasePolicy{
+				TTL:           time.Minute,
+				Wait:          10 * time.Second,
+				RetryInterval: time.Millisecond,
+			})
+			subject = append(subject, broker.NewLeased(logger, broker.New(logger, wrapped, store, mask, broker.VolumeIDSchemeV1), leases))
+		}
+	})
+
+	// onEveryBroker runs operation on every broker at once, and returns the
+	// error each returned.
+	onEveryBroker := func(operation func(i int, b domain.ServiceBroker) error) []error {
+		errs := make([]error, brokers)
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i, b := range subject {
+			wg.Add(1)
+			go func(i int, b domain.ServiceBroker) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				<-start
+				errs[i] = operation(i, b)
+			}(i, b)
+		}
+		close(start)
+		wg.Wait()
+		return errs
+	}
+
+	succeeded := func(errs []error) []int {
+		var winners []int
+		for i, err := range errs {
+			if err == nil {
+				winners = append(winners, i)
+			}
+		}
+		return winners
+	}
+
+	provision := func(b domain.ServiceBroker, share string) error {
+		_, err := b.Provision(ctx, "instance-1", domain.ProvisionDetails{
+			ServiceID:     "service-id",
+			PlanID:        "plan-id",
+			RawParameters: json.RawMessage(fmt.Sprintf(`{"share": %q}`, share)),
+		}, false)
+		return err
+	}
+
+	It("creates an instance once when each broker provisions it differently", func() {
+		errs := onEveryBroker(func(i int, b domain.ServiceBroker) error {
+			return provision(b, fmt.Sprintf("server/export-%d", i))
+		})
+
+		winners := succeeded(errs)
+		Expect(winners).To(HaveLen(1))
+		for i, err := range errs {
+			if i != winners[0] {
+				Expect(err).To(Equal(apiresponses.ErrInstanceAlreadyExists))
+			}
+		}
+
+		instance, err := credhubstore.NewStore(lager.NewLogger("test"), memory, "nfsbroker").RetrieveInstanceDetails("instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.ServiceFingerPrint).To(HaveKeyWithValue("share", fmt.Sprintf("server/export-%d", winners[0])))
+	})
+
+	It("creates a binding once when each broker binds it to a different app", func() {
+		Expect(provision(subject[0], "server/export")).To(Succeed())
+
+		errs := onEveryBroker(func(i int, b domain.ServiceBroker) error {
+			_, err := b.Bind(ctx, "instance-1", "binding-1", domain.BindDetails{
+				ServiceID: "service-id",
+				PlanID:    "plan-id",
+				AppGUID:   fmt.Sprintf("app-%d", i),
+			}, false)
+			return err
+		})
+
+		winners := succeeded(errs)
+		Expect(winners).To(HaveLen(1))
+		for i, err := range errs {
+			if i != winners[0] {
+				Expect(err).To(Equal(apiresponses.ErrBindingAlreadyExists))
+			}
+		}
+
+		binding, err := credhubstore.NewStore(lager.NewLogger("test"), memory, "nfsbroker").RetrieveBindingDetails("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.AppGUID).To(Equal(fmt.Sprintf("app-%d", winners[0])))
+	})
+
+	It("deletes an instance once when every broker deprovisions it", func() {
+		Expect(provision(subject[0], "server/export")).To(Succeed())
+
+		errs := onEveryBroker(func(_ int, b domain.ServiceBroker) error {
+			_, err := b.Deprovision(ctx, "instance-1", domain.DeprovisionDetails{ServiceID: "service-id", PlanID: "plan-id"}, false)
+			return err
+		})
+
+		winners := succeeded(errs)
+		Expect(winners).To(HaveLen(1))
+		for i, err := range errs {
+			if i != winners[0] {
+				Expect(err).To(Equal(apiresponses.ErrInstanceDoesNotExist))
+			}
+		}
+		Expect(memory.names("/nfsbroker/leases/instance-1/")).To(HaveLen(1))
+	})
+
+	It("grants an instance's lease to one operation at a time while it is forgotten", func() {
+		policy := credhubstore.LeasePolicy{TTL: time.Minute, Wait: 50 * time.Millisecond, RetryInterval: time.Millisecond}
+		deprovisioner := credhubstore.NewLeases(lager.NewLogger("deprovisioner"), memory, "nfsbroker", clock.NewClock(), policy)
+		release, err := deprovisioner.Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		release()
+
+		By("pausing an acquire that has found the lease free, before it takes it")
+		paused := &pausedCredhub{memoryCredhub: memory, reached: make(chan struct{}), resume: make(chan struct{})}
+		acquired := make(chan error, 1)
+		go func() {
+			release, err := credhubstore.NewLeases(lager.NewLogger("paused"), paused, "nfsbroker", clock.NewClock(), policy).Acquire(ctx, "instance-1")
+			if err == nil {
+				release()
+			}
+			acquired <- err
+		}()
+		Eventually(paused.reached).Should(BeClosed())
+
+		By("forgetting the lease, then taking it again")
+		Expect(deprovisioner.Forget("instance-1")).To(Succeed())
+		release, err = credhubstore.NewLeases(lager.NewLogger("other"), memory, "nfsbroker", clock.NewClock(), policy).Acquire(ctx, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		close(paused.resume)
+		Eventually(acquired).Should(Receive(Equal(broker.ErrLeaseHeld)))
+		release()
+	})
+
+	It("runs operations on different instances side by side", func() {
+		errs := onEveryBroker(func(i int, b domain.ServiceBroker) error {
+			instanceID := fmt.Sprintf("instance-%d", i)
+			if _, err := b.Provision(ctx, instanceID, domain.ProvisionDetails{
+				ServiceID:     "service-id",
+				PlanID:        "plan-id",
+				RawParameters: json.RawMessage(`{"share": "server/export"}`),
+			}, false); err != nil {
+				return err
+			}
+			_, err := b.Bind(ctx, instanceID, instanceID+"-binding", domain.BindDetails{ServiceID: "service-id", PlanID: "plan-id", AppGUID: "app"}, false)
+			return err
+		})
+
+		Expect(succeeded(errs)).To(HaveLen(brokers))
+		instances, err := credhubstore.NewStore(lager.NewLogger("test"), memory, "nfsbroker").RetrieveAllInstanceDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(brokers))
+	})
+})
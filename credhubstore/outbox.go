@@ -0,0 +1,96 @@
+package credhubstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+)
+
+// Outbox keeps webhook deliveries in CredHub, under the store ID, beside the
+// instances and bindings: pending ones in outbox/ and dead letters in
+// dead-letters/.
+type Outbox struct {
+	shim    credhub_shims.Credhub
+	storeID string
+}
+
+var _ webhooks.Outbox = &Outbox{}
+
+func NewOutbox(shim credhub_shims.Credhub, storeID string) *Outbox {
+	return &Outbox{shim: shim, storeID: storeID}
+}
+
+func (o *Outbox) Put(delivery webhooks.Delivery) error {
+	return o.put(o.path("outbox", delivery.ID), delivery)
+}
+
+func (o *Outbox) List() ([]webhooks.Delivery, error) {
+	return o.list("outbox")
+}
+
+func (o *Outbox) Delete(id string) error {
+	return o.shim.Delete(o.path("outbox", id))
+}
+
+func (o *Outbox) PutDeadLetter(delivery webhooks.Delivery) error {
+	return o.put(o.path("dead-letters", delivery.ID), delivery)
+}
+
+func (o *Outbox) ListDeadLetters() ([]webhooks.Delivery, error) {
+	return o.list("dead-letters")
+}
+
+func (o *Outbox) path(folder, id string) string {
+	return "/" + o.storeID + "/" + folder + "/" + id
+}
+
+func (o *Outbox) put(name string, delivery webhooks.Delivery) error {
+	var value values.JSON
+	if err := convert(delivery, &value); err != nil {
+		return err
+	}
+	_, err := o.shim.SetJSON(name, value)
+	return err
+}
+
+// list reads every delivery in folder, one request per delivery. One that has
+// been removed since it was found is skipped.
+func (o *Outbox) list(folder string) ([]webhooks.Delivery, error) {
+	prefix := "/" + o.storeID + "/" + folder + "/"
+
+	results, err := o.shim.FindByPath(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []webhooks.Delivery
+	for _, result := range results.Credentials {
+		if !strings.HasPrefix(result.Name, prefix) {
+			continue
+		}
+
+		credential, err := o.shim.GetLatestJSON(result.Name)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("reading %s: %w", result.Name, err)
+		}
+		var delivery webhooks.Delivery
+		if err := convert(credential.Value, &delivery); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", result.Name, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func isNotFound(err error) bool {
+	var notFound *credhub.NotFoundError
+	return errors.As(err, &notFound)
+}
@@ -0,0 +1,110 @@
+package credhubstore_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/nfsbroker/credhubstore"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Outbox", func() {
+	var (
+		shim     *fakes.FakeCredhub
+		outbox   *credhubstore.Outbox
+		saved    map[string]values.JSON
+		delivery webhooks.Delivery
+	)
+
+	BeforeEach(func() {
+		shim = &fakes.FakeCredhub{}
+		outbox = credhubstore.NewOutbox(shim, "nfsbroker")
+
+		saved = map[string]values.JSON{}
+		shim.SetJSONStub = func(name string, value values.JSON) (credentials.JSON, error) {
+			saved[name] = value
+			return credentials.JSON{}, nil
+		}
+		shim.FindByPathStub = func(path string) (credentials.FindResults, error) {
+			var results credentials.FindResults
+			for name := range saved {
+				results.Credentials = append(results.Credentials, struct {
+					Name             string `json:"name" yaml:"name"`
+					VersionCreatedAt string `json:"version_created_at" yaml:"version_created_at"`
+				}{Name: name})
+			}
+			return results, nil
+		}
+		shim.GetLatestJSONStub = func(name string) (credentials.JSON, error) {
+			value, ok := saved[name]
+			if !ok {
+				return credentials.JSON{}, &credhub.NotFoundError{}
+			}
+			var credential credentials.JSON
+			credential.Value = value
+			return credential, nil
+		}
+
+		delivery = webhooks.Delivery{
+			ID:          "delivery-1",
+			Subscriber:  "cmdb",
+			Event:       webhooks.Event{ID: "event-1", Type: webhooks.EventProvision, Time: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), InstanceID: "instance-1"},
+			Attempts:    2,
+			NextAttempt: time.Date(2026, 10, 19, 12, 5, 0, 0, time.UTC),
+			LastError:   "subscriber responded with 503",
+		}
+	})
+
+	It("keeps deliveries beside the instances and bindings", func() {
+		Expect(outbox.Put(delivery)).To(Succeed())
+		Expect(saved).To(HaveKey("/nfsbroker/outbox/delivery-1"))
+
+		deliveries, err := outbox.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deliveries).To(Equal([]webhooks.Delivery{delivery}))
+		Expect(shim.FindByPathArgsForCall(0)).To(Equal("/nfsbroker/outbox"))
+	})
+
+	It("deletes deliveries", func() {
+		Expect(outbox.Delete("delivery-1")).To(Succeed())
+		Expect(shim.DeleteArgsForCall(0)).To(Equal("/nfsbroker/outbox/delivery-1"))
+	})
+
+	It("keeps dead letters apart from pending deliveries", func() {
+		Expect(outbox.PutDeadLetter(delivery)).To(Succeed())
+		Expect(saved).To(HaveKey("/nfsbroker/dead-letters/delivery-1"))
+
+		deliveries, err := outbox.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deliveries).To(BeEmpty())
+
+		deadLetters, err := outbox.ListDeadLetters()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deadLetters).To(Equal([]webhooks.Delivery{delivery}))
+	})
+
+	It("skips deliveries removed while listing", func() {
+		Expect(outbox.Put(delivery)).To(Succeed())
+		shim.GetLatestJSONReturns(credentials.JSON{}, &credhub.NotFoundError{})
+		shim.GetLatestJSONStub = nil
+
+		deliveries, err := outbox.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deliveries).To(BeEmpty())
+	})
+
+	It("fails when a delivery cannot be read", func() {
+		Expect(outbox.Put(delivery)).To(Succeed())
+		shim.GetLatestJSONStub = nil
+		shim.GetLatestJSONReturns(credentials.JSON{}, errors.New("credhub unavailable"))
+
+		_, err := outbox.List()
+		Expect(err).To(MatchError("reading /nfsbroker/outbox/delivery-1: credhub unavailable"))
+	})
+})
@@ -0,0 +1,116 @@
+package credhubstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
+//counterfeiter:generate -o ../fakes/fake_credhub.go code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims.Credhub
+
+// activationMarker is the value brokerstore.CredhubStore.Activate writes
+// alongside the instances and bindings.
+const activationMarker = "migrated-from-sql"
+
+// Store is a brokerstore.CredhubStore that can also list its records, which
+// the upstream store does not implement.
+type Store struct {
+	*brokerstore.CredhubStore
+
+	shim    credhub_shims.Credhub
+	storeID string
+}
+
+var _ brokerstore.Store = &Store{}
+
+func NewStore(logger lager.Logger, shim credhub_shims.Credhub, storeID string) *Store {
+	return &Store{
+		CredhubStore: brokerstore.NewCredhubStore(logger, shim, storeID),
+		shim:         shim,
+		storeID:      storeID,
+	}
+}
+
+// RetrieveAllInstanceDetails reads every instance in the store, one request
+// per record.
+func (s *Store) RetrieveAllInstanceDetails() (map[string]brokerstore.ServiceInstance, error) {
+	instances := map[string]brokerstore.ServiceInstance{}
+	err := s.each(func(id string, value map[string]interface{}) error {
+		if !isInstance(value) {
+			return nil
+		}
+		var instance brokerstore.ServiceInstance
+		if err := convert(value, &instance); err != nil {
+			return fmt.Errorf("reading instance %s: %w", id, err)
+		}
+		instances[id] = instance
+		return nil
+	})
+	return instances, err
+}
+
+// RetrieveAllBindingDetails reads every binding in the store, one request per
+// record.
+func (s *Store) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	bindings := map[string]domain.BindDetails{}
+	err := s.each(func(id string, value map[string]interface{}) error {
+		if isInstance(value) {
+			return nil
+		}
+		var binding domain.BindDetails
+		if err := convert(value, &binding); err != nil {
+			return fmt.Errorf("reading binding %s: %w", id, err)
+		}
+		bindings[id] = binding
+		return nil
+	})
+	return bindings, err
+}
+
+// each calls fn with every instance and binding. Instances and bindings are
+// stored side by side, directly under the store ID; anything nested deeper
+// belongs to something else.
+func (s *Store) each(fn func(id string, value map[string]interface{}) error) error {
+	prefix := "/" + s.storeID + "/"
+
+	results, err := s.shim.FindByPath("/" + s.storeID)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results.Credentials {
+		id := strings.TrimPrefix(result.Name, prefix)
+		if id == result.Name || id == activationMarker || strings.Contains(id, "/") {
+			continue
+		}
+
+		credential, err := s.shim.GetLatestJSON(result.Name)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", result.Name, err)
+		}
+		if err := fn(id, credential.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isInstance tells instances from bindings, which never have a fingerprint.
+func isInstance(value map[string]interface{}) bool {
+	_, ok := value["ServiceFingerPrint"]
+	return ok
+}
+
+func convert(value interface{}, target interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, target)
+}
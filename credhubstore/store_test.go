@@ -0,0 +1,100 @@
+package credhubstore_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/credhubstore"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		shim  *fakes.FakeCredhub
+		store *credhubstore.Store
+	)
+
+	BeforeEach(func() {
+		shim = &fakes.FakeCredhub{}
+		store = credhubstore.NewStore(lager.NewLogger("test"), shim, "nfsbroker")
+
+		var results credentials.FindResults
+		for _, name := range []string{
+			"/nfsbroker/instance-1",
+			"/nfsbroker/binding-1",
+			"/nfsbroker/migrated-from-sql",
+			"/nfsbroker/outbox/event-1",
+		} {
+			results.Credentials = append(results.Credentials, struct {
+				Name             string `json:"name" yaml:"name"`
+				VersionCreatedAt string `json:"version_created_at" yaml:"version_created_at"`
+			}{Name: name})
+		}
+		shim.FindByPathReturns(results, nil)
+
+		shim.GetLatestJSONStub = func(name string) (credentials.JSON, error) {
+			var credential credentials.JSON
+			switch name {
+			case "/nfsbroker/instance-1":
+				credential.Value = map[string]interface{}{
+					"service_id":         "service-id",
+					"plan_id":            "plan-id",
+					"organization_guid":  "org-guid",
+					"space_guid":         "space-guid",
+					"ServiceFingerPrint": map[string]interface{}{"share": "server/export"},
+				}
+			case "/nfsbroker/binding-1":
+				credential.Value = map[string]interface{}{
+					"service_id": "service-id",
+					"plan_id":    "plan-id",
+					"app_guid":   "app-guid",
+				}
+			default:
+				return credential, errors.New("unexpected read of " + name)
+			}
+			return credential, nil
+		}
+	})
+
+	It("lists every instance", func() {
+		instances, err := store.RetrieveAllInstanceDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(Equal(map[string]brokerstore.ServiceInstance{
+			"instance-1": {
+				ServiceID:          "service-id",
+				PlanID:             "plan-id",
+				OrganizationGUID:   "org-guid",
+				SpaceGUID:          "space-guid",
+				ServiceFingerPrint: map[string]interface{}{"share": "server/export"},
+			},
+		}))
+		Expect(shim.FindByPathArgsForCall(0)).To(Equal("/nfsbroker"))
+	})
+
+	It("lists every binding", func() {
+		bindings, err := store.RetrieveAllBindingDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bindings).To(Equal(map[string]domain.BindDetails{
+			"binding-1": {ServiceID: "service-id", PlanID: "plan-id", AppGUID: "app-guid"},
+		}))
+	})
+
+	It("fails when a record cannot be read", func() {
+		shim.GetLatestJSONReturns(credentials.JSON{}, errors.New("credhub unavailable"))
+
+		_, err := store.RetrieveAllInstanceDetails()
+		Expect(err).To(MatchError(ContainSubstring("credhub unavailable")))
+	})
+
+	It("fails when the records cannot be found", func() {
+		shim.FindByPathReturns(credentials.FindResults{}, errors.New("credhub unavailable"))
+
+		_, err := store.RetrieveAllBindingDetails()
+		Expect(err).To(MatchError("credhub unavailable"))
+	})
+})
@@ -0,0 +1,191 @@
+// Package explain answers "what mount config will I get with these
+// parameters?" without binding. It provisions and binds with the broker's
+// own code, against a scratch store that is thrown away afterwards, and
+// explains where each mount option came from and what became of it.
+package explain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// The IDs the scratch instance and binding are created with. Volume IDs
+// include the instance ID, so a request may give a real one instead.
+const (
+	DefaultInstanceID = "explain-instance"
+	bindingID         = "explain-binding"
+	appGUID           = "explain-app"
+	orgGUID           = "explain-org"
+	spaceGUID         = "explain-space"
+)
+
+// Request describes an instance to provision and a binding to make to it.
+type Request struct {
+	// PlanID is a plan ID or name. It may be left out when the catalog has
+	// only one plan.
+	PlanID              string                 `json:"plan_id"`
+	Share               string                 `json:"share"`
+	ProvisionParameters map[string]interface{} `json:"provision_parameters,omitempty"`
+	BindParameters      map[string]interface{} `json:"bind_parameters,omitempty"`
+	InstanceID          string                 `json:"instance_id,omitempty"`
+}
+
+// Explanation is what binding would give. When the broker would refuse,
+// Error is its error and there is no volume mount.
+type Explanation struct {
+	ServiceID   string              `json:"service_id"`
+	PlanID      string              `json:"plan_id"`
+	InstanceID  string              `json:"instance_id"`
+	Allowed     bool                `json:"allowed"`
+	Error       string              `json:"error,omitempty"`
+	VolumeMount *domain.VolumeMount `json:"volume_mount,omitempty"`
+	Options     []Option            `json:"options"`
+}
+
+// Config is the broker to explain, as loaded from the services config and
+// mount option policy.
+type Config struct {
+	// Build returns the broker the API serves, on the given store.
+	Build                   func(logger lager.Logger, store brokerstore.Store) domain.ServiceBroker
+	Mask                    vmo.MountOptsMask
+	DisallowedBindOverrides []string
+}
+
+// Explainer explains binds with the most recently loaded config.
+type Explainer struct {
+	logger lager.Logger
+
+	lock   sync.RWMutex
+	config Config
+}
+
+func New(logger lager.Logger, config Config) *Explainer {
+	return &Explainer{logger: logger.Session("explain"), config: config}
+}
+
+// Reload replaces the config that subsequent requests are explained with.
+func (e *Explainer) Reload(config Config) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.config = config
+}
+
+// Explain returns an error only for a request that cannot be explained, such
+// as one for an unknown plan. A bind the broker would refuse is explained.
+func (e *Explainer) Explain(ctx context.Context, request Request) (Explanation, error) {
+	e.lock.RLock()
+	config := e.config
+	e.lock.RUnlock()
+
+	provision := map[string]interface{}{}
+	for key, value := range request.ProvisionParameters {
+		provision[key] = value
+	}
+	if request.Share != "" {
+		if share, ok := provision["share"]; ok && share != request.Share {
+			return Explanation{}, errors.New("the share is given twice, with different values")
+		}
+		provision["share"] = request.Share
+	}
+
+	instanceID := request.InstanceID
+	if instanceID == "" {
+		instanceID = DefaultInstanceID
+	}
+
+	serviceBroker := config.Build(e.logger, newScratchStore())
+	serviceID, planID, err := findPlan(ctx, serviceBroker, request.PlanID)
+	if err != nil {
+		return Explanation{}, err
+	}
+
+	explanation := Explanation{
+		ServiceID:  serviceID,
+		PlanID:     planID,
+		InstanceID: instanceID,
+		Options:    explainOptions(config.Mask, config.DisallowedBindOverrides, provision, request.BindParameters),
+	}
+
+	provisionParameters, err := json.Marshal(provision)
+	if err != nil {
+		return Explanation{}, err
+	}
+	_, err = serviceBroker.Provision(ctx, instanceID, domain.ProvisionDetails{
+		ServiceID:        serviceID,
+		PlanID:           planID,
+		OrganizationGUID: orgGUID,
+		SpaceGUID:        spaceGUID,
+		RawParameters:    provisionParameters,
+	}, false)
+	if err != nil {
+		explanation.Error = "provision: " + strings.TrimSpace(err.Error())
+		return explanation, nil
+	}
+
+	var bindParameters json.RawMessage
+	if len(request.BindParameters) > 0 {
+		if bindParameters, err = json.Marshal(request.BindParameters); err != nil {
+			return Explanation{}, err
+		}
+	}
+	binding, err := serviceBroker.Bind(ctx, instanceID, bindingID, domain.BindDetails{
+		AppGUID:       appGUID,
+		ServiceID:     serviceID,
+		PlanID:        planID,
+		RawParameters: bindParameters,
+	}, false)
+	if err != nil {
+		explanation.Error = "bind: " + strings.TrimSpace(err.Error())
+		return explanation, nil
+	}
+	if len(binding.VolumeMounts) != 1 {
+		return Explanation{}, fmt.Errorf("the broker returned %d volume mounts, not 1", len(binding.VolumeMounts))
+	}
+
+	explanation.Allowed = true
+	explanation.VolumeMount = &binding.VolumeMounts[0]
+	return explanation, nil
+}
+
+// findPlan looks a plan up by ID, then by name.
+func findPlan(ctx context.Context, serviceBroker domain.ServiceBroker, plan string) (string, string, error) {
+	services, err := serviceBroker.Services(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	var plans [][2]string
+	for _, service := range services {
+		for _, p := range service.Plans {
+			plans = append(plans, [2]string{service.ID, p.ID})
+			if plan != "" && p.ID == plan {
+				return service.ID, p.ID, nil
+			}
+		}
+	}
+	for _, service := range services {
+		for _, p := range service.Plans {
+			if plan != "" && p.Name == plan {
+				return service.ID, p.ID, nil
+			}
+		}
+	}
+
+	if plan == "" {
+		if len(plans) == 1 {
+			return plans[0][0], plans[0][1], nil
+		}
+		return "", "", errors.New("the catalog has more than one plan, so a plan must be given")
+	}
+	return "", "", fmt.Errorf("no plan has the ID or name %q", plan)
+}
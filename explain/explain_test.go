@@ -0,0 +1,274 @@
+package explain_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/existingvolumebroker"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/explain"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+var _ = Describe("Explainer", func() {
+	var (
+		services    *fakes.FakeServices
+		mask        vmo.MountOptsMask
+		scheme      broker.VolumeIDScheme
+		stores      []brokerstore.Store
+		explainer   *explain.Explainer
+		request     explain.Request
+		explanation explain.Explanation
+		err         error
+	)
+
+	config := func() explain.Config {
+		disallowed := []string{existingvolumebroker.SHARE_KEY, existingvolumebroker.SOURCE_KEY, broker.MetadataKey}
+		return explain.Config{
+			Build: func(logger lager.Logger, store brokerstore.Store) domain.ServiceBroker {
+				stores = append(stores, store)
+				wrapped := existingvolumebroker.New(existingvolumebroker.BrokerTypeNFS, logger, services, &osshim.OsShim{}, clock.NewClock(), store, mask)
+				wrapped.DisallowedBindOverrides = disallowed
+				return broker.New(logger, wrapped, store, mask, scheme)
+			},
+			Mask:                    mask,
+			DisallowedBindOverrides: disallowed,
+		}
+	}
+
+	BeforeEach(func() {
+		services = &fakes.FakeServices{}
+		services.ListReturns([]domain.Service{{
+			ID:    "nfs-service",
+			Plans: []domain.ServicePlan{{ID: "plan-id", Name: "Existing"}},
+		}})
+
+		mask, err = vmo.NewMountOptsMask(
+			[]string{"source", "uid", "gid", "mount", "readonly", "cache"},
+			map[string]interface{}{"uid": "1000", "cache": "true"},
+			map[string]string{"share": "source"},
+			[]string{broker.MetadataKey},
+			[]string{"source"},
+			vmo.UserOptsValidationFunc(func(key, value string) error {
+				if key == "cache" && value != "true" && value != "false" {
+					return errors.New("cache must be true or false")
+				}
+				return nil
+			}),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		scheme = broker.VolumeIDSchemeV1
+		stores = nil
+
+		request = explain.Request{
+			Share:               "server/export",
+			ProvisionParameters: map[string]interface{}{"gid": "2000"},
+			BindParameters:      map[string]interface{}{"uid": "3000", "mount": "/data"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		explainer = explain.New(lager.NewLogger("test"), config())
+		explanation, err = explainer.Explain(context.Background(), request)
+	})
+
+	It("returns the volume mount a bind would give", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(explanation.Allowed).To(BeTrue())
+		Expect(explanation.ServiceID).To(Equal("nfs-service"))
+		Expect(explanation.PlanID).To(Equal("plan-id"))
+
+		mount := explanation.VolumeMount
+		Expect(mount.Driver).To(Equal("nfsv3driver"))
+		Expect(mount.ContainerDir).To(Equal("/data"))
+		Expect(mount.Mode).To(Equal("rw"))
+		Expect(mount.Device.VolumeId).To(HavePrefix("explain-instance-"))
+		Expect(mount.Device.MountConfig).To(Equal(map[string]interface{}{
+			"source": "nfs://server/export",
+			"uid":    "3000",
+			"gid":    "2000",
+			"cache":  "true",
+			"mount":  "/data",
+		}))
+	})
+
+	It("explains each option", func() {
+		Expect(explanation.Options).To(Equal([]explain.Option{
+			{Name: "cache", Value: "true", Source: explain.SourceDefault, Outcome: explain.OutcomeApplied},
+			{Name: "uid", Value: "1000", Source: explain.SourceDefault, Outcome: explain.OutcomeOverridden, Reason: "overridden by the bind parameter"},
+			{Name: "gid", Value: "2000", Source: explain.SourceProvision, Outcome: explain.OutcomeApplied},
+			{Name: "mount", Value: "/data", Source: explain.SourceBind, Outcome: explain.OutcomeApplied},
+			{Name: "share", MountOption: "source", Value: "server/export", Source: explain.SourceProvision, Outcome: explain.OutcomeApplied},
+			{Name: "uid", Value: "3000", Source: explain.SourceBind, Outcome: explain.OutcomeApplied},
+		}))
+	})
+
+	It("throws the store away", func() {
+		Expect(stores).To(HaveLen(1))
+		instances, err := stores[0].RetrieveAllInstanceDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveKey(explain.DefaultInstanceID))
+
+		_, err = explainer.Explain(context.Background(), request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stores).To(HaveLen(2))
+	})
+
+	Context("with an instance ID and the v2 volume ID scheme", func() {
+		BeforeEach(func() {
+			scheme = broker.VolumeIDSchemeV2
+			request.InstanceID = "real-instance"
+		})
+
+		It("computes the volume ID the instance would get", func() {
+			Expect(explanation.VolumeMount.Device.VolumeId).To(Equal(broker.VolumeIDV2("real-instance", explanation.VolumeMount.Device.MountConfig)))
+		})
+	})
+
+	Context("when a bind parameter overrides the instance's", func() {
+		BeforeEach(func() {
+			request.BindParameters = map[string]interface{}{"gid": "3000", "readonly": true}
+		})
+
+		It("explains the override", func() {
+			Expect(explanation.VolumeMount.Mode).To(Equal("r"))
+			Expect(explanation.VolumeMount.Device.MountConfig).To(HaveKeyWithValue("gid", "3000"))
+			Expect(explanation.Options).To(ContainElement(explain.Option{
+				Name: "gid", Value: "2000", Source: explain.SourceProvision, Outcome: explain.OutcomeOverridden, Reason: "overridden by the bind parameter",
+			}))
+		})
+	})
+
+	Context("when an option is not allowed", func() {
+		BeforeEach(func() {
+			request.ProvisionParameters = map[string]interface{}{"nosuid": true}
+		})
+
+		It("explains why the bind would be refused", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(explanation.Allowed).To(BeFalse())
+			Expect(explanation.Error).To(Equal("bind: - Not allowed options: nosuid"))
+			Expect(explanation.VolumeMount).To(BeNil())
+			Expect(explanation.Options).To(ContainElement(explain.Option{
+				Name: "nosuid", Value: true, Source: explain.SourceProvision, Outcome: explain.OutcomeRejected, Reason: "is not an allowed mount option",
+			}))
+		})
+
+		Context("when sloppy_mount is set", func() {
+			BeforeEach(func() {
+				mask.Defaults["sloppy_mount"] = "true"
+				mask.SloppyMount = true
+				mask.Allowed = append(mask.Allowed, "sloppy_mount")
+			})
+
+			It("explains that it is dropped", func() {
+				Expect(explanation.Allowed).To(BeTrue())
+				Expect(explanation.VolumeMount.Device.MountConfig).NotTo(HaveKey("nosuid"))
+				Expect(explanation.Options).To(ContainElement(explain.Option{
+					Name: "nosuid", Value: true, Source: explain.SourceProvision, Outcome: explain.OutcomeIgnored, Reason: "is not an allowed mount option, and sloppy_mount is set",
+				}))
+			})
+		})
+	})
+
+	Context("when a bind parameter may not be overridden", func() {
+		BeforeEach(func() {
+			request.BindParameters = map[string]interface{}{"share": "other/export"}
+		})
+
+		It("explains why the bind would be refused", func() {
+			Expect(explanation.Allowed).To(BeFalse())
+			Expect(explanation.Error).To(Equal("bind: bind configuration contains the following invalid option: ['share']"))
+			Expect(explanation.Options).To(ContainElement(explain.Option{
+				Name: "share", Value: "other/export", Source: explain.SourceBind, Outcome: explain.OutcomeRejected, Reason: "can only be set when the instance is created",
+			}))
+		})
+	})
+
+	Context("when a value fails validation", func() {
+		BeforeEach(func() {
+			request.BindParameters = map[string]interface{}{"cache": "sometimes"}
+		})
+
+		It("explains why", func() {
+			Expect(explanation.Allowed).To(BeFalse())
+			Expect(explanation.Options).To(ContainElement(explain.Option{
+				Name: "cache", Value: "sometimes", Source: explain.SourceBind, Outcome: explain.OutcomeRejected, Reason: "cache must be true or false",
+			}))
+		})
+	})
+
+	Context("when readonly is not true", func() {
+		BeforeEach(func() {
+			request.BindParameters = map[string]interface{}{"readonly": false}
+		})
+
+		It("explains why the bind would be refused", func() {
+			Expect(explanation.Error).To(Equal(`bind: Invalid ro parameter value: "false"`))
+			Expect(explanation.Options).To(ContainElement(explain.Option{
+				Name: "readonly", Value: false, Source: explain.SourceBind, Outcome: explain.OutcomeRejected, Reason: "can only be true",
+			}))
+		})
+	})
+
+	Context("when the share is invalid", func() {
+		BeforeEach(func() {
+			request.Share = "server:/export"
+		})
+
+		It("explains why the provision would be refused", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(explanation.Error).To(Equal("provision: syntax error for share: no colon allowed after server"))
+		})
+	})
+
+	Context("with a plan name", func() {
+		BeforeEach(func() {
+			request.PlanID = "Existing"
+		})
+
+		It("finds the plan", func() {
+			Expect(explanation.PlanID).To(Equal("plan-id"))
+		})
+	})
+
+	Context("with an unknown plan", func() {
+		BeforeEach(func() {
+			request.PlanID = "missing"
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(`no plan has the ID or name "missing"`))
+		})
+	})
+
+	Context("with the share given twice", func() {
+		BeforeEach(func() {
+			request.ProvisionParameters = map[string]interface{}{"share": "other/export"}
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError("the share is given twice, with different values"))
+		})
+	})
+
+	Describe("Reload", func() {
+		It("explains with the new config", func() {
+			mask.Allowed = []string{"source"}
+			mask.Defaults = map[string]interface{}{}
+			explainer.Reload(config())
+
+			explanation, err := explainer.Explain(context.Background(), request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(explanation.Allowed).To(BeFalse())
+		})
+	})
+})
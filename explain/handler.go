@@ -0,0 +1,52 @@
+package explain
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const Path = "/admin/explain"
+
+type handler struct {
+	logger    lager.Logger
+	explainer *Explainer
+}
+
+// NewHandler explains the bind described by a POSTed Request. It responds
+// 200 with an Explanation whether or not the broker would allow the bind,
+// and 400 for a request that cannot be explained. It does not authenticate
+// requests itself.
+func NewHandler(logger lager.Logger, explainer *Explainer) http.Handler {
+	return &handler{logger: logger.Session("explain-handler"), explainer: explainer}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request Request
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	explanation, err := h.explainer.Explain(req.Context(), request)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
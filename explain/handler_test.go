@@ -0,0 +1,60 @@
+package explain_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/explain"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		handler  http.Handler
+		recorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		serviceBroker := &fakes.FakeServiceBroker{}
+		serviceBroker.ServicesReturns([]domain.Service{{ID: "nfs", Plans: []domain.ServicePlan{{ID: "plan-id"}}}}, nil)
+		serviceBroker.BindReturns(domain.Binding{VolumeMounts: []domain.VolumeMount{{ContainerDir: "/data"}}}, nil)
+
+		explainer := explain.New(lager.NewLogger("test"), explain.Config{
+			Build: func(lager.Logger, brokerstore.Store) domain.ServiceBroker { return serviceBroker },
+		})
+		handler = explain.NewHandler(lager.NewLogger("test"), explainer)
+		recorder = httptest.NewRecorder()
+	})
+
+	It("explains the posted request", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", explain.Path, strings.NewReader(`{"share": "server/export"}`)))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"allowed":true`))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"container_dir":"/data"`))
+	})
+
+	It("rejects unknown fields", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", explain.Path, strings.NewReader(`{"shares": "server/export"}`)))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(ContainSubstring(`unknown field \"shares\"`))
+	})
+
+	It("rejects requests it cannot explain", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", explain.Path, strings.NewReader(`{"plan_id": "missing"}`)))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"error": "no plan has the ID or name \"missing\""}`))
+	})
+
+	It("only allows POST", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", explain.Path, nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(recorder.Header().Get("Allow")).To(Equal("POST"))
+	})
+})
@@ -0,0 +1,135 @@
+package explain
+
+import (
+	"fmt"
+	"sort"
+
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	vmou "code.cloudfoundry.org/volume-mount-options/utils"
+)
+
+// Where an option came from.
+const (
+	SourceDefault   = "default"
+	SourceProvision = "provision"
+	SourceBind      = "bind"
+)
+
+// What became of an option.
+const (
+	OutcomeApplied    = "applied"
+	OutcomeOverridden = "overridden"
+	OutcomeIgnored    = "ignored"
+	OutcomeRejected   = "rejected"
+)
+
+// Option is a mount option as it was given, and what became of it. A
+// rejected option makes the broker refuse the bind.
+type Option struct {
+	Name string `json:"name"`
+	// MountOption is the option a parameter is applied as, when it is not
+	// the parameter's name; share is applied as source.
+	MountOption string      `json:"mount_option,omitempty"`
+	Value       interface{} `json:"value"`
+	Source      string      `json:"source"`
+	Outcome     string      `json:"outcome"`
+	Reason      string      `json:"reason,omitempty"`
+}
+
+// explainOptions follows the rules of existingvolumebroker's Bind and
+// vmo.NewMountOpts: bind parameters are merged over the instance's, unless
+// they are disallowed at bind, and the result over the policy's defaults.
+// Options are listed defaults first, then by name.
+func explainOptions(mask vmo.MountOptsMask, disallowedBindOverrides []string, provision, bind map[string]interface{}) []Option {
+	options := []Option{}
+
+	merged := map[string]interface{}{}
+	sources := map[string]string{}
+	for key, value := range provision {
+		merged[key], sources[key] = value, SourceProvision
+	}
+
+	var unused []Option
+	for _, key := range sortedKeys(bind) {
+		value := bind[key]
+		if contains(disallowedBindOverrides, key) {
+			unused = append(unused, Option{Name: key, Value: value, Source: SourceBind, Outcome: OutcomeRejected, Reason: "can only be set when the instance is created"})
+			continue
+		}
+		if previous, ok := provision[key]; ok {
+			unused = append(unused, Option{Name: key, Value: previous, Source: SourceProvision, Outcome: OutcomeOverridden, Reason: "overridden by the bind parameter"})
+		}
+		merged[key], sources[key] = value, SourceBind
+	}
+
+	applied := map[string]string{}
+	var given []Option
+	for _, key := range sortedKeys(merged) {
+		option := Option{Name: key, Value: merged[key], Source: sources[key]}
+		canonical := key
+		if mapped, ok := mask.KeyPerms[key]; ok {
+			canonical = mapped
+			option.MountOption = mapped
+		}
+
+		switch {
+		case key == "readonly" && vmou.InterfaceToString(option.Value) != "true":
+			// the broker reads the mode before applying the policy
+			option.Outcome, option.Reason = OutcomeRejected, "can only be true"
+		case contains(mask.Ignored, canonical):
+			option.Outcome, option.Reason = OutcomeIgnored, "is reserved for the broker"
+		case !contains(mask.Allowed, canonical) && mask.SloppyMount:
+			option.Outcome, option.Reason = OutcomeIgnored, "is not an allowed mount option, and sloppy_mount is set"
+		case !contains(mask.Allowed, canonical):
+			option.Outcome, option.Reason = OutcomeRejected, "is not an allowed mount option"
+		default:
+			option.Outcome = OutcomeApplied
+			if reason := invalid(mask, canonical, option.Value); reason != "" {
+				option.Outcome, option.Reason = OutcomeRejected, reason
+			}
+			applied[canonical] = option.Source
+		}
+		given = append(given, option)
+	}
+
+	for _, key := range sortedKeys(mask.Defaults) {
+		option := Option{Name: key, Value: mask.Defaults[key], Source: SourceDefault, Outcome: OutcomeApplied}
+		if source, ok := applied[key]; ok {
+			option.Outcome, option.Reason = OutcomeOverridden, fmt.Sprintf("overridden by the %s parameter", source)
+		} else if reason := invalid(mask, key, option.Value); reason != "" {
+			option.Outcome, option.Reason = OutcomeRejected, reason
+		}
+		options = append(options, option)
+	}
+
+	options = append(options, given...)
+	return append(options, unused...)
+}
+
+// invalid returns why the policy's validation rejects a value, if it does.
+func invalid(mask vmo.MountOptsMask, key string, value interface{}) string {
+	for _, validation := range mask.ValidationFunc {
+		if err := validation.Validate(key, fmt.Sprintf("%v", value)); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package explain
+
+import (
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// scratchStore keeps records in memory for one explanation. Like the
+// CredHub store, it keeps them as JSON, so that what is read back has the
+// types a real bind would see.
+type scratchStore struct {
+	instances map[string][]byte
+	bindings  map[string][]byte
+}
+
+var _ brokerstore.Store = &scratchStore{}
+
+func newScratchStore() *scratchStore {
+	return &scratchStore{instances: map[string][]byte{}, bindings: map[string][]byte{}}
+}
+
+func (s *scratchStore) RetrieveInstanceDetails(id string) (brokerstore.ServiceInstance, error) {
+	var instance brokerstore.ServiceInstance
+	b, ok := s.instances[id]
+	if !ok {
+		return instance, errors.New("not found")
+	}
+	err := json.Unmarshal(b, &instance)
+	return instance, err
+}
+
+func (s *scratchStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	var binding domain.BindDetails
+	b, ok := s.bindings[id]
+	if !ok {
+		return binding, errors.New("not found")
+	}
+	err := json.Unmarshal(b, &binding)
+	return binding, err
+}
+
+func (s *scratchStore) RetrieveAllInstanceDetails() (map[string]brokerstore.ServiceInstance, error) {
+	all := map[string]brokerstore.ServiceInstance{}
+	for id := range s.instances {
+		instance, err := s.RetrieveInstanceDetails(id)
+		if err != nil {
+			return nil, err
+		}
+		all[id] = instance
+	}
+	return all, nil
+}
+
+func (s *scratchStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	all := map[string]domain.BindDetails{}
+	for id := range s.bindings {
+		binding, err := s.RetrieveBindingDetails(id)
+		if err != nil {
+			return nil, err
+		}
+		all[id] = binding
+	}
+	return all, nil
+}
+
+func (s *scratchStore) CreateInstanceDetails(id string, details brokerstore.ServiceInstance) error {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	s.instances[id] = b
+	return nil
+}
+
+func (s *scratchStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	s.bindings[id] = b
+	return nil
+}
+
+func (s *scratchStore) DeleteInstanceDetails(id string) error {
+	delete(s.instances, id)
+	return nil
+}
+
+func (s *scratchStore) DeleteBindingDetails(id string) error {
+	delete(s.bindings, id)
+	return nil
+}
+
+func (s *scratchStore) IsInstanceConflict(string, brokerstore.ServiceInstance) bool { return false }
+func (s *scratchStore) IsBindingConflict(string, domain.BindDetails) bool           { return false }
+
+func (s *scratchStore) Restore(lager.Logger) error { return nil }
+func (s *scratchStore) Save(lager.Logger) error    { return nil }
+func (s *scratchStore) Cleanup() error             { return nil }
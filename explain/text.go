@@ -0,0 +1,58 @@
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// WriteText writes an explanation for people: the outcome, the volume mount
+// the bind would return, then every option.
+func WriteText(w io.Writer, explanation Explanation) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "service:\t%s\n", explanation.ServiceID)
+	fmt.Fprintf(tw, "plan:\t%s\n", explanation.PlanID)
+	fmt.Fprintf(tw, "instance:\t%s\n", explanation.InstanceID)
+	if !explanation.Allowed {
+		fmt.Fprintf(tw, "result:\trefused: %s\n", explanation.Error)
+	} else {
+		mount := explanation.VolumeMount
+		fmt.Fprintf(tw, "result:\tallowed\n")
+		fmt.Fprintf(tw, "driver:\t%s\n", mount.Driver)
+		fmt.Fprintf(tw, "container path:\t%s\n", mount.ContainerDir)
+		fmt.Fprintf(tw, "mode:\t%s\n", mount.Mode)
+		fmt.Fprintf(tw, "volume id:\t%s\n", mount.Device.VolumeId)
+		fmt.Fprintf(tw, "mount config:\t\n")
+
+		var keys []string
+		for key := range mount.Device.MountConfig {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(tw, "  %s:\t%v\n", key, mount.Device.MountConfig[key])
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPTION\tVALUE\tFROM\tOUTCOME\tWHY")
+	for _, option := range explanation.Options {
+		name := option.Name
+		if option.MountOption != "" {
+			name += " (" + option.MountOption + ")"
+		}
+		value, err := json.Marshal(option.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, value, option.Source, option.Outcome, option.Reason)
+	}
+	return tw.Flush()
+}
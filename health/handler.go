@@ -0,0 +1,108 @@
+// Package health serves the liveness and readiness endpoints used by BOSH
+// health checks and load balancers. Neither requires authentication.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// Check is one condition for readiness. Check returns nil when it holds.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type handler struct {
+	logger  lager.Logger
+	timeout time.Duration
+	checks  []Check
+}
+
+// NewHandler serves LivenessPath, which succeeds for as long as the process
+// can serve requests, and ReadinessPath, which runs every check concurrently
+// and fails with 503 if any of them fails or does not finish within timeout.
+func NewHandler(logger lager.Logger, timeout time.Duration, checks ...Check) http.Handler {
+	h := &handler{
+		logger:  logger.Session("health"),
+		timeout: timeout,
+		checks:  checks,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, h.live)
+	mux.HandleFunc(ReadinessPath, h.ready)
+	return mux
+}
+
+func (h *handler) live(w http.ResponseWriter, _ *http.Request) {
+	respond(w, http.StatusOK, Response{Status: "ok"})
+}
+
+func (h *handler) ready(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	defer cancel()
+
+	results := make([]error, len(h.checks))
+	var wg sync.WaitGroup
+	for i, check := range h.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	response := Response{Status: "ready", Checks: map[string]string{}}
+	status := http.StatusOK
+	for i, check := range h.checks {
+		if results[i] != nil {
+			h.logger.Info("check-failed", lager.Data{"check": check.Name, "error": results[i].Error()})
+			response.Checks[check.Name] = results[i].Error()
+			response.Status = "not-ready"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[check.Name] = "ok"
+	}
+
+	respond(w, status, response)
+}
+
+// run gives up on a check when ctx is done, even if the check itself does not
+// honour ctx.
+func run(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func respond(w http.ResponseWriter, status int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
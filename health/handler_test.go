@@ -0,0 +1,90 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/health"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		logger *gbytes.Buffer
+		checks []health.Check
+	)
+
+	BeforeEach(func() {
+		logger = gbytes.NewBuffer()
+		checks = []health.Check{
+			{Name: "store", Check: func(context.Context) error { return nil }},
+			{Name: "catalog", Check: func(context.Context) error { return nil }},
+		}
+	})
+
+	get := func(path string) (int, health.Response) {
+		l := lager.NewLogger("test")
+		l.RegisterSink(lager.NewWriterSink(logger, lager.DEBUG))
+		handler := health.NewHandler(l, 100*time.Millisecond, checks...)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+
+		var response health.Response
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+		return recorder.Code, response
+	}
+
+	Describe("/healthz", func() {
+		It("is ok even when checks fail", func() {
+			checks[0].Check = func(context.Context) error { return errors.New("credhub down") }
+
+			status, response := get("/healthz")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response.Status).To(Equal("ok"))
+		})
+	})
+
+	Describe("/readyz", func() {
+		It("is ready when every check passes", func() {
+			status, response := get("/readyz")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response).To(Equal(health.Response{
+				Status: "ready",
+				Checks: map[string]string{"store": "ok", "catalog": "ok"},
+			}))
+		})
+
+		It("is not ready when a check fails", func() {
+			checks[0].Check = func(context.Context) error { return errors.New("credhub down") }
+
+			status, response := get("/readyz")
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(response).To(Equal(health.Response{
+				Status: "not-ready",
+				Checks: map[string]string{"store": "credhub down", "catalog": "ok"},
+			}))
+			Expect(logger).To(gbytes.Say(`check-failed.*"check":"store","error":"credhub down"`))
+		})
+
+		It("is not ready when a check does not finish in time", func() {
+			release := make(chan struct{})
+			defer close(release)
+			checks[1].Check = func(context.Context) error {
+				<-release
+				return nil
+			}
+
+			status, response := get("/readyz")
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(response.Checks).To(HaveKeyWithValue("catalog", "context deadline exceeded"))
+		})
+	})
+})
@@ -1,31 +1,49 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/credhub-cli/credhub/server"
 	"code.cloudfoundry.org/debugserver"
 	"code.cloudfoundry.org/existingvolumebroker"
 	evbutils "code.cloudfoundry.org/existingvolumebroker/utils"
 	"code.cloudfoundry.org/goshims/osshim"
 	"code.cloudfoundry.org/lager/v3"
 	"code.cloudfoundry.org/lager/v3/lagerflags"
+	"code.cloudfoundry.org/nfsbroker/audit"
+	"code.cloudfoundry.org/nfsbroker/auth"
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"code.cloudfoundry.org/nfsbroker/credhubstore"
+	"code.cloudfoundry.org/nfsbroker/explain"
+	"code.cloudfoundry.org/nfsbroker/health"
+	"code.cloudfoundry.org/nfsbroker/metrics"
+	"code.cloudfoundry.org/nfsbroker/reconcile"
+	"code.cloudfoundry.org/nfsbroker/shares"
+	"code.cloudfoundry.org/nfsbroker/tlsconfig"
+	"code.cloudfoundry.org/nfsbroker/tracing"
+	"code.cloudfoundry.org/nfsbroker/transport"
 	"code.cloudfoundry.org/nfsbroker/utils"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
 	"code.cloudfoundry.org/service-broker-store/brokerstore"
 	vmo "code.cloudfoundry.org/volume-mount-options"
 	vmou "code.cloudfoundry.org/volume-mount-options/utils"
 	"github.com/pivotal-cf/brokerapi/v11"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
 	"github.com/tedsuo/ifrit/http_server"
@@ -67,6 +85,12 @@ var defaultOptions = flag.String(
 	"A comma separated list of defaults specified as param:value. If a parameter has a default value and is not in the allowed list, this default value becomes a fixed value that cannot be overridden",
 )
 
+var mountOptionsConfig = flag.String(
+	"mountOptionsConfig",
+	"",
+	"(optional) Path to a JSON file with \"allowed_options\" and \"default_options\" in the same format as the allowedOptions and defaultOptions flags, which it takes precedence over. Unlike the flags, it is re-read on SIGHUP",
+)
+
 var credhubURL = flag.String(
 	"credhubURL",
 	"",
@@ -79,6 +103,18 @@ var credhubCACertPath = flag.String(
 	"(optional) Path to CA Cert for CredHub",
 )
 
+var credhubClientCertPath = flag.String(
+	"credhubClientCertPath",
+	"",
+	"(optional) Path to a client certificate to authenticate to CredHub with mutual TLS instead of UAA",
+)
+
+var credhubClientKeyPath = flag.String(
+	"credhubClientKeyPath",
+	"",
+	"(optional) Path to the private key for credhubClientCertPath",
+)
+
 var uaaClientID = flag.String(
 	"uaaClientID",
 	"",
@@ -97,17 +133,166 @@ var uaaCACertPath = flag.String(
 	"(optional) Path to CA Cert for UAA used for CredHub authorization",
 )
 
+var outboundTimeout = flag.Duration(
+	"outboundTimeout",
+	30*time.Second,
+	"(optional) Timeout for each request the broker makes to CredHub and UAA",
+)
+
 var storeID = flag.String(
 	"storeID",
 	"nfsbroker",
 	"(optional) Store ID used to namespace instance details and bindings (credhub only)",
 )
 
+var storeLeases = flag.Bool(
+	"storeLeases",
+	false,
+	"(optional) Lock instances in CredHub while they are changed, so that several brokers can share one store ID (credhub only)",
+)
+
+var volumeIDScheme = flag.String(
+	"volumeIdScheme",
+	string(broker.VolumeIDSchemeV1),
+	"(optional) Volume ID scheme recorded on newly provisioned instances: v1 (legacy md5 of all mount options) or v2 (sha256 of identity-relevant mount options). Existing instances keep the scheme they were provisioned with",
+)
+
+var maintenanceInfoVersion = flag.String(
+	"maintenanceInfoVersion",
+	"",
+	"(optional) Semantic version advertised as maintenance_info on plans that do not declare their own. Bump it when changing defaultOptions or allowedOptions so that existing instances are offered an upgrade",
+)
+
+var maintenanceInfoDescription = flag.String(
+	"maintenanceInfoDescription",
+	"",
+	"(optional) Description of the changes in maintenanceInfoVersion",
+)
+
+var credentialsFile = flag.String(
+	"credentialsFile",
+	"",
+	"(optional) Path to a YAML file listing the accepted broker credentials, each with an optional not_before and not_after time, and client certificate identities. Replaces USERNAME and PASSWORD, and is watched for changes so that credentials can be rotated without a restart",
+)
+
+var tlsCertFile = flag.String(
+	"tlsCertFile",
+	"",
+	"(optional) Path to a PEM certificate to serve the broker API over TLS. Reloaded when it changes",
+)
+
+var tlsKeyFile = flag.String(
+	"tlsKeyFile",
+	"",
+	"(optional) Path to the PEM private key for tlsCertFile",
+)
+
+var tlsClientCAFile = flag.String(
+	"tlsClientCAFile",
+	"",
+	"(optional) Path to a PEM CA bundle. When set, clients must present a certificate signed by it",
+)
+
+var tlsMinVersion = flag.String(
+	"tlsMinVersion",
+	"1.2",
+	"(optional) Minimum TLS version accepted by the broker API: 1.2 or 1.3",
+)
+
+var healthAddr = flag.String(
+	"healthAddr",
+	"",
+	"(optional) host:port to serve /healthz, /readyz and /metrics over plain HTTP, in addition to the broker API listener",
+)
+
+var tracingEndpoint = flag.String(
+	"tracingEndpoint",
+	"",
+	"(optional) URL of a Zipkin collector, such as http://zipkin:9411/api/v2/spans, to send traces of broker API requests and the CredHub and UAA requests made for them to",
+)
+
+var auditLog = flag.String(
+	"auditLog",
+	"",
+	"(optional) Path to a file to append a hash-chained audit log of provision, update, deprovision, bind and unbind operations, and of admin share rewrites, to, or \"syslog\" to send it to the local syslog daemon. Check it with the audit-verify command",
+)
+
+var webhooksConfig = flag.String(
+	"webhooksConfig",
+	"",
+	"(optional) Path to a YAML file listing webhook subscribers to notify of provisions, binds, unbinds and deprovisions with HMAC-signed JSON. Deliveries are kept in the store until they succeed (credhub only)",
+)
+
+var ccAPIURL = flag.String(
+	"ccAPIURL",
+	"",
+	"(optional) URL of the Cloud Controller API, such as https://api.example.com, to reconcile the store against",
+)
+
+var ccCACertPath = flag.String(
+	"ccCACertPath",
+	"",
+	"(optional) Path to a CA certificate for the Cloud Controller API and its UAA",
+)
+
+var ccClientID = flag.String(
+	"ccClientID",
+	"",
+	"(optional) UAA client ID, with the cloud_controller.admin_read_only or cloud_controller.global_auditor authority, to reconcile with",
+)
+
+var ccClientSecret = flag.String(
+	"ccClientSecret",
+	"",
+	"(optional) UAA client secret to reconcile with",
+)
+
+var ccBrokerName = flag.String(
+	"ccBrokerName",
+	"nfsbroker",
+	"(optional) Name the broker is registered with in Cloud Controller",
+)
+
+var reconcileInterval = flag.Duration(
+	"reconcileInterval",
+	0,
+	"(optional) How often to reconcile the store against Cloud Controller and log the records only one of them has, such as 1h. Zero disables it; the reconcile command runs it on demand",
+)
+
+var reconcileGarbageCollect = flag.Bool(
+	"reconcileGarbageCollect",
+	false,
+	"(optional) Delete the store records that Cloud Controller does not have when reconciling on a schedule",
+)
+
 var (
 	username string
 	password string
 )
 
+const (
+	outboundDialTimeout         = 10 * time.Second
+	outboundTLSHandshakeTimeout = 10 * time.Second
+)
+
+const metricsPath = "/metrics"
+
+// inventoryMaxAge is how long the instance and binding counts reported as
+// metrics may be out of date; counting them reads the whole store.
+const inventoryMaxAge = time.Minute
+
+// tracingFlushInterval is how often finished spans are sent to the collector.
+const tracingFlushInterval = time.Second
+
+// readinessTimeout bounds the checks behind each /readyz request.
+const readinessTimeout = 5 * time.Second
+
+// filePollInterval is how often watched credentials and certificates are
+// checked for changes.
+const filePollInterval = 5 * time.Second
+
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 //counterfeiter:generate -o fakes/retired_store_fake.go . RetiredStore
 type RetiredStore interface {
@@ -117,26 +302,51 @@ type RetiredStore interface {
 
 func main() {
 	parseCommandLine()
+	if err := applyConfigFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s\n\n", err.Error())
+		os.Exit(1)
+	}
 	parseEnvironment()
 
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	checkParams()
 
 	logger, logSink := newLogger()
 	logger.Info("starting")
 	defer logger.Info("ends")
 
-	verifyCredhubIsReachable(logger)
+	transportFactory, err := newTransportFactory()
+	if err != nil {
+		logger.Fatal("outbound-transport-error", err)
+	}
+	logger.Info("outbound-transport", transportFactory.Settings())
+
+	credhubClientCertificate := loadCredhubClientCertificate(logger)
+	credhubInfo := verifyCredhubIsReachable(logger, transportFactory.Client(credhubClientCertificate))
+
+	tracer, reporter := newTracer(logger, transportFactory)
+	credhubHTTPClient := transportFactory.Client(credhubClientCertificate)
+	if tracer != nil {
+		credhubHTTPClient.Transport = tracer.Transport(credhubHTTPClient.Transport)
+	}
+
+	members := createServer(logger, credhubHTTPClient, credhubInfo, tracer, transportFactory.Client(nil))
 
-	server := createServer(logger)
+	if reporter != nil {
+		// stopped last, to send the spans of requests that finish on shutdown
+		members = append(grouper.Members{{Name: "tracing-reporter", Runner: reporter}}, members...)
+	}
 
 	if dbgAddr := debugserver.DebugAddress(flag.CommandLine); dbgAddr != "" {
-		server = utils.ProcessRunnerFor(grouper.Members{
+		members = append(grouper.Members{
 			{Name: "debug-server", Runner: debugserver.Runner(dbgAddr, logSink)},
-			{Name: "broker-api", Runner: server},
-		})
+		}, members...)
 	}
 
-	process := ifrit.Invoke(server)
+	process := ifrit.Invoke(utils.ProcessRunnerFor(members))
 	logger.Info("started")
 	utils.UntilTerminated(logger, process)
 }
@@ -145,20 +355,25 @@ func parseCommandLine() {
 	lagerflags.AddFlags(flag.CommandLine)
 	debugserver.AddFlags(flag.CommandLine)
 	flag.Parse()
+	recordCommandLineFlags()
 }
 
+// parseEnvironment overrides the config file, but not the command line.
 func parseEnvironment() {
-	username, _ = os.LookupEnv("USERNAME")
-	password, _ = os.LookupEnv("PASSWORD")
+	if usernameString, ok := os.LookupEnv("USERNAME"); ok {
+		username = usernameString
+	}
+	if passwordString, ok := os.LookupEnv("PASSWORD"); ok {
+		password = passwordString
+	}
 	uaaClientSecretString, _ := os.LookupEnv("UAA_CLIENT_SECRET")
-	if uaaClientSecretString != "" {
+	if uaaClientSecretString != "" && !commandLineFlags["uaaClientSecret"] {
 		uaaClientSecret = &uaaClientSecretString
 	}
 	uaaClientIDString, _ := os.LookupEnv("UAA_CLIENT_ID")
-	if uaaClientIDString != "" {
+	if uaaClientIDString != "" && !commandLineFlags["uaaClientID"] {
 		uaaClientID = &uaaClientIDString
 	}
-
 }
 
 func checkParams() {
@@ -173,6 +388,89 @@ func checkParams() {
 		flag.Usage()
 		os.Exit(1)
 	}
+
+	if *maintenanceInfoVersion != "" && !semverPattern.MatchString(*maintenanceInfoVersion) {
+		fmt.Fprint(os.Stderr, "\nERROR: maintenanceInfoVersion must be a semantic version.\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if _, err := broker.ParseVolumeIDScheme(*volumeIDScheme); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s.\n\n", err.Error())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := checkTracingParams(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s.\n\n", err.Error())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := checkTLSParams(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s.\n\n", err.Error())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := checkReconcileParams(*reconcileInterval > 0); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s.\n\n", err.Error())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := checkCredhubParams(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s.\n\n", err.Error())
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func checkCredhubParams() error {
+	if (*credhubClientCertPath == "") != (*credhubClientKeyPath == "") {
+		return errors.New("credhubClientCertPath and credhubClientKeyPath must be provided together")
+	}
+	return nil
+}
+
+func checkTracingParams() error {
+	if *tracingEndpoint == "" {
+		return nil
+	}
+	endpoint, err := url.Parse(*tracingEndpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("tracingEndpoint must be an http or https URL, not %q", *tracingEndpoint)
+	}
+	return nil
+}
+
+// checkReconcileParams checks the Cloud Controller settings, which are
+// required when reconciling.
+func checkReconcileParams(reconciling bool) error {
+	if *reconcileInterval < 0 {
+		return errors.New("reconcileInterval must not be negative")
+	}
+	if *ccAPIURL != "" {
+		apiURL, err := url.Parse(*ccAPIURL)
+		if err != nil || (apiURL.Scheme != "http" && apiURL.Scheme != "https") || apiURL.Host == "" {
+			return fmt.Errorf("ccAPIURL must be an http or https URL, not %q", *ccAPIURL)
+		}
+	}
+	if reconciling && (*ccAPIURL == "" || *ccClientID == "" || *ccClientSecret == "" || *ccBrokerName == "") {
+		return errors.New("reconciling needs ccAPIURL, ccClientID, ccClientSecret and ccBrokerName")
+	}
+	return nil
+}
+
+func checkTLSParams() error {
+	if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+		return errors.New("tlsCertFile and tlsKeyFile must be provided together")
+	}
+	if *tlsClientCAFile != "" && *tlsCertFile == "" {
+		return errors.New("tlsClientCAFile requires tlsCertFile and tlsKeyFile")
+	}
+	_, err := tlsconfig.ParseMinVersion(*tlsMinVersion)
+	return err
 }
 
 func newLogger() (lager.Logger, *lager.ReconfigurableSink) {
@@ -182,49 +480,66 @@ func newLogger() (lager.Logger, *lager.ReconfigurableSink) {
 	return lagerflags.NewFromConfig("nfsbroker", lagerConfig)
 }
 
-func verifyCredhubIsReachable(logger lager.Logger) {
-	var client = &http.Client{
-		Timeout: 30 * time.Second,
+// newTransportFactory returns the factory for every outbound HTTP client,
+// trusting the CredHub, UAA and Cloud Controller CAs in addition to the
+// system roots.
+func newTransportFactory() (*transport.Factory, error) {
+	var caCertPaths []string
+	for _, path := range []string{*credhubCACertPath, *uaaCACertPath, *ccCACertPath} {
+		if path != "" {
+			caCertPaths = append(caCertPaths, path)
+		}
+	}
+
+	return transport.NewFactory(transport.Config{
+		CACertPaths:         caCertPaths,
+		Timeout:             *outboundTimeout,
+		DialTimeout:         outboundDialTimeout,
+		TLSHandshakeTimeout: outboundTLSHandshakeTimeout,
+	})
+}
+
+// newTracer returns nil when tracing is not configured. Spans are sent with a
+// client of their own, so that sending them is not itself traced.
+func newTracer(logger lager.Logger, factory *transport.Factory) (*tracing.Tracer, *tracing.HTTPReporter) {
+	if *tracingEndpoint == "" {
+		return nil, nil
 	}
 
-	configureCACert(logger, client)
+	reporter := tracing.NewHTTPReporter(logger, factory.Client(nil), *tracingEndpoint, clock.NewClock(), tracingFlushInterval)
+	return tracing.NewTracer("nfsbroker", reporter, clock.NewClock()), reporter
+}
+
+func loadCredhubClientCertificate(logger lager.Logger) *tls.Certificate {
+	if *credhubClientCertPath == "" {
+		return nil
+	}
 
+	certificate, err := tls.LoadX509KeyPair(*credhubClientCertPath, *credhubClientKeyPath)
+	if err != nil {
+		logger.Fatal("loading credhub client certificate", err)
+	}
+	return &certificate
+}
+
+func verifyCredhubIsReachable(logger lager.Logger, client *http.Client) server.Info {
 	evbutils.IsThereAProxy(&osshim.OsShim{}, logger)
 
 	resp, err := client.Get(*credhubURL + "/info")
 	if err != nil {
 		logger.Fatal("Unable to connect to credhub", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		logger.Fatal(fmt.Sprintf("Attempted to connect to credhub. Expected 200. Got %d", resp.StatusCode), nil, lager.Data{"response_headers": fmt.Sprintf("%v", resp.Header)})
 	}
-}
-
-func configureCACert(logger lager.Logger, client *http.Client) {
-	if *credhubCACertPath != "" {
-		certpool := x509.NewCertPool()
-
-		certPEM, err := os.ReadFile(*credhubCACertPath)
-		if err != nil {
-			logger.Fatal("reading credhub ca cert path", err)
-		}
 
-		ok := certpool.AppendCertsFromPEM(certPEM)
-		if !ok {
-			logger.Fatal("appending certs from PEM", err)
-		}
-		// disable "G402 (CWE-295): TLS MinVersion too low. (Confidence: HIGH, Severity: HIGH)"
-		// #nosec G402 - Enforcing a MinVersion for TLS could break numerous existing systems
-		clientTLSConf := &tls.Config{
-			RootCAs: certpool,
-		}
-
-		transport := &http.Transport{
-			TLSClientConfig: clientTLSConf,
-		}
-
-		client.Transport = transport
+	// the store looks the auth server up itself when it is missing here
+	var info server.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		logger.Info("credhub-info-unreadable", lager.Data{"error": err.Error()})
 	}
+	return info
 }
 
 func parseVcapServices(logger lager.Logger, os osshim.Os) {
@@ -262,85 +577,405 @@ func getByAlias(data map[string]interface{}, keys ...string) interface{} {
 	return nil
 }
 
-func createServer(logger lager.Logger) ifrit.Runner {
+func createServer(logger lager.Logger, credhubHTTPClient *http.Client, credhubInfo server.Info, tracer *tracing.Tracer, outboundHTTPClient *http.Client) grouper.Members {
 	if isCfPushed() {
 		parseVcapServices(logger, &osshim.OsShim{})
 	}
 
-	var credhubCACert string
-	if *credhubCACertPath != "" {
-		b, err := os.ReadFile(*credhubCACertPath)
+	caCerts, err := readCACerts()
+	if err != nil {
+		logger.Fatal("cannot-read-credhub-ca-cert", err)
+	}
+
+	if *credhubURL == "" {
+		logger.Fatal("failed-creating-broker-store", errors.New("Invalid brokerstore configuration"))
+	}
+	credhubClients, err := newCredhubClients(credhubHTTPClient, credhubInfo, caCerts)
+	if err != nil {
+		logger.Fatal("failed-creating-credhub-store", err)
+	}
+	store := credhubstore.NewStore(logger, credhubstore.NewCredhubShim(credhubClients.Client()), *storeID)
+
+	retired, err := IsRetired(store)
+	if err != nil {
+		logger.Fatal("check-is-retired-failed", err)
+	}
+
+	if retired {
+		logger.Fatal("retired-store", errors.New("Store is retired"))
+	}
+
+	registry := metrics.NewRegistry()
+	brokerMetrics := broker.NewMetrics(registry)
+	instrumentedStore := broker.NewInstrumentedStore(store, brokerMetrics, clock.NewClock())
+	storeFor := func(context.Context) brokerstore.Store { return instrumentedStore }
+	if tracer != nil {
+		storeFor = func(ctx context.Context) brokerstore.Store {
+			traced := broker.NewTracedStore(ctx, tracer, func(ctx context.Context) brokerstore.Store {
+				return credhubstore.NewStore(logger, credhubstore.NewCredhubShim(credhubClients.ForContext(ctx)), *storeID)
+			})
+			return broker.NewInstrumentedStore(traced, brokerMetrics, clock.NewClock())
+		}
+	}
+	inventory := broker.NewInventory(logger, instrumentedStore, clock.NewClock(), inventoryMaxAge)
+	registry.NewGaugeFunc("nfsbroker_instances", "Service instances in the store.", inventory.Instances)
+	registry.NewGaugeFunc("nfsbroker_bindings", "Service bindings in the store.", inventory.Bindings)
+	registry.NewGaugeFunc("nfsbroker_legacy_instances", "Service instances in the store with a legacy fingerprint, which admin upgrade fingerprints rewrites.", inventory.LegacyInstances)
+
+	scheme, _ := broker.ParseVolumeIDScheme(*volumeIDScheme)
+
+	serviceBroker, explainConfig, err := newServiceBroker(logger, storeFor, scheme, flagSettings())
+	if err != nil {
+		logger.Fatal("loading-config-error", err)
+	}
+	reloadable := broker.NewReloadable(serviceBroker)
+	explainer := explain.New(logger, explainConfig)
+	var bindingFinder shares.BindingFinder
+	if *ccAPIURL != "" {
+		bindingFinder = newCloudController(outboundHTTPClient)
+	}
+	reporter := shares.NewReporter(logger, instrumentedStore, bindingFinder, explainConfig.Mask.Defaults)
+
+	reloader := utils.NewReloader(logger.Session("config-reloader"), func() error {
+		values, err := reloadConfigFile()
 		if err != nil {
-			logger.Fatal("cannot-read-credhub-ca-cert", err, lager.Data{"path": *credhubCACertPath})
+			return err
+		}
+		settings := newBrokerSettings(values)
+		if err := settings.check(); err != nil {
+			return err
+		}
+		serviceBroker, explainConfig, err := newServiceBroker(logger, storeFor, scheme, settings)
+		if err != nil {
+			return err
+		}
+		if err := setFlags(values); err != nil {
+			return err
 		}
-		credhubCACert = string(b)
+		reloadable.Reload(serviceBroker)
+		explainer.Reload(explainConfig)
+		reporter.Reload(explainConfig.Mask.Defaults)
+		return nil
+	})
+
+	credentials, err := loadCredentials()
+	if err != nil {
+		logger.Fatal("loading-credentials-error", err)
 	}
+	authenticator := auth.NewAuthenticator(logger, clock.NewClock(), credentials)
+
+	members := grouper.Members{{Name: "config-reloader", Runner: reloader}}
+	applyCredentials := authenticator.SetCredentials
 
-	var uaaCACert string
-	if *uaaCACertPath != "" {
-		b, err := os.ReadFile(*uaaCACertPath)
+	served := domain.ServiceBroker(reloadable)
+	if *auditLog != "" {
+		log, _, err := audit.Open(*auditLog, clock.NewClock())
 		if err != nil {
-			logger.Fatal("cannot-read-credhub-ca-cert", err, lager.Data{"path": *uaaCACertPath})
+			logger.Fatal("opening-audit-log-failed", err)
 		}
-		uaaCACert = string(b)
+		if err := log.Record(audit.Entry{Operation: audit.OperationStart}); err != nil {
+			logger.Fatal("writing-audit-log-failed", err)
+		}
+		served = broker.NewAudited(logger, served, log, storeFor)
 	}
 
-	store := brokerstore.NewStore(
-		logger,
-		*credhubURL,
-		credhubCACert,
-		*uaaClientID,
-		*uaaClientSecret,
-		uaaCACert,
-		*storeID,
-	)
+	var deadLetters http.Handler
+	if *webhooksConfig != "" {
+		subscribers, err := webhooks.LoadSubscribers(*webhooksConfig)
+		if err != nil {
+			logger.Fatal("loading-webhooks-config-error", err)
+		}
+		outbox := credhubstore.NewOutbox(credhubstore.NewCredhubShim(credhubClients.Client()), *storeID)
+		dispatcher := webhooks.NewDispatcher(logger, outbox, subscribers, outboundHTTPClient, clock.NewClock(), webhooks.DefaultPolicy)
+		members = append(members, grouper.Member{Name: "webhooks-dispatcher", Runner: dispatcher})
+		served = broker.NewNotifying(logger, served, dispatcher, storeFor)
+		deadLetters = authenticator.Wrap(webhooks.NewDeadLettersHandler(logger, outbox))
+	}
 
-	retired, err := IsRetired(store)
+	if *reconcileInterval > 0 {
+		reconciler := reconcile.NewReconciler(logger, instrumentedStore, newCloudController(outboundHTTPClient), clock.NewClock())
+		members = append(members, grouper.Member{
+			Name:   "reconciler",
+			Runner: reconcile.NewScheduler(reconciler, clock.NewClock(), *reconcileInterval, *reconcileGarbageCollect),
+		})
+	}
+
+	if *storeLeases {
+		leases := credhubstore.NewLeases(logger, credhubClients.Client(), *storeID, clock.NewClock(), credhubstore.DefaultLeasePolicy)
+		leases.InstrumentWith(brokerMetrics)
+		served = broker.NewLeased(logger, served, leases)
+	}
+
+	instrumented := broker.NewInstrumented(broker.NewSerialized(served, broker.DefaultLockStripes, brokerMetrics, clock.NewClock()), brokerMetrics, clock.NewClock())
+	handler := brokerapi.NewWithOptions(instrumented, slog.New(lager.NewHandler(logger.Session("broker-api"))), brokerapi.WithCustomAuth(authenticator.Wrap))
+
+	// the health and metrics endpoints are served without authentication
+	healthHandler := health.NewHandler(logger, readinessTimeout, readinessChecks(credhubHTTPClient, store, reloadable)...)
+	operations := http.NewServeMux()
+	operations.Handle(health.LivenessPath, healthHandler)
+	operations.Handle(health.ReadinessPath, healthHandler)
+	operations.Handle(metricsPath, registry)
+	if *healthAddr != "" {
+		members = append(members, grouper.Member{Name: "health-server", Runner: http_server.New(*healthAddr, operations)})
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(health.LivenessPath, operations)
+	mux.Handle(health.ReadinessPath, operations)
+	mux.Handle(metricsPath, operations)
+	if deadLetters != nil {
+		mux.Handle(webhooks.DeadLettersPath, deadLetters)
+	}
+	mux.Handle(explain.Path, authenticator.Wrap(explain.NewHandler(logger, explainer)))
+	mux.Handle(shares.Path, authenticator.Wrap(shares.NewHandler(logger, reporter)))
+	if tracer != nil {
+		mux.Handle("/", tracer.Middleware(handler))
+	} else {
+		mux.Handle("/", handler)
+	}
+
+	server := http_server.New(*atAddress, mux)
+	if *tlsCertFile != "" {
+		minVersion, _ := tlsconfig.ParseMinVersion(*tlsMinVersion)
+		tlsServer, err := tlsconfig.NewServer(logger, *tlsCertFile, *tlsKeyFile, *tlsClientCAFile, minVersion)
+		if err != nil {
+			logger.Fatal("loading-tls-config-error", err)
+		}
+		tlsServer.SetOptionalClientCertificates(optionalClientCertificates(credentials))
+		applyCredentials = func(credentials auth.Credentials) {
+			tlsServer.SetOptionalClientCertificates(optionalClientCertificates(credentials))
+			authenticator.SetCredentials(credentials)
+		}
+		server = http_server.NewTLSServer(*atAddress, mux, tlsServer.Config())
+		members = append(members, grouper.Member{Name: "tls-watcher", Runner: tlsServer.Watcher(clock.NewClock(), filePollInterval)})
+	}
+
+	if *credentialsFile != "" {
+		members = append(members, grouper.Member{
+			Name:   "credentials-watcher",
+			Runner: auth.NewCredentialsWatcher(logger, clock.NewClock(), *credentialsFile, filePollInterval, loadCredentials, applyCredentials),
+		})
+	}
+
+	return append(grouper.Members{{Name: "broker-api", Runner: server}}, members...)
+}
+
+func newCloudController(client *http.Client) *reconcile.CloudController {
+	return reconcile.NewCloudController(reconcile.CloudControllerConfig{
+		APIURL:       *ccAPIURL,
+		ClientID:     *ccClientID,
+		ClientSecret: *ccClientSecret,
+		BrokerName:   *ccBrokerName,
+	}, client)
+}
+
+// readCACerts reads the CredHub and UAA CAs.
+func readCACerts() ([]string, error) {
+	var caCerts []string
+	for _, path := range []string{*credhubCACertPath, *uaaCACertPath} {
+		if path == "" {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		caCerts = append(caCerts, string(b))
+	}
+	return caCerts, nil
+}
+
+func newCredhubClients(credhubHTTPClient *http.Client, credhubInfo server.Info, caCerts []string) (*credhubstore.Clients, error) {
+	return credhubstore.NewClients(credhubstore.Config{
+		URL:             *credhubURL,
+		AuthURL:         credhubInfo.AuthServer.URL,
+		ServerVersion:   credhubInfo.App.Version,
+		CACerts:         caCerts,
+		ClientCertPath:  *credhubClientCertPath,
+		ClientKeyPath:   *credhubClientKeyPath,
+		UAAClientID:     *uaaClientID,
+		UAAClientSecret: *uaaClientSecret,
+	}, credhubHTTPClient)
+}
+
+// readinessChecks report whether the broker can serve requests: CredHub is
+// reachable, the store is usable and not retired, and the catalog is loaded.
+func readinessChecks(credhubClient *http.Client, store brokerstore.Store, serviceBroker domain.ServiceBroker) []health.Check {
+	return []health.Check{
+		{Name: "credhub", Check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, "GET", *credhubURL+"/info", nil)
+			if err != nil {
+				return err
+			}
+			resp, err := credhubClient.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return fmt.Errorf("credhub responded with %d", resp.StatusCode)
+			}
+			return nil
+		}},
+		{Name: "store", Check: func(context.Context) error {
+			retired, err := IsRetired(store)
+			if err != nil {
+				return err
+			}
+			if retired {
+				return errors.New("store is retired")
+			}
+			return nil
+		}},
+		{Name: "catalog", Check: func(ctx context.Context) error {
+			services, err := serviceBroker.Services(ctx)
+			if err != nil {
+				return err
+			}
+			if len(services) == 0 {
+				return errors.New("no services in catalog")
+			}
+			return nil
+		}},
+	}
+}
+
+// newServiceBroker builds a broker from the services config and mount option
+// policy in settings. It runs at startup and again on every SIGHUP, so that both
+// can change without a restart. The broker serves each request with a store
+// from storeFor, which may trace the calls made for that request. The
+// explain config builds the same broker on any store.
+func newServiceBroker(logger lager.Logger, storeFor func(ctx context.Context) brokerstore.Store, scheme broker.VolumeIDScheme, settings brokerSettings) (domain.ServiceBroker, explain.Config, error) {
+	config, err := newExplainConfig(logger, scheme, settings)
 	if err != nil {
-		logger.Fatal("check-is-retired-failed", err)
+		return nil, config, err
 	}
 
-	if retired {
-		logger.Fatal("retired-store", errors.New("Store is retired"))
+	return broker.NewPerRequest(func(ctx context.Context) domain.ServiceBroker {
+		return config.Build(logger, storeFor(ctx))
+	}), config, nil
+}
+
+func newExplainConfig(logger lager.Logger, scheme broker.VolumeIDScheme, settings brokerSettings) (explain.Config, error) {
+	configMask, err := newConfigMask(settings)
+	if err != nil {
+		return explain.Config{}, fmt.Errorf("invalid mount option policy: %w", err)
 	}
 
+	logger.Debug("nfsbroker-startup-config", lager.Data{"config-mask": configMask})
+
+	services, err := NewServicesFromConfig(settings.servicesConfig)
+	if err != nil {
+		return explain.Config{}, err
+	}
+
+	disallowedBindOverrides := []string{existingvolumebroker.SHARE_KEY, existingvolumebroker.SOURCE_KEY, broker.MetadataKey}
+	planDefaults := PlanDefaults{Schemas: ParameterSchemas(configMask, disallowedBindOverrides)}
+	if settings.maintenanceInfoVersion != "" {
+		planDefaults.MaintenanceInfo = &domain.MaintenanceInfo{
+			Version:     settings.maintenanceInfoVersion,
+			Description: settings.maintenanceInfoDescription,
+		}
+	}
+	services = NewServicesWithPlanDefaults(services, planDefaults)
+
+	return explain.Config{
+		Build: func(logger lager.Logger, store brokerstore.Store) domain.ServiceBroker {
+			existingVolumeBroker := existingvolumebroker.New(
+				existingvolumebroker.BrokerTypeNFS,
+				logger,
+				services,
+				&osshim.OsShim{},
+				clock.NewClock(),
+				store,
+				configMask,
+			)
+			existingVolumeBroker.DisallowedBindOverrides = disallowedBindOverrides
+
+			return broker.New(logger, existingVolumeBroker, store, configMask, scheme)
+		},
+		Mask:                    configMask,
+		DisallowedBindOverrides: disallowedBindOverrides,
+	}, nil
+}
+
+// optionalClientCertificates reports whether clients may connect without a
+// certificate: with both client certificate identities and basic auth
+// credentials, clients may use either.
+func optionalClientCertificates(credentials auth.Credentials) bool {
+	return len(credentials.ClientCertificates) > 0 && len(credentials.Basic) > 0
+}
+
+// loadCredentials returns the credentials from credentialsFile, or when it is
+// not set, the USERNAME and PASSWORD from the environment. It runs at startup
+// and again whenever the credentials file changes.
+func loadCredentials() (auth.Credentials, error) {
+	if *credentialsFile == "" {
+		return auth.Credentials{Basic: []auth.Credential{{Name: "default", Username: username, Password: password}}}, nil
+	}
+
+	credentials, err := auth.LoadCredentials(*credentialsFile)
+	if err != nil {
+		return credentials, err
+	}
+	if len(credentials.ClientCertificates) > 0 && *tlsClientCAFile == "" {
+		return credentials, errors.New("client_certificates in credentialsFile require tlsClientCAFile")
+	}
+	return credentials, nil
+}
+
+func newConfigMask(settings brokerSettings) (vmo.MountOptsMask, error) {
 	cacheOptsValidator := vmo.UserOptsValidationFunc(validateCache)
 
-	configMask, err := vmo.NewMountOptsMask(
-		strings.Split(*allowedOptions, ","),
-		vmou.ParseOptionStringToMap(*defaultOptions, ":"),
+	allowed, defaults, err := mountOptionsPolicy(settings)
+	if err != nil {
+		return vmo.MountOptsMask{}, err
+	}
+
+	return vmo.NewMountOptsMask(
+		strings.Split(allowed, ","),
+		vmou.ParseOptionStringToMap(defaults, ":"),
 		map[string]string{
 			"share": "source",
 		},
-		[]string{},
+		[]string{broker.MetadataKey},
 		[]string{"source"},
 		cacheOptsValidator,
 	)
-	if err != nil {
-		logger.Fatal("creating-config-mask-error", err)
-	}
+}
 
-	logger.Debug("nfsbroker-startup-config", lager.Data{"config-mask": configMask})
+// mountOptionsPolicy returns the allowed and default options, read from
+// mountOptionsConfig when it is set and from allowedOptions and
+// defaultOptions otherwise.
+func mountOptionsPolicy(settings brokerSettings) (string, string, error) {
+	allowed, defaults := settings.allowedOptions, settings.defaultOptions
+	if settings.mountOptionsConfig == "" {
+		return allowed, defaults, nil
+	}
 
-	services, err := NewServicesFromConfig(*servicesConfig)
+	/* #nosec */
+	contents, err := os.ReadFile(settings.mountOptionsConfig)
 	if err != nil {
-		logger.Fatal("loading-services-config-error", err)
+		return "", "", err
 	}
 
-	serviceBroker := existingvolumebroker.New(
-		existingvolumebroker.BrokerTypeNFS,
-		logger,
-		services,
-		&osshim.OsShim{},
-		clock.NewClock(),
-		store,
-		configMask,
-	)
-
-	credentials := brokerapi.BrokerCredentials{Username: username, Password: password}
-	handler := brokerapi.New(serviceBroker, slog.New(lager.NewHandler(logger.Session("broker-api"))), credentials)
+	var policy struct {
+		AllowedOptions *string `json:"allowed_options"`
+		DefaultOptions *string `json:"default_options"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return "", "", fmt.Errorf("invalid mount options config %s: %w", settings.mountOptionsConfig, err)
+	}
 
-	return http_server.New(*atAddress, handler)
+	if policy.AllowedOptions != nil {
+		allowed = *policy.AllowedOptions
+	}
+	if policy.DefaultOptions != nil {
+		defaults = *policy.DefaultOptions
+	}
+	return allowed, defaults, nil
 }
 
 func isCfPushed() bool {
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,11 +13,15 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/tlsconfig/tlsconfigtest"
 	fuzz "github.com/google/gofuzz"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -61,6 +70,265 @@ var _ = Describe("nfsbroker Main", func() {
 		})
 	})
 
+	Context("validate-config", func() {
+		It("succeeds for a valid configuration without contacting the store", func() {
+			command := exec.Command(binaryPath, "-servicesConfig", "./test_default_services.json", "validate-config")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session, "10s").Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("configuration is valid"))
+		})
+
+		It("reports every problem and fails for an invalid configuration", func() {
+			servicesPath := filepath.Join(GinkgoT().TempDir(), "services.json")
+			Expect(os.WriteFile(servicesPath, []byte(`[{"id": "a", "name": "nfs", "bindable": true, "plans": []}]`), 0600)).To(Succeed())
+
+			command := exec.Command(binaryPath, "-servicesConfig", servicesPath, "-volumeIdScheme", "v9", "validate-config")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session, "10s").Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`1:2: \[0\]: requires must include "volume_mount"`))
+			Expect(session.Err).To(gbytes.Say(`unknown volume id scheme "v9"`))
+		})
+	})
+
+	Context("admin", func() {
+		var credhubServer *ghttp.Server
+
+		BeforeEach(func() {
+			credhubServer = ghttp.NewServer()
+			credhubServer.RouteToHandler("GET", "/info", ghttp.RespondWithJSONEncoded(http.StatusOK, credhubInfoResponse{
+				AuthServer: credhubInfoResponseAuthServer{URL: credhubServer.URL()},
+			}))
+			credhubServer.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusOK, `{ "access_token" : "111", "refresh_token" : "", "token_type" : "" }`))
+			credhubServer.RouteToHandler("GET", "/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Query().Get("path") == "/nfsbroker":
+					_, _ = w.Write([]byte(`{ "credentials" : [ { "name": "/nfsbroker/instance-1", "version_created_at": "2026" }, { "name": "/nfsbroker/binding-1", "version_created_at": "2026" } ] }`))
+				case r.URL.Query().Get("name") == "/nfsbroker/instance-1":
+					_, _ = w.Write([]byte(`{ "data" : [ { "type": "json", "version_created_at": "2026", "id": "1", "name": "/nfsbroker/instance-1", "value": { "service_id": "nfs", "plan_id": "existing", "organization_guid": "org-guid", "space_guid": "space-guid", "ServiceFingerPrint": { "share": "server/export" } } } ] }`))
+				case r.URL.Query().Get("name") == "/nfsbroker/binding-1":
+					_, _ = w.Write([]byte(`{ "data" : [ { "type": "json", "version_created_at": "2026", "id": "2", "name": "/nfsbroker/binding-1", "value": { "service_id": "nfs", "plan_id": "existing", "app_guid": "app-guid" } } ] }`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+		})
+
+		AfterEach(func() {
+			credhubServer.Close()
+		})
+
+		admin := func(args ...string) *gexec.Session {
+			command := exec.Command(binaryPath, append([]string{"-credhubURL", credhubServer.URL(), "-uaaClientID", "client", "-uaaClientSecret", "secret", "admin"}, args...)...)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, "10s").Should(gexec.Exit())
+			return session
+		}
+
+		It("lists the instances in the store as JSON", func() {
+			session := admin("list", "instances", "-org", "org-guid", "-json")
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out.Contents()).To(MatchJSON(`[{"id": "instance-1", "service_id": "nfs", "plan_id": "existing", "organization_guid": "org-guid", "space_guid": "space-guid", "share": "server/export", "fingerprint": {"share": "server/export"}}]`))
+		})
+
+		It("deletes a binding once confirmed", func() {
+			credhubServer.RouteToHandler("DELETE", "/api/v1/data", ghttp.CombineHandlers(
+				ghttp.VerifyRequest("DELETE", "/api/v1/data", "name=%2Fnfsbroker%2Fbinding-1"),
+				ghttp.RespondWith(http.StatusNoContent, nil),
+			))
+
+			command := exec.Command(binaryPath, "-credhubURL", credhubServer.URL(), "-uaaClientID", "client", "-uaaClientSecret", "secret", "admin", "delete", "binding", "binding-1")
+			command.Stdin = strings.NewReader("binding-1\n")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, "10s").Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("deleted binding binding-1"))
+		})
+
+		It("rewrites shares, recording each in the audit log", func() {
+			var written json.RawMessage
+			credhubServer.RouteToHandler("GET", "/version", ghttp.RespondWith(http.StatusOK, `{ "version" : "0.0.0" }`))
+			credhubServer.RouteToHandler("PUT", "/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				var credential struct {
+					Name  string          `json:"name"`
+					Value json.RawMessage `json:"value"`
+				}
+				Expect(json.NewDecoder(r.Body).Decode(&credential)).To(Succeed())
+				Expect(credential.Name).To(Equal("/nfsbroker/instance-1"))
+				written = credential.Value
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`{ "type" : "json", "version_created_at" : "", "id" : "", "name" : "", "value" : { } }`))
+			})
+			auditPath := filepath.Join(GinkgoT().TempDir(), "audit.log")
+
+			command := exec.Command(binaryPath, "-credhubURL", credhubServer.URL(), "-uaaClientID", "client", "-uaaClientSecret", "secret", "-auditLog", auditPath,
+				"admin", "rewrite", "shares", "-host", "server=newserver", "-offline", "-yes")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, "10s").Should(gexec.Exit(0))
+			Expect(session.Err).To(gbytes.Say(`- server/export\s+\+ newserver/export`))
+			Expect(session.Out).To(gbytes.Say("rewrote 1 of 1 share"))
+			Expect(session.Out).To(gbytes.Say("configure cloud_controller to list their bindings"))
+			Expect(written).To(MatchJSON(`{"service_id": "nfs", "plan_id": "existing", "organization_guid": "org-guid", "space_guid": "space-guid", "ServiceFingerPrint": {"share": "newserver/export"}}`))
+
+			verify := exec.Command(binaryPath, "audit-verify", auditPath)
+			session, err = gexec.Start(verify, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, "10s").Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("entries: 2"))
+			contents, err := os.ReadFile(auditPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring(`"operation":"rewrite-share","instance_id":"instance-1","platform":"admin"`))
+		})
+
+		It("reports the shares in the store as CSV", func() {
+			command := exec.Command(binaryPath, "-credhubURL", credhubServer.URL(), "-uaaClientID", "client", "-uaaClientSecret", "secret", "-defaultOptions", "uid:1000", "shares")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, "10s").Should(gexec.Exit(0))
+			Expect(string(session.Out.Contents())).To(Equal("server,export,instances,bindings,organization_guids,space_guids,plan_ids,uids,gids\nserver,/export,1,,org-guid,space-guid,existing,1000,\n"))
+		})
+
+		It("explains its usage without contacting the store", func() {
+			session := admin("list")
+			Expect(session.ExitCode()).To(Equal(1))
+			Expect(session.Err).To(gbytes.Say("admin list instances"))
+			Expect(credhubServer.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Context("reconcile", func() {
+		var (
+			credhubServer *ghttp.Server
+			ccServer      *ghttp.Server
+		)
+
+		BeforeEach(func() {
+			credhubServer = ghttp.NewServer()
+			credhubServer.RouteToHandler("GET", "/info", ghttp.RespondWithJSONEncoded(http.StatusOK, credhubInfoResponse{
+				AuthServer: credhubInfoResponseAuthServer{URL: credhubServer.URL()},
+			}))
+			credhubServer.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusOK, `{ "access_token" : "111", "refresh_token" : "", "token_type" : "" }`))
+			credhubServer.RouteToHandler("GET", "/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Query().Get("path") == "/nfsbroker":
+					_, _ = w.Write([]byte(`{ "credentials" : [ { "name": "/nfsbroker/instance-1", "version_created_at": "2026" }, { "name": "/nfsbroker/instance-gone", "version_created_at": "2026" } ] }`))
+				case strings.HasPrefix(r.URL.Query().Get("name"), "/nfsbroker/instance-"):
+					_, _ = w.Write([]byte(`{ "data" : [ { "type": "json", "version_created_at": "2026", "id": "1", "name": "` + r.URL.Query().Get("name") + `", "value": { "service_id": "nfs", "plan_id": "existing", "ServiceFingerPrint": { "share": "server/export" } } } ] }`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+
+			ccServer = ghttp.NewServer()
+			ccServer.RouteToHandler("GET", "/", ghttp.RespondWith(http.StatusOK, `{ "links": { "uaa": { "href": "`+ccServer.URL()+`" } } }`))
+			ccServer.RouteToHandler("POST", "/oauth/token", ghttp.CombineHandlers(
+				ghttp.VerifyBasicAuth("reconciler", "secret"),
+				ghttp.RespondWith(http.StatusOK, `{ "access_token": "cc-token" }`),
+			))
+			ccServer.RouteToHandler("GET", "/v3/service_plans", ghttp.CombineHandlers(
+				ghttp.VerifyFormKV("service_broker_names", "nfsbroker"),
+				ghttp.RespondWith(http.StatusOK, `{ "pagination": {}, "resources": [ { "guid": "plan-1" } ] }`),
+			))
+			ccServer.RouteToHandler("GET", "/v3/service_instances", ghttp.RespondWith(http.StatusOK, `{ "pagination": {}, "resources": [ { "guid": "instance-1" } ] }`))
+			ccServer.RouteToHandler("GET", "/v3/service_credential_bindings", ghttp.RespondWith(http.StatusOK, `{ "pagination": {}, "resources": [] }`))
+		})
+
+		AfterEach(func() {
+			credhubServer.Close()
+			ccServer.Close()
+		})
+
+		reconcile := func(args ...string) *gexec.Session {
+			command := exec.Command(binaryPath, append([]string{
+				"-credhubURL", credhubServer.URL(), "-uaaClientID", "client", "-uaaClientSecret", "secret",
+				"-ccAPIURL", ccServer.URL(), "-ccClientID", "reconciler", "-ccClientSecret", "secret",
+				"reconcile",
+			}, args...)...)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, "10s").Should(gexec.Exit())
+			return session
+		}
+
+		It("reports the instances Cloud Controller does not have", func() {
+			session := reconcile()
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out).To(gbytes.Say("instances only in the store: 1\n  instance-gone\n"))
+		})
+
+		It("deletes them with -gc", func() {
+			credhubServer.RouteToHandler("DELETE", "/api/v1/data", ghttp.CombineHandlers(
+				ghttp.VerifyRequest("DELETE", "/api/v1/data", "name=%2Fnfsbroker%2Finstance-gone"),
+				ghttp.RespondWith(http.StatusNoContent, nil),
+			))
+
+			session := reconcile("-gc", "-json")
+			Expect(session.ExitCode()).To(Equal(0))
+			var report map[string]interface{}
+			Expect(json.Unmarshal(session.Out.Contents(), &report)).To(Succeed())
+			Expect(report["deleted_instances"]).To(ConsistOf("instance-gone"))
+		})
+
+		It("needs the Cloud Controller settings", func() {
+			command := exec.Command(binaryPath, "-credhubURL", credhubServer.URL(), "reconcile")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, "10s").Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("reconciling needs ccAPIURL, ccClientID, ccClientSecret and ccBrokerName"))
+			Expect(credhubServer.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Context("explain", func() {
+		It("shows the mount config a bind would give, without contacting the store", func() {
+			command := exec.Command(binaryPath,
+				"-servicesConfig", "./test_default_services.json", "-allowedOptions", "source,uid,gid", "-defaultOptions", "uid:1000",
+				"explain", "-plan", "09a09260-1df5-4445-9ed7-1ba56dadbbc8", "-share", "server/export", "-bind", `{"gid": "2000"}`)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session, "10s").Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`result:\s+allowed`))
+			Expect(session.Out).To(gbytes.Say(`source:\s+nfs://server/export`))
+			Expect(session.Out).To(gbytes.Say(`uid\s+"1000"\s+default\s+applied`))
+			Expect(session.Out).To(gbytes.Say(`gid\s+"2000"\s+bind\s+applied`))
+		})
+
+		It("explains why a bind would be refused", func() {
+			command := exec.Command(binaryPath,
+				"-servicesConfig", "./test_default_services.json", "-allowedOptions", "source,uid",
+				"explain", "-plan", "09a09260-1df5-4445-9ed7-1ba56dadbbc8", "-share", "server/export", "-bind", `{"gid": "2000"}`, "-json")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session, "10s").Should(gexec.Exit(0))
+			var explanation map[string]interface{}
+			Expect(json.Unmarshal(session.Out.Contents(), &explanation)).To(Succeed())
+			Expect(explanation["allowed"]).To(BeFalse())
+			Expect(explanation["options"]).To(ContainElement(HaveKeyWithValue("reason", "is not an allowed mount option")))
+		})
+	})
+
+	Context("with an invalid config file", func() {
+		It("reports the unknown key and its line", func() {
+			configPath := filepath.Join(GinkgoT().TempDir(), "config.yml")
+			Expect(os.WriteFile(configPath, []byte("listen_addr: 0.0.0.0:8999\nlisten_adr: 0.0.0.0:8999\n"), 0600)).To(Succeed())
+
+			command := exec.Command(binaryPath, "-configFile", configPath, "validate-config")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session, "10s").Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`line 2: field listen_adr not found`))
+		})
+	})
+
 	Context("credhub /info returns error", func() {
 		var volmanRunner *ginkgomon.Runner
 		var credhubServer *ghttp.Server
@@ -152,6 +420,7 @@ var _ = Describe("nfsbroker Main", func() {
 		)
 
 		BeforeEach(func() {
+			args = nil
 			listenAddr = "0.0.0.0:" + strconv.Itoa(7999+GinkgoParallelProcess())
 			username = "admin"
 			password = "password"
@@ -211,6 +480,10 @@ var _ = Describe("nfsbroker Main", func() {
 			Eventually(volmanRunner.Buffer()).Should(gbytes.Say("no-proxy-found"))
 		})
 
+		It("should report the outbound transport settings", func() {
+			Eventually(volmanRunner.Buffer()).Should(gbytes.Say(`outbound-transport.*"timeout":"30s"`))
+		})
+
 		It("should listen on the given address", func() {
 			resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
 			Expect(err).NotTo(HaveOccurred())
@@ -245,6 +518,420 @@ var _ = Describe("nfsbroker Main", func() {
 			Expect(catalog.Services[1].Plans[0].Description).To(Equal("A preexisting filesystem"))
 		})
 
+		It("should advertise parameter schemas for the allowed options", func() {
+			resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(200))
+
+			var catalog apiresponses.CatalogResponse
+			err = json.NewDecoder(resp.Body).Decode(&catalog)
+			Expect(err).NotTo(HaveOccurred())
+
+			schemas := catalog.Services[1].Plans[0].Schemas
+			Expect(schemas).NotTo(BeNil())
+			Expect(schemas.Instance.Create.Parameters["properties"]).To(HaveKey("share"))
+			Expect(schemas.Instance.Create.Parameters["properties"]).To(HaveKey("cache"))
+			Expect(schemas.Binding.Create.Parameters["properties"]).NotTo(HaveKey("share"))
+		})
+
+		Context("when maintenanceInfoVersion is set", func() {
+			BeforeEach(func() {
+				args = append(args, "-maintenanceInfoVersion", "1.2.3", "-maintenanceInfoDescription", "new defaults")
+			})
+
+			It("should advertise maintenance info on every plan", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+
+				var catalog apiresponses.CatalogResponse
+				err = json.NewDecoder(resp.Body).Decode(&catalog)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, service := range catalog.Services {
+					Expect(service.Plans[0].MaintenanceInfo).To(Equal(&domain.MaintenanceInfo{Version: "1.2.3", Description: "new defaults"}))
+				}
+			})
+		})
+
+		Context("with a config file", func() {
+			BeforeEach(func() {
+				configPath := filepath.Join(GinkgoT().TempDir(), "config.yml")
+				Expect(os.WriteFile(configPath, []byte(fmt.Sprintf(`
+listen_addr: 127.0.0.1:1
+services_config: ./test_default_services.json
+mount_options:
+  allowed: [source, uid]
+  defaults:
+    auto_cache: "true"
+credhub:
+  url: %s
+auth:
+  username: overridden-by-env
+  password: overridden-by-env
+`, credhubServer.URL())), 0600)).To(Succeed())
+
+				args = []string{"-configFile", configPath, "-listenAddr", listenAddr}
+			})
+
+			It("should use settings from the file unless overridden by the environment or flags", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+
+				var catalog apiresponses.CatalogResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&catalog)).To(Succeed())
+				Expect(catalog.Services).To(HaveLen(2))
+
+				bindProperties := catalog.Services[1].Plans[0].Schemas.Binding.Create.Parameters["properties"]
+				Expect(bindProperties).To(HaveKey("uid"))
+				Expect(bindProperties).NotTo(HaveKey("gid"))
+			})
+		})
+
+		Context("with a credentials file", func() {
+			var credentialsPath string
+
+			BeforeEach(func() {
+				credentialsPath = filepath.Join(GinkgoT().TempDir(), "credentials.yml")
+				Expect(os.WriteFile(credentialsPath, []byte(`
+credentials:
+- name: old
+  username: admin
+  password: old-password
+- name: new
+  username: admin
+  password: new-password
+`), 0600)).To(Succeed())
+
+				args = append(args, "-credentialsFile", credentialsPath)
+			})
+
+			It("should accept every listed credential and log which one was used", func() {
+				for _, name := range []string{"old", "new"} {
+					password = name + "-password"
+					resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(200))
+					Eventually(volmanRunner.Buffer()).Should(gbytes.Say(`nfsbroker.auth.authenticated.*"credential":"` + name + `"`))
+				}
+			})
+
+			It("should no longer accept the credentials from the environment", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(401))
+			})
+
+			It("should reject a reloaded file with client certificates but no client CA", func() {
+				Expect(os.WriteFile(credentialsPath, []byte(`
+credentials:
+- {name: new, username: admin, password: new-password}
+client_certificates:
+- {name: cloud-controller, common_names: [cloud_controller]}
+`), 0600)).To(Succeed())
+
+				Eventually(volmanRunner.Buffer(), 10*time.Second).Should(gbytes.Say("load-failed-keeping-current-credentials.*require tlsClientCAFile"))
+				password = "old-password"
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+			})
+		})
+
+		Context("with TLS", func() {
+			var (
+				ca     *tlsconfigtest.CA
+				dir    string
+				client *http.Client
+			)
+
+			httpsDo := func(client *http.Client, withBasicAuth bool) (*http.Response, error) {
+				req, err := http.NewRequest("GET", "https://127.0.0.1:"+strings.Split(listenAddr, ":")[1]+"/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Add("X-Broker-Api-Version", "2.14")
+				if withBasicAuth {
+					req.SetBasicAuth(username, password)
+				}
+				return client.Do(req)
+			}
+
+			BeforeEach(func() {
+				var err error
+				ca, err = tlsconfigtest.NewCA("broker-ca")
+				Expect(err).NotTo(HaveOccurred())
+				cert, key, err := ca.Issue("nfsbroker", "127.0.0.1")
+				Expect(err).NotTo(HaveOccurred())
+
+				dir = GinkgoT().TempDir()
+				Expect(os.WriteFile(filepath.Join(dir, "cert.pem"), cert, 0600)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "key.pem"), key, 0600)).To(Succeed())
+				args = append(args, "-tlsCertFile", filepath.Join(dir, "cert.pem"), "-tlsKeyFile", filepath.Join(dir, "key.pem"))
+
+				roots := x509.NewCertPool()
+				roots.AppendCertsFromPEM(ca.CertPEM)
+				client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+			})
+
+			It("should serve the broker API over TLS", func() {
+				resp, err := httpsDo(client, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+			})
+
+			Context("with client certificate identities", func() {
+				var certClient *http.Client
+
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(dir, "client-ca.pem"), ca.CertPEM, 0600)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(dir, "credentials.yml"), []byte(`
+credentials:
+- {name: basic, username: admin, password: password}
+client_certificates:
+- {name: cloud-controller, common_names: [cloud_controller]}
+`), 0600)).To(Succeed())
+					args = append(args, "-tlsClientCAFile", filepath.Join(dir, "client-ca.pem"), "-credentialsFile", filepath.Join(dir, "credentials.yml"))
+
+					certPEM, keyPEM, err := ca.Issue("cloud_controller")
+					Expect(err).NotTo(HaveOccurred())
+					certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+					Expect(err).NotTo(HaveOccurred())
+
+					transport := client.Transport.(*http.Transport).Clone()
+					transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+					certClient = &http.Client{Transport: transport}
+				})
+
+				It("should authenticate clients by certificate", func() {
+					resp, err := httpsDo(certClient, false)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(200))
+					Eventually(volmanRunner.Buffer()).Should(gbytes.Say(`nfsbroker.auth.authenticated.*"auth_method":"client-certificate","credential":"cloud-controller"`))
+				})
+
+				It("should still accept basic auth alongside", func() {
+					resp, err := httpsDo(client, true)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(200))
+
+					resp, err = httpsDo(client, false)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(401))
+				})
+
+				It("should require certificates once the basic auth credentials are removed, and not once they are back", func() {
+					Expect(os.WriteFile(filepath.Join(dir, "credentials.yml"), []byte(`
+client_certificates:
+- {name: cloud-controller, common_names: [cloud_controller]}
+`), 0600)).To(Succeed())
+					Eventually(volmanRunner.Buffer(), 10*time.Second).Should(gbytes.Say("credentials-reloaded"))
+
+					_, err := httpsDo(client, true)
+					Expect(err).To(HaveOccurred())
+					resp, err := httpsDo(certClient, false)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(200))
+
+					Expect(os.WriteFile(filepath.Join(dir, "credentials.yml"), []byte(`
+credentials:
+- {name: basic, username: admin, password: password}
+client_certificates:
+- {name: cloud-controller, common_names: [cloud_controller]}
+`), 0600)).To(Succeed())
+					Eventually(volmanRunner.Buffer(), 10*time.Second).Should(gbytes.Say("credentials-reloaded"))
+
+					resp, err = httpsDo(client, true)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(200))
+				})
+			})
+
+			It("should not serve plain HTTP", func() {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("health endpoints", func() {
+			var healthAddr string
+
+			BeforeEach(func() {
+				healthAddr = "127.0.0.1:" + strconv.Itoa(7799+GinkgoParallelProcess())
+				args = append(args, "-healthAddr", healthAddr)
+			})
+
+			It("reports liveness without authentication", func() {
+				resp, err := http.Get("http://" + listenAddr + "/healthz")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+
+			It("reports readiness without authentication", func() {
+				credhubServer.RouteToHandler("GET", "/info", ghttp.RespondWithJSONEncoded(http.StatusOK, credhubInfoResponse{}))
+
+				resp, err := http.Get("http://" + listenAddr + "/readyz")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(body).To(MatchJSON(`{"status": "ready", "checks": {"credhub": "ok", "store": "ok", "catalog": "ok"}}`))
+			})
+
+			It("is not ready when CredHub is unavailable", func() {
+				credhubServer.RouteToHandler("GET", "/info", ghttp.RespondWith(http.StatusBadGateway, ""))
+
+				resp, err := http.Get("http://" + listenAddr + "/readyz")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring(`"credhub":"credhub responded with 502"`))
+			})
+
+			It("serves the endpoints on the health address too", func() {
+				resp, err := http.Get("http://" + healthAddr + "/healthz")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = http.Get("http://" + healthAddr + "/v2/catalog")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+
+			It("serves metrics without authentication", func() {
+				credhubServer.RouteToHandler("GET", "/api/v1/data", ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/data", "path=%2Fnfsbroker"),
+					ghttp.RespondWith(http.StatusOK, `{"credentials": []}`),
+				))
+
+				resp, err := http.Get("http://" + listenAddr + "/metrics")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring("# TYPE nfsbroker_operations_total counter"))
+				Expect(string(body)).To(ContainSubstring("nfsbroker_instances 0\n"))
+				Expect(string(body)).To(ContainSubstring("nfsbroker_bindings 0\n"))
+				Expect(string(body)).To(ContainSubstring("nfsbroker_legacy_instances 0\n"))
+			})
+
+			It("still requires authentication for the broker API", func() {
+				resp, err := http.Get("http://" + listenAddr + "/v2/catalog")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		Context("explain endpoint", func() {
+			It("explains a bind to authenticated clients, without touching the store", func() {
+				body := `{"plan_id": "09a09260-1df5-4445-9ed7-1ba56dadbbc8", "share": "server/export", "bind_parameters": {"uid": "3000", "nosuid": true}}`
+				resp, err := http.Post("http://"+listenAddr+"/admin/explain", "application/json", strings.NewReader(body))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+				resp, err = httpDoWithAuth("POST", "/admin/explain", strings.NewReader(body))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				var explanation map[string]interface{}
+				Expect(json.NewDecoder(resp.Body).Decode(&explanation)).To(Succeed())
+				Expect(explanation["allowed"]).To(BeFalse())
+				Expect(explanation["error"]).To(Equal("bind: - Not allowed options: nosuid"))
+
+				for _, request := range credhubServer.ReceivedRequests() {
+					Expect(request.URL.Path).NotTo(Equal("/api/v1/data"))
+				}
+			})
+		})
+
+		Context("shares endpoint", func() {
+			BeforeEach(func() {
+				credhubServer.RouteToHandler("GET", "/info", ghttp.RespondWithJSONEncoded(http.StatusOK, credhubInfoResponse{
+					AuthServer: credhubInfoResponseAuthServer{URL: uaaServer.URL()},
+				}))
+				uaaServer.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusOK, `{ "access_token" : "111", "refresh_token" : "", "token_type" : "" }`))
+			})
+
+			It("reports the shares in the store to authenticated clients", func() {
+				credhubServer.RouteToHandler("GET", "/api/v1/data", ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/data", "path=%2Fnfsbroker"),
+					ghttp.RespondWith(http.StatusOK, `{"credentials": []}`),
+				))
+
+				resp, err := http.Get("http://" + listenAddr + "/admin/shares")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+				resp, err = httpDoWithAuth("GET", "/admin/shares?format=csv", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal("server,export,instances,bindings,organization_guids,space_guids,plan_ids,uids,gids\n"))
+			})
+		})
+
+		Context("on SIGHUP", func() {
+			var servicesPath, mountOptionsPath string
+
+			fetchCatalog := func() apiresponses.CatalogResponse {
+				resp, err := httpDoWithAuth("GET", "/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+
+				var catalog apiresponses.CatalogResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&catalog)).To(Succeed())
+				return catalog
+			}
+
+			BeforeEach(func() {
+				dir := GinkgoT().TempDir()
+
+				contents, err := os.ReadFile("./test_default_services.json")
+				Expect(err).NotTo(HaveOccurred())
+				servicesPath = filepath.Join(dir, "services.json")
+				Expect(os.WriteFile(servicesPath, contents, 0600)).To(Succeed())
+
+				mountOptionsPath = filepath.Join(dir, "mount_options.json")
+				Expect(os.WriteFile(mountOptionsPath, []byte(`{"allowed_options": "source,uid,gid"}`), 0600)).To(Succeed())
+
+				args = append(args, "-servicesConfig", servicesPath, "-mountOptionsConfig", mountOptionsPath)
+			})
+
+			It("should reload the services config and mount option policy", func() {
+				Expect(fetchCatalog().Services).To(HaveLen(2))
+
+				var services []map[string]interface{}
+				contents, err := os.ReadFile(servicesPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(json.Unmarshal(contents, &services)).To(Succeed())
+				contents, err = json.Marshal(services[1:])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(servicesPath, contents, 0600)).To(Succeed())
+				Expect(os.WriteFile(mountOptionsPath, []byte(`{"allowed_options": "source,uid"}`), 0600)).To(Succeed())
+
+				process.Signal(syscall.SIGHUP)
+				Eventually(volmanRunner.Buffer()).Should(gbytes.Say("config-reloader.reloaded"))
+
+				catalog := fetchCatalog()
+				Expect(catalog.Services).To(HaveLen(1))
+				Expect(catalog.Services[0].Name).To(Equal("nfs"))
+				bindProperties := catalog.Services[0].Plans[0].Schemas.Binding.Create.Parameters["properties"]
+				Expect(bindProperties).To(HaveKey("uid"))
+				Expect(bindProperties).NotTo(HaveKey("gid"))
+			})
+
+			It("should keep serving the old config when the new one is invalid", func() {
+				Expect(os.WriteFile(servicesPath, []byte(`[{"id": "a"`), 0600)).To(Succeed())
+
+				process.Signal(syscall.SIGHUP)
+				Eventually(volmanRunner.Buffer()).Should(gbytes.Say("config-reloader.reload-failed-keeping-current-config"))
+
+				Expect(fetchCatalog().Services).To(HaveLen(2))
+			})
+		})
+
 		Context("#update", func() {
 
 			It("should respond with a 422", func() {
@@ -381,6 +1068,366 @@ var _ = Describe("nfsbroker Main", func() {
 					Expect(string(responseBody)).To(MatchJSON(expectedJsonResponse))
 				})
 			})
+
+			Context("with an audit log", func() {
+				var auditPath string
+
+				BeforeEach(func() {
+					auditPath = filepath.Join(GinkgoT().TempDir(), "audit.log")
+					args = append(args, "-auditLog", auditPath)
+				})
+
+				verify := func() *gexec.Session {
+					session, err := gexec.Start(exec.Command(binaryPath, "audit-verify", auditPath), GinkgoWriter, GinkgoWriter)
+					Expect(err).NotTo(HaveOccurred())
+					Eventually(session, "10s").Should(gexec.Exit())
+					return session
+				}
+
+				It("records the bind and who asked for it, in a log that verifies until it is changed", func() {
+					bindDetails, err := json.Marshal(domain.BindDetails{ServiceID: serviceOfferingID, PlanID: planID, AppGUID: "222"})
+					Expect(err).NotTo(HaveOccurred())
+					req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s/v2/service_instances/%s/service_bindings/%s", listenAddr, serviceInstanceID, bindingID), strings.NewReader(string(bindDetails)))
+					Expect(err).NotTo(HaveOccurred())
+					req.Header.Add("X-Broker-Api-Version", "2.14")
+					req.Header.Set("X-Broker-API-Originating-Identity", "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgtMzA5Mi00ZmY0LWI2NTYtMzljYWNjNGQ1MzYwIn0=")
+					req.SetBasicAuth(username, password)
+
+					resp, err := http.DefaultClient.Do(req)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+					contents, err := os.ReadFile(auditPath)
+					Expect(err).NotTo(HaveOccurred())
+					lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+					Expect(lines).To(HaveLen(2))
+					Expect(lines[0]).To(ContainSubstring(`"operation":"start"`))
+					Expect(lines[1]).To(ContainSubstring(`"operation":"bind"`))
+					Expect(lines[1]).To(ContainSubstring(`"user":"683ea748-3092-4ff4-b656-39cacc4d5360"`))
+					Expect(lines[1]).To(ContainSubstring(`"source":"nfs://`))
+					Expect(lines[1]).To(ContainSubstring(`"outcome":"success"`))
+
+					session := verify()
+					Expect(session.ExitCode()).To(Equal(0))
+					Expect(session.Out).To(gbytes.Say("audit log is intact\nentries: 2\nchains: 1\n"))
+
+					tampered := strings.Replace(string(contents), "683ea748", "00000000", 1)
+					Expect(os.WriteFile(auditPath, []byte(tampered), 0600)).To(Succeed())
+
+					session = verify()
+					Expect(session.ExitCode()).To(Equal(1))
+					Expect(session.Err).To(gbytes.Say("line 2: hash does not match the entry"))
+				})
+			})
+
+			Context("with webhook subscribers", func() {
+				var (
+					receiver *ghttp.Server
+					received chan *http.Request
+					bodies   chan []byte
+					outbox   map[string]json.RawMessage
+					lock     sync.Mutex
+				)
+
+				BeforeEach(func() {
+					received = make(chan *http.Request, 10)
+					bodies = make(chan []byte, 10)
+					receiver = ghttp.NewServer()
+					receiver.RouteToHandler("POST", "/hooks", func(w http.ResponseWriter, r *http.Request) {
+						body, _ := io.ReadAll(r.Body)
+						received <- r
+						bodies <- body
+					})
+
+					configPath := filepath.Join(GinkgoT().TempDir(), "webhooks.yml")
+					Expect(os.WriteFile(configPath, []byte(fmt.Sprintf(`
+subscribers:
+- name: cmdb
+  url: %s/hooks
+  secret: cmdb-secret
+`, receiver.URL())), 0600)).To(Succeed())
+					args = append(args, "-webhooksConfig", configPath)
+
+					// the dispatcher may authenticate at the same time as the broker
+					uaaServer.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusOK, `{ "access_token" : "111", "refresh_token" : "", "token_type" : "" }`))
+
+					// keeps the outbox, and otherwise serves instances as above
+					outbox = map[string]json.RawMessage{}
+					credhubServer.RouteToHandler("PUT", "/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+						defer GinkgoRecover()
+						var credential struct {
+							Name  string          `json:"name"`
+							Value json.RawMessage `json:"value"`
+						}
+						Expect(json.NewDecoder(r.Body).Decode(&credential)).To(Succeed())
+						if strings.HasPrefix(credential.Name, "/nfsbroker/outbox/") {
+							lock.Lock()
+							outbox[credential.Name] = credential.Value
+							lock.Unlock()
+						}
+						w.WriteHeader(http.StatusCreated)
+						_, _ = w.Write([]byte(`{ "type" : "json", "version_created_at" : "", "id" : "", "name" : "", "value" : { } }`))
+					})
+					credhubServer.RouteToHandler("DELETE", "/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+						lock.Lock()
+						delete(outbox, r.URL.Query().Get("name"))
+						lock.Unlock()
+						w.WriteHeader(http.StatusNoContent)
+					})
+					credhubServer.RouteToHandler("GET", "/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+						lock.Lock()
+						defer lock.Unlock()
+						name := r.URL.Query().Get("name")
+						switch {
+						case r.URL.Query().Get("path") != "":
+							var names []string
+							for name := range outbox {
+								if strings.HasPrefix(name, r.URL.Query().Get("path")+"/") {
+									names = append(names, fmt.Sprintf(`{"name": %q, "version_created_at": "2026"}`, name))
+								}
+							}
+							_, _ = w.Write([]byte(`{ "credentials" : [` + strings.Join(names, ",") + `] }`))
+						case strings.HasPrefix(name, "/nfsbroker/outbox/"):
+							value, ok := outbox[name]
+							if !ok {
+								w.WriteHeader(http.StatusNotFound)
+								return
+							}
+							_, _ = w.Write([]byte(fmt.Sprintf(`{ "data" : [ { "type": "json", "version_created_at": "2026", "id": "1", "name": %q, "value": %s } ] }`, name, value)))
+						case name == "/nfsbroker/"+serviceInstanceID:
+							_, _ = w.Write([]byte(`{ "data" : [ { "type": "json", "version_created_at": "2019", "id": "1", "name": "/some-name", "value": { "organization_guid": "org-guid", "ServiceFingerPrint": "foobar" } } ] }`))
+						default:
+							w.WriteHeader(http.StatusNotFound)
+						}
+					})
+				})
+
+				AfterEach(func() {
+					receiver.Close()
+				})
+
+				It("sends a signed event for the bind, and clears it from the outbox", func() {
+					bindDetails, err := json.Marshal(domain.BindDetails{ServiceID: serviceOfferingID, PlanID: planID, AppGUID: "222"})
+					Expect(err).NotTo(HaveOccurred())
+					resp, err := httpDoWithAuth("PUT", fmt.Sprintf("/v2/service_instances/%s/service_bindings/%s", serviceInstanceID, bindingID), strings.NewReader(string(bindDetails)))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+					var req *http.Request
+					Eventually(received, 5*time.Second).Should(Receive(&req))
+					body := <-bodies
+
+					var event map[string]interface{}
+					Expect(json.Unmarshal(body, &event)).To(Succeed())
+					Expect(event).To(HaveKeyWithValue("type", "bind"))
+					Expect(event).To(HaveKeyWithValue("instance_id", serviceInstanceID))
+					Expect(event).To(HaveKeyWithValue("binding_id", bindingID))
+					Expect(event).To(HaveKeyWithValue("app_guid", "222"))
+					Expect(event).To(HaveKeyWithValue("organization_guid", "org-guid"))
+					Expect(event).To(HaveKeyWithValue("share", "foobar"))
+
+					timestamp, err := strconv.ParseInt(req.Header.Get("X-Nfsbroker-Timestamp"), 10, 64)
+					Expect(err).NotTo(HaveOccurred())
+					mac := hmac.New(sha256.New, []byte("cmdb-secret"))
+					mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+					mac.Write(body)
+					Expect(req.Header.Get("X-Nfsbroker-Signature")).To(Equal("sha256=" + hex.EncodeToString(mac.Sum(nil))))
+
+					Eventually(func() int {
+						lock.Lock()
+						defer lock.Unlock()
+						return len(outbox)
+					}).Should(BeZero())
+				})
+
+				It("lists dead letters to authenticated clients", func() {
+					resp, err := http.Get("http://" + listenAddr + "/webhooks/dead-letters")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+					resp, err = httpDoWithAuth("GET", "/webhooks/dead-letters", nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					body, err := io.ReadAll(resp.Body)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(body)).To(MatchJSON("[]"))
+				})
+			})
+
+			Context("with a tracing endpoint", func() {
+				var (
+					collector *ghttp.Server
+					spans     chan map[string]interface{}
+				)
+
+				BeforeEach(func() {
+					spans = make(chan map[string]interface{}, 100)
+					collector = ghttp.NewServer()
+					collector.RouteToHandler("POST", "/api/v2/spans", func(w http.ResponseWriter, r *http.Request) {
+						var batch []map[string]interface{}
+						if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+							w.WriteHeader(http.StatusBadRequest)
+							return
+						}
+						for _, span := range batch {
+							spans <- span
+						}
+						w.WriteHeader(http.StatusAccepted)
+					})
+					args = append(args, "-tracingEndpoint", collector.URL()+"/api/v2/spans")
+				})
+
+				AfterEach(func() {
+					collector.Close()
+				})
+
+				It("traces the bind through the store to CredHub and UAA, continuing the caller's trace", func() {
+					bindDetails, err := json.Marshal(domain.BindDetails{ServiceID: serviceOfferingID, PlanID: planID, AppGUID: "222"})
+					Expect(err).NotTo(HaveOccurred())
+					req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s/v2/service_instances/%s/service_bindings/%s", listenAddr, serviceInstanceID, bindingID), strings.NewReader(string(bindDetails)))
+					Expect(err).NotTo(HaveOccurred())
+					req.Header.Add("X-Broker-Api-Version", "2.14")
+					req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+					req.SetBasicAuth(username, password)
+
+					resp, err := http.DefaultClient.Do(req)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+					byName := map[string]map[string]interface{}{}
+					Eventually(func() map[string]map[string]interface{} {
+						for {
+							select {
+							case span := <-spans:
+								if _, ok := byName[span["name"].(string)]; !ok {
+									byName[span["name"].(string)] = span
+								}
+							default:
+								return byName
+							}
+						}
+					}, 5*time.Second).Should(And(
+						HaveKey("put /v2/service_instances/:instance_id/service_bindings/:binding_id"),
+						HaveKey("store retrieveinstancedetails"),
+						HaveKey("get /api/v1/data"),
+						HaveKey("post /oauth/token"),
+					))
+
+					server := byName["put /v2/service_instances/:instance_id/service_bindings/:binding_id"]
+					Expect(server["traceId"]).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+					Expect(server["parentId"]).To(Equal("b7ad6b7169203331"))
+					Expect(server["kind"]).To(Equal("SERVER"))
+
+					store := byName["store retrieveinstancedetails"]
+					Expect(store["traceId"]).To(Equal(server["traceId"]))
+					Expect(store["parentId"]).To(Equal(server["id"]))
+
+					var storeSpanIDs []interface{}
+					for name, span := range byName {
+						if strings.HasPrefix(name, "store ") {
+							storeSpanIDs = append(storeSpanIDs, span["id"])
+						}
+					}
+					for _, name := range []string{"get /api/v1/data", "post /oauth/token"} {
+						Expect(byName[name]["kind"]).To(Equal("CLIENT"))
+						Expect(byName[name]["traceId"]).To(Equal(server["traceId"]))
+						Expect(storeSpanIDs).To(ContainElement(byName[name]["parentId"]))
+					}
+
+					var propagated []string
+					for _, r := range credhubServer.ReceivedRequests() {
+						propagated = append(propagated, r.Header.Get("X-B3-TraceId"))
+					}
+					Expect(propagated).To(ContainElement("0af7651916cd43dd8448eb211c80319c"))
+				})
+			})
+		})
+	})
+
+	Context("with CredHub mutual TLS", func() {
+		var (
+			credhubServer *ghttp.Server
+			listenAddr    string
+			process       ifrit.Process
+		)
+
+		BeforeEach(func() {
+			listenAddr = "127.0.0.1:" + strconv.Itoa(7899+GinkgoParallelProcess())
+			os.Setenv("USERNAME", "admin")
+			os.Setenv("PASSWORD", "password")
+
+			ca, err := tlsconfigtest.NewCA("credhub-ca")
+			Expect(err).NotTo(HaveOccurred())
+			serverCert, serverKey, err := ca.Issue("credhub", "127.0.0.1")
+			Expect(err).NotTo(HaveOccurred())
+			serverCertificate, err := tls.X509KeyPair(serverCert, serverKey)
+			Expect(err).NotTo(HaveOccurred())
+			clientCert, clientKey, err := ca.Issue("nfsbroker")
+			Expect(err).NotTo(HaveOccurred())
+
+			dir := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(dir, "ca.pem"), ca.CertPEM, 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "client.pem"), clientCert, 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "client.key"), clientKey, 0600)).To(Succeed())
+
+			clientCAs := x509.NewCertPool()
+			clientCAs.AppendCertsFromPEM(ca.CertPEM)
+
+			credhubServer = ghttp.NewUnstartedServer()
+			credhubServer.HTTPTestServer.TLS = &tls.Config{
+				Certificates: []tls.Certificate{serverCertificate},
+				ClientCAs:    clientCAs,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}
+			credhubServer.HTTPTestServer.StartTLS()
+
+			verifyClientCertificate := func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.TLS.PeerCertificates[0].Subject.CommonName).To(Equal("nfsbroker"))
+			}
+			credhubServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/info"),
+					verifyClientCertificate,
+					ghttp.RespondWithJSONEncoded(http.StatusOK, credhubInfoResponse{}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/data", "current=true&name=%2Fnfsbroker%2Fsome-instance-id"),
+					verifyClientCertificate,
+					ghttp.RespondWith(http.StatusNotFound, `{"error": "The request could not be completed because the credential does not exist or you do not have sufficient authorization."}`),
+				),
+			)
+
+			runner := ginkgomon.New(ginkgomon.Config{
+				Name: "nfsbroker",
+				Command: exec.Command(binaryPath,
+					"-credhubURL", credhubServer.URL(),
+					"-credhubCACertPath", filepath.Join(dir, "ca.pem"),
+					"-credhubClientCertPath", filepath.Join(dir, "client.pem"),
+					"-credhubClientKeyPath", filepath.Join(dir, "client.key"),
+					"-listenAddr", listenAddr,
+					"-servicesConfig", "./test_default_services.json",
+				),
+				StartCheck:        "started",
+				StartCheckTimeout: 20 * time.Second,
+			})
+			process = ginkgomon.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+			credhubServer.Close()
+		})
+
+		It("should authenticate to CredHub with the client certificate instead of UAA", func() {
+			req, err := http.NewRequest("DELETE", "http://"+listenAddr+"/v2/service_instances/some-instance-id?service_id=nfsbroker&plan_id=Existing", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Add("X-Broker-Api-Version", "2.14")
+			req.SetBasicAuth("admin", "password")
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusGone))
+			Expect(credhubServer.ReceivedRequests()).To(HaveLen(2))
 		})
 	})
 
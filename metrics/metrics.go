@@ -0,0 +1,244 @@
+// Package metrics keeps counters, histograms and gauges and serves them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit latencies from a few milliseconds to tens of seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds every metric that is served.
+type Registry struct {
+	lock    sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes every metric in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.lock.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+	_ = buffered.Flush()
+}
+
+// vec holds one series per combination of label values.
+type vec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	lock   sync.Mutex
+	series map[string]*series[T]
+}
+
+type series[T any] struct {
+	labelValues []string
+	value       T
+}
+
+func newVec[T any](name, help, kind string, labels []string) *vec[T] {
+	return &vec[T]{name: name, help: help, kind: kind, labels: labels, series: map[string]*series[T]{}}
+}
+
+// with calls update on the series for labelValues while holding the lock.
+func (v *vec[T]) with(labelValues []string, update func(*T)) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", v.name, v.labels, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	update(&s.value)
+}
+
+// each calls write for every series in a stable order.
+func (v *vec[T]) each(w io.Writer, write func(labels string, value T)) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		write(formatLabels(v.labels, s.labelValues), s.value)
+	}
+}
+
+// CounterVec counts events, partitioned by labels.
+type CounterVec struct {
+	*vec[float64]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec[float64](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.with(labelValues, func(value *float64) { *value += delta })
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.each(w, func(labels string, value float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, braces(labels), formatFloat(value))
+	})
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec observes distributions, partitioned by labels.
+type HistogramVec struct {
+	*vec[histogram]
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds, which must
+// be in increasing order.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec[histogram](name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.with(labelValues, func(hist *histogram) {
+		if hist.counts == nil {
+			hist.counts = make([]uint64, len(h.buckets))
+		}
+		for i, bound := range h.buckets {
+			if value <= bound {
+				hist.counts[i]++
+			}
+		}
+		hist.count++
+		hist.sum += value
+	})
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.each(w, func(labels string, hist histogram) {
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, join(labels, `le="`+formatFloat(bound)+`"`), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, join(labels, `le="+Inf"`), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(labels), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(labels), hist.count)
+	})
+}
+
+// GaugeFunc reports the value returned by a function at the time of each
+// scrape.
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, value: value}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeHelp(g.help), g.name, g.name, formatFloat(g.value()))
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func join(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/nfsbroker/metrics"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *metrics.Registry
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+	})
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4; charset=utf-8"))
+		return recorder.Body.String()
+	}
+
+	It("serves counters by label", func() {
+		counter := registry.NewCounterVec("requests_total", "Requests served.", "operation", "result")
+		counter.Inc("bind", "success")
+		counter.Inc("bind", "success")
+		counter.Inc("bind", "failure")
+
+		Expect(scrape()).To(Equal(`# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{operation="bind",result="failure"} 1
+requests_total{operation="bind",result="success"} 2
+`))
+	})
+
+	It("serves cumulative histogram buckets", func() {
+		histogram := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+		histogram.Observe(0.05, "get")
+		histogram.Observe(0.5, "get")
+		histogram.Observe(2, "get")
+
+		Expect(scrape()).To(Equal(`# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="get",le="0.1"} 1
+latency_seconds_bucket{method="get",le="1"} 2
+latency_seconds_bucket{method="get",le="+Inf"} 3
+latency_seconds_sum{method="get"} 2.55
+latency_seconds_count{method="get"} 3
+`))
+	})
+
+	It("serves gauges from their current value", func() {
+		value := 3.0
+		registry.NewGaugeFunc("instances", "Instances.", func() float64 { return value })
+		value = 4
+
+		Expect(scrape()).To(Equal("# HELP instances Instances.\n# TYPE instances gauge\ninstances 4\n"))
+	})
+
+	It("escapes label values", func() {
+		counter := registry.NewCounterVec("events_total", "Events.", "name")
+		counter.Inc("a \"quoted\"\nname\\")
+
+		Expect(scrape()).To(ContainSubstring(`events_total{name="a \"quoted\"\nname\\"} 1`))
+	})
+
+	It("panics when the label values do not match the labels", func() {
+		counter := registry.NewCounterVec("events_total", "Events.", "name")
+		Expect(func() { counter.Inc() }).To(Panic())
+	})
+})
@@ -0,0 +1,243 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// perPage is the page size asked of Cloud Controller.
+const perPage = 500
+
+// guidsPerRequest is how many GUIDs a filter is given at a time, to keep
+// URLs to a length Cloud Controller and the routers in front of it accept.
+const guidsPerRequest = 50
+
+// CloudController lists a broker's service instances and bindings with the
+// Cloud Controller v3 API. It authenticates as a UAA client, which needs the
+// cloud_controller.admin_read_only or cloud_controller.global_auditor
+// authority to see every org and space.
+type CloudController struct {
+	apiURL       string
+	clientID     string
+	clientSecret string
+	brokerName   string
+	client       *http.Client
+}
+
+// CloudControllerConfig names the broker as it is registered with Cloud
+// Controller, which need not be the storeID.
+type CloudControllerConfig struct {
+	APIURL       string
+	ClientID     string
+	ClientSecret string
+	BrokerName   string
+}
+
+func NewCloudController(config CloudControllerConfig, client *http.Client) *CloudController {
+	return &CloudController{
+		apiURL:       strings.TrimSuffix(config.APIURL, "/"),
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		brokerName:   config.BrokerName,
+		client:       client,
+	}
+}
+
+// State is what Cloud Controller knows of the broker: the GUIDs of its
+// service instances and bindings, which are the IDs the broker is given.
+type State struct {
+	Instances map[string]bool
+	Bindings  map[string]bool
+}
+
+// State lists every instance and binding of the broker's plans. It fails
+// rather than return a partial list, and when the broker has no plans, which
+// usually means it is registered under another name.
+func (c *CloudController) State(ctx context.Context) (State, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return State{}, fmt.Errorf("authenticating to cloud controller: %w", err)
+	}
+
+	plans, err := c.list(ctx, token, "/v3/service_plans", url.Values{"service_broker_names": {c.brokerName}})
+	if err != nil {
+		return State{}, err
+	}
+	if len(plans) == 0 {
+		return State{}, fmt.Errorf("cloud controller has no service plans for a broker named %q", c.brokerName)
+	}
+	planGUIDs := url.Values{"service_plan_guids": {strings.Join(keys(guidsOf(plans)), ",")}}
+
+	instances, err := c.list(ctx, token, "/v3/service_instances", planGUIDs)
+	if err != nil {
+		return State{}, err
+	}
+	bindings, err := c.list(ctx, token, "/v3/service_credential_bindings", planGUIDs)
+	if err != nil {
+		return State{}, err
+	}
+	return State{Instances: guidsOf(instances), Bindings: guidsOf(bindings)}, nil
+}
+
+// InstanceBindings lists the GUIDs of the bindings of each of the given
+// instances. Instances without bindings are left out.
+func (c *CloudController) InstanceBindings(ctx context.Context, instanceGUIDs []string) (map[string][]string, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authenticating to cloud controller: %w", err)
+	}
+
+	bindings := map[string][]string{}
+	for start := 0; start < len(instanceGUIDs); start += guidsPerRequest {
+		end := start + guidsPerRequest
+		if end > len(instanceGUIDs) {
+			end = len(instanceGUIDs)
+		}
+		query := url.Values{"service_instance_guids": {strings.Join(instanceGUIDs[start:end], ",")}}
+
+		resources, err := c.list(ctx, token, "/v3/service_credential_bindings", query)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			instance := resource.Relationships.ServiceInstance.Data.GUID
+			bindings[instance] = append(bindings[instance], resource.GUID)
+		}
+	}
+	for _, guids := range bindings {
+		sort.Strings(guids)
+	}
+	return bindings, nil
+}
+
+// token gets a token with the client credentials grant from the UAA that
+// Cloud Controller names in its root links.
+func (c *CloudController) token(ctx context.Context) (string, error) {
+	var root struct {
+		Links struct {
+			UAA   *struct{ Href string } `json:"uaa"`
+			Login *struct{ Href string } `json:"login"`
+		} `json:"links"`
+	}
+	if err := c.get(ctx, "", c.apiURL+"/", &root); err != nil {
+		return "", err
+	}
+	var uaaURL string
+	switch {
+	case root.Links.UAA != nil && root.Links.UAA.Href != "":
+		uaaURL = root.Links.UAA.Href
+	case root.Links.Login != nil && root.Links.Login.Href != "":
+		uaaURL = root.Links.Login.Href
+	default:
+		return "", errors.New("cloud controller does not link to a UAA")
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}, "response_type": {"token"}}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(uaaURL, "/")+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := c.do(req, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("UAA returned no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// resource is the part of a v3 resource the broker reads. Only bindings
+// have a service instance relationship.
+type resource struct {
+	GUID          string `json:"guid"`
+	Relationships struct {
+		ServiceInstance struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"service_instance"`
+	} `json:"relationships"`
+}
+
+// list follows every page of a v3 list and returns the resources.
+func (c *CloudController) list(ctx context.Context, token, path string, query url.Values) ([]resource, error) {
+	query.Set("per_page", fmt.Sprint(perPage))
+	next := c.apiURL + path + "?" + query.Encode()
+
+	var resources []resource
+	for next != "" {
+		var page struct {
+			Pagination struct {
+				Next *struct{ Href string } `json:"next"`
+			} `json:"pagination"`
+			Resources []resource `json:"resources"`
+		}
+		if err := c.get(ctx, token, next, &page); err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, page.Resources...)
+		next = ""
+		if page.Pagination.Next != nil {
+			next = page.Pagination.Next.Href
+		}
+	}
+	return resources, nil
+}
+
+func guidsOf(resources []resource) map[string]bool {
+	guids := map[string]bool{}
+	for _, resource := range resources {
+		guids[resource.GUID] = true
+	}
+	return guids
+}
+
+func (c *CloudController) get(ctx context.Context, token, target string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "bearer "+token)
+	}
+	return c.do(req, into)
+}
+
+func (c *CloudController) do(req *http.Request, into interface{}) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s %s responded with %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(into)
+}
+
+func keys(set map[string]bool) []string {
+	var out []string
+	for key := range set {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
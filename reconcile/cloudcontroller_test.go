@@ -0,0 +1,176 @@
+package reconcile_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/nfsbroker/reconcile"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("CloudController", func() {
+	var (
+		cc  *ghttp.Server
+		uaa *ghttp.Server
+
+		cloudController *reconcile.CloudController
+		state           reconcile.State
+		err             error
+	)
+
+	BeforeEach(func() {
+		cc = ghttp.NewServer()
+		uaa = ghttp.NewServer()
+		cloudController = reconcile.NewCloudController(reconcile.CloudControllerConfig{
+			APIURL:       cc.URL(),
+			ClientID:     "reconciler",
+			ClientSecret: "secret",
+			BrokerName:   "nfsbroker",
+		}, http.DefaultClient)
+
+		cc.RouteToHandler("GET", "/", ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+			"links": map[string]interface{}{"uaa": map[string]string{"href": uaa.URL()}},
+		}))
+		uaa.RouteToHandler("POST", "/oauth/token", ghttp.CombineHandlers(
+			ghttp.VerifyBasicAuth("reconciler", "secret"),
+			ghttp.VerifyFormKV("grant_type", "client_credentials"),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{"access_token": "some-token"}),
+		))
+	})
+
+	AfterEach(func() {
+		cc.Close()
+		uaa.Close()
+	})
+
+	page := func(next string, guids ...string) map[string]interface{} {
+		var resources []map[string]string
+		for _, guid := range guids {
+			resources = append(resources, map[string]string{"guid": guid})
+		}
+		pagination := map[string]interface{}{"next": nil}
+		if next != "" {
+			pagination["next"] = map[string]string{"href": next}
+		}
+		return map[string]interface{}{"pagination": pagination, "resources": resources}
+	}
+
+	Describe("State", func() {
+		JustBeforeEach(func() {
+			state, err = cloudController.State(context.Background())
+		})
+
+		Context("when the broker has plans", func() {
+			BeforeEach(func() {
+				cc.RouteToHandler("GET", "/v3/service_plans", ghttp.CombineHandlers(
+					ghttp.VerifyHeaderKV("Authorization", "bearer some-token"),
+					ghttp.VerifyFormKV("service_broker_names", "nfsbroker"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, page("", "plan-2", "plan-1")),
+				))
+				cc.RouteToHandler("GET", "/v3/service_instances", func(w http.ResponseWriter, req *http.Request) {
+					defer GinkgoRecover()
+					Expect(req.URL.Query().Get("service_plan_guids")).To(Equal("plan-1,plan-2"))
+					if req.URL.Query().Get("page") == "2" {
+						ghttp.RespondWithJSONEncoded(http.StatusOK, page("", "instance-3"))(w, req)
+						return
+					}
+					ghttp.RespondWithJSONEncoded(http.StatusOK, page(cc.URL()+"/v3/service_instances?page=2&service_plan_guids=plan-1,plan-2", "instance-1", "instance-2"))(w, req)
+				})
+				cc.RouteToHandler("GET", "/v3/service_credential_bindings", ghttp.CombineHandlers(
+					ghttp.VerifyFormKV("service_plan_guids", "plan-1,plan-2"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, page("", "binding-1")),
+				))
+			})
+
+			It("lists every instance and binding, following pages", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(state.Instances).To(Equal(map[string]bool{"instance-1": true, "instance-2": true, "instance-3": true}))
+				Expect(state.Bindings).To(Equal(map[string]bool{"binding-1": true}))
+			})
+
+			Context("when a list fails", func() {
+				BeforeEach(func() {
+					cc.RouteToHandler("GET", "/v3/service_credential_bindings", ghttp.RespondWith(http.StatusInternalServerError, ""))
+				})
+
+				It("fails rather than return a partial state", func() {
+					Expect(err).To(MatchError("GET /v3/service_credential_bindings responded with 500"))
+					Expect(state.Instances).To(BeNil())
+				})
+			})
+		})
+
+		Context("when the broker has no plans", func() {
+			BeforeEach(func() {
+				cc.RouteToHandler("GET", "/v3/service_plans", ghttp.RespondWithJSONEncoded(http.StatusOK, page("")))
+			})
+
+			It("fails", func() {
+				Expect(err).To(MatchError(`cloud controller has no service plans for a broker named "nfsbroker"`))
+			})
+		})
+
+		Context("when UAA rejects the client", func() {
+			BeforeEach(func() {
+				uaa.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusUnauthorized, ""))
+			})
+
+			It("fails", func() {
+				Expect(err).To(MatchError("authenticating to cloud controller: POST /oauth/token responded with 401"))
+				Expect(cc.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("InstanceBindings", func() {
+		binding := func(guid, instance string) map[string]interface{} {
+			return map[string]interface{}{
+				"guid": guid,
+				"relationships": map[string]interface{}{
+					"service_instance": map[string]interface{}{"data": map[string]string{"guid": instance}},
+				},
+			}
+		}
+
+		It("lists the bindings of each instance, a batch of instances at a time", func() {
+			var instances []string
+			for i := 0; i < 60; i++ {
+				instances = append(instances, fmt.Sprintf("instance-%02d", i))
+			}
+			var batches []string
+			cc.RouteToHandler("GET", "/v3/service_credential_bindings", func(w http.ResponseWriter, req *http.Request) {
+				defer GinkgoRecover()
+				Expect(req.Header.Get("Authorization")).To(Equal("bearer some-token"))
+				batches = append(batches, req.URL.Query().Get("service_instance_guids"))
+				resources := []map[string]interface{}{}
+				if len(batches) == 1 {
+					resources = append(resources, binding("binding-2", "instance-00"), binding("binding-1", "instance-00"))
+				} else {
+					resources = append(resources, binding("binding-3", "instance-55"))
+				}
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"pagination": map[string]interface{}{"next": nil}, "resources": resources})(w, req)
+			})
+
+			bindings, err := cloudController.InstanceBindings(context.Background(), instances)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bindings).To(Equal(map[string][]string{
+				"instance-00": {"binding-1", "binding-2"},
+				"instance-55": {"binding-3"},
+			}))
+			Expect(batches).To(HaveLen(2))
+			Expect(strings.Split(batches[0], ",")).To(Equal(instances[:50]))
+			Expect(strings.Split(batches[1], ",")).To(Equal(instances[50:]))
+		})
+
+		It("fails when a list fails", func() {
+			cc.RouteToHandler("GET", "/v3/service_credential_bindings", ghttp.RespondWith(http.StatusForbidden, ""))
+
+			_, err := cloudController.InstanceBindings(context.Background(), []string{"instance-1"})
+			Expect(err).To(MatchError("GET /v3/service_credential_bindings responded with 403"))
+		})
+	})
+})
@@ -0,0 +1,199 @@
+// Package reconcile compares the broker store with Cloud Controller, to find
+// records that one has and the other does not: instances purged from Cloud
+// Controller without a deprovision, and deprovisions that failed halfway.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	"github.com/tedsuo/ifrit"
+)
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
+//counterfeiter:generate -o ../fakes/fake_platform.go . Platform
+
+// Platform is the source of truth the store is compared with.
+type Platform interface {
+	State(ctx context.Context) (State, error)
+}
+
+// Report lists the IDs found in only one of the store and Cloud Controller,
+// and the store-only records that were deleted.
+type Report struct {
+	Time time.Time `json:"time"`
+
+	StoreOnlyInstances    []string `json:"store_only_instances"`
+	StoreOnlyBindings     []string `json:"store_only_bindings"`
+	PlatformOnlyInstances []string `json:"platform_only_instances"`
+	PlatformOnlyBindings  []string `json:"platform_only_bindings"`
+
+	DeletedInstances []string `json:"deleted_instances,omitempty"`
+	DeletedBindings  []string `json:"deleted_bindings,omitempty"`
+	// DeleteErrors are keyed by ID.
+	DeleteErrors map[string]string `json:"delete_errors,omitempty"`
+}
+
+type Reconciler struct {
+	logger   lager.Logger
+	store    brokerstore.Store
+	platform Platform
+	clock    clock.Clock
+}
+
+func NewReconciler(logger lager.Logger, store brokerstore.Store, platform Platform, clock clock.Clock) *Reconciler {
+	return &Reconciler{logger: logger.Session("reconcile"), store: store, platform: platform, clock: clock}
+}
+
+// Reconcile compares the store with Cloud Controller and, if collect is set,
+// deletes the records only the store has.
+//
+// The store is read before Cloud Controller. Cloud Controller records an
+// instance or binding before asking the broker to create it, and forgets it
+// only after the broker has deleted it, so a record created or deleted
+// while Reconcile runs is never mistaken for one Cloud Controller has lost.
+func (r *Reconciler) Reconcile(ctx context.Context, collect bool) (Report, error) {
+	logger := r.logger.Session("run", lager.Data{"collect": collect})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := Report{Time: r.clock.Now().UTC()}
+
+	storeInstances, err := r.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return report, fmt.Errorf("reading instances from the store: %w", err)
+	}
+	storeBindings, err := r.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return report, fmt.Errorf("reading bindings from the store: %w", err)
+	}
+
+	state, err := r.platform.State(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for id := range storeInstances {
+		if !state.Instances[id] {
+			report.StoreOnlyInstances = append(report.StoreOnlyInstances, id)
+		}
+	}
+	for id := range storeBindings {
+		if !state.Bindings[id] {
+			report.StoreOnlyBindings = append(report.StoreOnlyBindings, id)
+		}
+	}
+	for id := range state.Instances {
+		if _, ok := storeInstances[id]; !ok {
+			report.PlatformOnlyInstances = append(report.PlatformOnlyInstances, id)
+		}
+	}
+	for id := range state.Bindings {
+		if _, ok := storeBindings[id]; !ok {
+			report.PlatformOnlyBindings = append(report.PlatformOnlyBindings, id)
+		}
+	}
+	report.sort()
+
+	if collect {
+		r.collect(logger, &report)
+	}
+
+	logger.Info("reconciled", lager.Data{
+		"store_only_instances":    report.StoreOnlyInstances,
+		"store_only_bindings":     report.StoreOnlyBindings,
+		"platform_only_instances": report.PlatformOnlyInstances,
+		"platform_only_bindings":  report.PlatformOnlyBindings,
+		"deleted_instances":       report.DeletedInstances,
+		"deleted_bindings":        report.DeletedBindings,
+	})
+	return report, nil
+}
+
+// collect deletes bindings before instances, so that an instance is never
+// left with bindings it could be rebound through.
+func (r *Reconciler) collect(logger lager.Logger, report *Report) {
+	for _, id := range report.StoreOnlyBindings {
+		if err := r.store.DeleteBindingDetails(id); err != nil {
+			report.deleteFailed(logger, id, err)
+			continue
+		}
+		report.DeletedBindings = append(report.DeletedBindings, id)
+	}
+	for _, id := range report.StoreOnlyInstances {
+		if err := r.store.DeleteInstanceDetails(id); err != nil {
+			report.deleteFailed(logger, id, err)
+			continue
+		}
+		report.DeletedInstances = append(report.DeletedInstances, id)
+	}
+
+	if err := r.store.Save(logger); err != nil {
+		logger.Error("save-failed", err)
+	}
+}
+
+func (r *Report) sort() {
+	for _, ids := range [][]string{r.StoreOnlyInstances, r.StoreOnlyBindings, r.PlatformOnlyInstances, r.PlatformOnlyBindings} {
+		sort.Strings(ids)
+	}
+}
+
+func (r *Report) deleteFailed(logger lager.Logger, id string, err error) {
+	logger.Error("delete-failed", err, lager.Data{"id": id})
+	if r.DeleteErrors == nil {
+		r.DeleteErrors = map[string]string{}
+	}
+	r.DeleteErrors[id] = err.Error()
+}
+
+// Scheduler reconciles every interval, for as long as it runs. Each report is
+// logged; a run that fails is logged and retried at the next interval.
+type Scheduler struct {
+	reconciler *Reconciler
+	clock      clock.Clock
+	interval   time.Duration
+	collect    bool
+}
+
+var _ ifrit.Runner = &Scheduler{}
+
+func NewScheduler(reconciler *Reconciler, clock clock.Clock, interval time.Duration, collect bool) *Scheduler {
+	return &Scheduler{reconciler: reconciler, clock: clock, interval: interval, collect: collect}
+}
+
+func (s *Scheduler) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	close(ready)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				if _, err := s.reconciler.Reconcile(ctx, s.collect); err != nil && ctx.Err() == nil {
+					s.reconciler.logger.Error("reconcile-failed", err)
+				}
+			}
+		}
+	}()
+
+	<-signals
+	cancel()
+	<-done
+	return nil
+}
@@ -0,0 +1,169 @@
+package reconcile_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/reconcile"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("Reconciler", func() {
+	var (
+		logs       *gbytes.Buffer
+		store      *fakes.FakeStore
+		platform   *fakes.FakePlatform
+		reconciler *reconcile.Reconciler
+
+		collect bool
+		report  reconcile.Report
+		err     error
+	)
+
+	BeforeEach(func() {
+		logs = gbytes.NewBuffer()
+		logger := lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+
+		store = &fakes.FakeStore{}
+		store.RetrieveAllInstanceDetailsReturns(map[string]brokerstore.ServiceInstance{
+			"instance-1":    {PlanID: "existing"},
+			"instance-gone": {PlanID: "existing"},
+		}, nil)
+		store.RetrieveAllBindingDetailsReturns(map[string]domain.BindDetails{
+			"binding-1":    {PlanID: "existing"},
+			"binding-gone": {PlanID: "existing"},
+		}, nil)
+
+		platform = &fakes.FakePlatform{}
+		platform.StateReturns(reconcile.State{
+			Instances: map[string]bool{"instance-1": true, "instance-new": true},
+			Bindings:  map[string]bool{"binding-1": true},
+		}, nil)
+
+		reconciler = reconcile.NewReconciler(logger, store, platform, clock.NewClock())
+		collect = false
+	})
+
+	JustBeforeEach(func() {
+		report, err = reconciler.Reconcile(context.Background(), collect)
+	})
+
+	It("reports the records only one side has", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.StoreOnlyInstances).To(Equal([]string{"instance-gone"}))
+		Expect(report.StoreOnlyBindings).To(Equal([]string{"binding-gone"}))
+		Expect(report.PlatformOnlyInstances).To(Equal([]string{"instance-new"}))
+		Expect(report.PlatformOnlyBindings).To(BeEmpty())
+		Expect(logs).To(gbytes.Say("reconciled"))
+	})
+
+	Context("when the store is read", func() {
+		var platformReadFirst bool
+
+		BeforeEach(func() {
+			platformReadFirst = false
+			store.RetrieveAllBindingDetailsStub = func() (map[string]domain.BindDetails, error) {
+				platformReadFirst = platform.StateCallCount() > 0
+				return nil, nil
+			}
+		})
+
+		It("is read before the platform", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platformReadFirst).To(BeFalse())
+			Expect(platform.StateCallCount()).To(Equal(1))
+		})
+	})
+
+	It("changes nothing", func() {
+		Expect(store.DeleteInstanceDetailsCallCount()).To(Equal(0))
+		Expect(store.DeleteBindingDetailsCallCount()).To(Equal(0))
+		Expect(store.SaveCallCount()).To(Equal(0))
+	})
+
+	Context("when the platform cannot be read", func() {
+		BeforeEach(func() {
+			collect = true
+			platform.StateReturns(reconcile.State{}, errors.New("cc is down"))
+		})
+
+		It("fails without deleting anything", func() {
+			Expect(err).To(MatchError("cc is down"))
+			Expect(store.DeleteInstanceDetailsCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the store cannot be read", func() {
+		BeforeEach(func() {
+			store.RetrieveAllInstanceDetailsReturns(nil, errors.New("credhub is down"))
+		})
+
+		It("fails without asking the platform", func() {
+			Expect(err).To(MatchError("reading instances from the store: credhub is down"))
+			Expect(platform.StateCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when collecting", func() {
+		BeforeEach(func() {
+			collect = true
+		})
+
+		It("deletes the store-only records, bindings first", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.DeleteBindingDetailsCallCount()).To(Equal(1))
+			Expect(store.DeleteBindingDetailsArgsForCall(0)).To(Equal("binding-gone"))
+			Expect(store.DeleteInstanceDetailsCallCount()).To(Equal(1))
+			Expect(store.DeleteInstanceDetailsArgsForCall(0)).To(Equal("instance-gone"))
+			Expect(store.SaveCallCount()).To(Equal(1))
+
+			Expect(report.DeletedBindings).To(Equal([]string{"binding-gone"}))
+			Expect(report.DeletedInstances).To(Equal([]string{"instance-gone"}))
+			Expect(report.DeleteErrors).To(BeEmpty())
+		})
+
+		Context("when a delete fails", func() {
+			BeforeEach(func() {
+				store.DeleteInstanceDetailsReturns(errors.New("permission denied"))
+			})
+
+			It("reports it and carries on", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.DeletedBindings).To(Equal([]string{"binding-gone"}))
+				Expect(report.DeletedInstances).To(BeEmpty())
+				Expect(report.DeleteErrors).To(Equal(map[string]string{"instance-gone": "permission denied"}))
+				Expect(logs).To(gbytes.Say("delete-failed"))
+			})
+		})
+	})
+
+	Describe("Scheduler", func() {
+		It("reconciles every interval until signalled", func() {
+			process := ifrit.Invoke(reconcile.NewScheduler(reconciler, clock.NewClock(), 10*time.Millisecond, false))
+			Eventually(platform.StateCallCount).Should(BeNumerically(">=", 3))
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+
+		It("logs failed runs and keeps going", func() {
+			platform.StateReturns(reconcile.State{}, errors.New("cc is down"))
+			process := ifrit.Invoke(reconcile.NewScheduler(reconciler, clock.NewClock(), 10*time.Millisecond, false))
+			defer process.Signal(os.Interrupt)
+
+			Eventually(logs).Should(gbytes.Say("reconcile-failed"))
+			Eventually(platform.StateCallCount).Should(BeNumerically(">=", 3))
+		})
+	})
+})
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-04/schema#"
+
+var (
+	booleanOption = map[string]interface{}{
+		"type": []string{"boolean", "string"},
+		"enum": []interface{}{true, false, "true", "false"},
+	}
+	idOption = map[string]interface{}{
+		"type":    []string{"integer", "string"},
+		"pattern": "^[0-9]+$",
+		"minimum": 0,
+	}
+)
+
+// optionDefinitions types the mount options nfsbroker knows about. Options
+// that are allowed but not listed here are accepted with any value.
+var optionDefinitions = map[string]map[string]interface{}{
+	"share": {
+		"type":        "string",
+		"description": "NFS export to mount, in the form server/path",
+	},
+	"uid":          withDescription(idOption, "User id that owns the files on the share"),
+	"gid":          withDescription(idOption, "Group id that owns the files on the share"),
+	"auto_cache":   withDescription(booleanOption, "Refresh the file attribute cache when files change on the server"),
+	"cache":        withDescription(booleanOption, "Enable the fuse file system cache"),
+	"readonly":     withDescription(booleanOption, "Mount the share read-only"),
+	"sloppy_mount": withDescription(booleanOption, "Ignore unknown mount options"),
+	"mount": {
+		"type":        "string",
+		"description": "Path in the application container to mount the share on",
+	},
+	"version": {
+		"type":        "string",
+		"description": "NFS protocol version",
+		"enum":        []string{"3", "4.0", "4.1", "4.2"},
+	},
+	"username": {
+		"type":        "string",
+		"description": "LDAP user whose uid and gid are used to access the share",
+	},
+	"password": {
+		"type":        "string",
+		"description": "Password for the LDAP user",
+	},
+}
+
+func withDescription(schema map[string]interface{}, description string) map[string]interface{} {
+	out := map[string]interface{}{"description": description}
+	for k, v := range schema {
+		out[k] = v
+	}
+	return out
+}
+
+// ParameterSchemas generates the OSBAPI parameter schemas for the effective
+// mount option policy. Defaults are advertised as schema defaults and options
+// with a fixed value are rejected, just as vmo.NewMountOpts would.
+func ParameterSchemas(mask vmo.MountOptsMask, disallowedBindOverrides []string) *domain.ServiceSchemas {
+	var fixed []string
+	for k := range mask.Defaults {
+		if !contains(mask.Allowed, k) {
+			fixed = append(fixed, k)
+		}
+	}
+	sort.Strings(fixed)
+
+	provisionKeys := userOptionKeys(mask)
+	provisionKeys = remove(provisionKeys, "source")
+
+	bindKeys := userOptionKeys(mask)
+	for _, k := range disallowedBindOverrides {
+		bindKeys = remove(bindKeys, k)
+	}
+
+	return &domain.ServiceSchemas{
+		Instance: domain.ServiceInstanceSchema{
+			Create: domain.Schema{Parameters: objectSchema(mask, provisionKeys, []string{"share"}, fixed)},
+			Update: domain.Schema{Parameters: emptyObjectSchema()},
+		},
+		Binding: domain.ServiceBindingSchema{
+			Create: domain.Schema{Parameters: objectSchema(mask, bindKeys, nil, fixed)},
+		},
+	}
+}
+
+// userOptionKeys lists the keys a user may pass: every allowed option plus
+// the aliases (such as share) that map onto an allowed option.
+func userOptionKeys(mask vmo.MountOptsMask) []string {
+	var keys []string
+	for _, k := range mask.Allowed {
+		if k != "" && !contains(mask.Ignored, k) {
+			keys = append(keys, k)
+		}
+	}
+	for alias, canonical := range mask.KeyPerms {
+		if contains(mask.Allowed, canonical) && !contains(keys, alias) {
+			keys = append(keys, alias)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func objectSchema(mask vmo.MountOptsMask, keys, required, fixed []string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, k := range keys {
+		property := map[string]interface{}{}
+		for pk, pv := range optionDefinitions[k] {
+			property[pk] = pv
+		}
+		canonical, ok := mask.KeyPerms[k]
+		if !ok {
+			canonical = k
+		}
+		if v, ok := mask.Defaults[canonical]; ok {
+			property["default"] = v
+		}
+		properties[k] = property
+	}
+
+	schema := map[string]interface{}{
+		"$schema":              jsonSchemaDraft,
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": mask.SloppyMount,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if len(fixed) > 0 {
+		var values []string
+		for _, k := range fixed {
+			values = append(values, fmt.Sprintf("%s=%v", k, mask.Defaults[k]))
+		}
+		schema["description"] = "The following options are fixed by the operator and cannot be set: " + strings.Join(values, ", ")
+	}
+	return schema
+}
+
+// emptyObjectSchema accepts no parameters at all.
+func emptyObjectSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"$schema":              jsonSchemaDraft,
+		"type":                 "object",
+		"properties":           map[string]interface{}{},
+		"additionalProperties": false,
+	}
+}
+
+func contains(list []string, key string) bool {
+	for _, k := range list {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, key string) []string {
+	var out []string
+	for _, k := range list {
+		if k != key {
+			out = append(out, k)
+		}
+	}
+	return out
+}
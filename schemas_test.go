@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	. "code.cloudfoundry.org/nfsbroker"
+	vmo "code.cloudfoundry.org/volume-mount-options"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+var _ = Describe("ParameterSchemas", func() {
+	var (
+		mask    vmo.MountOptsMask
+		schemas *domain.ServiceSchemas
+	)
+
+	BeforeEach(func() {
+		var err error
+		mask, err = vmo.NewMountOptsMask(
+			[]string{"source", "uid", "gid", "auto_cache", "custom"},
+			map[string]interface{}{"auto_cache": "true", "nfs_uid": "0"},
+			map[string]string{"share": "source"},
+			[]string{"nfsbroker_metadata"},
+			[]string{"source"},
+		)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		schemas = ParameterSchemas(mask, []string{"share", "source", "nfsbroker_metadata"})
+	})
+
+	Describe("provision", func() {
+		var parameters map[string]interface{}
+
+		JustBeforeEach(func() {
+			parameters = schemas.Instance.Create.Parameters
+		})
+
+		It("requires a share", func() {
+			Expect(parameters).To(HaveKeyWithValue("required", []string{"share"}))
+		})
+
+		It("lists the allowed options and rejects everything else", func() {
+			Expect(parameters["properties"]).To(HaveLen(5))
+			Expect(parameters["properties"]).To(HaveKey("share"))
+			Expect(parameters["properties"]).To(HaveKey("uid"))
+			Expect(parameters["properties"]).To(HaveKey("custom"))
+			Expect(parameters["properties"]).NotTo(HaveKey("source"))
+			Expect(parameters).To(HaveKeyWithValue("additionalProperties", false))
+		})
+
+		It("types the known options", func() {
+			uid := parameters["properties"].(map[string]interface{})["uid"]
+			Expect(uid).To(HaveKeyWithValue("pattern", "^[0-9]+$"))
+
+			custom := parameters["properties"].(map[string]interface{})["custom"]
+			Expect(custom).To(BeEmpty())
+		})
+
+		It("advertises the defaults", func() {
+			autoCache := parameters["properties"].(map[string]interface{})["auto_cache"]
+			Expect(autoCache).To(HaveKeyWithValue("default", "true"))
+		})
+
+		It("documents the fixed values", func() {
+			Expect(parameters["description"]).To(ContainSubstring("nfs_uid=0"))
+		})
+
+		Context("when sloppy_mount is enabled", func() {
+			BeforeEach(func() {
+				mask.SloppyMount = true
+			})
+
+			It("allows additional properties", func() {
+				Expect(parameters).To(HaveKeyWithValue("additionalProperties", true))
+			})
+		})
+	})
+
+	Describe("bind", func() {
+		It("does not allow the share to be overridden", func() {
+			parameters := schemas.Binding.Create.Parameters
+			Expect(parameters["properties"]).NotTo(HaveKey("share"))
+			Expect(parameters["properties"]).To(HaveKey("uid"))
+			Expect(parameters).NotTo(HaveKey("required"))
+		})
+	})
+
+	Describe("update", func() {
+		It("accepts no parameters", func() {
+			parameters := schemas.Instance.Update.Parameters
+			Expect(parameters["properties"]).To(BeEmpty())
+			Expect(parameters).To(HaveKeyWithValue("additionalProperties", false))
+		})
+	})
+})
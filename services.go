@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/pivotal-cf/brokerapi/v11/domain"
@@ -22,10 +22,9 @@ func NewServicesFromConfig(pathToServicesConfig string) (Services, error) {
 		return nil, err
 	}
 
-	var s []domain.Service
-	err = json.Unmarshal(contents, &s)
+	s, err := parseCatalog(contents)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid services config %s:\n%w", pathToServicesConfig, err)
 	}
 
 	return &services{s}, nil
@@ -34,3 +33,38 @@ func NewServicesFromConfig(pathToServicesConfig string) (Services, error) {
 func (s *services) List() []domain.Service {
 	return s.services
 }
+
+// PlanDefaults are applied to every plan that does not set the corresponding
+// field itself in the services config.
+type PlanDefaults struct {
+	Schemas         *domain.ServiceSchemas
+	MaintenanceInfo *domain.MaintenanceInfo
+}
+
+type servicesWithPlanDefaults struct {
+	Services
+	defaults PlanDefaults
+}
+
+func NewServicesWithPlanDefaults(services Services, defaults PlanDefaults) Services {
+	return &servicesWithPlanDefaults{Services: services, defaults: defaults}
+}
+
+func (s *servicesWithPlanDefaults) List() []domain.Service {
+	var out []domain.Service
+	for _, service := range s.Services.List() {
+		plans := make([]domain.ServicePlan, len(service.Plans))
+		for i, plan := range service.Plans {
+			if plan.Schemas == nil {
+				plan.Schemas = s.defaults.Schemas
+			}
+			if plan.MaintenanceInfo == nil {
+				plan.MaintenanceInfo = s.defaults.MaintenanceInfo
+			}
+			plans[i] = plan
+		}
+		service.Plans = plans
+		out = append(out, service)
+	}
+	return out
+}
@@ -1,7 +1,12 @@
 package main_test
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
 	. "code.cloudfoundry.org/nfsbroker"
+	"code.cloudfoundry.org/nfsbroker/fakes"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	`github.com/pivotal-cf/brokerapi/v11/domain`
@@ -58,4 +63,179 @@ var _ = Describe("Services", func() {
 			}))
 		})
 	})
+
+	Describe("NewServicesWithPlanDefaults", func() {
+		var defaults PlanDefaults
+
+		BeforeEach(func() {
+			defaults = PlanDefaults{
+				Schemas: &domain.ServiceSchemas{
+					Binding: domain.ServiceBindingSchema{
+						Create: domain.Schema{Parameters: map[string]interface{}{"type": "object"}},
+					},
+				},
+				MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.2.3", Description: "new defaults"},
+			}
+		})
+
+		It("adds the defaults to every plan", func() {
+			list := NewServicesWithPlanDefaults(services, defaults).List()
+			Expect(list).To(HaveLen(2))
+			Expect(list[0].Plans[0].Schemas).To(Equal(defaults.Schemas))
+			Expect(list[1].Plans[0].Schemas).To(Equal(defaults.Schemas))
+			Expect(list[1].Plans[0].MaintenanceInfo).To(Equal(defaults.MaintenanceInfo))
+		})
+
+		It("does not modify the underlying services", func() {
+			NewServicesWithPlanDefaults(services, defaults).List()
+			Expect(services.List()[0].Plans[0].Schemas).To(BeNil())
+			Expect(services.List()[0].Plans[0].MaintenanceInfo).To(BeNil())
+		})
+
+		Context("when a plan sets its own maintenance info", func() {
+			It("keeps the plan's value", func() {
+				planInfo := &domain.MaintenanceInfo{Version: "2.0.0"}
+				fakeServices := &fakes.FakeServices{}
+				fakeServices.ListReturns([]domain.Service{{Plans: []domain.ServicePlan{{ID: "plan", MaintenanceInfo: planInfo}}}})
+
+				list := NewServicesWithPlanDefaults(fakeServices, defaults).List()
+				Expect(list[0].Plans[0].MaintenanceInfo).To(Equal(planInfo))
+			})
+		})
+	})
+
+	Describe("NewServicesFromConfig validation", func() {
+		var (
+			contents string
+			err      error
+		)
+
+		JustBeforeEach(func() {
+			path := filepath.Join(GinkgoT().TempDir(), "services.json")
+			Expect(os.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+			_, err = NewServicesFromConfig(path)
+		})
+
+		Context("when a field is unknown", func() {
+			BeforeEach(func() {
+				contents = `[
+  {
+    "id": "service-id",
+    "name": "nfs",
+    "bindable": true,
+    "requires": ["volume_mount"],
+    "plans": [
+      {"id": "plan-id", "name": "Existing", "colour": "blue"}
+    ]
+  }
+]`
+			})
+
+			It("reports the field and where it is", func() {
+				Expect(err).To(MatchError(ContainSubstring(`8:45: [0].plans[0].colour: unknown field "colour"`)))
+			})
+		})
+
+		Context("when ids are duplicated", func() {
+			BeforeEach(func() {
+				contents = `[
+  {"id": "service-id", "name": "nfs", "bindable": true, "requires": ["volume_mount"],
+   "plans": [{"id": "plan-id", "name": "a"}, {"id": "plan-id", "name": "b"}]},
+  {"id": "service-id", "name": "nfs-2", "bindable": true, "requires": ["volume_mount"],
+   "plans": [{"id": "other-plan-id", "name": "a"}]}
+]`
+			})
+
+			It("reports every duplicate with both positions", func() {
+				Expect(err).To(MatchError(ContainSubstring(`3:47: [0].plans[1].id: duplicate plan id "plan-id" (first defined at 3:15)`)))
+				Expect(err).To(MatchError(ContainSubstring(`4:4: [1].id: duplicate service id "service-id" (first defined at 2:4)`)))
+			})
+		})
+
+		Context("when the service cannot be used as a volume service", func() {
+			BeforeEach(func() {
+				contents = `[{"id": "service-id", "name": "nfs", "bindable": false, "plans": [{"id": "plan-id"}]}]`
+			})
+
+			It("reports each problem", func() {
+				Expect(err).To(MatchError(ContainSubstring(`[0].bindable: volume services must be bindable`)))
+				Expect(err).To(MatchError(ContainSubstring(`[0]: requires must include "volume_mount"`)))
+				Expect(err).To(MatchError(ContainSubstring(`[0].plans[0]: plan name must not be empty`)))
+			})
+		})
+
+		Context("when a state is not a lifecycle state", func() {
+			BeforeEach(func() {
+				contents = `[
+  {"id": "service-id", "name": "nfs", "bindable": true, "requires": ["volume_mount"],
+   "plans": [{"id": "plan-id", "name": "Existing", "state": "retired"}]}
+]`
+			})
+
+			It("reports the state and where it is", func() {
+				Expect(err).To(MatchError(ContainSubstring(`3:52: [0].plans[0].state: state must be "active", "deprecated" or "disabled", not "retired"`)))
+			})
+		})
+
+		Context("when the file is not valid JSON", func() {
+			BeforeEach(func() {
+				contents = "[\n  {\"id\": \"service-id\",}\n]"
+			})
+
+			It("reports the position of the syntax error", func() {
+				Expect(err).To(MatchError(ContainSubstring("2:23: invalid character '}'")))
+			})
+		})
+	})
+
+	Describe("lifecycle states", func() {
+		var list []domain.Service
+
+		BeforeEach(func() {
+			path := filepath.Join(GinkgoT().TempDir(), "services.json")
+			Expect(os.WriteFile(path, []byte(`[
+  {"id": "legacy-id", "name": "nfs-legacy", "bindable": true, "requires": ["volume_mount"],
+   "state": "deprecated", "migration_hint": "Create an nfs instance instead.",
+   "plans": [
+     {"id": "legacy-plan", "name": "Existing", "metadata": {"displayName": "Existing", "support": "community"}},
+     {"id": "legacy-active-plan", "name": "Kept", "state": "active"},
+     {"id": "legacy-disabled-plan", "name": "Gone", "state": "disabled", "migration_hint": "Ask your operator."}
+   ]},
+  {"id": "nfs-id", "name": "nfs", "bindable": true, "requires": ["volume_mount"],
+   "plans": [{"id": "nfs-plan", "name": "Existing"}]}
+]`), 0600)).To(Succeed())
+
+			services, err := NewServicesFromConfig(path)
+			Expect(err).NotTo(HaveOccurred())
+			list = services.List()
+		})
+
+		It("gives plans their service's state and migration hint, in their metadata", func() {
+			metadata := list[0].Plans[0].Metadata
+			Expect(metadata.DisplayName).To(Equal("Existing"))
+			Expect(metadata.AdditionalMetadata).To(Equal(map[string]interface{}{
+				"support":        "community",
+				"state":          "deprecated",
+				"migration_hint": "Create an nfs instance instead.",
+			}))
+		})
+
+		It("lets a plan set its own state", func() {
+			Expect(list[0].Plans[1].Metadata).To(BeNil())
+			Expect(list[0].Plans[2].Metadata.AdditionalMetadata).To(Equal(map[string]interface{}{
+				"state":          "disabled",
+				"migration_hint": "Ask your operator.",
+			}))
+		})
+
+		It("leaves the metadata of active plans alone", func() {
+			Expect(list[1].Plans[0].Metadata).To(BeNil())
+		})
+
+		It("shows the state in the catalog", func() {
+			catalog, err := json.Marshal(list[0].Plans[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(catalog).To(ContainSubstring(`"state":"deprecated"`))
+		})
+	})
 })
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"code.cloudfoundry.org/nfsbroker/broker"
+	"github.com/pivotal-cf/brokerapi/v11/domain"
+)
+
+// CatalogError is a problem found in the services config, located by line
+// and column so that it can be fixed without guessing.
+type CatalogError struct {
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (e CatalogError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+type CatalogErrors []CatalogError
+
+func (e CatalogErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+
+	// extensions are the fields the services config adds to catalog types,
+	// which the broker reads itself rather than serving as they are.
+	extensions = map[reflect.Type]reflect.Type{
+		reflect.TypeOf(domain.Service{}):     reflect.TypeOf(lifecycle{}),
+		reflect.TypeOf(domain.ServicePlan{}): reflect.TypeOf(lifecycle{}),
+	}
+)
+
+// lifecycle is the state a service or plan is in, set in the services
+// config. A plan without its own state has its service's.
+type lifecycle struct {
+	State         string `json:"state"`
+	MigrationHint string `json:"migration_hint"`
+}
+
+type serviceLifecycle struct {
+	lifecycle
+	Plans []lifecycle `json:"plans"`
+}
+
+// parseCatalog strictly decodes a services config. Unknown fields, duplicate
+// IDs and services that Cloud Controller would reject for volume services are
+// all reported, each with the position it was found at.
+func parseCatalog(contents []byte) ([]domain.Service, error) {
+	var s []domain.Service
+	if err := json.Unmarshal(contents, &s); err != nil {
+		return nil, locateDecodeError(contents, err)
+	}
+
+	var lifecycles []serviceLifecycle
+	if err := json.Unmarshal(contents, &lifecycles); err != nil {
+		return nil, locateDecodeError(contents, err)
+	}
+
+	c := &catalogChecker{contents: contents, offsets: map[string]int64{}}
+
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	if err := c.walk(decoder, reflect.TypeOf(s), ""); err != nil {
+		return nil, locateDecodeError(contents, err)
+	}
+
+	c.checkServices(s)
+	c.checkLifecycles(lifecycles)
+
+	if len(c.errs) > 0 {
+		return nil, c.errs
+	}
+	return applyLifecycles(s, lifecycles), nil
+}
+
+func locateDecodeError(contents []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		// the offending byte is the last one read
+		line, column := position(contents, max(syntaxErr.Offset-1, 0))
+		return CatalogErrors{{Line: line, Column: column, Message: syntaxErr.Error()}}
+	case errors.As(err, &typeErr):
+		line, column := position(contents, typeErr.Offset)
+		return CatalogErrors{{Line: line, Column: column, Path: typeErr.Field, Message: fmt.Sprintf("cannot use JSON %s as %s", typeErr.Value, typeErr.Type)}}
+	}
+	return err
+}
+
+type catalogChecker struct {
+	contents []byte
+	offsets  map[string]int64
+	errs     CatalogErrors
+}
+
+// walk consumes one JSON value, recording the offset of every object key and
+// array element by path and reporting keys that t has no field for.
+func (c *catalogChecker) walk(decoder *json.Decoder, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == rawMessageType || t.Kind() == reflect.Interface || reflect.PointerTo(t).Implements(unmarshalerType) {
+		var skip json.RawMessage
+		return decoder.Decode(&skip)
+	}
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	switch delim {
+	case '[':
+		for i := 0; decoder.More(); i++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			c.offsets[elemPath] = c.nextOffset(decoder)
+			if err := c.walk(decoder, t.Elem(), elemPath); err != nil {
+				return err
+			}
+		}
+	case '{':
+		for decoder.More() {
+			offset := c.nextOffset(decoder)
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			key := token.(string)
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			c.offsets[keyPath] = offset
+
+			fieldType := t
+			if t.Kind() == reflect.Struct {
+				field, found := jsonField(t, key)
+				if extension, ok := extensions[t]; ok && !found {
+					field, found = jsonField(extension, key)
+				}
+				if !found {
+					c.addError(keyPath, fmt.Sprintf("unknown field %q", key))
+				}
+				fieldType = field
+			} else if t.Kind() == reflect.Map {
+				fieldType = t.Elem()
+			}
+
+			if err := c.walk(decoder, fieldType, keyPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	// closing delimiter
+	_, err = decoder.Token()
+	return err
+}
+
+// nextOffset is the offset of the next token, skipping the separators that the
+// decoder has not consumed yet.
+func (c *catalogChecker) nextOffset(decoder *json.Decoder) int64 {
+	offset := decoder.InputOffset()
+	for offset < int64(len(c.contents)) && strings.ContainsRune(" \t\r\n,:", rune(c.contents[offset])) {
+		offset++
+	}
+	return offset
+}
+
+func jsonField(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == key {
+			return field.Type, true
+		}
+	}
+	// skip the value of an unknown field
+	return reflect.TypeOf((*interface{})(nil)).Elem(), false
+}
+
+func (c *catalogChecker) checkServices(services []domain.Service) {
+	if len(services) == 0 {
+		c.addError("", "no services defined")
+	}
+
+	serviceIDs := map[string]string{}
+	serviceNames := map[string]string{}
+	planIDs := map[string]string{}
+
+	for i, service := range services {
+		path := fmt.Sprintf("[%d]", i)
+
+		c.checkUnique(serviceIDs, service.ID, path, "id", "service id")
+		c.checkUnique(serviceNames, service.Name, path, "name", "service name")
+
+		if !service.Bindable {
+			c.addError(path+".bindable", "volume services must be bindable")
+		}
+
+		if !requiresVolumeMount(service) {
+			c.addError(path, `requires must include "volume_mount"`)
+		}
+
+		if len(service.Plans) == 0 {
+			c.addError(path, "service has no plans")
+		}
+
+		planNames := map[string]string{}
+		for j, plan := range service.Plans {
+			planPath := fmt.Sprintf("%s.plans[%d]", path, j)
+
+			c.checkUnique(planIDs, plan.ID, planPath, "id", "plan id")
+			c.checkUnique(planNames, plan.Name, planPath, "name", "plan name")
+
+			if plan.Bindable != nil && !*plan.Bindable {
+				c.addError(planPath+".bindable", "volume service plans must be bindable")
+			}
+		}
+	}
+}
+
+func (c *catalogChecker) checkLifecycles(lifecycles []serviceLifecycle) {
+	for i, service := range lifecycles {
+		path := fmt.Sprintf("[%d]", i)
+		c.checkState(path, service.State)
+		for j, plan := range service.Plans {
+			c.checkState(fmt.Sprintf("%s.plans[%d]", path, j), plan.State)
+		}
+	}
+}
+
+func (c *catalogChecker) checkState(path, state string) {
+	if state != "" && !broker.ValidPlanState(state) {
+		c.addError(path+".state", fmt.Sprintf("state must be %q, %q or %q, not %q", broker.PlanStateActive, broker.PlanStateDeprecated, broker.PlanStateDisabled, state))
+	}
+}
+
+// applyLifecycles publishes the state and migration hint of every plan that
+// is not active in its catalog metadata, where the broker enforces it and
+// Cloud Controller shows it.
+func applyLifecycles(services []domain.Service, lifecycles []serviceLifecycle) []domain.Service {
+	for i := range services {
+		service := lifecycles[i].lifecycle
+		for j, plan := range services[i].Plans {
+			state := lifecycles[i].Plans[j]
+			if state.State == "" {
+				state.State = service.State
+			}
+			if state.MigrationHint == "" {
+				state.MigrationHint = service.MigrationHint
+			}
+			if state.State == "" || state.State == broker.PlanStateActive {
+				continue
+			}
+
+			metadata := domain.ServicePlanMetadata{}
+			if plan.Metadata != nil {
+				metadata = *plan.Metadata
+			}
+			additional := map[string]interface{}{}
+			for key, value := range metadata.AdditionalMetadata {
+				additional[key] = value
+			}
+			additional[broker.PlanStateKey] = state.State
+			if state.MigrationHint != "" {
+				additional[broker.MigrationHintKey] = state.MigrationHint
+			}
+			metadata.AdditionalMetadata = additional
+			plan.Metadata = &metadata
+			services[i].Plans[j] = plan
+		}
+	}
+	return services
+}
+
+func (c *catalogChecker) checkUnique(seen map[string]string, value, path, field, description string) {
+	fieldPath := path + "." + field
+	if value == "" {
+		c.addError(path, fmt.Sprintf("%s must not be empty", description))
+		return
+	}
+	if first, ok := seen[value]; ok {
+		line, column := c.position(first)
+		c.addError(fieldPath, fmt.Sprintf("duplicate %s %q (first defined at %d:%d)", description, value, line, column))
+		return
+	}
+	seen[value] = fieldPath
+}
+
+func requiresVolumeMount(service domain.Service) bool {
+	for _, permission := range service.Requires {
+		if permission == domain.PermissionVolumeMount {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *catalogChecker) addError(path, message string) {
+	line, column := c.position(path)
+	c.errs = append(c.errs, CatalogError{Line: line, Column: column, Path: path, Message: message})
+}
+
+// position locates a path, falling back to its closest recorded ancestor.
+func (c *catalogChecker) position(path string) (int, int) {
+	for {
+		if offset, ok := c.offsets[path]; ok {
+			return position(c.contents, offset)
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return 1, 1
+		}
+		path = path[:i]
+	}
+}
+
+func position(contents []byte, offset int64) (int, int) {
+	if offset > int64(len(contents)) {
+		offset = int64(len(contents))
+	}
+	before := contents[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
@@ -0,0 +1,41 @@
+package shares
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var csvHeader = []string{"server", "export", "instances", "bindings", "organization_guids", "space_guids", "plan_ids", "uids", "gids"}
+
+// WriteCSV writes a row per group, with a header. Lists are separated by
+// spaces, and bindings left empty when they were not counted.
+func WriteCSV(w io.Writer, report Report) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, group := range report.Groups {
+		bindings := ""
+		if group.Bindings != nil {
+			bindings = strconv.Itoa(*group.Bindings)
+		}
+		row := []string{
+			group.Server,
+			group.Export,
+			strconv.Itoa(group.Instances),
+			bindings,
+			strings.Join(group.Organizations, " "),
+			strings.Join(group.Spaces, " "),
+			strings.Join(group.Plans, " "),
+			strings.Join(group.UIDs, " "),
+			strings.Join(group.GIDs, " "),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
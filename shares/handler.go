@@ -0,0 +1,56 @@
+package shares
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const Path = "/admin/shares"
+
+type handler struct {
+	logger   lager.Logger
+	reporter *Reporter
+}
+
+// NewHandler responds to GET with the report, as JSON, or as CSV with
+// ?format=csv. It does not authenticate requests itself.
+func NewHandler(logger lager.Logger, reporter *Reporter) http.Handler {
+	return &handler{logger: logger.Session("shares-handler"), reporter: reporter}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be json or csv"})
+		return
+	}
+
+	report, err := h.reporter.Report(req.Context())
+	if err != nil {
+		h.logger.Error("report-failed", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusOK)
+		_ = WriteCSV(w, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
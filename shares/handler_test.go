@@ -0,0 +1,65 @@
+package shares_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/shares"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		store    *fakes.FakeStore
+		handler  http.Handler
+		recorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		store = &fakes.FakeStore{}
+		store.RetrieveAllInstanceDetailsReturns(map[string]brokerstore.ServiceInstance{
+			"instance-1": {PlanID: "existing", OrganizationGUID: "org-1", SpaceGUID: "space-1", ServiceFingerPrint: map[string]interface{}{"share": "filer/export"}},
+		}, nil)
+		handler = shares.NewHandler(lager.NewLogger("test"), shares.NewReporter(lager.NewLogger("test"), store, nil, nil))
+		recorder = httptest.NewRecorder()
+	})
+
+	It("responds with the report as JSON", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", shares.Path, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"groups": [{"server": "filer", "export": "/export", "instances": 1, "bindings": null, "organization_guids": ["org-1"], "space_guids": ["space-1"], "plan_ids": ["existing"], "uids": [], "gids": []}]}`))
+	})
+
+	It("responds with CSV when asked", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", shares.Path+"?format=csv", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/csv"))
+		Expect(recorder.Body.String()).To(HaveSuffix("\nfiler,/export,1,,org-1,space-1,existing,,\n"))
+	})
+
+	It("rejects unknown formats", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", shares.Path+"?format=xml", nil))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(store.RetrieveAllInstanceDetailsCallCount()).To(BeZero())
+	})
+
+	It("fails when the store cannot be read", func() {
+		store.RetrieveAllInstanceDetailsReturns(nil, errors.New("credhub unavailable"))
+
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", shares.Path, nil))
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"error": "credhub unavailable"}`))
+	})
+
+	It("only allows GET", func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", shares.Path, nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(recorder.Header().Get("Allow")).To(Equal("GET"))
+	})
+})
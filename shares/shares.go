@@ -0,0 +1,211 @@
+// Package shares reports which orgs, spaces and plans use each NFS server
+// and export, from the instances in the store, for storage admins who need
+// to know who a change to an export affects.
+package shares
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+)
+
+// Group is the instances of one export on one server.
+type Group struct {
+	Server    string `json:"server"`
+	Export    string `json:"export"`
+	Instances int    `json:"instances"`
+	// Bindings is nil when bindings could not be counted; the store does
+	// not record which instance a binding is of.
+	Bindings      *int     `json:"bindings"`
+	Organizations []string `json:"organization_guids"`
+	Spaces        []string `json:"space_guids"`
+	Plans         []string `json:"plan_ids"`
+	// UIDs and GIDs are the values the instances mount with, given when
+	// they were created or defaulted by the mount option policy. Binds may
+	// override them, where the policy allows.
+	UIDs []string `json:"uids"`
+	GIDs []string `json:"gids"`
+}
+
+// Report groups the instances in the store by server, then export.
+type Report struct {
+	Groups        []Group `json:"groups"`
+	BindingsError string  `json:"bindings_error,omitempty"`
+}
+
+// BindingFinder looks up the bindings of instances by instance ID.
+type BindingFinder interface {
+	InstanceBindings(ctx context.Context, instanceIDs []string) (map[string][]string, error)
+}
+
+// Reporter reports on a store with the most recently loaded mount option
+// defaults. Without a BindingFinder, bindings are not counted.
+type Reporter struct {
+	logger lager.Logger
+	store  brokerstore.Store
+	finder BindingFinder
+
+	lock     sync.RWMutex
+	defaults map[string]interface{}
+}
+
+func NewReporter(logger lager.Logger, store brokerstore.Store, finder BindingFinder, defaults map[string]interface{}) *Reporter {
+	return &Reporter{logger: logger.Session("shares"), store: store, finder: finder, defaults: defaults}
+}
+
+// Reload replaces the mount option defaults that subsequent reports use.
+func (r *Reporter) Reload(defaults map[string]interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.defaults = defaults
+}
+
+// Report fails only when the instances cannot be read. When bindings cannot
+// be counted, the report says why.
+func (r *Reporter) Report(ctx context.Context) (Report, error) {
+	r.lock.RLock()
+	defaults := r.defaults
+	r.lock.RUnlock()
+
+	all, err := r.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return Report{}, err
+	}
+
+	var bindings map[string][]string
+	if r.finder != nil && len(all) > 0 {
+		ids := make([]string, 0, len(all))
+		for id := range all {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if bindings, err = r.finder.InstanceBindings(ctx, ids); err != nil {
+			r.logger.Error("counting-bindings-failed", err)
+		}
+	}
+
+	report := Report{Groups: []Group{}}
+	if err != nil {
+		report.BindingsError = err.Error()
+	}
+	counted := r.finder != nil && err == nil
+
+	groups := map[[2]string]*group{}
+	for id, instance := range all {
+		parameters, _ := instance.ServiceFingerPrint.(map[string]interface{})
+		server, export := split(shareOf(instance.ServiceFingerPrint))
+
+		g, ok := groups[[2]string{server, export}]
+		if !ok {
+			g = newGroup(server, export)
+			groups[[2]string{server, export}] = g
+		}
+		g.instances++
+		g.bindings += len(bindings[id])
+		g.organizations.add(instance.OrganizationGUID)
+		g.spaces.add(instance.SpaceGUID)
+		g.plans.add(instance.PlanID)
+		g.uids.add(effective(parameters, defaults, "uid"))
+		g.gids.add(effective(parameters, defaults, "gid"))
+	}
+
+	for _, g := range groups {
+		report.Groups = append(report.Groups, g.group(counted))
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Server != report.Groups[j].Server {
+			return report.Groups[i].Server < report.Groups[j].Server
+		}
+		return report.Groups[i].Export < report.Groups[j].Export
+	})
+	return report, nil
+}
+
+// shareOf reads the share from a fingerprint in the current map format, or
+// the legacy bare string.
+func shareOf(fingerprint interface{}) string {
+	switch fingerprint := fingerprint.(type) {
+	case map[string]interface{}:
+		share, _ := fingerprint["share"].(string)
+		return share
+	case string:
+		return fingerprint
+	}
+	return ""
+}
+
+// split separates a share, server/export/path, into its server and the
+// export path.
+func split(share string) (string, string) {
+	server, path, ok := strings.Cut(share, "/")
+	if !ok {
+		return server, ""
+	}
+	return server, "/" + path
+}
+
+// effective returns the value an instance mounts with: its own, or the
+// policy's default.
+func effective(parameters, defaults map[string]interface{}, key string) string {
+	if value, ok := parameters[key]; ok {
+		return fmt.Sprint(value)
+	}
+	if value, ok := defaults[key]; ok {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+type group struct {
+	server, export string
+	instances      int
+	bindings       int
+
+	organizations, spaces, plans, uids, gids set
+}
+
+func newGroup(server, export string) *group {
+	return &group{server: server, export: export, organizations: set{}, spaces: set{}, plans: set{}, uids: set{}, gids: set{}}
+}
+
+func (g *group) group(counted bool) Group {
+	out := Group{
+		Server:        g.server,
+		Export:        g.export,
+		Instances:     g.instances,
+		Organizations: g.organizations.sorted(),
+		Spaces:        g.spaces.sorted(),
+		Plans:         g.plans.sorted(),
+		UIDs:          g.uids.sorted(),
+		GIDs:          g.gids.sorted(),
+	}
+	if counted {
+		bindings := g.bindings
+		out.Bindings = &bindings
+	}
+	return out
+}
+
+// set keeps distinct values, leaving out empty ones.
+type set map[string]bool
+
+func (s set) add(value string) {
+	if value != "" {
+		s[value] = true
+	}
+}
+
+func (s set) sorted() []string {
+	values := []string{}
+	for value := range s {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,115 @@
+package shares_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/fakes"
+	"code.cloudfoundry.org/nfsbroker/shares"
+	"code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// finder answers with fixed bindings.
+type finder struct {
+	bindings map[string][]string
+	err      error
+	asked    []string
+}
+
+func (f *finder) InstanceBindings(_ context.Context, instanceIDs []string) (map[string][]string, error) {
+	f.asked = instanceIDs
+	return f.bindings, f.err
+}
+
+var _ = Describe("Reporter", func() {
+	var (
+		store     *fakes.FakeStore
+		instances map[string]brokerstore.ServiceInstance
+		bindings  *finder
+		defaults  map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		instances = map[string]brokerstore.ServiceInstance{
+			"instance-1": {PlanID: "existing", OrganizationGUID: "org-1", SpaceGUID: "space-1", ServiceFingerPrint: map[string]interface{}{"share": "filer/export/a", "uid": "1000", "gid": float64(1000)}},
+			"instance-2": {PlanID: "existing", OrganizationGUID: "org-2", SpaceGUID: "space-2", ServiceFingerPrint: map[string]interface{}{"share": "filer/export/a"}},
+			"instance-3": {PlanID: "other", OrganizationGUID: "org-1", SpaceGUID: "space-1", ServiceFingerPrint: "filer/export/b"},
+			"instance-4": {PlanID: "existing", OrganizationGUID: "org-3", SpaceGUID: "space-3", ServiceFingerPrint: map[string]interface{}{"share": "another/export/a", "uid": "3000"}},
+		}
+		store = &fakes.FakeStore{}
+		store.RetrieveAllInstanceDetailsStub = func() (map[string]brokerstore.ServiceInstance, error) { return instances, nil }
+		bindings = &finder{bindings: map[string][]string{"instance-1": {"binding-1", "binding-2"}, "instance-2": {"binding-3"}}}
+		defaults = map[string]interface{}{"uid": "2000"}
+	})
+
+	report := func(finder shares.BindingFinder) shares.Report {
+		report, err := shares.NewReporter(lager.NewLogger("test"), store, finder, defaults).Report(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		return report
+	}
+
+	count := func(n int) *int { return &n }
+
+	It("groups instances by server, then export", func() {
+		Expect(report(bindings).Groups).To(Equal([]shares.Group{
+			{Server: "another", Export: "/export/a", Instances: 1, Bindings: count(0), Organizations: []string{"org-3"}, Spaces: []string{"space-3"}, Plans: []string{"existing"}, UIDs: []string{"3000"}, GIDs: []string{}},
+			{Server: "filer", Export: "/export/a", Instances: 2, Bindings: count(3), Organizations: []string{"org-1", "org-2"}, Spaces: []string{"space-1", "space-2"}, Plans: []string{"existing"}, UIDs: []string{"1000", "2000"}, GIDs: []string{"1000"}},
+			{Server: "filer", Export: "/export/b", Instances: 1, Bindings: count(0), Organizations: []string{"org-1"}, Spaces: []string{"space-1"}, Plans: []string{"other"}, UIDs: []string{"2000"}, GIDs: []string{}},
+		}))
+		Expect(bindings.asked).To(Equal([]string{"instance-1", "instance-2", "instance-3", "instance-4"}))
+	})
+
+	It("leaves bindings uncounted without a way to find them", func() {
+		for _, group := range report(nil).Groups {
+			Expect(group.Bindings).To(BeNil())
+		}
+	})
+
+	It("says why bindings could not be counted", func() {
+		bindings.err = errors.New("cloud controller unavailable")
+
+		r := report(bindings)
+		Expect(r.BindingsError).To(Equal("cloud controller unavailable"))
+		Expect(r.Groups).To(HaveLen(3))
+		Expect(r.Groups[1].Bindings).To(BeNil())
+	})
+
+	It("uses the reloaded defaults", func() {
+		reporter := shares.NewReporter(lager.NewLogger("test"), store, nil, defaults)
+		reporter.Reload(map[string]interface{}{"uid": "4000", "gid": "4000"})
+
+		r, err := reporter.Report(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Groups[2].UIDs).To(Equal([]string{"4000"}))
+		Expect(r.Groups[2].GIDs).To(Equal([]string{"4000"}))
+	})
+
+	It("fails when the store cannot be read", func() {
+		store.RetrieveAllInstanceDetailsReturns(nil, errors.New("credhub unavailable"))
+
+		_, err := shares.NewReporter(lager.NewLogger("test"), store, bindings, defaults).Report(context.Background())
+		Expect(err).To(MatchError("credhub unavailable"))
+	})
+
+	It("reports an empty store as no groups", func() {
+		instances = map[string]brokerstore.ServiceInstance{}
+		Expect(report(bindings).Groups).To(BeEmpty())
+		Expect(bindings.asked).To(BeNil())
+	})
+
+	It("writes CSV, a row per group", func() {
+		buffer := &bytes.Buffer{}
+		r := report(bindings)
+		r.Groups[0].Bindings = nil
+		Expect(shares.WriteCSV(buffer, r)).To(Succeed())
+		Expect(buffer.String()).To(Equal(`server,export,instances,bindings,organization_guids,space_guids,plan_ids,uids,gids
+another,/export/a,1,,org-3,space-3,existing,3000,
+filer,/export/a,2,3,org-1 org-2,space-1 space-2,existing,1000 2000,1000
+filer,/export/b,1,0,org-1,space-1,other,2000,
+`))
+	})
+})
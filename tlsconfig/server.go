@@ -0,0 +1,217 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/tedsuo/ifrit"
+)
+
+var minVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseMinVersion parses a minimum TLS version, "1.2" or "1.3".
+func ParseMinVersion(version string) (uint16, error) {
+	v, ok := minVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unsupported minimum TLS version %q, expected 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+// Server is the TLS configuration for the broker listener. The certificate,
+// key and client CA are read from disk and can be reloaded while the listener
+// is serving; new connections use the most recently loaded files.
+type Server struct {
+	logger       lager.Logger
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	minVersion   uint16
+
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	// see SetOptionalClientCertificates
+	optionalClientCertificates bool
+	// contents of the files at the last reload attempt
+	attempted [][]byte
+}
+
+func NewServer(logger lager.Logger, certFile, keyFile, clientCAFile string, minVersion uint16) (*Server, error) {
+	s := &Server{
+		logger:       logger.Session("tls"),
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		minVersion:   minVersion,
+	}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Config returns a tls.Config that always serves the current files.
+func (s *Server) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: s.minVersion,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			s.lock.RLock()
+			defer s.lock.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   s.minVersion,
+				Certificates: []tls.Certificate{*s.certificate},
+			}
+			if s.clientCAs != nil {
+				// client certificates are verified here rather than by
+				// crypto/tls so that rejections are logged
+				config.ClientCAs = s.clientCAs
+				config.ClientAuth = tls.RequestClientCert
+				config.VerifyConnection = s.verifyClientCertificate(hello, s.clientCAs, s.optionalClientCertificates)
+			}
+			return config, nil
+		},
+	}
+}
+
+// SetOptionalClientCertificates sets whether clients may connect without a
+// certificate when there is a client CA. Certificates that are presented must
+// still be signed by it. New connections use the most recent setting.
+func (s *Server) SetOptionalClientCertificates(optional bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.optionalClientCertificates = optional
+}
+
+func (s *Server) verifyClientCertificate(hello *tls.ClientHelloInfo, roots *x509.CertPool, optional bool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			if !optional {
+				s.logger.Info("client-certificate-missing", lager.Data{"remote_addr": hello.Conn.RemoteAddr().String()})
+				return errors.New("client certificate required")
+			}
+			return nil
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		leaf := state.PeerCertificates[0]
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			s.logger.Error("client-certificate-rejected", err, lager.Data{
+				"remote_addr": hello.Conn.RemoteAddr().String(),
+				"subject":     leaf.Subject.String(),
+				"issuer":      leaf.Issuer.String(),
+			})
+			return err
+		}
+		return nil
+	}
+}
+
+// Reload re-reads the certificate, key and client CA. It reports whether any
+// of them changed; if they cannot be loaded the current ones stay in use, and
+// the same broken files are not reported again.
+func (s *Server) Reload() (bool, error) {
+	files := []string{s.certFile, s.keyFile}
+	if s.clientCAFile != "" {
+		files = append(files, s.clientCAFile)
+	}
+
+	var contents [][]byte
+	for _, file := range files {
+		/* #nosec */
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		contents = append(contents, b)
+	}
+
+	s.lock.Lock()
+	unchanged := equal(contents, s.attempted)
+	s.attempted = contents
+	s.lock.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, fmt.Errorf("loading TLS certificate %s and key %s: %w", s.certFile, s.keyFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+	if s.clientCAFile != "" {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(contents[2]) {
+			return false, fmt.Errorf("loading TLS client CA %s: %w", s.clientCAFile, errors.New("no certificates found"))
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.certificate = &certificate
+	s.clientCAs = clientCAs
+
+	return true, nil
+}
+
+func equal(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Watcher returns a runner that checks the files for changes every interval.
+func (s *Server) Watcher(clock clock.Clock, interval time.Duration) ifrit.Runner {
+	logger := s.logger.Session("watcher", lager.Data{"cert_file": s.certFile, "key_file": s.keyFile, "client_ca_file": s.clientCAFile})
+
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		close(ready)
+
+		for {
+			select {
+			case <-signals:
+				return nil
+			case <-ticker.C():
+				changed, err := s.Reload()
+				if err != nil {
+					logger.Error("reload-failed-keeping-current-certificate", err)
+					continue
+				}
+				if changed {
+					logger.Info("certificate-reloaded")
+				}
+			}
+		}
+	})
+}
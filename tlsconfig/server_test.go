@@ -0,0 +1,214 @@
+package tlsconfig_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/tlsconfig"
+	"code.cloudfoundry.org/nfsbroker/tlsconfig/tlsconfigtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	ginkgomon "github.com/tedsuo/ifrit/ginkgomon_v2"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		logger                          lager.Logger
+		logs                            *gbytes.Buffer
+		ca                              *tlsconfigtest.CA
+		certFile, keyFile, clientCAFile string
+		minVersion                      uint16
+		server                          *tlsconfig.Server
+		listener                        net.Listener
+	)
+
+	writeCert := func(commonName string) {
+		cert, key, err := ca.Issue(commonName, "127.0.0.1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(certFile, cert, 0600)).To(Succeed())
+		Expect(os.WriteFile(keyFile, key, 0600)).To(Succeed())
+	}
+
+	dial := func(config *tls.Config) (*tls.Conn, error) {
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca.CertPEM)
+		config.RootCAs = roots
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+		if err != nil {
+			return nil, err
+		}
+		// client certificate failures only surface once the server replies
+		_, err = conn.Read(make([]byte, 1))
+		return conn, err
+	}
+
+	servedCommonName := func() string {
+		conn, err := dial(&tls.Config{})
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	BeforeEach(func() {
+		logs = gbytes.NewBuffer()
+		logger = lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+
+		var err error
+		ca, err = tlsconfigtest.NewCA("test-ca")
+		Expect(err).NotTo(HaveOccurred())
+
+		dir := GinkgoT().TempDir()
+		certFile = filepath.Join(dir, "cert.pem")
+		keyFile = filepath.Join(dir, "key.pem")
+		clientCAFile = ""
+		minVersion = tls.VersionTLS12
+		writeCert("first")
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		server, err = tlsconfig.NewServer(logger, certFile, keyFile, clientCAFile, minVersion)
+		Expect(err).NotTo(HaveOccurred())
+
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", server.Config())
+		Expect(err).NotTo(HaveOccurred())
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					conn.Write([]byte("!"))
+				}()
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	It("serves the certificate", func() {
+		Expect(servedCommonName()).To(Equal("first"))
+	})
+
+	It("serves a new certificate after a reload", func() {
+		writeCert("second")
+
+		changed, err := server.Reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+
+		Expect(servedCommonName()).To(Equal("second"))
+	})
+
+	It("keeps serving the current certificate when the new one is invalid", func() {
+		Expect(os.WriteFile(keyFile, []byte("not a key"), 0600)).To(Succeed())
+
+		_, err := server.Reload()
+		Expect(err).To(MatchError(ContainSubstring("loading TLS certificate")))
+
+		Expect(servedCommonName()).To(Equal("first"))
+	})
+
+	It("picks up changed files while watching", func() {
+		process := ginkgomon.Invoke(server.Watcher(clock.NewClock(), 10*time.Millisecond))
+		defer ginkgomon.Interrupt(process)
+
+		writeCert("second")
+
+		Eventually(logs).Should(gbytes.Say("certificate-reloaded"))
+		Expect(servedCommonName()).To(Equal("second"))
+	})
+
+	Context("with a minimum version of TLS 1.3", func() {
+		BeforeEach(func() {
+			minVersion = tls.VersionTLS13
+		})
+
+		It("refuses older clients", func() {
+			_, err := dial(&tls.Config{MaxVersion: tls.VersionTLS12})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("with a client CA", func() {
+		BeforeEach(func() {
+			clientCAFile = filepath.Join(filepath.Dir(certFile), "client-ca.pem")
+			Expect(os.WriteFile(clientCAFile, ca.CertPEM, 0600)).To(Succeed())
+		})
+
+		It("requires a client certificate signed by it", func() {
+			_, err := dial(&tls.Config{})
+			Expect(err).To(HaveOccurred())
+			Eventually(logs).Should(gbytes.Say("client-certificate-missing"))
+
+			certPEM, keyPEM, err := ca.Issue("client")
+			Expect(err).NotTo(HaveOccurred())
+			certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+			Expect(err).NotTo(HaveOccurred())
+
+			conn, err := dial(&tls.Config{Certificates: []tls.Certificate{certificate}})
+			Expect(err).NotTo(HaveOccurred())
+			conn.Close()
+		})
+
+		It("logs client certificates that are rejected", func() {
+			otherCA, err := tlsconfigtest.NewCA("other-ca")
+			Expect(err).NotTo(HaveOccurred())
+			certPEM, keyPEM, err := otherCA.Issue("intruder")
+			Expect(err).NotTo(HaveOccurred())
+			certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = dial(&tls.Config{GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &certificate, nil
+			}})
+			Expect(err).To(HaveOccurred())
+			Eventually(logs).Should(gbytes.Say(`client-certificate-rejected.*CN=intruder`))
+		})
+
+		It("accepts clients without a certificate when they are optional", func() {
+			server.SetOptionalClientCertificates(true)
+
+			conn, err := dial(&tls.Config{})
+			Expect(err).NotTo(HaveOccurred())
+			conn.Close()
+		})
+
+		It("requires a certificate from new clients once they are no longer optional", func() {
+			server.SetOptionalClientCertificates(true)
+			conn, err := dial(&tls.Config{})
+			Expect(err).NotTo(HaveOccurred())
+			conn.Close()
+
+			server.SetOptionalClientCertificates(false)
+			_, err = dial(&tls.Config{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ParseMinVersion", func() {
+		It("parses supported versions", func() {
+			Expect(tlsconfig.ParseMinVersion("1.2")).To(Equal(uint16(tls.VersionTLS12)))
+			Expect(tlsconfig.ParseMinVersion("1.3")).To(Equal(uint16(tls.VersionTLS13)))
+		})
+
+		It("rejects anything else", func() {
+			_, err := tlsconfig.ParseMinVersion("1.0")
+			Expect(err).To(MatchError(`unsupported minimum TLS version "1.0", expected 1.2 or 1.3`))
+		})
+	})
+})
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// Middleware records a server span for each request to next, continuing the
+// trace the caller sent, if any. Spans are named after the OSBAPI route, with
+// the instance and binding IDs as tags.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ids := routeOf(r.URL.Path)
+		span := t.start(r.Method+" "+route, model.Server, Extract(r.Header))
+		span.Tag("http.method", r.Method)
+		span.Tag("http.path", r.URL.Path)
+		for key, value := range ids {
+			span.Tag(key, value)
+		}
+		if identity := r.Header.Get("X-Broker-API-Request-Identity"); identity != "" {
+			span.Tag("osbapi.request_identity", identity)
+		}
+		defer span.Finish()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ContextWithSpan(r.Context(), span)))
+
+		span.Tag("http.status_code", strconv.Itoa(recorder.status))
+		if recorder.status >= 500 {
+			span.Tag("error", strconv.Itoa(recorder.status))
+		}
+	})
+}
+
+// routeOf replaces the instance and binding IDs in an OSBAPI path with
+// placeholders, and returns them separately.
+func routeOf(path string) (string, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 3 || segments[0] != "v2" || segments[1] != "service_instances" {
+		return path, nil
+	}
+
+	ids := map[string]string{"osbapi.instance_id": segments[2]}
+	segments[2] = ":instance_id"
+	if len(segments) >= 5 && segments[3] == "service_bindings" {
+		ids["osbapi.binding_id"] = segments[4]
+		segments[4] = ":binding_id"
+	}
+	return "/" + strings.Join(segments, "/"), ids
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Transport records a client span for each request made with a context that
+// carries a span, and sends the trace context with it. Requests made outside
+// any trace are passed to base untouched.
+func (t *Tracer) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{tracer: t, base: base}
+}
+
+type transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if SpanFromContext(req.Context()) == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	span, ctx := t.tracer.StartSpan(req.Context(), req.Method+" "+req.URL.Path, model.Client)
+	defer span.Finish()
+	span.SetRemoteServiceName(req.URL.Hostname())
+	span.Tag("http.method", req.Method)
+	span.Tag("http.path", req.URL.Path)
+
+	// a RoundTripper must not modify the request it is given
+	req = req.Clone(ctx)
+	Inject(span.Context(), req.Header)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return resp, err
+	}
+	span.Tag("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.Tag("error", strconv.Itoa(resp.StatusCode))
+	}
+	return resp, nil
+}
@@ -0,0 +1,159 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/nfsbroker/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+var _ = Describe("HTTP tracing", func() {
+	var (
+		reporter *recorder
+		tracer   *tracing.Tracer
+	)
+
+	BeforeEach(func() {
+		reporter = &recorder{}
+		tracer = tracing.NewTracer("nfsbroker", reporter, clock.NewClock())
+	})
+
+	Describe("Middleware", func() {
+		var (
+			handled  *http.Request
+			status   int
+			response *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			status = http.StatusCreated
+			response = httptest.NewRecorder()
+		})
+
+		serve := func(req *http.Request) {
+			tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handled = r
+				w.WriteHeader(status)
+			})).ServeHTTP(response, req)
+		}
+
+		It("records a server span named after the route", func() {
+			serve(httptest.NewRequest("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1", nil))
+
+			Expect(response.Code).To(Equal(http.StatusCreated))
+			spans := reporter.Spans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name).To(Equal("PUT /v2/service_instances/:instance_id/service_bindings/:binding_id"))
+			Expect(spans[0].Kind).To(Equal(model.Server))
+			Expect(spans[0].LocalEndpoint.ServiceName).To(Equal("nfsbroker"))
+			Expect(spans[0].Tags).To(HaveKeyWithValue("osbapi.instance_id", "instance-1"))
+			Expect(spans[0].Tags).To(HaveKeyWithValue("osbapi.binding_id", "binding-1"))
+			Expect(spans[0].Tags).To(HaveKeyWithValue("http.status_code", "201"))
+			Expect(spans[0].Tags).NotTo(HaveKey("error"))
+		})
+
+		It("passes the span to the handler", func() {
+			serve(httptest.NewRequest("GET", "/v2/catalog", nil))
+
+			span := tracing.SpanFromContext(handled.Context())
+			Expect(span).NotTo(BeNil())
+			Expect(span.Context().ID).To(Equal(reporter.Spans()[0].ID))
+		})
+
+		It("continues the caller's trace", func() {
+			req := httptest.NewRequest("GET", "/v2/catalog", nil)
+			req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+			serve(req)
+
+			span := reporter.Spans()[0]
+			Expect(span.TraceID.String()).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+			Expect(span.ParentID.String()).To(Equal("b7ad6b7169203331"))
+		})
+
+		It("does not record traces the caller did not sample", func() {
+			req := httptest.NewRequest("GET", "/v2/catalog", nil)
+			req.Header.Set("X-B3-Sampled", "0")
+			serve(req)
+
+			Expect(reporter.Spans()).To(BeEmpty())
+			Expect(*tracing.SpanFromContext(handled.Context()).Context().Sampled).To(BeFalse())
+		})
+
+		It("marks server errors", func() {
+			status = http.StatusInternalServerError
+			serve(httptest.NewRequest("DELETE", "/v2/service_instances/instance-1", nil))
+
+			Expect(reporter.Spans()[0].Tags).To(HaveKeyWithValue("error", "500"))
+		})
+	})
+
+	Describe("Transport", func() {
+		var (
+			server  *httptest.Server
+			headers chan http.Header
+			client  *http.Client
+		)
+
+		BeforeEach(func() {
+			headers = make(chan http.Header, 1)
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers <- r.Header
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			client = &http.Client{Transport: tracer.Transport(nil)}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("records a client span as a child of the span in the request context", func() {
+			parent, ctx := tracer.StartSpan(context.Background(), "bind", "")
+			req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/data", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			spans := reporter.Spans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name).To(Equal("GET /api/v1/data"))
+			Expect(spans[0].Kind).To(Equal(model.Client))
+			Expect(spans[0].RemoteEndpoint.ServiceName).To(Equal("127.0.0.1"))
+			Expect(spans[0].TraceID).To(Equal(parent.Context().TraceID))
+			Expect(*spans[0].ParentID).To(Equal(parent.Context().ID))
+			Expect(spans[0].Tags).To(HaveKeyWithValue("http.status_code", "204"))
+
+			sent := <-headers
+			Expect(sent.Get("X-B3-SpanId")).To(Equal(spans[0].ID.String()))
+			Expect(sent.Get("traceparent")).To(ContainSubstring(spans[0].ID.String()))
+			Expect(req.Header).NotTo(HaveKey("X-B3-Spanid"))
+		})
+
+		It("leaves requests made outside a trace alone", func() {
+			resp, err := client.Get(server.URL + "/info")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(reporter.Spans()).To(BeEmpty())
+			Expect(<-headers).NotTo(HaveKey("Traceparent"))
+		})
+
+		It("records failed requests", func() {
+			server.Close()
+			_, ctx := tracer.StartSpan(context.Background(), "bind", "")
+			req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/data", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.Do(req)
+			Expect(err).To(HaveOccurred())
+			Expect(reporter.Spans()[0].Tags).To(HaveKey("error"))
+		})
+	})
+})
@@ -0,0 +1,205 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// B3 headers, in their single and multiple header forms, and the W3C Trace
+// Context header. See https://github.com/openzipkin/b3-propagation and
+// https://www.w3.org/TR/trace-context/.
+const (
+	B3Header           = "b3"
+	B3TraceIDHeader    = "X-B3-TraceId"
+	B3SpanIDHeader     = "X-B3-SpanId"
+	B3ParentSpanHeader = "X-B3-ParentSpanId"
+	B3SampledHeader    = "X-B3-Sampled"
+	B3FlagsHeader      = "X-B3-Flags"
+	TraceparentHeader  = "traceparent"
+)
+
+// Extract reads the trace context that a caller sent with header, preferring
+// single header B3, then multiple header B3, then W3C. It returns nil when
+// there is none, or none that can be read. A context with no trace ID carries
+// only the caller's sampling decision.
+func Extract(header http.Header) *model.SpanContext {
+	if value := header.Get(B3Header); value != "" {
+		if sc, err := parseB3(value); err == nil {
+			return sc
+		}
+	}
+	if sc, err := parseB3Multi(header); err == nil && sc != nil {
+		return sc
+	}
+	if value := header.Get(TraceparentHeader); value != "" {
+		if sc, err := parseTraceparent(value); err == nil {
+			return sc
+		}
+	}
+	return nil
+}
+
+// Inject writes sc to header in both multiple header B3 and W3C form, so that
+// the callee can read whichever it understands.
+func Inject(sc model.SpanContext, header http.Header) {
+	header.Set(B3TraceIDHeader, sc.TraceID.String())
+	header.Set(B3SpanIDHeader, sc.ID.String())
+	if sc.ParentID != nil {
+		header.Set(B3ParentSpanHeader, sc.ParentID.String())
+	} else {
+		header.Del(B3ParentSpanHeader)
+	}
+
+	sampled := sc.Sampled == nil || *sc.Sampled
+	header.Del(B3FlagsHeader)
+	switch {
+	case sc.Debug:
+		header.Del(B3SampledHeader)
+		header.Set(B3FlagsHeader, "1")
+	case sampled:
+		header.Set(B3SampledHeader, "1")
+	default:
+		header.Set(B3SampledHeader, "0")
+	}
+	header.Del(B3Header)
+
+	flags := "00"
+	if sampled || sc.Debug {
+		flags = "01"
+	}
+	header.Set(TraceparentHeader, fmt.Sprintf("00-%016x%016x-%s-%s", sc.TraceID.High, sc.TraceID.Low, sc.ID, flags))
+}
+
+// parseB3 reads {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}, where the
+// last two are optional, or a sampling state on its own.
+func parseB3(value string) (*model.SpanContext, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) == 1 {
+		sc := &model.SpanContext{}
+		return sc, setSamplingState(sc, parts[0])
+	}
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("invalid b3 header %q", value)
+	}
+
+	sc, err := newSpanContext(parts[0], parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) > 2 {
+		if err := setSamplingState(sc, parts[2]); err != nil {
+			return nil, err
+		}
+	}
+	if len(parts) > 3 {
+		parentID, err := parseID(parts[3])
+		if err != nil {
+			return nil, err
+		}
+		sc.ParentID = &parentID
+	}
+	return sc, nil
+}
+
+func parseB3Multi(header http.Header) (*model.SpanContext, error) {
+	traceID, spanID := header.Get(B3TraceIDHeader), header.Get(B3SpanIDHeader)
+
+	var sc *model.SpanContext
+	switch {
+	case traceID != "" && spanID != "":
+		var err error
+		if sc, err = newSpanContext(traceID, spanID); err != nil {
+			return nil, err
+		}
+	case traceID == "" && spanID == "":
+		sc = &model.SpanContext{}
+	default:
+		return nil, fmt.Errorf("%s and %s must be sent together", B3TraceIDHeader, B3SpanIDHeader)
+	}
+
+	if parent := header.Get(B3ParentSpanHeader); parent != "" && !sc.TraceID.Empty() {
+		parentID, err := parseID(parent)
+		if err != nil {
+			return nil, err
+		}
+		sc.ParentID = &parentID
+	}
+
+	if header.Get(B3FlagsHeader) == "1" {
+		sc.Debug = true
+	} else if sampled := header.Get(B3SampledHeader); sampled != "" {
+		if err := setSamplingState(sc, sampled); err != nil {
+			return nil, err
+		}
+	}
+
+	if sc.TraceID.Empty() && sc.Sampled == nil && !sc.Debug {
+		return nil, nil
+	}
+	return sc, nil
+}
+
+// parseTraceparent reads version 00 of the header, and the same fields of any
+// later version.
+func parseTraceparent(value string) (*model.SpanContext, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, fmt.Errorf("invalid traceparent header %q", value)
+	}
+
+	sc, err := newSpanContext(parts[1], parts[2])
+	if err != nil {
+		return nil, err
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid traceparent flags %q", parts[3])
+	}
+	sampled := flags&1 == 1
+	sc.Sampled = &sampled
+	return sc, nil
+}
+
+func newSpanContext(traceID, spanID string) (*model.SpanContext, error) {
+	if len(traceID) != 16 && len(traceID) != 32 {
+		return nil, fmt.Errorf("invalid trace ID %q", traceID)
+	}
+	tid, err := model.TraceIDFromHex(traceID)
+	if err != nil || tid.Empty() {
+		return nil, fmt.Errorf("invalid trace ID %q", traceID)
+	}
+	id, err := parseID(spanID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.SpanContext{TraceID: tid, ID: id}, nil
+}
+
+func parseID(value string) (model.ID, error) {
+	id, err := strconv.ParseUint(value, 16, 64)
+	if err != nil || len(value) != 16 || id == 0 {
+		return 0, fmt.Errorf("invalid span ID %q", value)
+	}
+	return model.ID(id), nil
+}
+
+func setSamplingState(sc *model.SpanContext, state string) error {
+	switch state {
+	case "d":
+		sc.Debug = true
+	case "1", "true":
+		sampled := true
+		sc.Sampled = &sampled
+	case "0", "false":
+		sampled := false
+		sc.Sampled = &sampled
+	default:
+		return fmt.Errorf("invalid sampling state %q", state)
+	}
+	return nil
+}
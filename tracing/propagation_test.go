@@ -0,0 +1,113 @@
+package tracing_test
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/nfsbroker/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+var _ = Describe("Propagation", func() {
+	const (
+		traceID = "463ac35c9f6413ad48485a3953bb6124"
+		spanID  = "a2fb4a1d1a96d312"
+	)
+
+	var header http.Header
+
+	BeforeEach(func() {
+		header = http.Header{}
+	})
+
+	Describe("Extract", func() {
+		It("reads single header B3", func() {
+			header.Set("b3", traceID+"-"+spanID+"-1-0020000000000001")
+
+			sc := tracing.Extract(header)
+			Expect(sc).NotTo(BeNil())
+			Expect(sc.TraceID.String()).To(Equal(traceID))
+			Expect(sc.ID.String()).To(Equal(spanID))
+			Expect(sc.ParentID.String()).To(Equal("0020000000000001"))
+			Expect(*sc.Sampled).To(BeTrue())
+		})
+
+		It("reads multiple header B3", func() {
+			header.Set("X-B3-TraceId", "48485a3953bb6124")
+			header.Set("X-B3-SpanId", spanID)
+			header.Set("X-B3-Sampled", "0")
+
+			sc := tracing.Extract(header)
+			Expect(sc).NotTo(BeNil())
+			Expect(sc.TraceID.String()).To(Equal("48485a3953bb6124"))
+			Expect(*sc.Sampled).To(BeFalse())
+		})
+
+		It("reads W3C trace context", func() {
+			header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+
+			sc := tracing.Extract(header)
+			Expect(sc).NotTo(BeNil())
+			Expect(sc.TraceID.String()).To(Equal(traceID))
+			Expect(sc.ID.String()).To(Equal(spanID))
+			Expect(*sc.Sampled).To(BeTrue())
+		})
+
+		It("prefers B3 to W3C", func() {
+			header.Set("X-B3-TraceId", traceID)
+			header.Set("X-B3-SpanId", spanID)
+			header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+			Expect(tracing.Extract(header).TraceID.String()).To(Equal(traceID))
+		})
+
+		It("keeps a sampling decision sent on its own", func() {
+			header.Set("b3", "0")
+
+			sc := tracing.Extract(header)
+			Expect(sc).NotTo(BeNil())
+			Expect(sc.TraceID.Empty()).To(BeTrue())
+			Expect(*sc.Sampled).To(BeFalse())
+		})
+
+		It("ignores headers it cannot read", func() {
+			header.Set("b3", "not-a-trace")
+			header.Set("X-B3-TraceId", traceID)
+			header.Set("traceparent", "00-"+traceID+"-0000000000000000-01")
+
+			Expect(tracing.Extract(header)).To(BeNil())
+		})
+
+		It("returns nil without trace context", func() {
+			Expect(tracing.Extract(header)).To(BeNil())
+		})
+	})
+
+	Describe("Inject", func() {
+		It("writes B3 and W3C headers that Extract reads back", func() {
+			tid, err := model.TraceIDFromHex(traceID)
+			Expect(err).NotTo(HaveOccurred())
+			parentID := model.ID(1)
+			sampled := true
+			tracing.Inject(model.SpanContext{TraceID: tid, ID: model.ID(2), ParentID: &parentID, Sampled: &sampled}, header)
+
+			Expect(header.Get("X-B3-TraceId")).To(Equal(traceID))
+			Expect(header.Get("X-B3-SpanId")).To(Equal("0000000000000002"))
+			Expect(header.Get("X-B3-ParentSpanId")).To(Equal("0000000000000001"))
+			Expect(header.Get("X-B3-Sampled")).To(Equal("1"))
+			Expect(header.Get("traceparent")).To(Equal("00-" + traceID + "-0000000000000002-01"))
+
+			header.Del("X-B3-TraceId")
+			sc := tracing.Extract(header)
+			Expect(sc.TraceID).To(Equal(tid))
+			Expect(sc.ID).To(Equal(model.ID(2)))
+		})
+
+		It("pads 64 bit trace IDs in the W3C header", func() {
+			tracing.Inject(model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: model.ID(2)}, header)
+
+			Expect(header.Get("traceparent")).To(Equal("00-00000000000000000000000000000001-0000000000000002-01"))
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/tedsuo/ifrit"
+)
+
+// maxQueuedSpans bounds the spans held between flushes, so that a collector
+// that is down cannot exhaust the broker's memory. Spans beyond it are
+// dropped.
+const maxQueuedSpans = 1000
+
+// HTTPReporter sends spans to a Zipkin collector, such as
+// http://zipkin:9411/api/v2/spans, in batches. Run it to flush the queue
+// every interval, and once more when the broker shuts down. Failed batches
+// are logged and dropped: tracing never holds up the broker.
+type HTTPReporter struct {
+	logger   lager.Logger
+	client   *http.Client
+	url      string
+	clock    clock.Clock
+	interval time.Duration
+
+	lock    sync.Mutex
+	queue   []model.SpanModel
+	dropped int
+}
+
+var (
+	_ Reporter     = &HTTPReporter{}
+	_ ifrit.Runner = &HTTPReporter{}
+)
+
+func NewHTTPReporter(logger lager.Logger, client *http.Client, url string, clock clock.Clock, interval time.Duration) *HTTPReporter {
+	return &HTTPReporter{
+		logger:   logger.Session("tracing-reporter", lager.Data{"url": url}),
+		client:   client,
+		url:      url,
+		clock:    clock,
+		interval: interval,
+	}
+}
+
+func (r *HTTPReporter) Report(span model.SpanModel) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.queue) >= maxQueuedSpans {
+		r.dropped++
+		return
+	}
+	r.queue = append(r.queue, span)
+}
+
+func (r *HTTPReporter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			r.Flush()
+			return nil
+		case <-ticker.C():
+			r.Flush()
+		}
+	}
+}
+
+// Flush sends every queued span.
+func (r *HTTPReporter) Flush() {
+	r.lock.Lock()
+	spans, dropped := r.queue, r.dropped
+	r.queue, r.dropped = nil, 0
+	r.lock.Unlock()
+
+	if dropped > 0 {
+		r.logger.Info("spans-dropped", lager.Data{"count": dropped})
+	}
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := r.send(spans); err != nil {
+		r.logger.Error("export-failed", err, lager.Data{"spans": len(spans)})
+	}
+}
+
+func (r *HTTPReporter) send(spans []model.SpanModel) error {
+	body, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+	ginkgomon "github.com/tedsuo/ifrit/ginkgomon_v2"
+)
+
+var _ = Describe("HTTPReporter", func() {
+	var (
+		collector *ghttp.Server
+		received  chan []map[string]interface{}
+		logs      *gbytes.Buffer
+		reporter  *tracing.HTTPReporter
+		tracer    *tracing.Tracer
+	)
+
+	BeforeEach(func() {
+		received = make(chan []map[string]interface{}, 10)
+		collector = ghttp.NewServer()
+		collector.RouteToHandler("POST", "/api/v2/spans", func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			var spans []map[string]interface{}
+			Expect(json.NewDecoder(r.Body).Decode(&spans)).To(Succeed())
+			received <- spans
+			w.WriteHeader(http.StatusAccepted)
+		})
+
+		logs = gbytes.NewBuffer()
+		logger := lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+
+		reporter = tracing.NewHTTPReporter(logger, http.DefaultClient, collector.URL()+"/api/v2/spans", clock.NewClock(), time.Hour)
+		tracer = tracing.NewTracer("nfsbroker", reporter, clock.NewClock())
+	})
+
+	AfterEach(func() {
+		collector.Close()
+	})
+
+	It("sends finished spans to the collector in Zipkin v2 format", func() {
+		parent, ctx := tracer.StartSpan(context.Background(), "PUT /v2/service_instances/:instance_id", "SERVER")
+		child, _ := tracer.StartSpan(ctx, "store RetrieveInstanceDetails", "")
+		child.Finish()
+		parent.Finish()
+
+		reporter.Flush()
+
+		var spans []map[string]interface{}
+		Eventually(received).Should(Receive(&spans))
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0]["name"]).To(Equal("store retrieveinstancedetails"))
+		Expect(spans[0]["parentId"]).To(Equal(parent.Context().ID.String()))
+		Expect(spans[1]["traceId"]).To(Equal(parent.Context().TraceID.String()))
+		Expect(spans[1]["kind"]).To(Equal("SERVER"))
+		Expect(spans[1]["localEndpoint"]).To(Equal(map[string]interface{}{"serviceName": "nfsbroker"}))
+		Expect(spans[1]["duration"]).To(BeNumerically(">", 0))
+	})
+
+	It("flushes when it is stopped", func() {
+		process := ginkgomon.Invoke(reporter)
+		span, _ := tracer.StartSpan(context.Background(), "GET /v2/catalog", "SERVER")
+		span.Finish()
+
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Expect(received).To(Receive(HaveLen(1)))
+	})
+
+	It("does not send anything when no spans have finished", func() {
+		reporter.Flush()
+		Expect(collector.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("logs batches the collector rejects", func() {
+		collector.RouteToHandler("POST", "/api/v2/spans", ghttp.RespondWith(http.StatusBadRequest, nil))
+		span, _ := tracer.StartSpan(context.Background(), "GET /v2/catalog", "SERVER")
+		span.Finish()
+
+		reporter.Flush()
+		Expect(logs).To(gbytes.Say("export-failed.*collector responded with 400"))
+	})
+})
@@ -0,0 +1,155 @@
+// Package tracing records Zipkin spans for the broker API requests and the
+// store and CredHub calls made while serving them, and propagates B3 and W3C
+// trace context to and from other services.
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/openzipkin/zipkin-go/idgenerator"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// Reporter receives every finished span that was sampled.
+type Reporter interface {
+	Report(span model.SpanModel)
+}
+
+type Tracer struct {
+	reporter Reporter
+	endpoint *model.Endpoint
+	clock    clock.Clock
+	ids      idgenerator.IDGenerator
+}
+
+func NewTracer(serviceName string, reporter Reporter, clock clock.Clock) *Tracer {
+	return &Tracer{
+		reporter: reporter,
+		endpoint: &model.Endpoint{ServiceName: serviceName},
+		clock:    clock,
+		ids:      idgenerator.NewRandom128(),
+	}
+}
+
+// StartSpan starts a span that is a child of the span in ctx, or the root of
+// a new trace when there is none, and returns a context carrying it.
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind model.Kind) (*Span, context.Context) {
+	var parent *model.SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parentContext := span.Context()
+		parent = &parentContext
+	}
+	span := t.start(name, kind, parent)
+	return span, ContextWithSpan(ctx, span)
+}
+
+// start continues the trace of parent, which may belong to another process,
+// or starts a new one when parent is nil or carries only a sampling decision.
+// Traces that the caller chose not to sample are propagated but not recorded.
+func (t *Tracer) start(name string, kind model.Kind, parent *model.SpanContext) *Span {
+	sampled := true
+	var spanContext model.SpanContext
+	if parent != nil {
+		sampled = parent.Debug || parent.Sampled == nil || *parent.Sampled
+		spanContext.Debug = parent.Debug
+	}
+	spanContext.Sampled = &sampled
+
+	if parent == nil || parent.TraceID.Empty() {
+		spanContext.TraceID = t.ids.TraceID()
+		spanContext.ID = t.ids.SpanID(spanContext.TraceID)
+	} else {
+		parentID := parent.ID
+		spanContext.TraceID = parent.TraceID
+		spanContext.ID = t.ids.SpanID(model.TraceID{})
+		spanContext.ParentID = &parentID
+	}
+
+	return &Span{
+		tracer: t,
+		model: model.SpanModel{
+			SpanContext:   spanContext,
+			Name:          name,
+			Kind:          kind,
+			Timestamp:     t.clock.Now(),
+			LocalEndpoint: t.endpoint,
+			Tags:          map[string]string{},
+		},
+	}
+}
+
+// Span is an operation in a trace. Its tags may be set concurrently, but it
+// is only reported once, however often it is finished, and cannot be changed
+// once it has been.
+type Span struct {
+	tracer *Tracer
+
+	lock     sync.Mutex
+	model    model.SpanModel
+	finished bool
+}
+
+func (s *Span) Context() model.SpanContext {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.model.SpanContext
+}
+
+func (s *Span) Tag(key, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.finished {
+		s.model.Tags[key] = value
+	}
+}
+
+// SetError tags the span with err, which Zipkin shows as a failed span. A nil
+// err is ignored.
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.Tag("error", err.Error())
+	}
+}
+
+// SetRemoteServiceName names the service on the other end of a client span.
+func (s *Span) SetRemoteServiceName(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.finished {
+		s.model.RemoteEndpoint = &model.Endpoint{ServiceName: name}
+	}
+}
+
+func (s *Span) Finish() {
+	s.lock.Lock()
+	if s.finished {
+		s.lock.Unlock()
+		return
+	}
+	s.finished = true
+	s.model.Duration = s.tracer.clock.Since(s.model.Timestamp)
+	if s.model.Duration <= 0 {
+		s.model.Duration = time.Microsecond
+	}
+	span := s.model
+	s.lock.Unlock()
+
+	if *span.Sampled {
+		s.tracer.reporter.Report(span)
+	}
+}
+
+type spanKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx, or nil when there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
@@ -0,0 +1,33 @@
+package tracing_test
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+func TestTracing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tracing Suite")
+}
+
+// recorder keeps every span it is given.
+type recorder struct {
+	lock  sync.Mutex
+	spans []model.SpanModel
+}
+
+func (r *recorder) Report(span model.SpanModel) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func (r *recorder) Spans() []model.SpanModel {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]model.SpanModel(nil), r.spans...)
+}
@@ -0,0 +1,123 @@
+// Package transport builds the HTTP clients used for every outbound
+// connection the broker makes, so that CA bundles, proxies and timeouts are
+// applied the same way whatever the broker is talking to.
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/lager/v3"
+	socks5 "github.com/cloudfoundry/socks5-proxy"
+)
+
+const keepAlive = 30 * time.Second
+
+// Config is the outbound connection policy.
+type Config struct {
+	// CACertPaths are PEM bundles trusted in addition to the system roots.
+	CACertPaths []string
+	// Timeout bounds a whole request, including reading the response body.
+	Timeout             time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+}
+
+// Factory creates HTTP clients that share one outbound connection policy.
+// Proxies are read from the environment when the factory is created:
+// https_proxy, http_proxy and no_proxy (or their upper case forms), and
+// CREDHUB_PROXY for a SOCKS5 proxy, optionally tunnelled over SSH, in the
+// format the CredHub CLI uses.
+type Factory struct {
+	config  Config
+	rootCAs *x509.CertPool
+	proxy   *proxyConfig
+	socks5  string
+}
+
+func NewFactory(config Config) (*Factory, error) {
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	for _, path := range config.CACertPaths {
+		/* #nosec */
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle %s: %w", path, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("reading CA bundle %s: %w", path, errors.New("no certificates found"))
+		}
+	}
+
+	proxy, err := proxyFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Factory{
+		config:  config,
+		rootCAs: rootCAs,
+		proxy:   proxy,
+		socks5:  os.Getenv("CREDHUB_PROXY"),
+	}, nil
+}
+
+// Client returns a client that presents clientCertificate, if it is not nil,
+// to servers that ask for one.
+func (f *Factory) Client(clientCertificate *tls.Certificate) *http.Client {
+	tlsConfig := &tls.Config{
+		RootCAs:    f.rootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	if clientCertificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCertificate}
+	}
+
+	return &http.Client{
+		Timeout: f.config.Timeout,
+		Transport: &http.Transport{
+			Proxy:               f.proxy.URL,
+			DialContext:         f.dialContext(),
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: f.config.TLSHandshakeTimeout,
+			MaxIdleConnsPerHost: 100,
+		},
+	}
+}
+
+func (f *Factory) dialContext() func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: f.config.DialTimeout, KeepAlive: keepAlive}
+	if f.socks5 == "" {
+		return dialer.DialContext
+	}
+
+	dial := credhub.SOCKS5DialFuncFromEnvironment(dialer.Dial, socks5.NewSocks5Proxy(socks5.NewHostKey(), nil, keepAlive))
+	return func(_ context.Context, network, address string) (net.Conn, error) {
+		return dial(network, address)
+	}
+}
+
+// Settings reports the effective policy, with any proxy passwords redacted.
+func (f *Factory) Settings() lager.Data {
+	return lager.Data{
+		"ca_cert_paths":         f.config.CACertPaths,
+		"https_proxy":           redact(f.proxy.https),
+		"http_proxy":            redact(f.proxy.http),
+		"no_proxy":              f.proxy.noProxy,
+		"socks5_proxy":          redactString(f.socks5),
+		"timeout":               f.config.Timeout.String(),
+		"dial_timeout":          f.config.DialTimeout.String(),
+		"tls_handshake_timeout": f.config.TLSHandshakeTimeout.String(),
+	}
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyConfig selects a proxy the way http.ProxyFromEnvironment does, but
+// reads the environment when it is created rather than once per process.
+type proxyConfig struct {
+	https   *url.URL
+	http    *url.URL
+	noProxy string
+}
+
+func proxyFromEnvironment() (*proxyConfig, error) {
+	https, err := parseProxy(getenv("HTTPS_PROXY", "https_proxy"))
+	if err != nil {
+		return nil, err
+	}
+	http, err := parseProxy(getenv("HTTP_PROXY", "http_proxy"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConfig{
+		https:   https,
+		http:    http,
+		noProxy: getenv("NO_PROXY", "no_proxy"),
+	}, nil
+}
+
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseProxy accepts a URL, or a bare host:port which is taken to be an HTTP
+// proxy.
+func parseProxy(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+		if proxyURL, err := url.Parse("http://" + proxy); err == nil {
+			return proxyURL, nil
+		}
+	}
+	if err != nil {
+		// the parse error would repeat the address, password and all
+		return nil, fmt.Errorf("invalid proxy address %q", redactString(proxy))
+	}
+	return proxyURL, nil
+}
+
+// URL returns the proxy for a request, or nil to connect directly.
+func (p *proxyConfig) URL(req *http.Request) (*url.URL, error) {
+	var proxyURL *url.URL
+	switch req.URL.Scheme {
+	case "https":
+		proxyURL = p.https
+	case "http":
+		proxyURL = p.http
+	}
+
+	if proxyURL == nil || p.bypass(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return proxyURL, nil
+}
+
+// bypass reports whether host is local or matches no_proxy: "*", an IP
+// address, a CIDR block, or a domain, which also matches its subdomains.
+func (p *proxyConfig) bypass(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	for _, entry := range strings.Split(strings.ToLower(p.noProxy), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		domain := strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+func redactString(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		if strings.Contains(s, "@") {
+			return "xxxxx"
+		}
+		return s
+	}
+	if u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
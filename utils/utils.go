@@ -2,6 +2,8 @@ package utils
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/tedsuo/ifrit"
@@ -24,3 +26,29 @@ func UntilTerminated(logger lager.Logger, process ifrit.Process) {
 func ProcessRunnerFor(servers grouper.Members) ifrit.Runner {
 	return sigmon.New(grouper.NewOrdered(os.Interrupt, servers))
 }
+
+// NewReloader returns a runner that calls reload every time the process
+// receives SIGHUP. A failed reload is logged and leaves the process running.
+func NewReloader(logger lager.Logger, reload func() error) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		close(ready)
+
+		for {
+			select {
+			case <-signals:
+				return nil
+			case <-hup:
+				logger.Info("reloading")
+				if err := reload(); err != nil {
+					logger.Error("reload-failed-keeping-current-config", err)
+					continue
+				}
+				logger.Info("reloaded")
+			}
+		}
+	})
+}
@@ -0,0 +1,271 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/uuid"
+	"github.com/tedsuo/ifrit"
+)
+
+// Policy says how often and for how long failed deliveries are retried.
+// Attempt n waits InitialBackoff * 2^(n-1), up to MaxBackoff, after the one
+// before it; after MaxAttempts, the delivery becomes a dead letter.
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// MaxAge is how long a delivery to a subscriber that is not in this
+	// broker's config is left in the outbox, for a broker sharing the store
+	// whose config has it, before it becomes a dead letter. It should be
+	// longer than a reload or a rolling deploy takes.
+	MaxAge time.Duration
+
+	// PollInterval is how often the outbox is checked for deliveries that
+	// are due. New events are sent straight away.
+	PollInterval time.Duration
+}
+
+var DefaultPolicy = Policy{
+	MaxAttempts:    10,
+	InitialBackoff: 10 * time.Second,
+	MaxBackoff:     time.Hour,
+	MaxAge:         24 * time.Hour,
+	PollInterval:   10 * time.Second,
+}
+
+// Dispatcher writes events to the outbox and sends them to subscribers. Run
+// it to send them: deliveries left in the outbox by an earlier run, or by
+// another broker sharing the store, are sent too. Each event is delivered at
+// least once; subscribers can use DeliveryHeader to ignore repeats.
+type Dispatcher struct {
+	logger      lager.Logger
+	outbox      Outbox
+	subscribers map[string]Subscriber
+	order       []string
+	client      *http.Client
+	clock       clock.Clock
+	policy      Policy
+
+	wake chan struct{}
+}
+
+var _ ifrit.Runner = &Dispatcher{}
+
+func NewDispatcher(logger lager.Logger, outbox Outbox, subscribers []Subscriber, client *http.Client, clock clock.Clock, policy Policy) *Dispatcher {
+	d := &Dispatcher{
+		logger:      logger.Session("webhooks"),
+		outbox:      outbox,
+		subscribers: map[string]Subscriber{},
+		client:      client,
+		clock:       clock,
+		policy:      policy,
+		wake:        make(chan struct{}, 1),
+	}
+	for _, subscriber := range subscribers {
+		d.subscribers[subscriber.Name] = subscriber
+		d.order = append(d.order, subscriber.Name)
+	}
+	return d
+}
+
+// Enqueue writes a delivery of event to the outbox for each subscriber that
+// wants it. The event is given an ID and time if it has none.
+func (d *Dispatcher) Enqueue(event Event) error {
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.Time.IsZero() {
+		event.Time = d.clock.Now().UTC()
+	}
+
+	enqueued := false
+	for _, name := range d.order {
+		if !d.subscribers[name].Wants(event.Type) {
+			continue
+		}
+		delivery := Delivery{
+			ID:          uuid.NewString(),
+			Subscriber:  name,
+			Event:       event,
+			NextAttempt: event.Time,
+		}
+		if err := d.outbox.Put(delivery); err != nil {
+			return fmt.Errorf("writing delivery to %s: %w", name, err)
+		}
+		enqueued = true
+	}
+
+	if enqueued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := d.clock.NewTicker(d.policy.PollInterval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			d.DeliverDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+			case <-d.wake:
+			}
+		}
+	}()
+
+	close(ready)
+
+	<-signals
+	// abandons a delivery in flight; it is still in the outbox, and is sent
+	// again on the next run
+	cancel()
+	<-done
+	return nil
+}
+
+// DeliverDue sends every delivery in the outbox whose next attempt is due,
+// oldest event first.
+func (d *Dispatcher) DeliverDue(ctx context.Context) {
+	deliveries, err := d.outbox.List()
+	if err != nil {
+		d.logger.Error("reading-outbox-failed", err)
+		return
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].Event.Time.Before(deliveries[j].Event.Time)
+	})
+
+	now := d.clock.Now()
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		if delivery.NextAttempt.After(now) {
+			continue
+		}
+		d.attempt(ctx, delivery)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery) {
+	logger := d.logger.Session("deliver", lager.Data{"delivery": delivery.ID, "subscriber": delivery.Subscriber, "event": delivery.Event.Type, "instance_id": delivery.Event.InstanceID})
+
+	subscriber, ok := d.subscribers[delivery.Subscriber]
+	if !ok {
+		// Another broker sharing the store may have it in its config, or
+		// this one may once its config is reloaded, so it is only given up
+		// on once it is old enough that it was removed from every config.
+		if d.clock.Now().Sub(delivery.Event.Time) < d.policy.MaxAge {
+			logger.Debug("skipping-unknown-subscriber")
+			return
+		}
+		delivery.LastError = "no such subscriber"
+		d.deadLetter(logger, delivery)
+		return
+	}
+
+	delivery.Attempts++
+	err := d.send(ctx, subscriber, delivery)
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		if err := d.outbox.Delete(delivery.ID); err != nil {
+			// it will be sent again
+			logger.Error("removing-delivery-failed", err)
+		}
+		logger.Debug("delivered")
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.policy.MaxAttempts {
+		d.deadLetter(logger, delivery)
+		return
+	}
+
+	delivery.NextAttempt = d.clock.Now().Add(d.backoff(delivery.Attempts))
+	logger.Info("delivery-failed", lager.Data{"error": delivery.LastError, "attempts": delivery.Attempts, "next_attempt": delivery.NextAttempt})
+	if err := d.outbox.Put(delivery); err != nil {
+		logger.Error("rescheduling-delivery-failed", err)
+	}
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.policy.InitialBackoff
+	for i := 1; i < attempts && backoff < d.policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.policy.MaxBackoff {
+		backoff = d.policy.MaxBackoff
+	}
+	return backoff
+}
+
+// deadLetter moves a delivery out of the outbox. If either step fails the
+// delivery stays where it was, or is in both, and is moved again later.
+func (d *Dispatcher) deadLetter(logger lager.Logger, delivery Delivery) {
+	logger.Error("delivery-abandoned", fmt.Errorf("%s", delivery.LastError), lager.Data{"attempts": delivery.Attempts})
+
+	if err := d.outbox.PutDeadLetter(delivery); err != nil {
+		logger.Error("writing-dead-letter-failed", err)
+		return
+	}
+	if err := d.outbox.Delete(delivery.ID); err != nil {
+		logger.Error("removing-delivery-failed", err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, subscriber Subscriber, delivery Delivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", subscriber.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := d.clock.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event.Type)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(subscriber.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("subscriber responded with %d", resp.StatusCode)
+	}
+	return nil
+}
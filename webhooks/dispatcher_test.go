@@ -0,0 +1,214 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+	ginkgomon "github.com/tedsuo/ifrit/ginkgomon_v2"
+)
+
+var _ = Describe("Dispatcher", func() {
+	var (
+		receiver   *ghttp.Server
+		status     int
+		received   chan *http.Request
+		bodies     chan []byte
+		outbox     *memoryOutbox
+		clk        *manualClock
+		logger     lager.Logger
+		logs       *gbytes.Buffer
+		policy     webhooks.Policy
+		dispatcher *webhooks.Dispatcher
+		event      webhooks.Event
+	)
+
+	BeforeEach(func() {
+		status = http.StatusOK
+		received = make(chan *http.Request, 10)
+		bodies = make(chan []byte, 10)
+		receiver = ghttp.NewServer()
+		receiver.RouteToHandler("POST", "/hooks", func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- r
+			bodies <- body
+			w.WriteHeader(status)
+		})
+
+		outbox = newMemoryOutbox()
+		clk = newManualClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+		logs = gbytes.NewBuffer()
+		logger = lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+		policy = webhooks.Policy{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: 90 * time.Second, MaxAge: 24 * time.Hour, PollInterval: time.Hour}
+
+		event = webhooks.Event{Type: webhooks.EventBind, InstanceID: "instance-1", BindingID: "binding-1", AppGUID: "app-guid", Share: "server/export"}
+	})
+
+	JustBeforeEach(func() {
+		dispatcher = webhooks.NewDispatcher(logger, outbox, []webhooks.Subscriber{
+			{Name: "cmdb", URL: receiver.URL() + "/hooks", Secret: "cmdb-secret"},
+			{Name: "provisions-only", URL: receiver.URL() + "/hooks", Secret: "other-secret", Events: []string{webhooks.EventProvision}},
+		}, http.DefaultClient, clk, policy)
+	})
+
+	AfterEach(func() {
+		receiver.Close()
+	})
+
+	It("writes a delivery to the outbox for each subscriber that wants the event", func() {
+		Expect(dispatcher.Enqueue(event)).To(Succeed())
+
+		deliveries, _ := outbox.List()
+		Expect(deliveries).To(HaveLen(1))
+		Expect(deliveries[0].Subscriber).To(Equal("cmdb"))
+		Expect(deliveries[0].ID).NotTo(BeEmpty())
+		Expect(deliveries[0].Event.ID).NotTo(BeEmpty())
+		Expect(deliveries[0].Event.Time).To(Equal(clk.Now()))
+		Expect(deliveries[0].NextAttempt).To(Equal(clk.Now()))
+	})
+
+	It("fails to enqueue when the outbox cannot be written", func() {
+		outbox.failPuts = true
+		Expect(dispatcher.Enqueue(event)).To(MatchError("writing delivery to cmdb: credhub unavailable"))
+	})
+
+	It("sends a signed JSON payload, then removes the delivery", func() {
+		Expect(dispatcher.Enqueue(event)).To(Succeed())
+		dispatcher.DeliverDue(context.Background())
+
+		var req *http.Request
+		Eventually(received).Should(Receive(&req))
+		body := <-bodies
+
+		deliveries, _ := outbox.List()
+		Expect(deliveries).To(BeEmpty())
+
+		var payload map[string]interface{}
+		Expect(json.Unmarshal(body, &payload)).To(Succeed())
+		Expect(payload).To(HaveKeyWithValue("type", "bind"))
+		Expect(payload).To(HaveKeyWithValue("instance_id", "instance-1"))
+		Expect(payload).To(HaveKeyWithValue("binding_id", "binding-1"))
+		Expect(payload).To(HaveKeyWithValue("app_guid", "app-guid"))
+		Expect(payload).To(HaveKeyWithValue("share", "server/export"))
+
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(req.Header.Get(webhooks.EventHeader)).To(Equal("bind"))
+		Expect(req.Header.Get(webhooks.DeliveryHeader)).NotTo(BeEmpty())
+		Expect(req.Header.Get(webhooks.TimestampHeader)).To(Equal(strconv.FormatInt(clk.Now().Unix(), 10)))
+		Expect(req.Header.Get(webhooks.SignatureHeader)).To(Equal(webhooks.Sign("cmdb-secret", clk.Now(), body)))
+		Expect(req.Header.Get(webhooks.SignatureHeader)).NotTo(Equal(webhooks.Sign("other-secret", clk.Now(), body)))
+	})
+
+	It("retries failed deliveries with backoff, then gives up on them", func() {
+		status = http.StatusInternalServerError
+		Expect(dispatcher.Enqueue(event)).To(Succeed())
+
+		dispatcher.DeliverDue(context.Background())
+		Expect(received).To(HaveLen(1))
+		deliveries, _ := outbox.List()
+		Expect(deliveries).To(HaveLen(1))
+		Expect(deliveries[0].Attempts).To(Equal(1))
+		Expect(deliveries[0].LastError).To(Equal("subscriber responded with 500"))
+		Expect(deliveries[0].NextAttempt).To(Equal(clk.Now().Add(time.Minute)))
+
+		By("waiting for the backoff")
+		dispatcher.DeliverDue(context.Background())
+		Expect(received).To(HaveLen(1))
+
+		clk.Advance(time.Minute)
+		dispatcher.DeliverDue(context.Background())
+		Expect(received).To(HaveLen(2))
+		deliveries, _ = outbox.List()
+		Expect(deliveries[0].NextAttempt).To(Equal(clk.Now().Add(90*time.Second)), "capped at MaxBackoff")
+
+		clk.Advance(90 * time.Second)
+		dispatcher.DeliverDue(context.Background())
+		Expect(received).To(HaveLen(3))
+
+		deliveries, _ = outbox.List()
+		Expect(deliveries).To(BeEmpty())
+		deadLetters, _ := outbox.ListDeadLetters()
+		Expect(deadLetters).To(HaveLen(1))
+		Expect(deadLetters[0].Attempts).To(Equal(3))
+		Expect(deadLetters[0].LastError).To(Equal("subscriber responded with 500"))
+	})
+
+	It("leaves deliveries to subscribers it does not know to other brokers, then gives up on them", func() {
+		event.Time = clk.Now()
+		Expect(outbox.Put(webhooks.Delivery{ID: "delivery-1", Subscriber: "removed", Event: event, NextAttempt: clk.Now()})).To(Succeed())
+
+		dispatcher.DeliverDue(context.Background())
+		clk.Advance(23 * time.Hour)
+		dispatcher.DeliverDue(context.Background())
+
+		deliveries, _ := outbox.List()
+		Expect(deliveries).To(ConsistOf(HaveField("ID", "delivery-1")))
+		deadLetters, _ := outbox.ListDeadLetters()
+		Expect(deadLetters).To(BeEmpty())
+
+		clk.Advance(time.Hour)
+		dispatcher.DeliverDue(context.Background())
+
+		Expect(received).To(BeEmpty())
+		deliveries, _ = outbox.List()
+		Expect(deliveries).To(BeEmpty())
+		Expect(logs).To(gbytes.Say("delivery-abandoned"))
+		deadLetters, _ = outbox.ListDeadLetters()
+		Expect(deadLetters).To(ConsistOf(HaveField("LastError", "no such subscriber")))
+	})
+
+	Describe("Run", func() {
+		It("sends deliveries left in the outbox, and new events straight away", func() {
+			Expect(outbox.Put(webhooks.Delivery{ID: "left-over", Subscriber: "cmdb", Event: event})).To(Succeed())
+
+			process := ginkgomon.Invoke(dispatcher)
+			defer ginkgomon.Interrupt(process)
+
+			var req *http.Request
+			Eventually(received).Should(Receive(&req))
+			Expect(req.Header.Get(webhooks.DeliveryHeader)).To(Equal("left-over"))
+
+			Expect(dispatcher.Enqueue(webhooks.Event{Type: webhooks.EventProvision, InstanceID: "instance-2"})).To(Succeed())
+			Eventually(received).Should(Receive())
+			Eventually(received).Should(Receive())
+			Eventually(func() []webhooks.Delivery {
+				deliveries, _ := outbox.List()
+				return deliveries
+			}).Should(BeEmpty())
+		})
+	})
+
+	Describe("DeadLettersHandler", func() {
+		It("lists the dead letters as JSON", func() {
+			Expect(outbox.PutDeadLetter(webhooks.Delivery{ID: "delivery-1", Subscriber: "cmdb", Event: event, Attempts: 3, LastError: "subscriber responded with 500"})).To(Succeed())
+
+			recorder := httptest.NewRecorder()
+			webhooks.NewDeadLettersHandler(lager.NewLogger("test"), outbox).ServeHTTP(recorder, httptest.NewRequest("GET", webhooks.DeadLettersPath, nil))
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+			var deadLetters []webhooks.Delivery
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &deadLetters)).To(Succeed())
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].ID).To(Equal("delivery-1"))
+			Expect(deadLetters[0].LastError).To(Equal("subscriber responded with 500"))
+		})
+
+		It("lists no dead letters as an empty list", func() {
+			recorder := httptest.NewRecorder()
+			webhooks.NewDeadLettersHandler(lager.NewLogger("test"), outbox).ServeHTTP(recorder, httptest.NewRequest("GET", webhooks.DeadLettersPath, nil))
+			Expect(recorder.Body.String()).To(Equal("[]\n"))
+		})
+	})
+})
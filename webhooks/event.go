@@ -0,0 +1,84 @@
+// Package webhooks notifies other systems of changes to service instances
+// and bindings. Events are written to an outbox in the broker store before
+// they are sent, and are retried with backoff until they are delivered or
+// given up on, so that a broker restart does not lose them.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Event types.
+const (
+	EventProvision   = "provision"
+	EventDeprovision = "deprovision"
+	EventBind        = "bind"
+	EventUnbind      = "unbind"
+)
+
+var knownEventTypes = map[string]bool{
+	EventProvision:   true,
+	EventDeprovision: true,
+	EventBind:        true,
+	EventUnbind:      true,
+}
+
+// Headers sent with each delivery. See Sign for the signature.
+const (
+	EventHeader     = "X-Nfsbroker-Event"
+	DeliveryHeader  = "X-Nfsbroker-Delivery"
+	TimestampHeader = "X-Nfsbroker-Timestamp"
+	SignatureHeader = "X-Nfsbroker-Signature"
+)
+
+// Event is the JSON payload sent to subscribers.
+type Event struct {
+	ID               string    `json:"id"`
+	Type             string    `json:"type"`
+	Time             time.Time `json:"time"`
+	InstanceID       string    `json:"instance_id"`
+	BindingID        string    `json:"binding_id,omitempty"`
+	ServiceID        string    `json:"service_id,omitempty"`
+	PlanID           string    `json:"plan_id,omitempty"`
+	OrganizationGUID string    `json:"organization_guid,omitempty"`
+	SpaceGUID        string    `json:"space_guid,omitempty"`
+	AppGUID          string    `json:"app_guid,omitempty"`
+	Share            string    `json:"share,omitempty"`
+}
+
+// Delivery is an event on its way to one subscriber, as kept in the outbox,
+// or once it has been given up on, in the dead letters.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Subscriber  string    `json:"subscriber"`
+	Event       Event     `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Outbox keeps deliveries until they succeed, and those that never will.
+type Outbox interface {
+	Put(delivery Delivery) error
+	List() ([]Delivery, error)
+	Delete(id string) error
+
+	PutDeadLetter(delivery Delivery) error
+	ListDeadLetters() ([]Delivery, error)
+}
+
+// Sign returns the signature of a delivery sent at timestamp: the hex
+// HMAC-SHA256, keyed with the subscriber's secret, of the timestamp in Unix
+// seconds, a ".", and the body. Subscribers should recompute it, compare it
+// in constant time, and reject timestamps too far in the past.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,47 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const DeadLettersPath = "/webhooks/dead-letters"
+
+type deadLettersHandler struct {
+	logger lager.Logger
+	outbox Outbox
+}
+
+// NewDeadLettersHandler lists the deliveries that were given up on, oldest
+// event first, with the error from their last attempt. It does not
+// authenticate requests itself.
+func NewDeadLettersHandler(logger lager.Logger, outbox Outbox) http.Handler {
+	return &deadLettersHandler{logger: logger.Session("dead-letters"), outbox: outbox}
+}
+
+func (h *deadLettersHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	deadLetters, err := h.outbox.ListDeadLetters()
+	if err != nil {
+		h.logger.Error("listing-dead-letters-failed", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	sort.SliceStable(deadLetters, func(i, j int) bool {
+		return deadLetters[i].Event.Time.Before(deadLetters[j].Event.Time)
+	})
+	if deadLetters == nil {
+		deadLetters = []Delivery{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(deadLetters)
+}
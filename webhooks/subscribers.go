@@ -0,0 +1,93 @@
+package webhooks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Subscriber receives the events it lists, or every event when it lists
+// none, signed with its secret.
+type Subscriber struct {
+	Name   string   `yaml:"name"`
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+}
+
+// Wants reports whether the subscriber receives events of type eventType.
+func (s Subscriber) Wants(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, wanted := range s.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSubscribers reads a webhooks config file:
+//
+//	subscribers:
+//	- name: cmdb
+//	  url: https://cmdb.example.com/hooks/nfs
+//	  secret: shared-hmac-key
+//	  events: [provision, deprovision]
+//
+// Names must be unique: pending deliveries refer to their subscriber by name.
+func LoadSubscribers(path string) ([]Subscriber, error) {
+	var config struct {
+		Subscribers []Subscriber `yaml:"subscribers"`
+	}
+
+	/* #nosec */
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid webhooks config %s: %w", path, err)
+	}
+
+	if err := validate(config.Subscribers); err != nil {
+		return nil, fmt.Errorf("invalid webhooks config %s: %w", path, err)
+	}
+	return config.Subscribers, nil
+}
+
+func validate(subscribers []Subscriber) error {
+	names := map[string]bool{}
+	for i, subscriber := range subscribers {
+		if subscriber.Name == "" {
+			return fmt.Errorf("subscriber %d must have a name", i)
+		}
+		if names[subscriber.Name] {
+			return fmt.Errorf("duplicate subscriber name %q", subscriber.Name)
+		}
+		names[subscriber.Name] = true
+
+		target, err := url.Parse(subscriber.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return fmt.Errorf("subscriber %q must have an http or https url", subscriber.Name)
+		}
+		if subscriber.Secret == "" {
+			return fmt.Errorf("subscriber %q must have a secret", subscriber.Name)
+		}
+		for _, eventType := range subscriber.Events {
+			if !knownEventTypes[eventType] {
+				return fmt.Errorf("subscriber %q lists unknown event %q", subscriber.Name, eventType)
+			}
+		}
+	}
+	return nil
+}
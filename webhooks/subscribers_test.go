@@ -0,0 +1,68 @@
+package webhooks_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Subscribers", func() {
+	Describe("Wants", func() {
+		It("wants the events it lists", func() {
+			subscriber := webhooks.Subscriber{Events: []string{webhooks.EventProvision}}
+			Expect(subscriber.Wants(webhooks.EventProvision)).To(BeTrue())
+			Expect(subscriber.Wants(webhooks.EventBind)).To(BeFalse())
+		})
+
+		It("wants every event when it lists none", func() {
+			Expect(webhooks.Subscriber{}.Wants(webhooks.EventUnbind)).To(BeTrue())
+		})
+	})
+
+	Describe("LoadSubscribers", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "webhooks.yml")
+		})
+
+		It("loads every subscriber", func() {
+			Expect(os.WriteFile(path, []byte(`
+subscribers:
+- name: cmdb
+  url: https://cmdb.example.com/hooks/nfs
+  secret: cmdb-secret
+  events: [provision, deprovision]
+- name: chargeback
+  url: http://chargeback.internal:8080/events
+  secret: chargeback-secret
+`), 0600)).To(Succeed())
+
+			subscribers, err := webhooks.LoadSubscribers(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subscribers).To(Equal([]webhooks.Subscriber{
+				{Name: "cmdb", URL: "https://cmdb.example.com/hooks/nfs", Secret: "cmdb-secret", Events: []string{"provision", "deprovision"}},
+				{Name: "chargeback", URL: "http://chargeback.internal:8080/events", Secret: "chargeback-secret"},
+			}))
+		})
+
+		DescribeTable("rejects invalid subscribers",
+			func(contents, message string) {
+				Expect(os.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+
+				_, err := webhooks.LoadSubscribers(path)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("unknown keys", "subscribers:\n- name: cmdb\n  uri: https://cmdb\n", "field uri not found"),
+			Entry("no name", "subscribers:\n- url: https://cmdb\n  secret: s\n", "subscriber 0 must have a name"),
+			Entry("duplicate names", "subscribers:\n- {name: a, url: 'https://a', secret: s}\n- {name: a, url: 'https://b', secret: s}\n", `duplicate subscriber name "a"`),
+			Entry("no url", "subscribers:\n- {name: a, secret: s}\n", `subscriber "a" must have an http or https url`),
+			Entry("other schemes", "subscribers:\n- {name: a, url: 'ftp://a', secret: s}\n", `subscriber "a" must have an http or https url`),
+			Entry("no secret", "subscribers:\n- {name: a, url: 'https://a'}\n", `subscriber "a" must have a secret`),
+			Entry("unknown events", "subscribers:\n- {name: a, url: 'https://a', secret: s, events: [update]}\n", `subscriber "a" lists unknown event "update"`),
+		)
+	})
+})
@@ -0,0 +1,99 @@
+package webhooks_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/nfsbroker/webhooks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestWebhooks(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Webhooks Suite")
+}
+
+// memoryOutbox keeps deliveries in maps, as the CredHub outbox keeps them
+// under paths.
+type memoryOutbox struct {
+	lock        sync.Mutex
+	deliveries  map[string]webhooks.Delivery
+	deadLetters map[string]webhooks.Delivery
+	failPuts    bool
+}
+
+func newMemoryOutbox() *memoryOutbox {
+	return &memoryOutbox{deliveries: map[string]webhooks.Delivery{}, deadLetters: map[string]webhooks.Delivery{}}
+}
+
+func (o *memoryOutbox) Put(delivery webhooks.Delivery) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.failPuts {
+		return errors.New("credhub unavailable")
+	}
+	o.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (o *memoryOutbox) List() ([]webhooks.Delivery, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	var deliveries []webhooks.Delivery
+	for _, delivery := range o.deliveries {
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (o *memoryOutbox) Delete(id string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	delete(o.deliveries, id)
+	return nil
+}
+
+func (o *memoryOutbox) PutDeadLetter(delivery webhooks.Delivery) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.deadLetters[delivery.ID] = delivery
+	return nil
+}
+
+func (o *memoryOutbox) ListDeadLetters() ([]webhooks.Delivery, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	var deliveries []webhooks.Delivery
+	for _, delivery := range o.deadLetters {
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// manualClock reads the time it is set to, and ticks in real time.
+type manualClock struct {
+	clock.Clock
+
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newManualClock(now time.Time) *manualClock {
+	return &manualClock{Clock: clock.NewClock(), now: now}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}